@@ -0,0 +1,156 @@
+package internal
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"tradesim/src/db"
+	"tradesim/src/prob"
+)
+
+const (
+	// FormatLedger is the format of simulation output files.
+	FormatLedger = "ledger"
+	// FormatCSV is the format of trade files with a header row and
+	// timestamp, price and quantity columns, where timestamps are
+	// RFC 3339 times or seconds since the Unix epoch.
+	FormatCSV = "csv"
+)
+
+var ErrFit = errors.New("failed to fit distributions")
+
+// observation represents an observed trade.
+type observation struct {
+	at       time.Time
+	price    float64
+	quantity float64
+}
+
+func Fit(inFilepath, format, method string, w io.Writer) error {
+	var (
+		observations []observation
+		err          error
+	)
+	switch format {
+	case FormatLedger:
+		observations, err = readLedger(inFilepath)
+	case FormatCSV:
+		observations, err = readCSV(inFilepath)
+	default:
+		err = fmt.Errorf("unsupported input format: supported=%s, %s got=%s", FormatLedger, FormatCSV, format)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrFit, err)
+	}
+	sort.SliceStable(observations, func(i, j int) bool { return observations[i].at.Before(observations[j].at) })
+
+	series := []struct {
+		name    string
+		samples []float64
+	}{
+		{name: "inter_arrival_seconds", samples: make([]float64, 0, len(observations))},
+		{name: "price", samples: make([]float64, 0, len(observations))},
+		{name: "quantity", samples: make([]float64, 0, len(observations))},
+	}
+	for i, o := range observations {
+		if i > 0 {
+			series[0].samples = append(series[0].samples, o.at.Sub(observations[i-1].at).Seconds())
+		}
+		series[1].samples = append(series[1].samples, o.price)
+		series[2].samples = append(series[2].samples, o.quantity)
+	}
+
+	for _, s := range series {
+		fits, err := prob.FitDistributions(method, s.samples)
+		if errors.Is(err, prob.ErrSamples) {
+			fmt.Fprintf(w, "series=%s n=%d no distribution fits\n", s.name, len(s.samples))
+			continue
+		} else if err != nil {
+			return fmt.Errorf("%w: %v", ErrFit, err)
+		}
+		for _, f := range fits {
+			fmt.Fprintf(w, "series=%s %s\n", s.name, f)
+		}
+	}
+	return nil
+}
+
+func readLedger(filepath string) ([]observation, error) {
+	entries, err := db.ReadLedger(filepath)
+	if err != nil {
+		return nil, err
+	}
+	observations := make([]observation, len(entries))
+	for i, e := range entries {
+		observations[i] = observation{
+			at:       e.CreatedOn,
+			price:    e.Transaction.Credit.Price,
+			quantity: e.Transaction.Credit.Quantity,
+		}
+	}
+	return observations, nil
+}
+
+func readCSV(filepath string) ([]observation, error) {
+	f, err := os.Open(filepath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+	columns := map[string]int{"timestamp": -1, "price": -1, "quantity": -1}
+	for i, name := range records[0] {
+		if _, ok := columns[strings.ToLower(strings.TrimSpace(name))]; ok {
+			columns[strings.ToLower(strings.TrimSpace(name))] = i
+		}
+	}
+	for name, i := range columns {
+		if i < 0 {
+			return nil, fmt.Errorf("missing csv column: %s", name)
+		}
+	}
+
+	observations := make([]observation, 0, len(records)-1)
+	for n, r := range records[1:] {
+		at, err := parseTimestamp(r[columns["timestamp"]])
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %v", n+2, err)
+		}
+		price, err := strconv.ParseFloat(strings.TrimSpace(r[columns["price"]]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %v", n+2, err)
+		}
+		quantity, err := strconv.ParseFloat(strings.TrimSpace(r[columns["quantity"]]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %v", n+2, err)
+		}
+		observations = append(observations, observation{at: at, price: price, quantity: quantity})
+	}
+	return observations, nil
+}
+
+// parseTimestamp parses an RFC 3339 time or a number of seconds since the Unix epoch.
+func parseTimestamp(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	secs, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid timestamp: %s", s)
+	}
+	return time.Unix(0, int64(secs*float64(time.Second))).UTC(), nil
+}
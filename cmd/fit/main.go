@@ -0,0 +1,36 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"tradesim/cmd/fit/internal"
+	"tradesim/src/prob"
+)
+
+var (
+	help               bool
+	in, format, method string
+)
+
+func init() {
+	flag.BoolVar(&help, "h", false, "")
+	flag.BoolVar(&help, "help", false, "print description and available command options")
+	flag.StringVar(&in, "i", "", "path to simulation output file or trade csv file")
+	flag.StringVar(&format, "f", internal.FormatLedger, "input file format: ledger or csv")
+	flag.StringVar(&method, "m", prob.FitMLE, "fit method: mle or moments")
+}
+
+func main() {
+	flag.Parse()
+
+	if help {
+		fmt.Printf("fit distribution parameters to observed trades\n\noptions\n")
+		flag.PrintDefaults()
+		return
+	}
+
+	if err := internal.Fit(in, format, method, os.Stdout); err != nil {
+		fmt.Printf("error: %v\n", err)
+	}
+}
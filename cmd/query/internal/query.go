@@ -0,0 +1,108 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+	"tradesim/src/db"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// FormatDB is the format of blockchain files persisted by sim.
+	FormatDB = "db"
+	// FormatLedger is the format of simulation output files.
+	FormatLedger = "ledger"
+)
+
+var ErrQuery = errors.New("failed to query ledger")
+
+// Options represents the input and query of a ledger query.
+// Empty filter options match every transaction.
+type Options struct {
+	InFilepath string
+	Format     string
+	TraderID   string
+	ItemID     string
+	// From and To are RFC 3339 times.
+	From, To             string
+	MinHeight, MaxHeight int
+	Offset, Limit        int
+}
+
+// Query writes the transactions of the ledger at the input path
+// that match the provided options to w, followed by their aggregate.
+func Query(opts Options, w io.Writer) error {
+	ix, err := readIndex(opts.InFilepath, opts.Format)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrQuery, err)
+	}
+	q, err := parseQuery(opts)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrQuery, err)
+	}
+	result, err := ix.Query(q)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrQuery, err)
+	}
+
+	for _, e := range result.Entries {
+		fmt.Fprintf(w, "height=%d created on=%s %s\n", e.Height, e.CreatedOn.Format(time.RFC3339Nano), &e.Transaction)
+	}
+	a := result.Aggregate
+	fmt.Fprintf(w, "aggregate count=%d quantity=%f vwap=%f\n", a.Count, a.Quantity, a.VWAP)
+	return nil
+}
+
+// readIndex returns an index of the ledger at the provided path.
+func readIndex(filepath, format string) (*db.Index, error) {
+	switch format {
+	case FormatDB:
+		b, err := db.Load(filepath)
+		if err != nil {
+			return nil, err
+		}
+		return db.NewIndex(b), nil
+	case FormatLedger:
+		entries, err := db.ReadLedger(filepath)
+		if err != nil {
+			return nil, err
+		}
+		return db.NewLedgerIndex(entries), nil
+	default:
+		return nil, fmt.Errorf("unsupported input format: supported=%s, %s got=%s", FormatDB, FormatLedger, format)
+	}
+}
+
+func parseQuery(opts Options) (db.Query, error) {
+	q := db.Query{
+		MinHeight: opts.MinHeight,
+		MaxHeight: opts.MaxHeight,
+		Offset:    opts.Offset,
+		Limit:     opts.Limit,
+	}
+	var err error
+	if opts.TraderID != "" {
+		if q.TraderID, err = uuid.Parse(opts.TraderID); err != nil {
+			return db.Query{}, fmt.Errorf("trader id: %v", err)
+		}
+	}
+	if opts.ItemID != "" {
+		if q.ItemID, err = uuid.Parse(opts.ItemID); err != nil {
+			return db.Query{}, fmt.Errorf("item id: %v", err)
+		}
+	}
+	if opts.From != "" {
+		if q.From, err = time.Parse(time.RFC3339Nano, opts.From); err != nil {
+			return db.Query{}, fmt.Errorf("from: %v", err)
+		}
+	}
+	if opts.To != "" {
+		if q.To, err = time.Parse(time.RFC3339Nano, opts.To); err != nil {
+			return db.Query{}, fmt.Errorf("to: %v", err)
+		}
+	}
+	return q, nil
+}
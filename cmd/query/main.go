@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"tradesim/cmd/query/internal"
+)
+
+var (
+	help                 bool
+	in, format           string
+	trader, item         string
+	from, to             string
+	minHeight, maxHeight int
+	offset, limit        int
+)
+
+func init() {
+	flag.BoolVar(&help, "h", false, "")
+	flag.BoolVar(&help, "help", false, "print description and available command options")
+	flag.StringVar(&in, "i", "", "path to blockchain file or simulation output file")
+	flag.StringVar(&format, "f", internal.FormatDB, "input file format: db or ledger")
+	flag.StringVar(&trader, "trader", "", "trader id of the credit or debit party")
+	flag.StringVar(&item, "item", "", "item id")
+	flag.StringVar(&from, "from", "", "earliest block time, inclusive, in RFC 3339")
+	flag.StringVar(&to, "to", "", "latest block time, exclusive, in RFC 3339")
+	flag.IntVar(&minHeight, "min-height", 0, "lowest block height, inclusive")
+	flag.IntVar(&maxHeight, "max-height", 0, "highest block height, inclusive, or 0 for no bound")
+	flag.IntVar(&offset, "offset", 0, "number of matching transactions to skip")
+	flag.IntVar(&limit, "limit", 0, "maximum number of transactions to print, or 0 for all")
+}
+
+func main() {
+	flag.Parse()
+
+	if help {
+		fmt.Printf("query the transactions of a saved ledger\n\noptions\n")
+		flag.PrintDefaults()
+		return
+	}
+
+	opts := internal.Options{
+		InFilepath: in,
+		Format:     format,
+		TraderID:   trader,
+		ItemID:     item,
+		From:       from,
+		To:         to,
+		MinHeight:  minHeight,
+		MaxHeight:  maxHeight,
+		Offset:     offset,
+		Limit:      limit,
+	}
+	if err := internal.Query(opts, os.Stdout); err != nil {
+		fmt.Printf("error: %v\n", err)
+	}
+}
@@ -0,0 +1,47 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"tradesim/src/exchange"
+)
+
+var ErrDiverged = errors.New("replay diverged from recorded events")
+
+// Replay replays the trader messages of the event log at the provided
+// input path, writes the resulting ledger to the provided output path,
+// and writes every divergence from the recorded events to w.
+func Replay(inFilepath, outFilepath string, w io.Writer) error {
+	f, err := os.Open(inFilepath)
+	if err != nil {
+		return fmt.Errorf("%w: %v", exchange.ErrReplay, err)
+	}
+	defer f.Close()
+
+	events, err := exchange.ReadEvents(f)
+	if err != nil {
+		return err
+	}
+	e, err := exchange.NewReplayExchange(events)
+	if err != nil {
+		return err
+	}
+	divergences, err := e.Replay(events)
+	if err != nil {
+		return err
+	}
+	if err := e.DB.Write(outFilepath); err != nil {
+		return err
+	}
+
+	for _, d := range divergences {
+		fmt.Fprintln(w, d)
+	}
+	if len(divergences) > 0 {
+		return fmt.Errorf("%w: divergences=%d", ErrDiverged, len(divergences))
+	}
+	fmt.Fprintf(w, "replayed events=%d without divergence\n", len(events))
+	return nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"tradesim/cmd/replay/internal"
+)
+
+var (
+	help    bool
+	in, out string
+)
+
+func init() {
+	flag.BoolVar(&help, "h", false, "")
+	flag.BoolVar(&help, "help", false, "print description and available command options")
+	flag.StringVar(&in, "i", "", "path to event log file recorded by a simulation")
+	flag.StringVar(&out, "o", "", "path to replayed simulation output file")
+}
+
+func main() {
+	flag.Parse()
+
+	if help {
+		fmt.Printf("replay recorded trader messages against the exchange\n\noptions\n")
+		flag.PrintDefaults()
+		return
+	}
+
+	if err := internal.Replay(in, out, os.Stdout); err != nil {
+		fmt.Printf("error: %v\n", err)
+	}
+}
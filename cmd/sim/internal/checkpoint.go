@@ -0,0 +1,131 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"time"
+	"tradesim/src/db"
+	"tradesim/src/exchange"
+	"tradesim/src/prob"
+	"tradesim/src/trade"
+)
+
+var ErrCheckpoint = errors.New("failed to access simulation checkpoint")
+
+// keptSnapshots is the number of the latest snapshots kept in a
+// checkpoint directory, whose older snapshots are removed.
+const keptSnapshots = 3
+
+// Snapshot represents the state of a stopped simulation, from which
+// it can be resumed. The blockchain of a snapshot is stored alongside
+// it in a blockchain file.
+type Snapshot struct {
+	// Sequence orders the snapshots of a checkpoint directory.
+	Sequence  int       `json:"sequence"`
+	CreatedOn time.Time `json:"created_on"`
+	// Epoch is the simulated time the simulation started at, and
+	// Elapsed is the simulated duration at the time of the snapshot.
+	Epoch   time.Time     `json:"epoch"`
+	Elapsed time.Duration `json:"elapsed"`
+	// RNG is the state of the source of every random number and identifier.
+	RNG uint64 `json:"rng"`
+	// NonceSeed is the nonce the nonce search of every block starts from.
+	NonceSeed uint64                            `json:"nonce_seed"`
+	Items     map[string]trade.Item             `json:"items"`
+	Traders   map[string]trade.TraderCheckpoint `json:"traders"`
+	Regime    *prob.ChainCheckpoint             `json:"regime,omitempty"`
+	// Exchange is the state of the exchange, whose orders and
+	// timers are resumed along with it.
+	Exchange *exchange.Checkpoint `json:"exchange,omitempty"`
+}
+
+// snapshotPath returns the path to the snapshot with the provided
+// sequence in the provided directory, with the provided extension.
+func snapshotPath(dir string, sequence int, ext string) string {
+	return path.Join(dir, fmt.Sprintf("checkpoint-%06d.%s", sequence, ext))
+}
+
+// writeSnapshot writes the provided snapshot and blockchain to the
+// provided directory. The snapshot is only written once its blockchain
+// is, and each is only replaced once complete, so that a crash while
+// writing leaves the previous snapshot as the latest. Once the snapshot
+// is written, the snapshots before the latest keptSnapshots are removed.
+// Snapshots hold the key seeds of their traders, so only their owner may
+// read them or their directory.
+func writeSnapshot(dir string, s Snapshot, chain *db.Blockchain) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("%w: %v", ErrCheckpoint, err)
+	}
+	if err := chain.Save(snapshotPath(dir, s.Sequence, "db")); err != nil {
+		return fmt.Errorf("%w: %v", ErrCheckpoint, err)
+	}
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrCheckpoint, err)
+	}
+	p := snapshotPath(dir, s.Sequence, "json")
+	if err := ioutil.WriteFile(p+".tmp", b, 0600); err != nil {
+		return fmt.Errorf("%w: %v", ErrCheckpoint, err)
+	}
+	if err := os.Rename(p+".tmp", p); err != nil {
+		return fmt.Errorf("%w: %v", ErrCheckpoint, err)
+	}
+	return removeSnapshots(dir, s.Sequence-keptSnapshots)
+}
+
+// removeSnapshots removes the snapshots in the provided directory, and
+// their blockchains, whose sequence is at most the provided sequence.
+func removeSnapshots(dir string, sequence int) error {
+	paths, err := filepath.Glob(path.Join(dir, "checkpoint-*.json"))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrCheckpoint, err)
+	}
+	for _, p := range paths {
+		var seq int
+		if _, err := fmt.Sscanf(path.Base(p), "checkpoint-%06d.json", &seq); err != nil || seq > sequence {
+			continue
+		}
+		// The blockchain is removed last, so that a snapshot is never
+		// left without its blockchain.
+		for _, ext := range []string{"json", "db"} {
+			if err := os.Remove(snapshotPath(dir, seq, ext)); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("%w: %v", ErrCheckpoint, err)
+			}
+		}
+	}
+	return nil
+}
+
+// readLatestSnapshot returns the snapshot with the highest sequence in
+// the provided directory along with its blockchain, or a nil snapshot
+// if the directory has none.
+func readLatestSnapshot(dir string) (*Snapshot, *db.Blockchain, error) {
+	paths, err := filepath.Glob(path.Join(dir, "checkpoint-*.json"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrCheckpoint, err)
+	}
+	if len(paths) == 0 {
+		return nil, nil, nil
+	}
+	// Sequences are zero-padded, so the latest sorts last.
+	sort.Strings(paths)
+	b, err := ioutil.ReadFile(paths[len(paths)-1])
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrCheckpoint, err)
+	}
+	var s Snapshot
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, nil, fmt.Errorf("%w: %s: %v", ErrCheckpoint, paths[len(paths)-1], err)
+	}
+	chain, err := db.Load(snapshotPath(dir, s.Sequence, "db"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrCheckpoint, err)
+	}
+	return &s, chain, nil
+}
@@ -2,40 +2,480 @@ package internal
 
 import (
 	"context"
+	"crypto/ed25519"
 	"errors"
-
+	"fmt"
+	"net"
+	"os"
 	"time"
+	"tradesim/src/agent"
+	"tradesim/src/db"
+	"tradesim/src/exchange"
+	"tradesim/src/fix"
+	"tradesim/src/network"
+	"tradesim/src/prob"
+	"tradesim/src/sim/api"
 	"tradesim/src/sim/config"
+	"tradesim/src/trade"
 
-	"golang.org/x/sync/errgroup"
+	"github.com/google/uuid"
 )
 
+// networkBuffer is the number of exchange events buffered for the
+// simulated ledger network, beyond which it has fallen behind.
+const networkBuffer = 1 << 12
+
 var ErrSim = errors.New("failed to run simulation")
 
-func Simulate(inFilepath, outFilepath string) error {
-	cfg, err := config.NewSimConfig(inFilepath)
+// Options represents the options of a simulation run.
+type Options struct {
+	// InFilepath is the path to the simulation configuration file.
+	InFilepath string
+	// OutFilepath is the path to the simulation output file.
+	OutFilepath string
+	// RegimeFilepath is the path the state path of the regime chain is
+	// written to; if empty, or without a regime chain, it isn't written.
+	RegimeFilepath string
+	// MiningFilepath is the path the mining statistics of the
+	// blockchain are written to; if empty, they aren't written.
+	MiningFilepath string
+	// NetworkFilepath is the path the report of the simulated ledger
+	// network is written to, which is required if it has any nodes.
+	NetworkFilepath string
+	// OrdersFilepath is the path the lifecycle of every order, a line
+	// per execution report, and the number of execution reports of every
+	// type are written to; if empty, they aren't written.
+	OrdersFilepath string
+	// LogFilepath is the path to the event log of every message
+	// passing through the exchange; if empty, no events are logged.
+	LogFilepath string
+	// DBFilepath is the path to the blockchain file every block is
+	// persisted to, and that is resumed from if it exists; if empty,
+	// the blockchain is only kept in memory.
+	DBFilepath string
+	// CheckpointDir is the directory a snapshot of the simulation is
+	// written to every CheckpointInterval; if empty, no snapshots are
+	// written.
+	CheckpointDir      string
+	CheckpointInterval time.Duration
+	// Resume resumes the simulation from the latest snapshot in
+	// CheckpointDir, if it has one, for the remainder of its duration.
+	Resume bool
+	// Realtime paces the simulation by the wall clock, so that a simulated
+	// second takes a second. A simulation without a duration, or with
+	// agent or FIX traders, is always paced, and a simulation is run as
+	// fast as it's computed otherwise.
+	Realtime bool
+	// AgentAddr is the address external agents connect to in order
+	// to drive the traders configured as agents, which is required
+	// if any trader is.
+	AgentAddr string
+	// FIXAddr is the address FIX clients connect to in order to drive
+	// the traders configured as FIX traders, which is required if any
+	// trader is.
+	FIXAddr string
+	// HTTPAddr is the address the simulation API is served on
+	// while the simulation runs; if empty, it isn't served.
+	HTTPAddr string
+}
+
+func Simulate(opts Options) (err error) {
+	cfg, err := config.NewSimConfig(opts.InFilepath)
 	if err != nil {
 		return err
 	}
+	if opts.CheckpointDir != "" && opts.CheckpointInterval <= 0 {
+		return fmt.Errorf("%w: checkpoint interval must be positive: got=%s", ErrSim, opts.CheckpointInterval)
+	}
+	if opts.Resume && opts.CheckpointDir == "" {
+		return fmt.Errorf("%w: resume requires a checkpoint directory", ErrSim)
+	}
+	if cfg.Seed != 0 {
+		prob.Rng.Seed(cfg.Seed)
+		uuid.SetRand(prob.Rng)
+	}
+
+	var snap *Snapshot
+	var chain *db.Blockchain
+	if opts.Resume {
+		if snap, chain, err = readLatestSnapshot(opts.CheckpointDir); err != nil {
+			return fmt.Errorf("%w: %v", ErrSim, err)
+		}
+	}
+	// The simulated time is the simulated duration elapsed since
+	// the epoch the simulation started at, which a resumed run
+	// keeps, and stamps every block and event.
+	epoch := cfg.Epoch.UTC()
+	if epoch.IsZero() {
+		epoch = time.Now().UTC()
+	}
+	if snap != nil {
+		epoch = snap.Epoch
+	}
 
 	items := config.ParseItems(cfg.Items)
-	traders := config.ParseTraders(cfg.Traders, items)
-	exchange := config.ParseExchange(cfg.Exchange, items, traders)
-
-	ctx := context.Background()
-	if cfg.Duration > 0 {
-		c, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.Duration)*time.Second)
-		ctx = c
-		defer cancel()
-	}
-	wg, c := errgroup.WithContext(ctx)
-	for _, t := range traders {
-		_t := t
-		wg.Go(func() error { return _t.Start(c) })
-	}
-	wg.Go(func() error { return exchange.Start(c) })
-	if err := wg.Wait(); err != nil && !errors.Is(err, context.DeadlineExceeded) {
+	regime := config.ParseRegime(cfg.Regime)
+	traders, err := config.ParseTraders(cfg.Traders, items, regime)
+	if err != nil {
 		return err
 	}
-	return exchange.DB.Write(outFilepath)
+	// A seeded simulation draws the keys of its traders, in the order
+	// they're configured, and the nonce seed of its blockchain, so that
+	// it signs and seals the same ledger on every run.
+	var nonceSeed uint64
+	if cfg.Seed != 0 {
+		for _, c := range cfg.Traders {
+			key := make([]byte, ed25519.SeedSize)
+			if _, err := prob.Rng.Read(key); err != nil {
+				return fmt.Errorf("%w: %v", ErrSim, err)
+			}
+			if err := traders[c.ID].SetKey(key); err != nil {
+				return fmt.Errorf("%w: %v", ErrSim, err)
+			}
+		}
+		nonceSeed = prob.Rng.Uint64()
+	}
+	if snap != nil {
+		// Items and traders keep the identities they had when the
+		// snapshot was taken, so the restored ledger refers to them.
+		for id, i := range snap.Items {
+			items[id] = i
+		}
+		for id, c := range snap.Traders {
+			if t, ok := traders[id]; ok {
+				if err := t.Restore(c); err != nil {
+					return fmt.Errorf("%w: %v", ErrSim, err)
+				}
+			}
+		}
+		if regime != nil && snap.Regime != nil {
+			if err := regime.Restore(*snap.Regime); err != nil {
+				return fmt.Errorf("%w: %v", ErrSim, err)
+			}
+		}
+		nonceSeed = snap.NonceSeed
+	}
+	var log *exchange.EventLog
+	if opts.LogFilepath != "" {
+		if log, err = exchange.OpenEventLog(opts.LogFilepath); err != nil {
+			return fmt.Errorf("%w: %v", ErrSim, err)
+		}
+		defer log.Close()
+	}
+	e, err := config.ParseExchange(cfg.Exchange, items, traders)
+	if err != nil {
+		return err
+	}
+	e.Log = log
+	var orders *os.File
+	if opts.OrdersFilepath != "" {
+		if orders, err = os.Create(opts.OrdersFilepath); err != nil {
+			return fmt.Errorf("%w: %v", ErrSim, err)
+		}
+		defer orders.Close()
+		e.Lifecycle = orders
+	}
+	e.DB = db.NewBlockchainAt(epoch)
+	if chain != nil {
+		e.DB = chain
+	}
+	if opts.DBFilepath != "" {
+		// A resumed blockchain replaces any blocks appended
+		// to the blockchain file after its snapshot was taken.
+		if chain != nil {
+			if err := chain.Save(opts.DBFilepath); err != nil {
+				return fmt.Errorf("%w: %v", ErrSim, err)
+			}
+		}
+		chain, err := db.OpenAt(opts.DBFilepath, epoch)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrSim, err)
+		}
+		defer chain.Close()
+		e.DB = chain
+	}
+	// The difficulties of the blocks of a reopened
+	// blockchain are verified by its proof of work.
+	if err := e.DB.SetProofOfWork(config.ParseProofOfWork(cfg.Mining)); err != nil {
+		return fmt.Errorf("%w: %v", ErrSim, err)
+	}
+	if cfg.Seed != 0 || snap != nil {
+		e.DB.SetNonceSeed(nonceSeed)
+	}
+	if err := e.RegisterKeys(); err != nil {
+		return fmt.Errorf("%w: %v", ErrSim, err)
+	}
+
+	// Agent and FIX traders are driven by their clients rather than
+	// their processes, and are external to the simulation.
+	var simulated, external []*trade.Trader
+	agents := make(map[string]*trade.Trader)
+	fixes := make(map[string]*trade.Trader)
+	for _, c := range cfg.Traders {
+		t, ok := traders[c.ID]
+		switch {
+		case !ok:
+		case c.Agent:
+			agents[c.ID] = t
+			external = append(external, t)
+		case c.FIX:
+			fixes[c.ID] = t
+			external = append(external, t)
+		default:
+			simulated = append(simulated, t)
+		}
+	}
+	if len(agents) > 0 && opts.AgentAddr == "" {
+		return fmt.Errorf("%w: agent traders require an agent address", ErrSim)
+	}
+	if len(fixes) > 0 && opts.FIXAddr == "" {
+		return fmt.Errorf("%w: fix traders require a fix address", ErrSim)
+	}
+	if opts.CheckpointDir != "" && len(external) > 0 {
+		return fmt.Errorf("%w: agent and fix traders can't be checkpointed", ErrSim)
+	}
+	sim, err := exchange.NewSimulation(e, simulated, external, regime, epoch)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrSim, err)
+	}
+	sequence := 0
+	if snap != nil {
+		sequence = snap.Sequence
+		sim.SetElapsed(snap.Elapsed)
+		if snap.Exchange != nil {
+			if err := sim.Restore(*snap.Exchange); err != nil {
+				return fmt.Errorf("%w: %v", ErrSim, err)
+			}
+		}
+	}
+
+	// The simulation runs until it's done, or a server it serves fails.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	duration := time.Duration(cfg.Duration) * time.Second
+	// Pacing follows the configured duration rather than the duration of
+	// a checkpointed run, so that checkpoints don't change it.
+	sim.Paced = opts.Realtime || len(external) > 0 || duration == 0
+	clk := newClock(sim, duration, regime)
+	if cfg.Network.Nodes > 0 {
+		if opts.NetworkFilepath == "" {
+			return fmt.Errorf("%w: network nodes require a network filepath", ErrSim)
+		}
+		netCfg := config.ParseNetwork(cfg.Network)
+		netCfg.Genesis = epoch
+		netCfg.ProofOfWork = config.ParseProofOfWork(cfg.Mining)
+		netCfg.Keys = make(map[uuid.UUID]ed25519.PublicKey, len(traders))
+		for _, t := range traders {
+			netCfg.Keys[t.ID] = t.PublicKey()
+		}
+		stop, err := startNetwork(netCfg, e, opts.NetworkFilepath)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrSim, err)
+		}
+		defer func() {
+			if serr := stop(); serr != nil {
+				err = fmt.Errorf("%w: %v", ErrSim, serr)
+			}
+		}()
+	}
+	if opts.AgentAddr != "" {
+		ln, err := net.Listen("tcp", opts.AgentAddr)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrSim, err)
+		}
+		stop := serve(ctx, cancel, func(ctx context.Context) error {
+			return agent.NewServer(agents).Serve(ctx, ln)
+		})
+		defer func() {
+			if serr := stop(); serr != nil {
+				err = fmt.Errorf("%w: %v", ErrSim, serr)
+			}
+		}()
+	}
+	if opts.FIXAddr != "" {
+		ln, err := net.Listen("tcp", opts.FIXAddr)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrSim, err)
+		}
+		stop := serve(ctx, cancel, func(ctx context.Context) error {
+			return fix.NewGateway(e, fixes).Serve(ctx, ln)
+		})
+		defer func() {
+			if serr := stop(); serr != nil {
+				err = fmt.Errorf("%w: %v", ErrSim, serr)
+			}
+		}()
+	}
+
+	if opts.HTTPAddr != "" {
+		ln, err := net.Listen("tcp", opts.HTTPAddr)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrSim, err)
+		}
+		stop := serve(ctx, cancel, func(ctx context.Context) error {
+			return api.NewServer(e, clk.status).Serve(ctx, ln)
+		})
+		defer func() {
+			if serr := stop(); serr != nil {
+				err = fmt.Errorf("%w: %v", ErrSim, serr)
+			}
+		}()
+	}
+	// The random numbers of a resumed run continue from the snapshot
+	// once every other random number of its setup has been drawn.
+	if snap != nil {
+		prob.Rng.SetState(snap.RNG)
+	}
+	clk.setRunning(true)
+	for duration == 0 || sim.Elapsed() < duration {
+		// A run stops at every checkpoint, in simulated time,
+		// and runs until it fails if it has no duration.
+		until := duration
+		if opts.CheckpointDir != "" && (until == 0 || sim.Elapsed()+opts.CheckpointInterval < until) {
+			until = sim.Elapsed() + opts.CheckpointInterval
+		}
+		if err := sim.Run(ctx, until); err != nil {
+			clk.setRunning(false)
+			if ctx.Err() != nil {
+				// A server failed, and its error is returned once it's stopped.
+				return fmt.Errorf("%w: %v", ErrSim, ctx.Err())
+			}
+			return fmt.Errorf("%w: %v", ErrSim, err)
+		}
+		if opts.CheckpointDir == "" {
+			continue
+		}
+
+		sequence++
+		s := Snapshot{
+			Sequence:  sequence,
+			CreatedOn: time.Now().UTC(),
+			Epoch:     epoch,
+			Elapsed:   sim.Elapsed(),
+			RNG:       prob.Rng.State(),
+			NonceSeed: e.DB.NonceSeed(),
+			Items:     items,
+			Traders:   make(map[string]trade.TraderCheckpoint, len(traders)),
+		}
+		for id, t := range traders {
+			c, err := t.Checkpoint()
+			if err != nil {
+				return fmt.Errorf("%w: %v", ErrSim, err)
+			}
+			s.Traders[id] = c
+		}
+		if regime != nil {
+			c := regime.Checkpoint()
+			s.Regime = &c
+		}
+		c, err := sim.Checkpoint()
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrSim, err)
+		}
+		s.Exchange = &c
+		if err := writeSnapshot(opts.CheckpointDir, s, e.DB); err != nil {
+			return err
+		}
+	}
+	clk.setRunning(false)
+
+	if err := e.DB.Write(opts.OutFilepath); err != nil {
+		return err
+	}
+	if opts.MiningFilepath != "" {
+		mining := e.DB.Mining()
+		if err := writeLine(opts.MiningFilepath, fmt.Sprintf("mining blocks=%d hashes=%d duration=%s hash rate=%f difficulty=%d",
+			mining.Blocks, mining.Hashes, mining.Duration, mining.HashRate(), mining.Difficulty)); err != nil {
+			return err
+		}
+	}
+	if orders != nil {
+		if _, err := orders.WriteString(e.Orders().String() + "\n"); err != nil {
+			return err
+		}
+	}
+	if regime != nil && opts.RegimeFilepath != "" {
+		return writeRegimePath(opts.RegimeFilepath, regime)
+	}
+	return nil
+}
+
+// serve serves the provided server in the background until the returned
+// function is called, which stops it and returns its error. If the server
+// fails before then, the provided cancel function is called, so that the
+// simulation stops.
+func serve(ctx context.Context, cancel context.CancelFunc, server func(context.Context) error) func() error {
+	ctx, stop := context.WithCancel(ctx)
+	errs := make(chan error, 1)
+	go func() {
+		err := server(ctx)
+		if err != nil {
+			cancel()
+		}
+		errs <- err
+	}()
+	return func() error {
+		stop()
+		return <-errs
+	}
+}
+
+// writeRegimePath writes the state path of the provided
+// regime chain to the file at the provided path.
+func writeRegimePath(filepath string, regime *prob.MarkovChain) error {
+	f, err := os.Create(filepath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	for _, s := range regime.Path() {
+		if _, err := f.WriteString(s.String() + "\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeLine writes the provided line to the file at the provided path.
+func writeLine(filepath, line string) error {
+	return os.WriteFile(filepath, []byte(line+"\n"), 0644)
+}
+
+// startNetwork collects the transactions of every block appended to the
+// provided exchange's blockchain as they're appended, at their simulated
+// time since the provided network's genesis, which is the simulation's
+// epoch. It returns a function that simulates the network receiving them,
+// and writes its report to the file at the provided path. The network is
+// only simulated once the simulation stops, since it draws from the
+// simulation's random numbers.
+func startNetwork(cfg network.Config, e *exchange.Exchange, filepath string) (func() error, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	sub := e.Subscribe(exchange.Filter{}, networkBuffer)
+	done := make(chan []network.Arrival, 1)
+	go func() {
+		var arrivals []network.Arrival
+		for ev := range sub.Events() {
+			if ev.Type != exchange.FeedBlock {
+				continue
+			}
+			for _, t := range ev.Block.Transactions {
+				arrivals = append(arrivals, network.Arrival{At: ev.Block.CreatedOn.Sub(cfg.Genesis), Transaction: t})
+			}
+		}
+		done <- arrivals
+	}()
+	return func() error {
+		e.Unsubscribe(sub)
+		arrivals := <-done
+		if dropped := sub.Dropped(); dropped > 0 {
+			return fmt.Errorf("%w: fell behind the exchange by %d events", network.ErrNetwork, dropped)
+		}
+		report, err := network.Simulate(cfg, arrivals)
+		if err != nil {
+			return err
+		}
+		return writeLine(filepath, report.String())
+	}, nil
 }
@@ -0,0 +1,158 @@
+package internal
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+	"tradesim/src/sim/config"
+
+	"gopkg.in/yaml.v3"
+)
+
+var simCfg = config.SimConfig{
+	Duration: 40,
+	Seed:     7,
+	Epoch:    time.Date(2024, 1, 2, 9, 30, 0, 0, time.UTC),
+	Items: []config.ItemConfig{
+		{ID: "1", Name: "a"},
+		{ID: "2", Name: "b"},
+	},
+	Traders: []config.TraderConfig{
+		{
+			ID:    "1",
+			Haves: []config.HaveConfig{{ItemID: "1", Price: 3, Quantity: 50}},
+			Wants: []config.WantConfig{{ItemID: "2", PriceMin: 4, PriceMax: 6, Quantity: 80}},
+			Process: config.ProcessConfig{
+				Type:    "bernoulli",
+				Clock:   config.ClockConfig{Frequency: 1},
+				Distrib: config.DistribConfig{Type: "uniform", Prob: 0.5},
+			},
+			Orders: config.OrdersConfig{Probability: 0.5, TimeInForce: "gtc"},
+		},
+		{
+			ID:    "2",
+			Haves: []config.HaveConfig{{ItemID: "2", Price: 5, Quantity: 30}},
+			Wants: []config.WantConfig{{ItemID: "1", PriceMin: 2, PriceMax: 4, Quantity: 50}},
+			Process: config.ProcessConfig{
+				Type: "poisson",
+				Rate: 0.5,
+			},
+			Orders: config.OrdersConfig{Probability: 0.5, TimeInForce: "ioc"},
+		},
+		{
+			ID: "3",
+			Haves: []config.HaveConfig{
+				{ItemID: "1", Price: 3.5, Quantity: 50},
+				{ItemID: "2", Price: 4.5, Quantity: 30},
+			},
+			Wants: []config.WantConfig{
+				{ItemID: "1", PriceMin: 3, PriceMax: 4, Quantity: 50},
+				{ItemID: "2", PriceMin: 4, PriceMax: 5, Quantity: 50},
+			},
+			Process: config.ProcessConfig{
+				Type:           "hawkes",
+				Baseline:       0.3,
+				Decay:          1,
+				BranchingRatio: 0.5,
+			},
+		},
+	},
+	Exchange: config.ExchangeConfig{
+		Markets: []config.MarketConfig{
+			{ItemID: "1", TraderIDs: []string{"1", "2", "3"}},
+			{ItemID: "2", TraderIDs: []string{"1", "2", "3"}},
+		},
+		Clock:        config.ClockConfig{Frequency: 1},
+		RequoteTicks: 3,
+	},
+}
+
+func TestSimulateResume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sim")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	b, err := yaml.Marshal(simCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := path.Join(dir, "sim.yaml")
+	if err := ioutil.WriteFile(in, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	uninterrupted := path.Join(dir, "uninterrupted.txt")
+	if err := Simulate(Options{InFilepath: in, OutFilepath: uninterrupted}); err != nil {
+		t.Fatal(err)
+	}
+	checkpoints := path.Join(dir, "checkpoints")
+	checkpointed := path.Join(dir, "checkpointed.txt")
+	opts := Options{
+		InFilepath:         in,
+		OutFilepath:        checkpointed,
+		CheckpointDir:      checkpoints,
+		CheckpointInterval: 7 * time.Second,
+	}
+	if err := Simulate(opts); err != nil {
+		t.Fatal(err)
+	}
+	// Only the latest snapshots are kept, and the run is
+	// resumed from the earliest of them.
+	paths, err := filepath.Glob(path.Join(checkpoints, "checkpoint-*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, actual := 2*keptSnapshots, len(paths); expected != actual {
+		t.Fatalf("expected %d checkpoint files: got=%v", expected, paths)
+	}
+	// Snapshots hold key seeds, so only their owner may read them.
+	modes := map[string]os.FileMode{checkpoints: 0700}
+	for _, p := range paths {
+		if filepath.Ext(p) == ".json" {
+			modes[p] = 0600
+		}
+	}
+	for p, expected := range modes {
+		info, err := os.Stat(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual := info.Mode().Perm(); expected != actual {
+			t.Errorf("expected mode of %s to be %v: got=%v", path.Base(p), expected, actual)
+		}
+	}
+	sort.Strings(paths)
+	for _, p := range paths[2:] {
+		if err := os.Remove(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	resumed := path.Join(dir, "resumed.txt")
+	opts.OutFilepath, opts.Resume = resumed, true
+	if err := Simulate(opts); err != nil {
+		t.Fatal(err)
+	}
+
+	want, err := ioutil.ReadFile(uninterrupted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Count(want, []byte("block created")) < 2 {
+		t.Fatalf("expected a ledger of trades: got=%s", want)
+	}
+	for _, p := range []string{checkpointed, resumed} {
+		got, err := ioutil.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("expected ledger of %s to equal the uninterrupted ledger: want=%s got=%s", path.Base(p), want, got)
+		}
+	}
+}
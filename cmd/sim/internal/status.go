@@ -0,0 +1,53 @@
+package internal
+
+import (
+	"sync"
+	"time"
+	"tradesim/src/exchange"
+	"tradesim/src/prob"
+	"tradesim/src/sim/api"
+)
+
+// clock reports the simulated duration elapsed by a simulation, which
+// runs between its checkpoints, so that its status can be read while it
+// runs.
+type clock struct {
+	startedOn time.Time
+	duration  time.Duration
+	sim       *exchange.Simulation
+	regime    *prob.MarkovChain
+	// mu guards running.
+	mu      sync.Mutex
+	running bool
+}
+
+func newClock(sim *exchange.Simulation, duration time.Duration, regime *prob.MarkovChain) *clock {
+	return &clock{
+		startedOn: time.Now().UTC(),
+		duration:  duration,
+		sim:       sim,
+		regime:    regime,
+	}
+}
+
+// setRunning marks whether the simulation is running.
+func (c *clock) setRunning(running bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.running = running
+}
+
+func (c *clock) status() api.Status {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := api.Status{
+		StartedOn: c.startedOn,
+		Duration:  c.duration.Seconds(),
+		Running:   c.running,
+		Elapsed:   c.sim.Elapsed().Seconds(),
+	}
+	if c.regime != nil {
+		s.Regime = c.regime.States()[c.regime.State()]
+	}
+	return s
+}
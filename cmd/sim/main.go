@@ -3,12 +3,18 @@ package main
 import (
 	"flag"
 	"fmt"
+	"time"
 	"tradesim/cmd/sim/internal"
 )
 
 var (
-	help    bool
-	in, out string
+	help                         bool
+	in, out, log, db, checkpoint string
+	regime, mining, netReport    string
+	orders                       string
+	checkpointInterval           int64
+	resume, realtime             bool
+	httpAddr, agentAddr, fixAddr string
 )
 
 func init() {
@@ -16,6 +22,19 @@ func init() {
 	flag.BoolVar(&help, "help", false, "print description and available command options")
 	flag.StringVar(&in, "i", "", "path to simulation configuration file")
 	flag.StringVar(&out, "o", "", "path to simulation output file")
+	flag.StringVar(&regime, "regime", "", "path to file to write the regime state path to")
+	flag.StringVar(&mining, "mining", "", "path to file to write the blockchain mining statistics to")
+	flag.StringVar(&netReport, "network", "", "path to file to write the simulated ledger network report to")
+	flag.StringVar(&orders, "orders", "", "path to file to write the lifecycle of every order and the order statistics to")
+	flag.StringVar(&log, "log", "", "path to event log file of every exchange message")
+	flag.StringVar(&db, "db", "", "path to blockchain file to persist blocks to and resume from")
+	flag.StringVar(&checkpoint, "checkpoint", "", "path to directory to write simulation snapshots to")
+	flag.Int64Var(&checkpointInterval, "checkpoint-interval", 60, "simulated seconds between simulation snapshots")
+	flag.StringVar(&httpAddr, "http", "", "address to serve simulation api on while running, such as :8080")
+	flag.StringVar(&agentAddr, "agents", "", "address to accept external trading agents on, such as :9000")
+	flag.StringVar(&fixAddr, "fix", "", "address to accept fix 4.4 clients on, such as :9878")
+	flag.BoolVar(&resume, "resume", false, "resume simulation from latest snapshot in checkpoint directory")
+	flag.BoolVar(&realtime, "realtime", false, "pace the simulation by the wall clock rather than running it as fast as possible; runs without a duration or with agents or fix traders are always paced")
 }
 
 func main() {
@@ -27,7 +46,25 @@ func main() {
 		return
 	}
 
-	if err := internal.Simulate(in, out); err != nil {
+	opts := internal.Options{
+		InFilepath:  in,
+		OutFilepath: out,
+		LogFilepath: log,
+		DBFilepath:  db,
+
+		RegimeFilepath:     regime,
+		MiningFilepath:     mining,
+		NetworkFilepath:    netReport,
+		OrdersFilepath:     orders,
+		CheckpointDir:      checkpoint,
+		CheckpointInterval: time.Duration(checkpointInterval) * time.Second,
+		Resume:             resume,
+		Realtime:           realtime,
+		HTTPAddr:           httpAddr,
+		AgentAddr:          agentAddr,
+		FIXAddr:            fixAddr,
+	}
+	if err := internal.Simulate(opts); err != nil {
 		fmt.Printf("error: %v\n", err)
 	}
 }
@@ -0,0 +1,387 @@
+// Package agent connects external trading agents to an exchange over TCP.
+//
+// An agent drives one of the exchange's traders in place of its process,
+// using a protocol of JSON messages, one per line, in both directions.
+// Every message is an object with a type, and a field named after its
+// type with its payload.
+//
+// An agent first sends a hello message with the configuration ID of the
+// trader it drives, which the server answers with a welcome message of
+// the trader's ID, haves and wants, or an error message:
+//
+//     -> {"type":"hello","hello":{"trader_id":"1"}}
+//     <- {"type":"welcome","welcome":{"trader_id":"<uuid>","haves":[...],"wants":[...]}}
+//
+// The agent may then send requests for items it wants, responses to
+// requests it receives, and choices of the responses to its requests,
+// whose payloads are trade.Request and trade.Response values:
+//
+//     -> {"type":"request","request":{...}}
+//     -> {"type":"response","response":{...}}
+//     -> {"type":"choice","choice":{...}}
+//
+// and receives the requests of the traders in its markets, the
+// responses to its own requests, and the execution reports of its
+// orders, whose payloads are trade.ExecutionReport values:
+//
+//     <- {"type":"request","request":{...}}
+//     <- {"type":"responses","responses":[...]}
+//     <- {"type":"report","report":{...}}
+//
+// A request with a Type of market or limit is an order, which the
+// exchange matches against the quotes of the other traders of its
+// market: a buy order against their asks, and a sell order against their
+// bids. An order must have a TimeInForce, or it's rejected. The responses
+// to an order are the fills the exchange allocated to it, which are
+// executed once the agent chooses them. An order may be canceled, or its
+// quantity and limit price replaced before it's matched, by its request
+// ID or order ID, whose payloads are trade.Cancel and trade.Replace
+// values, and which are answered by execution reports:
+//
+//     -> {"type":"cancel","cancel":{"OrderID":"<uuid>"}}
+//     -> {"type":"replace","replace":{"OrderID":"<uuid>","Quantity":2,"Price":1.5}}
+//
+// An order of a market in an auction is collected for the auction rather
+// than quoted. An auction crosses buy and sell orders at a single price,
+// and delivers every cross to its seller as a response to the buy order,
+// which the seller signs by sending it back as a response, after which
+// it's delivered to the buyer to be chosen.
+//
+// The trader IDs of the messages an agent sends are set to its trader's,
+// and its responses and choices are signed with its trader's key, so
+// that an agent can't act as another trader. A choice executes the fill
+// quantity of the response's quote, which is its bid for a sell request
+// and its ask otherwise, or all of it if the fill is 0, and is only
+// executed if the response is signed by its responder. An invalid message
+// is answered with an error message, and the connection is kept open:
+//
+//     <- {"type":"error","error":"..."}
+//
+// Only one agent may drive a trader at a time. Messages to a trader
+// without a connected agent are discarded.
+package agent
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+	"tradesim/src/trade"
+
+	"github.com/google/uuid"
+)
+
+// MessageType represents the type of a protocol message.
+type MessageType string
+
+const (
+	MessageHello     MessageType = "hello"
+	MessageWelcome   MessageType = "welcome"
+	MessageRequest   MessageType = "request"
+	MessageResponse  MessageType = "response"
+	MessageResponses MessageType = "responses"
+	MessageChoice    MessageType = "choice"
+	MessageReport    MessageType = "report"
+	MessageCancel    MessageType = "cancel"
+	MessageReplace   MessageType = "replace"
+	MessageError     MessageType = "error"
+)
+
+const (
+	// writeTimeout bounds how long a message to an agent may take to
+	// write, after which the agent is disconnected, so that a slow agent
+	// can't block the exchange.
+	writeTimeout = 5 * time.Second
+	// maxMessageLen bounds the length of a message from an agent.
+	maxMessageLen = 1 << 20
+)
+
+var ErrAgent = errors.New("failed to serve trading agents")
+
+// Message represents a protocol message, with the field of its type set.
+type Message struct {
+	Type      MessageType            `json:"type"`
+	Hello     *Hello                 `json:"hello,omitempty"`
+	Welcome   *Welcome               `json:"welcome,omitempty"`
+	Request   *trade.Request         `json:"request,omitempty"`
+	Response  *trade.Response        `json:"response,omitempty"`
+	Responses trade.Responses        `json:"responses,omitempty"`
+	Choice    *trade.Response        `json:"choice,omitempty"`
+	Report    *trade.ExecutionReport `json:"report,omitempty"`
+	Cancel    *trade.Cancel          `json:"cancel,omitempty"`
+	Replace   *trade.Replace         `json:"replace,omitempty"`
+	Error     string                 `json:"error,omitempty"`
+}
+
+// Hello represents the first message of an agent,
+// with the configuration ID of the trader it drives.
+type Hello struct {
+	TraderID string `json:"trader_id"`
+}
+
+// Welcome represents the answer to an agent's hello message.
+type Welcome struct {
+	TraderID uuid.UUID    `json:"trader_id"`
+	Haves    []trade.Have `json:"haves"`
+	Wants    []trade.Want `json:"wants"`
+}
+
+// Server represents a TCP server of agents for a set of traders.
+type Server struct {
+	// slots are the traders that agents may drive, by configuration ID.
+	slots map[string]*slot
+}
+
+// slot represents a trader and the connection of the agent driving it.
+type slot struct {
+	trader *trade.Trader
+	// mu guards conn.
+	mu   sync.Mutex
+	conn *conn
+}
+
+// conn represents the connection of an agent.
+type conn struct {
+	c net.Conn
+	// mu serializes writes to enc.
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewServer returns a server of agents for the provided traders,
+// by configuration ID. The traders must be simulated as external traders.
+func NewServer(traders map[string]*trade.Trader) *Server {
+	s := &Server{slots: make(map[string]*slot, len(traders))}
+	for id, t := range traders {
+		s.slots[id] = &slot{trader: t}
+	}
+	return s
+}
+
+// Serve accepts agents on the provided listener, and delivers the
+// messages to their traders, until the provided context is done.
+func (s *Server) Serve(ctx context.Context, ln net.Listener) error {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for _, sl := range s.slots {
+		_sl := sl
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_sl.deliver(ctx)
+		}()
+	}
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("%w: %v", ErrAgent, err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.handle(ctx, c)
+		}()
+	}
+}
+
+// handle serves the agent of the provided connection until
+// it disconnects or the provided context is done.
+func (s *Server) handle(ctx context.Context, c net.Conn) {
+	defer c.Close()
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.Close()
+		case <-stop:
+		}
+	}()
+
+	cn := &conn{c: c, enc: json.NewEncoder(c)}
+	scanner := bufio.NewScanner(c)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxMessageLen)
+	if !scanner.Scan() {
+		return
+	}
+	sl, err := s.hello(scanner.Bytes())
+	if err != nil {
+		cn.write(Message{Type: MessageError, Error: err.Error()})
+		return
+	}
+	if !sl.claim(cn) {
+		cn.write(Message{Type: MessageError, Error: "trader already driven by an agent"})
+		return
+	}
+	defer sl.release(cn)
+
+	haves, wants := sl.trader.Holdings()
+	cn.write(Message{Type: MessageWelcome, Welcome: &Welcome{TraderID: sl.trader.ID, Haves: haves, Wants: wants}})
+	for scanner.Scan() {
+		var m Message
+		if err := json.Unmarshal(scanner.Bytes(), &m); err != nil {
+			cn.write(Message{Type: MessageError, Error: fmt.Sprintf("malformed message: %v", err)})
+			continue
+		}
+		if err := sl.send(ctx, m); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			cn.write(Message{Type: MessageError, Error: err.Error()})
+		}
+	}
+}
+
+// hello returns the slot of the trader of the provided hello message.
+func (s *Server) hello(line []byte) (*slot, error) {
+	var m Message
+	if err := json.Unmarshal(line, &m); err != nil {
+		return nil, fmt.Errorf("malformed message: %v", err)
+	}
+	if m.Type != MessageHello || m.Hello == nil {
+		return nil, fmt.Errorf("unexpected message: expected=%s got=%s", MessageHello, m.Type)
+	}
+	sl, ok := s.slots[m.Hello.TraderID]
+	if !ok {
+		return nil, fmt.Errorf("no agent trader found: %s", m.Hello.TraderID)
+	}
+	return sl, nil
+}
+
+// claim connects the provided connection to the slot,
+// unless another connection is connected.
+func (sl *slot) claim(c *conn) bool {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	if sl.conn != nil {
+		return false
+	}
+	sl.conn = c
+	return true
+}
+
+// release disconnects the provided connection from the slot.
+func (sl *slot) release(c *conn) {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	if sl.conn == c {
+		sl.conn = nil
+	}
+}
+
+func (sl *slot) current() *conn {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	return sl.conn
+}
+
+// send sends the provided message of the slot's agent to the exchange.
+func (sl *slot) send(ctx context.Context, m Message) error {
+	t := sl.trader
+	switch {
+	case m.Type == MessageRequest && m.Request != nil:
+		r := *m.Request
+		r.TraderID = t.ID
+		if r.ID == uuid.Nil {
+			r.ID = uuid.New()
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case t.RequestSend <- r:
+		}
+	case m.Type == MessageResponse && m.Response != nil:
+		r := *m.Response
+		r.TraderID = t.ID
+		if r.ID == uuid.Nil {
+			r.ID = uuid.New()
+		}
+		t.SignQuote(&r)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case t.ResponseSend <- r:
+		}
+	case m.Type == MessageChoice && m.Choice != nil:
+		c := *m.Choice
+		c.Request.TraderID = t.ID
+		t.SignChoice(&c)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case t.Choice <- c:
+		}
+	case m.Type == MessageCancel && m.Cancel != nil:
+		c := *m.Cancel
+		c.TraderID = t.ID
+		if c.ID == uuid.Nil {
+			c.ID = uuid.New()
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case t.CancelSend <- c:
+		}
+	case m.Type == MessageReplace && m.Replace != nil:
+		r := *m.Replace
+		r.TraderID = t.ID
+		if r.ID == uuid.Nil {
+			r.ID = uuid.New()
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case t.ReplaceSend <- r:
+		}
+	default:
+		return fmt.Errorf("unexpected message: type=%s", m.Type)
+	}
+	return nil
+}
+
+// deliver writes the messages delivered to the slot's trader to
+// its agent, or discards them if it has none, until the provided
+// context is done.
+func (sl *slot) deliver(ctx context.Context) {
+	t := sl.trader
+	for {
+		var m Message
+		select {
+		case <-ctx.Done():
+			return
+		case r := <-t.RequestRecv:
+			m = Message{Type: MessageRequest, Request: &r}
+		case resps := <-t.ResponseRecv:
+			m = Message{Type: MessageResponses, Responses: resps}
+		case rep := <-t.ReportRecv:
+			m = Message{Type: MessageReport, Report: &rep}
+		}
+		if c := sl.current(); c != nil {
+			if err := c.write(m); err != nil {
+				// A slow or broken agent is disconnected
+				// rather than blocking the exchange.
+				c.c.Close()
+			}
+		}
+	}
+}
+
+// write writes the provided message to the connection.
+func (c *conn) write(m Message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.c.SetWriteDeadline(time.Now().Add(writeTimeout))
+	return c.enc.Encode(m)
+}
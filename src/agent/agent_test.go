@@ -0,0 +1,98 @@
+package agent
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+	"tradesim/src/trade"
+
+	"github.com/google/uuid"
+)
+
+// client represents the connection of a test agent.
+type client struct {
+	t       *testing.T
+	c       net.Conn
+	scanner *bufio.Scanner
+}
+
+func dial(t *testing.T, addr string) *client {
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	return &client{t: t, c: c, scanner: bufio.NewScanner(c)}
+}
+
+func (c *client) send(m Message) {
+	if err := json.NewEncoder(c.c).Encode(m); err != nil {
+		c.t.Fatalf("send %s: %v", m.Type, err)
+	}
+}
+
+func (c *client) recv() Message {
+	if !c.scanner.Scan() {
+		c.t.Fatalf("recv: %v", c.scanner.Err())
+	}
+	var m Message
+	if err := json.Unmarshal(c.scanner.Bytes(), &m); err != nil {
+		c.t.Fatalf("recv: %v", err)
+	}
+	return m
+}
+
+// TestAgent asserts that an agent drives its trader, receiving the
+// messages delivered to it and sending messages as the trader.
+func TestAgent(t *testing.T) {
+	item := trade.NewItem("a")
+	trader, err := trade.NewTrader(nil, []trade.Want{{Item: item, PriceMax: 2, Quantity: 1}}, nil)
+	if err != nil {
+		t.Fatalf("new trader: %v", err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error)
+	go func() { served <- NewServer(map[string]*trade.Trader{"1": trader}).Serve(ctx, ln) }()
+
+	c := dial(t, ln.Addr().String())
+	defer c.c.Close()
+	c.send(Message{Type: MessageHello, Hello: &Hello{TraderID: "1"}})
+	if m := c.recv(); m.Type != MessageWelcome || m.Welcome.TraderID != trader.ID || len(m.Welcome.Wants) != 1 {
+		t.Fatalf("welcome: expected: trader %s with one want actual: %+v", trader.ID, m)
+	}
+
+	other := dial(t, ln.Addr().String())
+	other.send(Message{Type: MessageHello, Hello: &Hello{TraderID: "1"}})
+	if m := other.recv(); m.Type != MessageError {
+		t.Errorf("second agent: expected: %s actual: %+v", MessageError, m)
+	}
+	other.c.Close()
+
+	// Requests sent by the agent are sent as the trader.
+	c.send(Message{Type: MessageRequest, Request: &trade.Request{TraderID: uuid.New(), Item: item, Quantity: 1}})
+	if r := <-trader.RequestSend; r.TraderID != trader.ID || r.ID == uuid.Nil {
+		t.Errorf("sent request: expected: trader %s with an id actual: %+v", trader.ID, r)
+	}
+	// Requests delivered to the trader are written to the agent.
+	req := trade.Request{ID: uuid.New(), TraderID: uuid.New(), Item: item, Quantity: 1}
+	trader.RequestRecv <- req
+	if m := c.recv(); m.Type != MessageRequest || *m.Request != req {
+		t.Errorf("delivered request: expected: %+v actual: %+v", req, m)
+	}
+	c.send(Message{Type: "unknown"})
+	if m := c.recv(); m.Type != MessageError {
+		t.Errorf("unknown message: expected: %s actual: %+v", MessageError, m)
+	}
+
+	cancel()
+	if err := <-served; err != nil {
+		t.Errorf("serve: %v", err)
+	}
+}
@@ -0,0 +1,222 @@
+// Package codec implements the primitives of the canonical binary encoding
+// of transactions, block headers and tree nodes, which is what they're
+// hashed, signed, stored and transported as.
+//
+// Every value is encoded as a fixed sequence of fields, without names or
+// separators, each of which is one of
+//
+//   - an unsigned integer: 1, 4 or 8 bytes, big-endian;
+//   - a float: the 8 bytes of its IEEE 754 bits, big-endian;
+//   - a bool: 1 byte, 0 or 1;
+//   - a UUID: its 16 bytes;
+//   - a time: its Unix seconds as 8 bytes, two's complement, followed by
+//     its nanoseconds as 4 bytes, both big-endian, and is decoded in UTC;
+//   - a byte string or string: its length as 4 bytes, big-endian,
+//     followed by its bytes.
+//
+// Since every variable-length field is prefixed with its length, no two
+// different values of a type have the same encoding. Every encoded value
+// starts with a version byte, so that its encoding can change.
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// maxLen bounds the length of a decoded byte string,
+// so that a corrupt length isn't allocated.
+const maxLen = 1 << 30
+
+var ErrDecode = errors.New("failed to decode")
+
+// Encoder appends the fields of an encoded value.
+type Encoder struct {
+	buf bytes.Buffer
+}
+
+// Bytes returns the encoded fields.
+func (e *Encoder) Bytes() []byte {
+	return e.buf.Bytes()
+}
+
+// Raw appends the provided bytes without a length prefix.
+func (e *Encoder) Raw(p []byte) {
+	e.buf.Write(p)
+}
+
+func (e *Encoder) Uint8(v uint8) {
+	e.buf.WriteByte(v)
+}
+
+func (e *Encoder) Uint32(v uint32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], v)
+	e.buf.Write(b[:])
+}
+
+func (e *Encoder) Uint64(v uint64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	e.buf.Write(b[:])
+}
+
+func (e *Encoder) Float64(v float64) {
+	e.Uint64(math.Float64bits(v))
+}
+
+func (e *Encoder) Bool(v bool) {
+	if v {
+		e.Uint8(1)
+	} else {
+		e.Uint8(0)
+	}
+}
+
+func (e *Encoder) UUID(id uuid.UUID) {
+	e.buf.Write(id[:])
+}
+
+func (e *Encoder) Time(t time.Time) {
+	e.Uint64(uint64(t.Unix()))
+	e.Uint32(uint32(t.Nanosecond()))
+}
+
+// Blob appends a byte string.
+func (e *Encoder) Blob(p []byte) {
+	e.Uint32(uint32(len(p)))
+	e.buf.Write(p)
+}
+
+// Text appends a string.
+func (e *Encoder) Text(s string) {
+	e.Uint32(uint32(len(s)))
+	e.buf.WriteString(s)
+}
+
+// Decoder reads the fields of an encoded value. Once a field fails to
+// decode, every later field decodes to its zero value, and Err returns
+// the first error.
+type Decoder struct {
+	p   []byte
+	err error
+}
+
+func NewDecoder(p []byte) *Decoder {
+	return &Decoder{p: p}
+}
+
+// Err returns the first error of the decoded fields.
+func (d *Decoder) Err() error {
+	return d.err
+}
+
+// Close returns the first error of the decoded fields,
+// or an error if any bytes weren't decoded.
+func (d *Decoder) Close() error {
+	if d.err == nil && len(d.p) > 0 {
+		d.err = fmt.Errorf("%w: %d trailing bytes", ErrDecode, len(d.p))
+	}
+	return d.err
+}
+
+// Fail fails the decoder with the provided error, if it hasn't failed.
+func (d *Decoder) Fail(err error) {
+	if d.err == nil {
+		d.err = err
+	}
+}
+
+// next returns the next n bytes, or nil if there aren't n bytes.
+func (d *Decoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n > len(d.p) {
+		d.err = fmt.Errorf("%w: unexpected end: need=%d have=%d", ErrDecode, n, len(d.p))
+		return nil
+	}
+	p := d.p[:n]
+	d.p = d.p[n:]
+	return p
+}
+
+func (d *Decoder) Uint8() uint8 {
+	if p := d.next(1); p != nil {
+		return p[0]
+	}
+	return 0
+}
+
+func (d *Decoder) Uint32() uint32 {
+	if p := d.next(4); p != nil {
+		return binary.BigEndian.Uint32(p)
+	}
+	return 0
+}
+
+func (d *Decoder) Uint64() uint64 {
+	if p := d.next(8); p != nil {
+		return binary.BigEndian.Uint64(p)
+	}
+	return 0
+}
+
+func (d *Decoder) Float64() float64 {
+	return math.Float64frombits(d.Uint64())
+}
+
+func (d *Decoder) Bool() bool {
+	switch v := d.Uint8(); v {
+	case 0:
+		return false
+	case 1:
+		return true
+	default:
+		d.Fail(fmt.Errorf("%w: bool: got=%d", ErrDecode, v))
+		return false
+	}
+}
+
+func (d *Decoder) UUID() uuid.UUID {
+	var id uuid.UUID
+	copy(id[:], d.next(len(id)))
+	return id
+}
+
+func (d *Decoder) Time() time.Time {
+	sec := int64(d.Uint64())
+	nsec := d.Uint32()
+	if nsec >= uint32(time.Second) {
+		d.Fail(fmt.Errorf("%w: time: nanoseconds: got=%d", ErrDecode, nsec))
+	}
+	if d.err != nil {
+		return time.Time{}
+	}
+	return time.Unix(sec, int64(nsec)).UTC()
+}
+
+// Blob decodes a byte string.
+func (d *Decoder) Blob() []byte {
+	n := d.Uint32()
+	if n > maxLen {
+		d.Fail(fmt.Errorf("%w: length: max=%d got=%d", ErrDecode, maxLen, n))
+		return nil
+	}
+	p := d.next(int(n))
+	if p == nil {
+		return nil
+	}
+	return append([]byte{}, p...)
+}
+
+// Text decodes a string.
+func (d *Decoder) Text() string {
+	return string(d.Blob())
+}
@@ -1,128 +1,450 @@
 package db
 
 import (
+	"context"
+	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/sha256"
 	"fmt"
 	"math/big"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 	"tradesim/src/trade"
+
+	"github.com/google/uuid"
 )
 
 // maxint64 is a pointer to the largest int64 value.
 var maxint64 = big.NewInt(int64(^uint64(0) >> 1))
 
+// cancelHashes is the number of hashes computed
+// between checks of whether mining is canceled.
+const cancelHashes = 1 << 12
+
 // block represents a block within a blockchain.
 type block struct {
+	// version is the version of the block's encoding,
+	// which determines how its hashes are computed.
+	version uint8
 	// createdOn represents the time of the block's initialization.
 	createdOn time.Time
 	// prev represents a hash pointer to the previous block in the blockchain.
 	prev string
+	// nonce is the nonce string hashed into the block's hash pointer.
+	nonce string
+	// difficulty is the number of leading zero bits of the block's hash.
+	difficulty int
 	// prevP is a pointer to the previous block in the blockchain.
 	prevP *block
 	// txnTree is the hash tree of transactions stored in the block.
 	txnTree *Tree
+	// id is the hex encoding of the block's hash, which is set once it's
+	// sealed, and height is the number of blocks before it, work is the
+	// cumulative work of its branch, latest is the latest creation time
+	// of its branch, and next is the difficulty of its children, which
+	// are set once it's added to a blockchain.
+	id     string
+	height int
+	work   *big.Int
+	latest time.Time
+	next   int
 }
 
 // NewBlock returns a block initialized with
 // a transaction tree with the provided transaction.
 func NewBlock(txn *trade.Transaction) *block {
+	return NewBlockAt(txn, time.Now().UTC())
+}
+
+// NewBlockAt returns a block created on the provided simulated time,
+// initialized with a transaction tree with the provided transaction.
+func NewBlockAt(txn *trade.Transaction, at time.Time) *block {
+	return NewBlockOf([]*trade.Transaction{txn}, at)
+}
+
+// NewBlockOf returns a block created on the provided simulated time,
+// initialized with a transaction tree with the provided transactions.
+func NewBlockOf(txns []*trade.Transaction, at time.Time) *block {
 	t := NewTree()
-	t.Insert(txn)
+	for _, txn := range txns {
+		// The hashes of a new tree's nodes are
+		// hex encoded, so inserting can't fail.
+		_ = t.Insert(txn)
+	}
 	return &block{
-		createdOn: time.Now().UTC(),
+		version:   BlockVersion,
+		createdOn: at,
 		txnTree:   t,
 	}
 }
 
-// setPrev sets the block's hash pointer to the hash of
-// the previous block's initialization timestamp,
-// transaction tree root hash, and a high min-entropy nonce string.
-func (b *block) setPrev() bool {
+// setPrev sets the block's hash pointer to the hash of the previous
+// block, searching from the provided nonce for a nonce such that the
+// block's hash has at least the provided number of leading zero bits.
+// It returns the number of hashes computed in the search, which stops
+// once the provided context is done.
+func (b *block) setPrev(ctx context.Context, difficulty int, start uint64) (uint64, bool) {
 	// prev must only be set if the underlying
 	// previous pointer points to another block.
 	// The only block with a null previous pointer
 	// in a blockchain is the genesis block.
 	if b.prevP == nil {
-		return false
+		return 0, false
 	} else {
-		nonce, err := rand.Int(rand.Reader, maxint64)
-		if err != nil {
-			return false
+		b.difficulty = difficulty
+		b.height = b.prevP.height + 1
+		b.prev = b.prevP.id
+		nonce := start
+		for hashes := uint64(1); ; hashes++ {
+			b.nonce = strconv.FormatUint(nonce, 10)
+			hash, err := b.hash()
+			if err != nil {
+				return hashes, false
+			}
+			if meetsDifficulty(hash, difficulty) {
+				b.id = fmt.Sprintf("%x", hash)
+				return hashes, true
+			}
+			if hashes%cancelHashes == 0 && ctx.Err() != nil {
+				return hashes, false
+			}
+			nonce++
 		}
-		p := b.prevP
-		data := p.createdOn.String() + p.txnTree.Root.hash + nonce.String()
-		b.prev = fmt.Sprintf("%x", sha256.Sum256([]byte(data)))
-		return true
 	}
 }
 
-// Blockchain is an append-only, singly linked-list blockchain.
+// validPrev returns whether the block's hash pointer
+// is the hash pointer of the provided previous block.
+func (b *block) validPrev(p *block) bool {
+	return b.prev == p.id
+}
+
+// hash returns the hash of the block's header.
+func (b *block) hash() ([sha256.Size]byte, error) {
+	h := b.header()
+	return h.hash()
+}
+
+// Blockchain is a tree of blocks rooted at a genesis block, whose
+// heaviest branch is its canonical chain.
 //
-// A blockchain has the form
+// Every block points to its parent, and blocks may be added after any
+// block of the tree, forking branches that compete with the canonical
+// chain. The canonical chain is the heaviest branch by the blockchain's
+// fork choice, and when another branch becomes heavier, the blockchain
+// reorganizes to it, returning the transactions of the blocks it
+// disconnects to a pending pool. The canonical chain has the form
 //
 //     NULL <- [head] <- [block] <- ... <- [tail]
 //
-// where the head is the genesis block, and all blocks point towards it.
-// The only means of traversal is to move from the tail towards the head.
+// where the head is the genesis block and the tail is the tip of the
+// heaviest branch. The blocks of the canonical chain are iterated in
+// either direction with Forward and Backward, and looked up by height in
+// constant time, while the blocks of every branch are looked up by hash.
 //
 // The genesis block is the first block in a blockchain, with no transactions,
 // and has a hash pointer of 64 zeros.
+//
+// A blockchain is safe for concurrent use. Blocks are never modified
+// once appended, and readers see a consistent snapshot of the canonical
+// chain as of when they read it, so a blockchain can be read by any
+// number of readers while blocks are appended to it.
 type Blockchain struct {
-	// head is the first block in the blockchain.
+	// mu guards tail, chain, earliest, store and the block tree.
+	mu sync.RWMutex
+	// head is the genesis block, and tail is the
+	// last block of the canonical chain.
 	head *block
-	// tail is the last block in the blockchain.
 	tail *block
+	// chain are the blocks of the canonical chain by height,
+	// whose length is the number of blocks in the blockchain.
+	chain []*block
+	// earliest are the earliest creation times of the blocks of the
+	// canonical chain from each height to the tail, which never
+	// decrease with height.
+	earliest []time.Time
+	// store persists every appended block, if not nil.
+	store *store
+	// blocks are every block of the block tree by ID, and order
+	// are every block in the order they were added.
+	blocks map[string]*block
+	order  []*block
+	// pending are the transactions of the blocks disconnected from the
+	// canonical chain by reorganizations that aren't in it, and reorgs
+	// are every reorganization, in order.
+	pending []trade.Transaction
+	reorgs  []Reorg
+	// forkChoice chooses the canonical chain among the branches.
+	forkChoice ForkChoice
+	// keys are the registered public keys of traders by ID, which
+	// verify the signatures of the transactions of added blocks.
+	keys map[uuid.UUID]ed25519.PublicKey
+	// quotes are the blocks of the block tree with transactions of
+	// every quote by quote ID, which bound the quantity of the quote
+	// executed on every branch once keys are registered.
+	quotes map[uuid.UUID][]*block
+	// pow configures the proof of work of appended blocks,
+	// and mining accumulates its statistics.
+	pow    ProofOfWork
+	mining MiningStats
+	// nonceSeed is the high min-entropy nonce the nonce search of every
+	// sealed block starts from, so that blockchains of the same seed
+	// seal the same blocks identically.
+	nonceSeed uint64
 }
 
 // NewBlockchain returns a blockchain initialized with a genesis block.
 func NewBlockchain() *Blockchain {
+	return NewBlockchainAt(time.Now().UTC())
+}
+
+// NewBlockchainAt returns a blockchain initialized with a genesis block
+// created on the provided simulated time, so that blockchains created on
+// the same time share their genesis block, and can exchange blocks.
+func NewBlockchainAt(at time.Time) *Blockchain {
 	gen := &block{
-		createdOn: time.Now().UTC(),
+		version:   BlockVersion,
+		createdOn: at,
 		prev:      strings.Repeat("0", 64),
 		txnTree:   NewTree(),
 	}
-	return &Blockchain{head: gen, tail: gen}
+	// The genesis block's hash pointer and root hash
+	// are hex encoded, so hashing it can't fail.
+	hash, _ := gen.hash()
+	gen.id = fmt.Sprintf("%x", hash)
+	return newBlockchain(gen)
+}
+
+// newBlockchain returns a blockchain of the provided genesis block,
+// whose ID is set.
+func newBlockchain(gen *block) *Blockchain {
+	gen.work = blockWork(gen.difficulty)
+	gen.latest = gen.createdOn
+	return &Blockchain{
+		head:     gen,
+		tail:     gen,
+		chain:    []*block{gen},
+		earliest: []time.Time{gen.createdOn},
+		blocks:   map[string]*block{gen.id: gen},
+		order:    []*block{gen},
+		quotes:   make(map[uuid.UUID][]*block),
+		// A failed draw only costs the seed its entropy.
+		nonceSeed: randomNonce(),
+	}
 }
 
+// randomNonce returns a random nonce, or 0 if it can't be drawn.
+func randomNonce() uint64 {
+	n, err := rand.Int(rand.Reader, maxint64)
+	if err != nil {
+		return 0
+	}
+	return n.Uint64()
+}
+
+// Write writes a line for every block in the blockchain, from the tail
+// towards the head, to the file at the provided path.
+// Written blockchains can be read back as a ledger with ReadLedger.
 func (b *Blockchain) Write(filepath string) error {
 	f, err := os.Create(filepath)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
-	for curr := b.tail; curr != nil; {
-		if _, err := f.WriteString(curr.txnTree.String() + "\n"); err != nil {
+	for it := b.Backward(); it.Next(); {
+		curr := it.block()
+		line := fmt.Sprintf("block created on=%s %s\n", curr.createdOn.Format(time.RFC3339Nano), curr.txnTree)
+		if _, err := f.WriteString(line); err != nil {
 			return err
 		}
-		curr = curr.prevP
 	}
 	return nil
 }
 
-// Append appends a block to the tail-end of the blockchain.
+// Append appends a block to the tail-end of the blockchain, once it's
+// sealed with the blockchain's proof of work. A block with a transaction
+// whose signatures don't verify with the registered keys isn't appended,
+// nor is a block that executes more than a quote's quoted quantity.
+// If the blockchain was opened from a file, the block is persisted
+// to the file before it's appended.
 func (b *Blockchain) Append(block *block) bool {
-	tmp := b.tail
-	block.prevP = tmp
-	// If setting the block's hash pointer fails,
-	// the block's previous pointer is defensively set to null.
-	if ok := block.setPrev(); !ok {
+	return b.AppendContext(context.Background(), block) == nil
+}
+
+// AppendContext appends a block to the tail-end of the blockchain like
+// Append, but stops mining the block once the provided context is done,
+// returning its error. If the block isn't appended, an error is returned.
+func (b *Blockchain) AppendContext(ctx context.Context, block *block) error {
+	if err := b.verifyBlock(block); err != nil {
+		return err
+	}
+	for {
+		// The block is sealed without holding the lock, so that the
+		// blockchain can be read while it's mined, and is resealed
+		// if another block was appended in the meantime.
+		tail, _ := b.last()
+		hashes, elapsed, ok := b.seal(ctx, tail, block)
+		if err := ctx.Err(); err != nil {
+			return err
+		} else if !ok {
+			return fmt.Errorf("%w: failed to seal block", ErrStore)
+		}
+		b.mu.Lock()
+		if b.tail != tail || block.difficulty != tail.next {
+			b.mu.Unlock()
+			continue
+		}
+		_, err := b.add(block)
+		if err == nil {
+			b.mined(hashes, elapsed)
+		}
+		b.mu.Unlock()
+		return err
+	}
+}
+
+// seal links the provided block to the provided parent, and seals it
+// with the difficulty of the parent's children, until the provided
+// context is done. If setting the block's hash pointer fails, the
+// block's previous pointer is defensively set to null.
+func (b *Blockchain) seal(ctx context.Context, parent, block *block) (uint64, time.Duration, bool) {
+	b.mu.RLock()
+	difficulty, nonce := parent.next, b.nonceSeed
+	b.mu.RUnlock()
+	block.prevP = parent
+	start := time.Now()
+	hashes, ok := block.setPrev(ctx, difficulty, nonce)
+	if !ok {
 		block.prevP = nil
-		return false
-	} else {
-		b.tail = block
-		return true
 	}
+	return hashes, time.Since(start), ok
+}
+
+// add persists the provided sealed block, if the blockchain was opened
+// from a file, and adds it to the block tree, returning the reorganization
+// it caused, if any. A block that executes more than the quoted quantity
+// of a quote on its branch isn't added, and ErrQuote is returned.
+// The caller must hold the lock. If persisting the block fails, its
+// previous pointer is defensively set to null.
+func (b *Blockchain) add(block *block) (*Reorg, error) {
+	if err := b.verifyQuotes(block.txnTree.Transactions(), block.prevP); err != nil {
+		return nil, err
+	}
+	if b.store != nil {
+		if err := b.store.append(block); err != nil {
+			block.prevP = nil
+			return nil, err
+		}
+	}
+	reorg := b.insert(block)
+	b.mining.Difficulty = b.tail.next
+	if reorg != nil {
+		b.reorgs = append(b.reorgs, *reorg)
+	}
+	return reorg, nil
+}
+
+// mined adds a block mined with the provided number of hashes in the
+// provided duration to the mining statistics. The caller must hold the lock.
+func (b *Blockchain) mined(hashes uint64, elapsed time.Duration) {
+	b.mining.Blocks++
+	b.mining.Hashes += hashes
+	b.mining.Duration += elapsed
+}
+
+// Close closes the file the blockchain was opened from, if any.
+func (b *Blockchain) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.store == nil {
+		return nil
+	}
+	err := b.store.close()
+	b.store = nil
+	return err
 }
 
 // Len returns the number of blocks in the blockchain.
 func (b *Blockchain) Len() int {
-	count := 0
-	for curr := b.tail; curr != nil; {
-		count++
-		curr = curr.prevP
+	_, length := b.last()
+	return length
+}
+
+// last returns the last block in the blockchain,
+// and the number of blocks up to and including it.
+func (b *Blockchain) last() (*block, int) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.tail, len(b.chain)
+}
+
+// BlockInfo represents a block within a blockchain.
+type BlockInfo struct {
+	BlockHeader
+	Hash         string              `json:"hash"`
+	Transactions []trade.Transaction `json:"transactions"`
+}
+
+func newBlockInfo(b *block) BlockInfo {
+	txns := b.txnTree.Transactions()
+	info := BlockInfo{
+		BlockHeader:  b.header(),
+		Hash:         b.id,
+		Transactions: make([]trade.Transaction, len(txns)),
+	}
+	for i, t := range txns {
+		info.Transactions[i] = *t
+	}
+	return info
+}
+
+// Height returns the height of the last block in the blockchain.
+func (b *Blockchain) Height() int {
+	return b.Len() - 1
+}
+
+// Info returns the block as it was appended to a blockchain,
+// which doesn't change as later blocks are appended.
+func (b *block) Info() BlockInfo {
+	return newBlockInfo(b)
+}
+
+// Block returns the block at the provided height,
+// and whether the blockchain has a block at the height.
+func (b *Blockchain) Block(height int) (BlockInfo, bool) {
+	blk, ok := b.blockAt(height)
+	if !ok {
+		return BlockInfo{}, false
+	}
+	return newBlockInfo(blk), true
+}
+
+// blockAt returns the block of the canonical chain at the provided
+// height, and whether the blockchain has a block at the height.
+func (b *Blockchain) blockAt(height int) (*block, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if height < 0 || height >= len(b.chain) {
+		return nil, false
+	}
+	return b.chain[height], true
+}
+
+// Last returns the last block in the blockchain.
+func (b *Blockchain) Last() BlockInfo {
+	tail, _ := b.last()
+	return newBlockInfo(tail)
+}
+
+// Recent returns up to the provided number of
+// the last blocks in the blockchain, from the tail.
+func (b *Blockchain) Recent(n int) []BlockInfo {
+	blocks := make([]BlockInfo, 0, n)
+	for it := b.Backward(); len(blocks) < n && it.Next(); {
+		blocks = append(blocks, it.Block())
 	}
-	return count
+	return blocks
 }
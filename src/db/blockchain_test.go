@@ -1,8 +1,14 @@
 package db
 
 import (
+	"fmt"
+	"path"
+	"sync"
 	"testing"
+	"time"
 	"tradesim/src/trade"
+
+	"github.com/google/uuid"
 )
 
 // TestLen asserts that a new blockchain with one new block appended has length 2.
@@ -15,3 +21,175 @@ func TestLen(t *testing.T) {
 		t.Errorf("blockchain length: expected: %d actual: %d", expected, actual)
 	}
 }
+
+// TestWriteReadLedger asserts that the transactions of a written
+// blockchain are read back as a ledger in the order they were appended.
+func TestWriteReadLedger(t *testing.T) {
+	b := NewBlockchain()
+	txns := []trade.Transaction{
+		{
+			ID:     uuid.New(),
+			Credit: trade.TransactionRecord{TraderID: uuid.New(), Item: trade.NewItem("a b"), Price: 1.5, Quantity: 2},
+			Debit:  trade.TransactionRecord{TraderID: uuid.New(), Item: trade.NewItem("a b"), Price: 1.5, Quantity: 2},
+		},
+		{
+			ID:     uuid.New(),
+			Credit: trade.TransactionRecord{TraderID: uuid.New(), Item: trade.NewItem("c"), Price: 3, Quantity: 0.25},
+			Debit:  trade.TransactionRecord{TraderID: uuid.New(), Item: trade.NewItem("c"), Price: 3, Quantity: 0.25},
+		},
+	}
+	for i := range txns {
+		b.Append(NewBlock(&txns[i]))
+	}
+
+	filepath := path.Join(t.TempDir(), "ledger.txt")
+	if err := b.Write(filepath); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	entries, err := ReadLedger(filepath)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if expected, actual := len(txns), len(entries); expected != actual {
+		t.Fatalf("ledger length: expected: %d actual: %d", expected, actual)
+	}
+	for i, e := range entries {
+		if expected, actual := txns[i].Hash(), e.Transaction.Hash(); expected != actual {
+			t.Errorf("transaction %d hash: expected: %s actual: %s", i, expected, actual)
+		}
+	}
+}
+
+// TestBlock asserts that blocks are looked up by height,
+// and that the most recent blocks are returned from the tail.
+func TestBlock(t *testing.T) {
+	b := NewBlockchain()
+	ids := make([]uuid.UUID, 3)
+	for i := range ids {
+		ids[i] = uuid.New()
+		b.Append(NewBlock(&trade.Transaction{ID: ids[i]}))
+	}
+
+	if expected, actual := 3, b.Height(); expected != actual {
+		t.Errorf("height: expected: %d actual: %d", expected, actual)
+	}
+	blk, ok := b.Block(2)
+	if !ok || len(blk.Transactions) != 1 || blk.Transactions[0].ID != ids[1] {
+		t.Errorf("block 2: expected transaction: %s actual: %+v", ids[1], blk)
+	}
+	if _, ok := b.Block(4); ok {
+		t.Errorf("block 4: expected: none actual: found")
+	}
+	recent := b.Recent(2)
+	if expected, actual := 2, len(recent); expected != actual {
+		t.Fatalf("recent blocks: expected: %d actual: %d", expected, actual)
+	}
+	if expected, actual := 3, recent[0].Height; expected != actual {
+		t.Errorf("most recent height: expected: %d actual: %d", expected, actual)
+	}
+}
+
+// TestHeader asserts that a block's hash is the hash of its header,
+// which links to the hash of the previous block, and that headers and
+// blocks are looked up by height and by hash.
+func TestHeader(t *testing.T) {
+	b := NewBlockchain()
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	b.Append(NewBlockAt(&trade.Transaction{ID: uuid.New()}, at))
+	b.Append(NewBlock(&trade.Transaction{ID: uuid.New()}))
+
+	prev, _ := b.Header(1)
+	prevHash, err := prev.Hash()
+	if err != nil {
+		t.Fatalf("header 1 hash: %v", err)
+	}
+	h, ok := b.Header(2)
+	if !ok {
+		t.Fatalf("header 2: expected: found actual: none")
+	}
+	if h.Height != 2 || h.Version != BlockVersion || h.TxCount != 1 || h.Prev != prevHash {
+		t.Errorf("header 2: expected: height 2 version %d 1 transaction prev %s actual: %+v", BlockVersion, prevHash, h)
+	}
+	if expected, actual := at, prev.CreatedOn; !expected.Equal(actual) {
+		t.Errorf("header 1 created on: expected: %s actual: %s", expected, actual)
+	}
+	last := b.Last()
+	if hash, err := h.Hash(); err != nil || hash != last.Hash {
+		t.Errorf("hash: expected: %s actual: %s %v", last.Hash, hash, err)
+	}
+	if byHash, ok := b.HeaderByHash(last.Hash); !ok || byHash != h {
+		t.Errorf("header by hash: expected: %+v actual: %+v", h, byHash)
+	}
+	if blk, ok := b.BlockByHash(prevHash); !ok || blk.Height != 1 || blk.Hash != prevHash {
+		t.Errorf("block by hash: expected: block 1 actual: %+v", blk)
+	}
+	if _, ok := b.HeaderByHash("missing"); ok {
+		t.Errorf("missing header: expected: none actual: found")
+	}
+}
+
+// TestConcurrentAppendRead asserts that blocks are appended and extended
+// concurrently with readers, and that every reader sees a consistent
+// canonical chain, where every block links to the block before it.
+// It's meant to be run with the race detector.
+func TestConcurrentAppendRead(t *testing.T) {
+	const writers, blocks = 4, 25
+	b := NewBlockchain()
+	gen := b.Last().Hash
+	b.Append(NewBlock(&trade.Transaction{ID: uuid.New()}))
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < blocks; j++ {
+				if i == 0 && j%5 == 0 {
+					b.Extend(gen, NewBlock(&trade.Transaction{ID: uuid.New()}))
+				}
+				b.Append(NewBlock(&trade.Transaction{ID: uuid.New()}))
+			}
+		}(i)
+	}
+	var readers sync.WaitGroup
+	errs := make(chan error, writers)
+	for i := 0; i < writers; i++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				var prev BlockInfo
+				height := -1
+				for it := b.Forward(); it.Next(); {
+					blk := it.Block()
+					if blk.Height != height+1 || (height >= 0 && blk.Prev != prev.Hash) {
+						errs <- fmt.Errorf("block %d: expected: height %d prev %s actual: %+v", blk.Height, height+1, prev.Hash, blk.BlockHeader)
+						return
+					}
+					prev, height = blk, blk.Height
+				}
+				b.Block(b.Height())
+				b.Recent(3)
+				b.Tips()
+				b.EncodeBlock(height)
+				b.HeaderByHash(prev.Hash)
+			}
+		}()
+	}
+	wg.Wait()
+	close(done)
+	readers.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	if expected, actual := writers*blocks+2, b.Len(); expected != actual {
+		t.Errorf("length: expected: %d actual: %d", expected, actual)
+	}
+}
@@ -0,0 +1,262 @@
+package db
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"tradesim/src/codec"
+	"tradesim/src/trade"
+)
+
+const (
+	// BlockVersion is the version of the canonical encoding of blocks.
+	BlockVersion = 2
+	// nodeDomain prefixes the encoding hashed by a hash node,
+	// so that it's never the encoding of a transaction.
+	nodeDomain = "tradesim node v1"
+)
+
+// ErrHash is returned when a hash isn't hex encoded.
+var ErrHash = errors.New("invalid hash")
+
+// encode appends the canonical encoding of the header,
+// which is its version, followed by
+//
+//     height                      uint64
+//     prev                        byte string
+//     transaction tree root hash  byte string
+//     createdOn                   time
+//     nonce                       string
+//     difficulty                  uint8
+//     number of transactions      uint64
+//
+// as encoded by package codec, where hashes are encoded
+// as the bytes of their hex encoding.
+func (h *BlockHeader) encode(e *codec.Encoder) error {
+	prev, err := hashBytes(h.Prev)
+	if err != nil {
+		return fmt.Errorf("prev: %w", err)
+	}
+	root, err := hashBytes(h.RootHash)
+	if err != nil {
+		return fmt.Errorf("root hash: %w", err)
+	}
+	e.Uint8(h.Version)
+	e.Uint64(uint64(h.Height))
+	e.Blob(prev)
+	e.Blob(root)
+	e.Time(h.CreatedOn)
+	e.Text(h.Nonce)
+	e.Uint8(uint8(h.Difficulty))
+	e.Uint64(h.TxCount)
+	return nil
+}
+
+// decodeHeader decodes the canonical encoding of a block's header.
+func decodeHeader(d *codec.Decoder) BlockHeader {
+	h := BlockHeader{Version: d.Uint8()}
+	if d.Err() == nil && h.Version != BlockVersion {
+		d.Fail(fmt.Errorf("%w: unsupported block version: supported=%d got=%d", codec.ErrDecode, BlockVersion, h.Version))
+	}
+	h.Height = int(d.Uint64())
+	h.Prev = hex.EncodeToString(d.Blob())
+	h.RootHash = hex.EncodeToString(d.Blob())
+	h.CreatedOn = d.Time()
+	h.Nonce = d.Text()
+	h.Difficulty = int(d.Uint8())
+	h.TxCount = d.Uint64()
+	return h
+}
+
+// encodeNode appends the canonical encoding of the provided tree node,
+// which is
+//
+//     key                         UUID
+//     createdOn                   time
+//     color                       bool, where red is true
+//     hash                        byte string
+//     whether it has a txn        bool, followed by its encoding
+//                                 as a byte string if it does
+//     whether it has a left       bool, followed by its encoding
+//     child                       if it does
+//     whether it has a right      bool, followed by its encoding
+//     child                       if it does
+//
+// as encoded by package codec.
+func encodeNode(e *codec.Encoder, n *node) error {
+	hash, err := hashBytes(n.hash)
+	if err != nil {
+		return err
+	}
+	e.UUID(n.key)
+	e.Time(n.createdOn)
+	e.Bool(bool(n.color))
+	e.Blob(hash)
+	e.Bool(n.txn != nil)
+	if n.txn != nil {
+		p, _ := n.txn.MarshalBinary()
+		e.Blob(p)
+	}
+	for _, c := range []*node{n.leftP, n.rightP} {
+		e.Bool(c != nil)
+		if c != nil {
+			if err := encodeNode(e, c); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// decodeNode decodes the canonical encoding of a tree node,
+// with the provided parent, up to the provided depth.
+func decodeNode(d *codec.Decoder, parent *node, depth int) *node {
+	if depth == 0 {
+		d.Fail(fmt.Errorf("%w: tree too deep", codec.ErrDecode))
+		return nil
+	}
+	n := &node{
+		key:       d.UUID(),
+		createdOn: d.Time(),
+		color:     color(d.Bool()),
+		parentP:   parent,
+		hash:      hex.EncodeToString(d.Blob()),
+	}
+	if d.Bool() {
+		var txn trade.Transaction
+		if err := txn.UnmarshalBinary(d.Blob()); err != nil {
+			d.Fail(err)
+		}
+		n.txn = &txn
+	}
+	if d.Bool() {
+		n.leftP = decodeNode(d, n, depth-1)
+	}
+	if d.Bool() {
+		n.rightP = decodeNode(d, n, depth-1)
+	}
+	if d.Err() != nil {
+		return nil
+	}
+	return n
+}
+
+// maxTreeDepth bounds the depth of a decoded tree,
+// so that a corrupt encoding doesn't exhaust the stack.
+const maxTreeDepth = 1 << 12
+
+// encodeBlock returns the canonical encoding of the provided block,
+// which is the encoding of its header, followed by the hash of its
+// parent as a byte string, empty for the genesis block, and the
+// encoding of the root node of its transaction tree.
+func encodeBlock(b *block) ([]byte, error) {
+	var e codec.Encoder
+	h := b.header()
+	if err := h.encode(&e); err != nil {
+		return nil, err
+	}
+	parent, err := hashBytes(parentID(b))
+	if err != nil {
+		return nil, fmt.Errorf("parent: %w", err)
+	}
+	e.Blob(parent)
+	b.txnTree.mu.RLock()
+	err = encodeNode(&e, b.txnTree.Root)
+	b.txnTree.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	return e.Bytes(), nil
+}
+
+// decodeBlock decodes the canonical encoding of a block into a block
+// without a previous pointer, along with the hash of its parent.
+func decodeBlock(p []byte) (*block, string, error) {
+	d := codec.NewDecoder(p)
+	h := decodeHeader(d)
+	parent := hex.EncodeToString(d.Blob())
+	blk := &block{
+		version:    h.Version,
+		createdOn:  h.CreatedOn,
+		prev:       h.Prev,
+		nonce:      h.Nonce,
+		difficulty: h.Difficulty,
+		txnTree:    &Tree{Root: decodeNode(d, nil, maxTreeDepth), Size: h.TxCount},
+		height:     h.Height,
+	}
+	if err := d.Close(); err != nil {
+		return nil, "", err
+	}
+	if blk.txnTree.Root.hash != h.RootHash {
+		return nil, "", fmt.Errorf("%w: root hash mismatch", codec.ErrDecode)
+	}
+	return blk, parent, nil
+}
+
+// EncodeBlock returns the canonical encoding of the block at the provided
+// height, for transport, or ErrUnknownBlock if the blockchain doesn't
+// have a block at the height. It's decoded by DecodeBlock.
+func (b *Blockchain) EncodeBlock(height int) ([]byte, error) {
+	blk, ok := b.blockAt(height)
+	if !ok {
+		return nil, fmt.Errorf("%w: height %d", ErrUnknownBlock, height)
+	}
+	return encodeBlock(blk)
+}
+
+// EncodeBlockByHash returns the canonical encoding of the block with the
+// provided hash, on any branch, or ErrUnknownBlock if the blockchain
+// doesn't have a block with the hash.
+func (b *Blockchain) EncodeBlockByHash(hash string) ([]byte, error) {
+	blk, ok := b.blockByHash(hash)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownBlock, hash)
+	}
+	return encodeBlock(blk)
+}
+
+// DecodeBlock returns the block of the provided canonical encoding,
+// as returned by EncodeBlock.
+func DecodeBlock(p []byte) (BlockInfo, error) {
+	blk, _, err := decodeBlock(p)
+	if err != nil {
+		return BlockInfo{}, err
+	}
+	hash, err := blk.hash()
+	if err != nil {
+		return BlockInfo{}, err
+	}
+	blk.id = fmt.Sprintf("%x", hash)
+	return newBlockInfo(blk), nil
+}
+
+// nodeHash returns the hash of a hash node with the provided children,
+// which is the hash of the node domain followed by the hashes of its
+// left and right children as byte strings, empty for missing children.
+func nodeHash(left, right *node) (string, error) {
+	var e codec.Encoder
+	e.Raw([]byte(nodeDomain))
+	for _, c := range []*node{left, right} {
+		if c == nil {
+			e.Blob(nil)
+			continue
+		}
+		p, err := hashBytes(c.hash)
+		if err != nil {
+			return "", err
+		}
+		e.Blob(p)
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(e.Bytes())), nil
+}
+
+// hashBytes returns the bytes of the provided hex encoded hash,
+// or ErrHash if it isn't hex encoded.
+func hashBytes(h string) ([]byte, error) {
+	p, err := hex.DecodeString(h)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q: %v", ErrHash, h, err)
+	}
+	return p, nil
+}
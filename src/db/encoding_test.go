@@ -0,0 +1,119 @@
+package db
+
+import (
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"tradesim/src/codec"
+	"tradesim/src/trade"
+
+	"github.com/google/uuid"
+)
+
+// goldenNode returns the leaf node of the golden encoding.
+func goldenNode() *node {
+	return &node{
+		key:       uuid.MustParse("00000000-0000-0000-0000-000000000006"),
+		createdOn: time.Date(2024, 1, 2, 3, 4, 5, 6, time.UTC),
+		color:     RED,
+		hash:      strings.Repeat("ab", 2),
+		txn:       &trade.Transaction{ID: uuid.MustParse("00000000-0000-0000-0000-000000000001")},
+	}
+}
+
+// goldenBlock returns the block of the golden header encoding.
+func goldenBlock() *block {
+	return &block{
+		version:    BlockVersion,
+		height:     1,
+		prev:       strings.Repeat("01", 2),
+		createdOn:  time.Date(2024, 1, 2, 3, 4, 5, 6, time.UTC),
+		nonce:      "42",
+		difficulty: 3,
+		txnTree:    &Tree{Root: &node{hash: strings.Repeat("cd", 2)}, Size: 1},
+	}
+}
+
+var (
+	// goldenNodeEncoding is the encoding of the golden node, with the
+	// 153 byte encoding of its transaction, which has only an ID.
+	goldenNodeEncoding = "00000000000000000000000000000006" + "0000000065937d25" + "00000006" + "01" + "00000002abab" +
+		"01" + "00000099" + "01" + "00000000000000000000000000000001" + strings.Repeat("0", 2*(2*(16+16+4+8+8)+16+8+4+4)) +
+		"00" + "00"
+	goldenNodeHash   = "1f06e018233b5981cabbb281b82dfa1452be84a57bbd32b31a486c4009001612"
+	goldenHeader     = "02" + "0000000000000001" + "000000020101" + "00000002cdcd" + "0000000065937d25" + "00000006" + "000000023432" + "03" + "0000000000000001"
+	goldenHeaderHash = "324e97be53ade03d2275d872074a2ba3cb53b533d40359491deb48a4135cf306"
+)
+
+// TestEncodingGolden asserts that the canonical encodings and hashes
+// of a block header and tree node match the golden vectors.
+func TestEncodingGolden(t *testing.T) {
+	var e codec.Encoder
+	if err := encodeNode(&e, goldenNode()); err != nil {
+		t.Fatalf("node encoding: %v", err)
+	}
+	if expected, actual := goldenNodeEncoding, hex.EncodeToString(e.Bytes()); expected != actual {
+		t.Errorf("node encoding: expected: %s actual: %s", expected, actual)
+	}
+	if hash, err := nodeHash(goldenNode(), nil); err != nil || hash != goldenNodeHash {
+		t.Errorf("node hash: expected: %s actual: %s %v", goldenNodeHash, hash, err)
+	}
+	e = codec.Encoder{}
+	h := goldenBlock().header()
+	if err := h.encode(&e); err != nil {
+		t.Fatalf("header: %v", err)
+	}
+	if expected, actual := goldenHeader, hex.EncodeToString(e.Bytes()); expected != actual {
+		t.Errorf("header: expected: %s actual: %s", expected, actual)
+	}
+	if hash, err := goldenBlock().hash(); err != nil || hex.EncodeToString(hash[:]) != goldenHeaderHash {
+		t.Errorf("header hash: expected: %s actual: %x %v", goldenHeaderHash, hash, err)
+	}
+}
+
+// TestEncodeInvalidHash asserts that a header or tree node
+// whose hashes aren't hex encoded isn't encoded or hashed.
+func TestEncodeInvalidHash(t *testing.T) {
+	h := goldenBlock().header()
+	h.Prev = "not a hash"
+	if _, err := h.Hash(); !errors.Is(err, ErrHash) {
+		t.Errorf("header hash: expected: %v actual: %v", ErrHash, err)
+	}
+	n := goldenNode()
+	n.hash = "zz"
+	if err := encodeNode(&codec.Encoder{}, n); !errors.Is(err, ErrHash) {
+		t.Errorf("node encoding: expected: %v actual: %v", ErrHash, err)
+	}
+	if _, err := nodeHash(n, nil); !errors.Is(err, ErrHash) {
+		t.Errorf("node hash: expected: %v actual: %v", ErrHash, err)
+	}
+}
+
+// TestEncodeBlock asserts that a block decodes from its canonical
+// encoding with its hash, header and transactions.
+func TestEncodeBlock(t *testing.T) {
+	b := NewBlockchain()
+	txns := []trade.Transaction{{ID: uuid.New(), Credit: trade.TransactionRecord{Item: trade.NewItem("a"), Price: 2}}, {ID: uuid.New()}}
+	blk := NewBlock(&txns[0])
+	blk.txnTree.Insert(&txns[1])
+	b.Append(blk)
+
+	p, err := b.EncodeBlock(1)
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	decoded, err := DecodeBlock(p)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	expected, _ := b.Block(1)
+	if !reflect.DeepEqual(expected, decoded) {
+		t.Errorf("decoded: expected: %+v actual: %+v", expected, decoded)
+	}
+	if _, err := DecodeBlock(p[:len(p)-1]); err == nil {
+		t.Errorf("truncated: expected: error actual: none")
+	}
+}
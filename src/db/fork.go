@@ -0,0 +1,307 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"tradesim/src/trade"
+
+	"github.com/google/uuid"
+)
+
+// ForkChoice represents the rule choosing the canonical chain of a block tree.
+type ForkChoice = string
+
+const (
+	// ForkChoiceWork chooses the branch with the most cumulative
+	// proof of work, and ForkChoiceHeight the highest branch.
+	ForkChoiceWork   ForkChoice = "work"
+	ForkChoiceHeight ForkChoice = "height"
+)
+
+var (
+	ErrUnknownBlock = errors.New("unknown block")
+	ErrInvalidBlock = errors.New("invalid block")
+)
+
+// Reorg represents a reorganization of a blockchain to a competing branch.
+type Reorg struct {
+	// OldTip and NewTip are the hashes of the last block of the
+	// canonical chain before and after the reorganization, and
+	// ForkHeight is the height of their last common block.
+	OldTip     string `json:"old_tip"`
+	NewTip     string `json:"new_tip"`
+	ForkHeight int    `json:"fork_height"`
+	// Disconnected and Connected are the number of blocks removed
+	// from and added to the canonical chain.
+	Disconnected int `json:"disconnected"`
+	Connected    int `json:"connected"`
+	// Orphaned are the transactions of the disconnected blocks
+	// that aren't in the connected blocks, which were returned
+	// to the pending pool.
+	Orphaned []trade.Transaction `json:"orphaned"`
+}
+
+// blockWork returns the expected number of hashes to seal
+// a block with the provided difficulty.
+func blockWork(difficulty int) *big.Int {
+	return new(big.Int).Lsh(big.NewInt(1), uint(difficulty))
+}
+
+// SetForkChoice sets the rule choosing the canonical chain among the
+// branches of the blockchain, where any rule but ForkChoiceHeight is
+// ForkChoiceWork, the default. It applies to blocks added after it's set.
+func (b *Blockchain) SetForkChoice(choice ForkChoice) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.forkChoice = choice
+}
+
+// Extend adds a block to the blockchain after the block with the provided
+// hash, once it's sealed with the blockchain's proof of work, which may be
+// on a branch competing with the canonical chain. If the block makes its
+// branch heavier than the canonical chain, the blockchain reorganizes to
+// it, and the reorganization is returned.
+// A block with a transaction whose signatures don't verify with the
+// registered keys isn't added, and ErrSignature is returned, nor is a block
+// that executes more than a quote's quantity on its branch, and ErrQuote is
+// returned. If the blockchain was opened from a file, the block is persisted
+// to the file before it's added.
+func (b *Blockchain) Extend(parent string, block *block) (*Reorg, error) {
+	b.mu.RLock()
+	p, ok := b.blocks[parent]
+	b.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownBlock, parent)
+	}
+	if err := b.verifyBlock(block); err != nil {
+		return nil, err
+	}
+	hashes, elapsed, ok := b.seal(context.Background(), p, block)
+	if !ok {
+		return nil, fmt.Errorf("%w: failed to seal block", ErrStore)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	reorg, err := b.add(block)
+	if err == nil {
+		b.mined(hashes, elapsed)
+	}
+	return reorg, err
+}
+
+// Seal seals the provided block after the block with the provided hash,
+// which may be on a branch competing with the canonical chain, with the
+// blockchain's proof of work, and returns its canonical encoding without
+// adding it to the blockchain. The sealed block is added to blockchains
+// of the same genesis block, including this one, by Receive.
+// A block with a transaction whose signatures don't verify with the
+// registered keys isn't sealed, and ErrSignature is returned.
+func (b *Blockchain) Seal(parent string, block *block) ([]byte, error) {
+	b.mu.RLock()
+	p, ok := b.blocks[parent]
+	b.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownBlock, parent)
+	}
+	if err := b.verifyBlock(block); err != nil {
+		return nil, err
+	}
+	hashes, elapsed, ok := b.seal(context.Background(), p, block)
+	if !ok {
+		return nil, fmt.Errorf("%w: failed to seal block", ErrStore)
+	}
+	b.mu.Lock()
+	b.mined(hashes, elapsed)
+	b.mu.Unlock()
+	return encodeBlock(block)
+}
+
+// Receive adds the block of the provided canonical encoding, as returned
+// by EncodeBlock, sealed by another blockchain of the same genesis block,
+// and returns the reorganization it caused, if any. The block's parent must
+// be in the blockchain, or ErrUnknownBlock is returned, and its height and
+// hash pointer must follow the parent's, or ErrInvalidBlock is returned.
+// Its difficulty must be the difficulty retargeted over its branch, and
+// its hash must meet it, or ErrDifficulty is returned. A block already in
+// the blockchain is ignored. Received blocks aren't counted as mined.
+func (b *Blockchain) Receive(p []byte) (*Reorg, error) {
+	blk, parent, err := decodeBlock(p)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := blk.hash()
+	if err != nil {
+		return nil, err
+	}
+	blk.id = fmt.Sprintf("%x", hash)
+	if err := b.verifyBlock(blk); err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.blocks[blk.id]; ok {
+		return nil, nil
+	}
+	prev, ok := b.blocks[parent]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownBlock, parent)
+	}
+	if blk.height != prev.height+1 || !blk.validPrev(prev) {
+		return nil, fmt.Errorf("%w: block=%s height=%d parent=%s", ErrInvalidBlock, blk.id, blk.height, parent)
+	}
+	if blk.difficulty != prev.next || !meetsDifficulty(hash, blk.difficulty) {
+		return nil, fmt.Errorf("%w: block=%s expected=%d got=%d", ErrDifficulty, blk.id, prev.next, blk.difficulty)
+	}
+	blk.prevP = prev
+	return b.add(blk)
+}
+
+// Pending returns the transactions of blocks disconnected from the
+// canonical chain that aren't in it, in the order they were orphaned.
+func (b *Blockchain) Pending() []trade.Transaction {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return append([]trade.Transaction{}, b.pending...)
+}
+
+// Reorgs returns every reorganization of the blockchain, in order.
+func (b *Blockchain) Reorgs() []Reorg {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return append([]Reorg{}, b.reorgs...)
+}
+
+// Tips returns the hashes of the last block of every branch of the
+// block tree, starting with the last block of the canonical chain.
+func (b *Blockchain) Tips() []string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	parents := make(map[*block]bool, len(b.order))
+	for _, blk := range b.order {
+		parents[blk.prevP] = true
+	}
+	tips := []string{b.tail.id}
+	for _, blk := range b.order {
+		if !parents[blk] && blk != b.tail {
+			tips = append(tips, blk.id)
+		}
+	}
+	return tips
+}
+
+// insert adds the provided sealed block to the block tree, and sets the
+// canonical chain to its branch if it's heavier than the canonical chain,
+// returning the reorganization if its branch doesn't extend the canonical
+// chain. The caller must hold the lock.
+func (b *Blockchain) insert(blk *block) *Reorg {
+	parent := blk.prevP
+	blk.height = parent.height + 1
+	blk.work = new(big.Int).Add(parent.work, blockWork(blk.difficulty))
+	blk.latest = parent.latest
+	if blk.createdOn.After(blk.latest) {
+		blk.latest = blk.createdOn
+	}
+	blk.next = retarget(b.pow, blk, parent.next)
+	b.blocks[blk.id] = blk
+	b.order = append(b.order, blk)
+	for _, t := range blk.txnTree.Transactions() {
+		if q := b.quotes[t.QuoteID]; t.QuoteID != uuid.Nil && (len(q) == 0 || q[len(q)-1] != blk) {
+			b.quotes[t.QuoteID] = append(q, blk)
+		}
+	}
+
+	if !b.heavier(blk, b.tail) {
+		return nil
+	}
+	if parent == b.tail {
+		b.tail = blk
+		b.chain = append(b.chain, blk)
+		b.earliest = appendEarliest(b.earliest, blk.createdOn)
+		b.unpend(blk)
+		return nil
+	}
+
+	old := b.tail
+	var disconnected, connected []*block
+	for o, n := old, blk; o != n; {
+		if o.height >= n.height {
+			disconnected = append(disconnected, o)
+			o = o.prevP
+		} else {
+			connected = append(connected, n)
+			n = n.prevP
+		}
+	}
+	b.tail = blk
+	// The chain is replaced rather than modified,
+	// since iterators may hold the old chain.
+	fork := blk.height - len(connected)
+	chain := make([]*block, fork+1, blk.height+1)
+	copy(chain, b.chain[:fork+1])
+	for i := len(connected) - 1; i >= 0; i-- {
+		chain = append(chain, connected[i])
+	}
+	b.chain = chain
+	b.earliest = earliestOf(chain)
+
+	reorg := &Reorg{
+		OldTip:       old.id,
+		NewTip:       blk.id,
+		ForkHeight:   fork,
+		Disconnected: len(disconnected),
+		Connected:    len(connected),
+		Orphaned:     []trade.Transaction{},
+	}
+	inChain := make(map[uuid.UUID]bool)
+	for _, c := range connected {
+		for _, t := range c.txnTree.Transactions() {
+			inChain[t.ID] = true
+		}
+	}
+	// Disconnected blocks are ordered from the old tip,
+	// so their transactions are orphaned from the fork.
+	for i := len(disconnected) - 1; i >= 0; i-- {
+		for _, t := range disconnected[i].txnTree.Transactions() {
+			if !inChain[t.ID] {
+				reorg.Orphaned = append(reorg.Orphaned, *t)
+				b.pending = append(b.pending, *t)
+			}
+		}
+	}
+	for i := len(connected) - 1; i >= 0; i-- {
+		b.unpend(connected[i])
+	}
+	return reorg
+}
+
+// heavier returns whether the branch ending in the provided block is
+// heavier than the branch ending in the other provided block, by the
+// blockchain's fork choice. Branches of equal weight keep the first.
+func (b *Blockchain) heavier(blk, than *block) bool {
+	if b.forkChoice == ForkChoiceHeight {
+		return blk.height > than.height
+	}
+	return blk.work.Cmp(than.work) > 0
+}
+
+// unpend removes the transactions of the provided
+// block from the pending pool. The caller must hold the lock.
+func (b *Blockchain) unpend(blk *block) {
+	if len(b.pending) == 0 {
+		return
+	}
+	ids := make(map[uuid.UUID]bool)
+	for _, t := range blk.txnTree.Transactions() {
+		ids[t.ID] = true
+	}
+	pending := b.pending[:0]
+	for _, t := range b.pending {
+		if !ids[t.ID] {
+			pending = append(pending, t)
+		}
+	}
+	b.pending = pending
+}
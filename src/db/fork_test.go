@@ -0,0 +1,242 @@
+package db
+
+import (
+	"errors"
+	"path"
+	"testing"
+	"time"
+	"tradesim/src/trade"
+
+	"github.com/google/uuid"
+)
+
+// TestExtendReorg asserts that a blockchain reorganizes to a competing
+// branch once it's heavier, returning the transactions of the disconnected
+// blocks that aren't in the new branch to the pending pool, and reorganizes
+// back once the original branch is heavier again.
+func TestExtendReorg(t *testing.T) {
+	b := NewBlockchain()
+	gen := b.Last().Hash
+	txns := make([]trade.Transaction, 3)
+	for i := range txns {
+		txns[i] = trade.Transaction{ID: uuid.New()}
+	}
+	b.Append(NewBlock(&txns[0]))
+	b.Append(NewBlock(&txns[1]))
+	main := b.Last().Hash
+
+	// A branch of the same weight doesn't reorganize the blockchain.
+	reorg, err := b.Extend(gen, NewBlock(&txns[1]))
+	if err != nil || reorg != nil {
+		t.Fatalf("extend: expected: no reorg actual: %+v %v", reorg, err)
+	}
+	side := b.Tips()[1]
+	if reorg, err = b.Extend(side, NewBlock(&txns[2])); err != nil || reorg != nil {
+		t.Fatalf("extend: expected: no reorg actual: %+v %v", reorg, err)
+	}
+	if expected, actual := main, b.Last().Hash; expected != actual {
+		t.Errorf("tip: expected: %s actual: %s", expected, actual)
+	}
+
+	side = b.Tips()[1]
+	reorg, err = b.Extend(side, NewBlock(&trade.Transaction{ID: uuid.New()}))
+	if err != nil || reorg == nil {
+		t.Fatalf("extend: expected: reorg actual: %+v %v", reorg, err)
+	}
+	if reorg.OldTip != main || reorg.ForkHeight != 0 || reorg.Disconnected != 2 || reorg.Connected != 3 {
+		t.Errorf("reorg: expected: from %s at 0 disconnected 2 connected 3 actual: %+v", main, reorg)
+	}
+	if len(reorg.Orphaned) != 1 || reorg.Orphaned[0].ID != txns[0].ID {
+		t.Errorf("orphaned: expected: %s actual: %+v", txns[0].ID, reorg.Orphaned)
+	}
+	if pending := b.Pending(); len(pending) != 1 || pending[0].ID != txns[0].ID {
+		t.Errorf("pending: expected: %s actual: %+v", txns[0].ID, pending)
+	}
+	if expected, actual := 4, b.Len(); expected != actual {
+		t.Errorf("length: expected: %d actual: %d", expected, actual)
+	}
+	if blk, ok := b.Block(2); !ok || blk.Transactions[0].ID != txns[2].ID {
+		t.Errorf("block 2: expected transaction: %s actual: %+v", txns[2].ID, blk)
+	}
+
+	// Extending the original branch twice makes it heavier again,
+	// and its orphaned transaction is no longer pending.
+	if _, err := b.Extend(main, NewBlock(&trade.Transaction{ID: uuid.New()})); err != nil {
+		t.Fatalf("extend: %v", err)
+	}
+	reorg, err = b.Extend(b.Tips()[1], NewBlock(&trade.Transaction{ID: uuid.New()}))
+	if err != nil || reorg == nil || reorg.Disconnected != 3 || reorg.Connected != 4 {
+		t.Fatalf("extend: expected: reorg disconnected 3 connected 4 actual: %+v %v", reorg, err)
+	}
+	if pending := b.Pending(); len(pending) != 2 {
+		t.Errorf("pending: expected: 2 actual: %+v", pending)
+	}
+	if expected, actual := 2, len(b.Reorgs()); expected != actual {
+		t.Errorf("reorgs: expected: %d actual: %d", expected, actual)
+	}
+
+	if _, err := b.Extend("missing", NewBlock(&trade.Transaction{})); !errors.Is(err, ErrUnknownBlock) {
+		t.Errorf("unknown parent: expected: %v actual: %v", ErrUnknownBlock, err)
+	}
+}
+
+// TestForkChoice asserts that branches are chosen by cumulative work by
+// default, so that a shorter branch of harder blocks is canonical, and by
+// height with ForkChoiceHeight. The blocks of the competing branch are
+// mined faster, so its difficulty is retargeted higher.
+func TestForkChoice(t *testing.T) {
+	for _, choice := range []ForkChoice{ForkChoiceWork, ForkChoiceHeight} {
+		b := NewBlockchain()
+		b.SetForkChoice(choice)
+		if err := b.SetProofOfWork(ProofOfWork{Difficulty: 2, TargetInterval: time.Second, RetargetInterval: 1}); err != nil {
+			t.Fatalf("%s: set proof of work: %v", choice, err)
+		}
+		start := b.head.createdOn
+		blockAt := func(d time.Duration) *block {
+			blk := NewBlock(&trade.Transaction{ID: uuid.New()})
+			blk.createdOn = start.Add(d)
+			return blk
+		}
+		gen := b.Last().Hash
+		for i := 1; i <= 3; i++ {
+			b.Append(blockAt(time.Duration(i) * time.Minute))
+		}
+		var reorg *Reorg
+		parent := gen
+		for i := 1; i <= 2; i++ {
+			blk := blockAt(time.Duration(i) * time.Millisecond)
+			r, err := b.Extend(parent, blk)
+			if err != nil {
+				t.Fatalf("%s: extend: %v", choice, err)
+			}
+			if r != nil {
+				reorg = r
+			}
+			parent = blk.id
+		}
+		if expected, actual := choice == ForkChoiceWork, reorg != nil; expected != actual {
+			t.Errorf("%s: reorg: expected: %t actual: %+v", choice, expected, reorg)
+		}
+	}
+}
+
+// TestForkPersisted asserts that every branch of a block tree is persisted,
+// and that the reloaded blockchain has the same canonical chain.
+func TestForkPersisted(t *testing.T) {
+	filepath := path.Join(t.TempDir(), "chain.db")
+	b, err := Open(filepath)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	gen := b.Last().Hash
+	b.Append(NewBlock(&trade.Transaction{ID: uuid.New()}))
+	if _, err := b.Extend(gen, NewBlock(&trade.Transaction{ID: uuid.New()})); err != nil {
+		t.Fatalf("extend: %v", err)
+	}
+	if _, err := b.Extend(b.Tips()[1], NewBlock(&trade.Transaction{ID: uuid.New()})); err != nil {
+		t.Fatalf("extend: %v", err)
+	}
+	tip := b.Last().Hash
+	if err := b.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	saved := path.Join(t.TempDir(), "saved.db")
+	l, err := Load(filepath)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if err := l.Save(saved); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	for _, p := range []string{filepath, saved} {
+		l, err := Load(p)
+		if err != nil {
+			t.Fatalf("load %s: %v", p, err)
+		}
+		if l.Last().Hash != tip || l.Len() != 3 || len(l.Tips()) != 2 {
+			t.Errorf("load %s: expected: tip %s length 3 tips 2 actual: %s %d %v", p, tip, l.Last().Hash, l.Len(), l.Tips())
+		}
+	}
+}
+
+// TestIndexUpdateReorg asserts that an index is rebuilt
+// when its blockchain reorganizes.
+func TestIndexUpdateReorg(t *testing.T) {
+	b := NewBlockchain()
+	gen := b.Last().Hash
+	b.Append(NewBlock(&trade.Transaction{ID: uuid.New()}))
+	ix := NewIndex(b)
+
+	id := uuid.New()
+	b.Extend(gen, NewBlock(&trade.Transaction{ID: uuid.New()}))
+	b.Extend(b.Tips()[1], NewBlock(&trade.Transaction{ID: id}))
+	ix.Update(b)
+	r, err := ix.Query(Query{})
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	if len(r.Entries) != 2 || r.Entries[1].Transaction.ID != id || r.Entries[1].Height != 2 {
+		t.Errorf("entries: expected: 2 ending in %s at 2 actual: %+v", id, r.Entries)
+	}
+}
+
+// TestReceive asserts that a blockchain receives the blocks sealed by
+// another blockchain of the same genesis block, once it has their parent,
+// that a blockchain receives the blocks it seals, and that it rejects
+// a block whose difficulty isn't the blockchain's.
+func TestReceive(t *testing.T) {
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	sealer, receiver := NewBlockchainAt(at), NewBlockchainAt(at)
+	for _, b := range []*Blockchain{sealer, receiver} {
+		if err := b.SetProofOfWork(ProofOfWork{Difficulty: 4}); err != nil {
+			t.Fatalf("set proof of work: %v", err)
+		}
+	}
+	var encoded [][]byte
+	for i := 0; i < 2; i++ {
+		if ok := sealer.Append(NewBlockAt(&trade.Transaction{ID: uuid.New()}, at.Add(time.Second))); !ok {
+			t.Fatalf("append block %d", i)
+		}
+		p, err := sealer.EncodeBlockByHash(sealer.Last().Hash)
+		if err != nil {
+			t.Fatalf("encode block %d: %v", i, err)
+		}
+		encoded = append(encoded, p)
+	}
+
+	if _, err := receiver.Receive(encoded[1]); !errors.Is(err, ErrUnknownBlock) {
+		t.Errorf("receive orphan: expected: %v actual: %v", ErrUnknownBlock, err)
+	}
+	for i, p := range encoded {
+		if _, err := receiver.Receive(p); err != nil {
+			t.Fatalf("receive block %d: %v", i, err)
+		}
+	}
+	if expected, actual := sealer.Last().Hash, receiver.Last().Hash; expected != actual {
+		t.Errorf("tip: expected: %s actual: %s", expected, actual)
+	}
+	if stats := receiver.Mining(); stats.Blocks != 0 {
+		t.Errorf("mined blocks: expected: 0 actual: %d", stats.Blocks)
+	}
+
+	// A sealed block is only added once it's received.
+	p, err := sealer.Seal(sealer.Last().Hash, NewBlockAt(&trade.Transaction{ID: uuid.New()}, at.Add(time.Second)))
+	if err != nil {
+		t.Fatalf("seal: %v", err)
+	}
+	if expected, actual := 3, sealer.Len(); expected != actual {
+		t.Errorf("sealed length: expected: %d actual: %d", expected, actual)
+	}
+	if _, err := sealer.Receive(p); err != nil || sealer.Len() != 4 {
+		t.Errorf("receive sealed: expected: length 4 actual: length %d %v", sealer.Len(), err)
+	}
+
+	harder := NewBlockchainAt(at)
+	if err := harder.SetProofOfWork(ProofOfWork{Difficulty: 8}); err != nil {
+		t.Fatalf("set proof of work: %v", err)
+	}
+	if _, err := harder.Receive(encoded[0]); !errors.Is(err, ErrDifficulty) {
+		t.Errorf("receive easier block: expected: %v actual: %v", ErrDifficulty, err)
+	}
+}
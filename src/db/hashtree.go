@@ -1,9 +1,10 @@
 package db
 
 import (
-	"crypto/sha256"
 	"fmt"
 	"log"
+	"sort"
+	"sync"
 	"time"
 	"tradesim/src/trade"
 
@@ -162,7 +163,12 @@ func (n *node) rotateRight() *node {
 }
 
 // Tree is a balanced hash tree of transactions.
+//
+// A tree is safe for concurrent use through its methods,
+// but its fields must not be accessed while it's modified.
 type Tree struct {
+	// mu guards Root and Size.
+	mu sync.RWMutex
 	// Root is the root hash node of the tree.
 	Root *node
 	// Size is the number of nodes with transactions in the tree.
@@ -181,6 +187,8 @@ func NewTree() *Tree {
 }
 
 func (t *Tree) String() string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
 	var firstTxn *trade.Transaction
 	l, r := t.Root.leftP, t.Root.rightP
 	if l != nil && r != nil {
@@ -200,13 +208,49 @@ func (t *Tree) String() string {
 	)
 }
 
-// Insert inserts the provided transaction as a leaf node into the tree.
-func (t *Tree) Insert(txn *trade.Transaction) {
+// Transactions returns the transactions of the tree,
+// in the order they were inserted.
+func (t *Tree) Transactions() []*trade.Transaction {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	var nodes []*node
+	var walk func(n *node)
+	walk = func(n *node) {
+		if n == nil {
+			return
+		}
+		if n.hasTxn() {
+			nodes = append(nodes, n)
+		}
+		walk(n.leftP)
+		walk(n.rightP)
+	}
+	walk(t.Root)
+	sort.SliceStable(nodes, func(i, j int) bool { return nodes[i].createdOn.Before(nodes[j].createdOn) })
+	txns := make([]*trade.Transaction, len(nodes))
+	for i, n := range nodes {
+		txns[i] = n.txn
+	}
+	return txns
+}
+
+// Insert inserts the provided transaction as a leaf node into the tree,
+// returning ErrHash if the hash of a node of the tree isn't hex encoded.
+func (t *Tree) Insert(txn *trade.Transaction) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 	n := newNode()
 	n.txn = txn
 	n.hash = txn.Hash()
 	t.insert(n)
-	t.rehash(n)
+	return t.rehash(n)
+}
+
+// root returns the root hash of the tree, and its size.
+func (t *Tree) root() (string, uint64) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.Root.hash, t.Size
 }
 
 func (t *Tree) insert(n *node) {
@@ -298,18 +342,16 @@ func (t *Tree) balance(n *node) {
 }
 
 // rehash recomputes node hashes from the provided node up to the root.
-func (t *Tree) rehash(n *node) {
+func (t *Tree) rehash(n *node) error {
 	for curr := n; curr != nil; {
 		if !curr.hasTxn() {
-			var data string
-			if curr.leftP != nil {
-				data += curr.leftP.hash
-			}
-			if curr.rightP != nil {
-				data += curr.rightP.hash
+			hash, err := nodeHash(curr.leftP, curr.rightP)
+			if err != nil {
+				return err
 			}
-			curr.hash = fmt.Sprintf("%x", sha256.Sum256([]byte(data)))
+			curr.hash = hash
 		}
 		curr = curr.parentP
 	}
+	return nil
 }
@@ -2,9 +2,14 @@ package db
 
 import (
 	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"sync"
 	"testing"
+	"tradesim/src/codec"
 	"tradesim/src/trade"
+
+	"github.com/google/uuid"
 )
 
 // TestInsertIncrementsSize asserts that every insertion into a tree
@@ -58,15 +63,16 @@ func TestInsertMaintainsHashPointers(t *testing.T) {
 
 		if ok := traverse(tree.Root, func(n *node) bool {
 			if n != nil && !n.hasTxn() {
-				var data, hash string
-				if n.leftP != nil {
-					data += n.leftP.hash
-				}
-				if n.rightP != nil {
-					data += n.rightP.hash
+				var e codec.Encoder
+				e.Raw([]byte(nodeDomain))
+				for _, c := range []*node{n.leftP, n.rightP} {
+					var h []byte
+					if c != nil {
+						h, _ = hex.DecodeString(c.hash)
+					}
+					e.Blob(h)
 				}
-				hash = fmt.Sprintf("%x", sha256.Sum256([]byte(data)))
-				return n.hash == hash
+				return n.hash == fmt.Sprintf("%x", sha256.Sum256(e.Bytes()))
 			}
 			return true
 		}); !ok {
@@ -172,3 +178,46 @@ func traversePathsCountBlackLinks(n *node, path []*node, counts *[]int) {
 		traversePathsCountBlackLinks(n.rightP, path, counts)
 	}
 }
+
+// TestTreeConcurrent asserts that transactions are inserted into a tree
+// concurrently with readers of its transactions and root hash.
+// It's meant to be run with the race detector.
+func TestTreeConcurrent(t *testing.T) {
+	const writers, txns = 4, 50
+	tree := NewTree()
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < txns; j++ {
+				tree.Insert(&trade.Transaction{ID: uuid.New()})
+			}
+		}()
+	}
+	var readers sync.WaitGroup
+	readers.Add(1)
+	go func() {
+		defer readers.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if root, size := tree.root(); root == "" && size > 0 {
+				t.Errorf("root hash: expected: hash of %d transactions actual: none", size)
+				return
+			}
+			_ = tree.String()
+			tree.Transactions()
+		}
+	}()
+	wg.Wait()
+	close(done)
+	readers.Wait()
+	if expected, actual := writers*txns, len(tree.Transactions()); expected != actual {
+		t.Errorf("transactions: expected: %d actual: %d", expected, actual)
+	}
+}
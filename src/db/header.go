@@ -0,0 +1,106 @@
+package db
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"time"
+	"tradesim/src/codec"
+)
+
+// BlockHeader represents the header of a block, whose hash is the hash
+// of the block. A header commits to the block's transactions through the
+// Merkle root of its transaction tree, and to the previous block through
+// its hash, so that a header hash commits to the whole chain up to it.
+type BlockHeader struct {
+	// Version is the version of the block's encoding.
+	Version uint8 `json:"version"`
+	// Height is the number of blocks before the block,
+	// so that the genesis block has a height of 0.
+	Height int `json:"height"`
+	// Prev is the hash of the previous block, which is 64 zeros
+	// for the genesis block.
+	Prev string `json:"prev"`
+	// RootHash is the Merkle root of the block's transaction tree.
+	RootHash string `json:"root_hash"`
+	// CreatedOn is the simulated time the block was created on.
+	CreatedOn time.Time `json:"created_on"`
+	// Nonce is the nonce that seals the block, such that its hash
+	// has at least Difficulty leading zero bits.
+	Nonce      string `json:"nonce"`
+	Difficulty int    `json:"difficulty"`
+	// TxCount is the number of transactions of the block.
+	TxCount uint64 `json:"tx_count"`
+}
+
+// header returns the header of the block.
+func (b *block) header() BlockHeader {
+	root, size := b.txnTree.root()
+	return BlockHeader{
+		Version:    b.version,
+		Height:     b.height,
+		Prev:       b.prev,
+		RootHash:   root,
+		CreatedOn:  b.createdOn,
+		Nonce:      b.nonce,
+		Difficulty: b.difficulty,
+		TxCount:    size,
+	}
+}
+
+// Hash returns the hex encoding of the hash of the header, which is the
+// hash of its block, or ErrHash if its hashes aren't hex encoded.
+func (h *BlockHeader) Hash() (string, error) {
+	hash, err := h.hash()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", hash), nil
+}
+
+// hash returns the hash of the canonical encoding of the header.
+func (h *BlockHeader) hash() ([sha256.Size]byte, error) {
+	var e codec.Encoder
+	if err := h.encode(&e); err != nil {
+		return [sha256.Size]byte{}, err
+	}
+	return sha256.Sum256(e.Bytes()), nil
+}
+
+// Header returns the header of the block at the provided height,
+// and whether the blockchain has a block at the height.
+func (b *Blockchain) Header(height int) (BlockHeader, bool) {
+	blk, ok := b.blockAt(height)
+	if !ok {
+		return BlockHeader{}, false
+	}
+	return blk.header(), true
+}
+
+// HeaderByHash returns the header of the block with the provided hash,
+// on any branch of the block tree, and whether the blockchain has it.
+func (b *Blockchain) HeaderByHash(hash string) (BlockHeader, bool) {
+	blk, ok := b.blockByHash(hash)
+	if !ok {
+		return BlockHeader{}, false
+	}
+	return blk.header(), true
+}
+
+// BlockByHash returns the block with the provided hash, on any
+// branch of the block tree, and whether the blockchain has it.
+func (b *Blockchain) BlockByHash(hash string) (BlockInfo, bool) {
+	blk, ok := b.blockByHash(hash)
+	if !ok {
+		return BlockInfo{}, false
+	}
+	return newBlockInfo(blk), true
+}
+
+// blockByHash returns the block of the block tree with the
+// provided hash, and whether the blockchain has it.
+func (b *Blockchain) blockByHash(hash string) (*block, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	blk, ok := b.blocks[hash]
+	return blk, ok
+}
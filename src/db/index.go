@@ -0,0 +1,279 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+	"tradesim/src/trade"
+
+	"github.com/google/uuid"
+)
+
+var ErrQuery = errors.New("invalid ledger query")
+
+// IndexEntry represents an indexed transaction, along with the
+// height of its block and the time its block was initialized.
+type IndexEntry struct {
+	// Height is the number of blocks before the transaction's block,
+	// so that the genesis block has a height of 0.
+	Height      int               `json:"height"`
+	CreatedOn   time.Time         `json:"created_on"`
+	Transaction trade.Transaction `json:"transaction"`
+}
+
+// Index represents a set of secondary indexes over the transactions
+// of a ledger, by trader ID, item ID, block height and time.
+//
+// An index isn't safe for concurrent use.
+type Index struct {
+	// entries are the indexed transactions in the order
+	// of their blocks, and so ordered by height.
+	entries []IndexEntry
+	// byTrader and byItem are the positions in entries
+	// of the transactions of each trader and item, in order.
+	byTrader map[uuid.UUID][]int
+	byItem   map[uuid.UUID][]int
+	// byTime are the positions in entries ordered by time.
+	byTime []int
+	// tail is the last indexed block of a blockchain,
+	// and height is its height.
+	tail   *block
+	height int
+}
+
+// NewIndex returns an index of the transactions of the provided blockchain.
+func NewIndex(b *Blockchain) *Index {
+	ix := newIndex()
+	ix.Update(b)
+	return ix
+}
+
+// NewLedgerIndex returns an index of the provided ledger entries,
+// as read by ReadLedger. Every entry is indexed as its own block,
+// following a genesis block.
+func NewLedgerIndex(entries []LedgerEntry) *Index {
+	ix := newIndex()
+	ix.height = 0
+	for _, e := range entries {
+		ix.height++
+		ix.add(IndexEntry{Height: ix.height, CreatedOn: e.CreatedOn, Transaction: e.Transaction})
+	}
+	ix.sortByTime()
+	return ix
+}
+
+func newIndex() *Index {
+	return &Index{
+		byTrader: make(map[uuid.UUID][]int),
+		byItem:   make(map[uuid.UUID][]int),
+		height:   -1,
+	}
+}
+
+// Update indexes the blocks appended to the provided blockchain since
+// it was last indexed, which must be the blockchain the index was
+// created from. If the blockchain reorganized to a branch that doesn't
+// extend the indexed chain, the index is rebuilt from its canonical chain.
+func (ix *Index) Update(b *Blockchain) {
+	it := b.ForwardFrom(ix.height + 1)
+	if ix.tail != nil && (ix.height >= len(it.chain) || it.chain[ix.height] != ix.tail) {
+		*ix = *newIndex()
+		it = b.ForwardFrom(0)
+	}
+	if ix.height == len(it.chain)-1 {
+		return
+	}
+	for it.Next() {
+		ix.height++
+		for _, txn := range it.block().txnTree.Transactions() {
+			ix.add(IndexEntry{Height: ix.height, CreatedOn: it.block().createdOn, Transaction: *txn})
+		}
+	}
+	ix.tail = it.chain[ix.height]
+	ix.sortByTime()
+}
+
+func (ix *Index) add(e IndexEntry) {
+	pos := len(ix.entries)
+	ix.entries = append(ix.entries, e)
+	t := e.Transaction
+	ix.byTrader[t.Credit.TraderID] = append(ix.byTrader[t.Credit.TraderID], pos)
+	if t.Debit.TraderID != t.Credit.TraderID {
+		ix.byTrader[t.Debit.TraderID] = append(ix.byTrader[t.Debit.TraderID], pos)
+	}
+	ix.byItem[t.Credit.Item.ID] = append(ix.byItem[t.Credit.Item.ID], pos)
+	if t.Debit.Item.ID != t.Credit.Item.ID {
+		ix.byItem[t.Debit.Item.ID] = append(ix.byItem[t.Debit.Item.ID], pos)
+	}
+	ix.byTime = append(ix.byTime, pos)
+}
+
+// sortByTime orders byTime by time, and then by height.
+func (ix *Index) sortByTime() {
+	sort.SliceStable(ix.byTime, func(i, j int) bool {
+		return ix.entries[ix.byTime[i]].CreatedOn.Before(ix.entries[ix.byTime[j]].CreatedOn)
+	})
+}
+
+// Len returns the number of indexed transactions.
+func (ix *Index) Len() int {
+	return len(ix.entries)
+}
+
+// Query represents a filter over the transactions of an index,
+// along with the page of matching transactions to return.
+// Zero values of the filter fields match every transaction.
+type Query struct {
+	// TraderID matches transactions where the trader
+	// is either the credit or debit party.
+	TraderID uuid.UUID
+	ItemID   uuid.UUID
+	// From and To match transactions whose block was
+	// initialized within [From, To).
+	From, To time.Time
+	// MinHeight and MaxHeight match transactions whose block height
+	// is within [MinHeight, MaxHeight], where a MaxHeight of 0 has no
+	// upper bound.
+	MinHeight, MaxHeight int
+	// Offset is the number of matching transactions to skip,
+	// and Limit is the maximum number to return, where
+	// a Limit of 0 returns every matching transaction.
+	Offset, Limit int
+}
+
+// Aggregate represents statistics over the transactions matching a query.
+type Aggregate struct {
+	Count int `json:"count"`
+	// Quantity is the sum of the quantity of the transactions.
+	Quantity float64 `json:"quantity"`
+	// VWAP is the volume-weighted average price of the transactions,
+	// or 0 if their quantity is 0.
+	VWAP float64 `json:"vwap"`
+}
+
+// QueryResult represents the page of transactions matching a query,
+// ordered by height, and statistics over every matching transaction.
+type QueryResult struct {
+	Entries   []IndexEntry `json:"entries"`
+	Aggregate Aggregate    `json:"aggregate"`
+}
+
+// Query returns the transactions of the index matching the provided query.
+func (ix *Index) Query(q Query) (QueryResult, error) {
+	if q.Offset < 0 || q.Limit < 0 {
+		return QueryResult{}, fmt.Errorf("%w: negative offset or limit: offset=%d limit=%d", ErrQuery, q.Offset, q.Limit)
+	}
+	if q.MinHeight < 0 || q.MaxHeight < 0 {
+		return QueryResult{}, fmt.Errorf("%w: negative height: min=%d max=%d", ErrQuery, q.MinHeight, q.MaxHeight)
+	}
+
+	var (
+		matches  []int
+		notional float64
+		result   = QueryResult{Entries: []IndexEntry{}}
+	)
+	for _, pos := range ix.candidates(q) {
+		e := ix.entries[pos]
+		if !q.matches(e) {
+			continue
+		}
+		matches = append(matches, pos)
+		result.Aggregate.Count++
+		result.Aggregate.Quantity += e.Transaction.Credit.Quantity
+		notional += e.Transaction.Credit.Price * e.Transaction.Credit.Quantity
+	}
+	if result.Aggregate.Quantity != 0 {
+		result.Aggregate.VWAP = notional / result.Aggregate.Quantity
+	}
+
+	sort.Ints(matches)
+	if q.Offset >= len(matches) {
+		return result, nil
+	}
+	matches = matches[q.Offset:]
+	if q.Limit > 0 && q.Limit < len(matches) {
+		matches = matches[:q.Limit]
+	}
+	for _, pos := range matches {
+		result.Entries = append(result.Entries, ix.entries[pos])
+	}
+	return result, nil
+}
+
+// candidates returns the positions of the transactions that may match
+// the provided query, from the most selective index that applies.
+func (ix *Index) candidates(q Query) []int {
+	var best []int
+	found := false
+	if q.TraderID != uuid.Nil {
+		best, found = ix.byTrader[q.TraderID], true
+	}
+	if q.ItemID != uuid.Nil {
+		if byItem := ix.byItem[q.ItemID]; !found || len(byItem) < len(best) {
+			best, found = byItem, true
+		}
+	}
+	if !q.From.IsZero() || !q.To.IsZero() {
+		lo := sort.Search(len(ix.byTime), func(i int) bool {
+			return !ix.entries[ix.byTime[i]].CreatedOn.Before(q.From)
+		})
+		hi := len(ix.byTime)
+		if !q.To.IsZero() {
+			hi = sort.Search(len(ix.byTime), func(i int) bool {
+				return !ix.entries[ix.byTime[i]].CreatedOn.Before(q.To)
+			})
+		}
+		if hi < lo {
+			hi = lo
+		}
+		if byTime := ix.byTime[lo:hi]; !found || len(byTime) < len(best) {
+			best, found = byTime, true
+		}
+	}
+	if q.MinHeight > 0 || q.MaxHeight > 0 {
+		lo := sort.Search(len(ix.entries), func(i int) bool { return ix.entries[i].Height >= q.MinHeight })
+		hi := len(ix.entries)
+		if q.MaxHeight > 0 {
+			hi = sort.Search(len(ix.entries), func(i int) bool { return ix.entries[i].Height > q.MaxHeight })
+		}
+		if hi < lo {
+			hi = lo
+		}
+		if !found || hi-lo < len(best) {
+			best = make([]int, 0, hi-lo)
+			for i := lo; i < hi; i++ {
+				best = append(best, i)
+			}
+			found = true
+		}
+	}
+	if !found {
+		best = make([]int, len(ix.entries))
+		for i := range best {
+			best[i] = i
+		}
+	}
+	return best
+}
+
+// matches returns whether the provided entry matches the query's filter.
+func (q Query) matches(e IndexEntry) bool {
+	t := e.Transaction
+	if q.TraderID != uuid.Nil && t.Credit.TraderID != q.TraderID && t.Debit.TraderID != q.TraderID {
+		return false
+	}
+	if q.ItemID != uuid.Nil && t.Credit.Item.ID != q.ItemID && t.Debit.Item.ID != q.ItemID {
+		return false
+	}
+	if !q.From.IsZero() && e.CreatedOn.Before(q.From) {
+		return false
+	}
+	if !q.To.IsZero() && !e.CreatedOn.Before(q.To) {
+		return false
+	}
+	if e.Height < q.MinHeight || (q.MaxHeight > 0 && e.Height > q.MaxHeight) {
+		return false
+	}
+	return true
+}
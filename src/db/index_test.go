@@ -0,0 +1,155 @@
+package db
+
+import (
+	"math"
+	"testing"
+	"time"
+	"tradesim/src/trade"
+
+	"github.com/google/uuid"
+)
+
+// indexedTrade returns a blockchain with a block for every provided
+// trade of an item between two traders, one second apart.
+func indexedTrade(t *testing.T, start time.Time, trades []trade.Transaction) *Blockchain {
+	b := NewBlockchain()
+	for i := range trades {
+		blk := NewBlock(&trades[i])
+		blk.createdOn = start.Add(time.Duration(i) * time.Second)
+		if ok := b.Append(blk); !ok {
+			t.Fatalf("append block %d", i)
+		}
+	}
+	return b
+}
+
+// TestIndexQuery asserts that querying an index filters transactions
+// by trader, item, time and height, paginates them by height,
+// and aggregates every matching transaction.
+func TestIndexQuery(t *testing.T) {
+	alice, bob, carol := uuid.New(), uuid.New(), uuid.New()
+	x, y := trade.NewItem("x"), trade.NewItem("y")
+	txn := func(credit, debit uuid.UUID, item trade.Item, price, quantity float64) trade.Transaction {
+		return trade.Transaction{
+			ID:     uuid.New(),
+			Credit: trade.TransactionRecord{TraderID: credit, Item: item, Price: price, Quantity: quantity},
+			Debit:  trade.TransactionRecord{TraderID: debit, Item: item, Price: price, Quantity: quantity},
+		}
+	}
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	b := indexedTrade(t, start, []trade.Transaction{
+		txn(alice, bob, x, 10, 1),
+		txn(alice, carol, y, 20, 2),
+		txn(bob, alice, x, 12, 3),
+		txn(carol, bob, x, 11, 1),
+		txn(alice, bob, x, 14, 4),
+	})
+	ix := NewIndex(b)
+	if expected, actual := 5, ix.Len(); expected != actual {
+		t.Fatalf("index length: expected: %d actual: %d", expected, actual)
+	}
+
+	tests := []struct {
+		name     string
+		query    Query
+		heights  []int
+		count    int
+		quantity float64
+		vwap     float64
+	}{
+		{
+			name:     "trader and item",
+			query:    Query{TraderID: alice, ItemID: x.ID},
+			heights:  []int{1, 3, 5},
+			count:    3,
+			quantity: 8,
+			vwap:     (10*1 + 12*3 + 14*4) / 8.0,
+		},
+		{
+			name:     "time range",
+			query:    Query{From: start.Add(time.Second), To: start.Add(3 * time.Second)},
+			heights:  []int{2, 3},
+			count:    2,
+			quantity: 5,
+			vwap:     (20*2 + 12*3) / 5.0,
+		},
+		{
+			name:     "height range",
+			query:    Query{ItemID: x.ID, MinHeight: 3, MaxHeight: 4},
+			heights:  []int{3, 4},
+			count:    2,
+			quantity: 4,
+			vwap:     (12*3 + 11*1) / 4.0,
+		},
+		{
+			name:     "page",
+			query:    Query{ItemID: x.ID, Offset: 1, Limit: 2},
+			heights:  []int{3, 4},
+			count:    4,
+			quantity: 9,
+			vwap:     (10*1 + 12*3 + 11*1 + 14*4) / 9.0,
+		},
+		{
+			name:    "no match",
+			query:   Query{TraderID: uuid.New()},
+			heights: []int{},
+		},
+	}
+	for _, test := range tests {
+		result, err := ix.Query(test.query)
+		if err != nil {
+			t.Fatalf("%s: query: %v", test.name, err)
+		}
+		heights := make([]int, len(result.Entries))
+		for i, e := range result.Entries {
+			heights[i] = e.Height
+		}
+		if !equalInts(test.heights, heights) {
+			t.Errorf("%s: heights: expected: %v actual: %v", test.name, test.heights, heights)
+		}
+		if expected, actual := test.count, result.Aggregate.Count; expected != actual {
+			t.Errorf("%s: count: expected: %d actual: %d", test.name, expected, actual)
+		}
+		if expected, actual := test.quantity, result.Aggregate.Quantity; expected != actual {
+			t.Errorf("%s: quantity: expected: %f actual: %f", test.name, expected, actual)
+		}
+		if expected, actual := test.vwap, result.Aggregate.VWAP; math.Abs(expected-actual) > 1e-9 {
+			t.Errorf("%s: vwap: expected: %f actual: %f", test.name, expected, actual)
+		}
+	}
+}
+
+// TestIndexUpdate asserts that updating an index
+// indexes only the blocks appended since it was built.
+func TestIndexUpdate(t *testing.T) {
+	b := NewBlockchain()
+	ix := NewIndex(b)
+	for i := 0; i < 3; i++ {
+		b.Append(NewBlock(&trade.Transaction{ID: uuid.New()}))
+		ix.Update(b)
+	}
+	ix.Update(b)
+
+	result, err := ix.Query(Query{})
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	if expected, actual := 3, result.Aggregate.Count; expected != actual {
+		t.Errorf("count: expected: %d actual: %d", expected, actual)
+	}
+	if expected, actual := 3, result.Entries[2].Height; expected != actual {
+		t.Errorf("last height: expected: %d actual: %d", expected, actual)
+	}
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
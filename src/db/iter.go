@@ -0,0 +1,157 @@
+package db
+
+import (
+	"sort"
+	"time"
+)
+
+// Iterator iterates over the blocks of the canonical chain of a
+// blockchain, as of when the iterator was created, so that blocks
+// appended and reorganizations after it's created aren't iterated.
+//
+// An iterator is used as
+//
+//     for it := b.Forward(); it.Next(); {
+//         blk := it.Block()
+//         ...
+//     }
+//
+// and isn't safe for concurrent use.
+type Iterator struct {
+	chain []*block
+	// next is the height of the next block, and step
+	// is 1 towards the tail, or -1 towards the head.
+	next, step int
+	// from and to bound the creation times of iterated
+	// blocks to [from, to), where zero values have no bound,
+	// and end is the height iteration stops before reaching.
+	from, to time.Time
+	end      int
+	// curr is the current block.
+	curr *block
+}
+
+// Forward returns an iterator over the blocks of the
+// canonical chain, from the head towards the tail.
+func (b *Blockchain) Forward() *Iterator {
+	return b.ForwardFrom(0)
+}
+
+// ForwardFrom returns an iterator over the blocks of the canonical
+// chain, from the block at the provided height towards the tail.
+func (b *Blockchain) ForwardFrom(height int) *Iterator {
+	chain := b.canonical()
+	if height < 0 {
+		height = 0
+	} else if height > len(chain) {
+		height = len(chain)
+	}
+	return &Iterator{chain: chain, next: height, step: 1, end: len(chain)}
+}
+
+// Backward returns an iterator over the blocks of the
+// canonical chain, from the tail towards the head.
+func (b *Blockchain) Backward() *Iterator {
+	chain := b.canonical()
+	return &Iterator{chain: chain, next: len(chain) - 1, step: -1, end: -1}
+}
+
+// Range returns an iterator over the blocks of the canonical chain
+// created within [from, to), from the head towards the tail, where
+// zero values of from and to have no bound. Blocks created before
+// from that precede every block created on or after it, and blocks
+// created on or after to that follow every block created before it,
+// are skipped without being visited.
+func (b *Blockchain) Range(from, to time.Time) *Iterator {
+	b.mu.RLock()
+	chain, earliest := b.chain[:len(b.chain):len(b.chain)], b.earliest
+	b.mu.RUnlock()
+	next := sort.Search(len(chain), func(i int) bool {
+		return !chain[i].latest.Before(from)
+	})
+	end := len(chain)
+	if !to.IsZero() {
+		end = sort.Search(len(chain), func(i int) bool {
+			return !earliest[i].Before(to)
+		})
+	}
+	return &Iterator{chain: chain, next: next, step: 1, from: from, to: to, end: end}
+}
+
+// Next advances the iterator to the next block,
+// and returns whether it has one.
+func (it *Iterator) Next() bool {
+	for it.next*it.step < it.end*it.step {
+		blk := it.chain[it.next]
+		it.next += it.step
+		if it.within(blk) {
+			it.curr = blk
+			return true
+		}
+	}
+	it.curr = nil
+	return false
+}
+
+// within returns whether the provided block
+// was created within the iterator's bounds.
+func (it *Iterator) within(blk *block) bool {
+	if !it.from.IsZero() && blk.createdOn.Before(it.from) {
+		return false
+	}
+	return it.to.IsZero() || blk.createdOn.Before(it.to)
+}
+
+// Block returns the current block.
+func (it *Iterator) Block() BlockInfo {
+	return newBlockInfo(it.curr)
+}
+
+// Header returns the header of the current block.
+func (it *Iterator) Header() BlockHeader {
+	return it.curr.header()
+}
+
+// block returns the current block.
+func (it *Iterator) block() *block {
+	return it.curr
+}
+
+// appendEarliest returns the provided earliest creation times of a chain
+// with a block created at the provided time appended to the chain. The
+// times are copied if any are lowered, since iterators may hold them.
+func appendEarliest(earliest []time.Time, createdOn time.Time) []time.Time {
+	i := sort.Search(len(earliest), func(i int) bool {
+		return earliest[i].After(createdOn)
+	})
+	if i < len(earliest) {
+		earliest = append(make([]time.Time, 0, len(earliest)+1), earliest...)
+		for j := i; j < len(earliest); j++ {
+			earliest[j] = createdOn
+		}
+	}
+	return append(earliest, createdOn)
+}
+
+// earliestOf returns the earliest creation times of
+// the provided chain from each height to its tail.
+func earliestOf(chain []*block) []time.Time {
+	earliest := make([]time.Time, len(chain), cap(chain))
+	for i := len(chain) - 1; i >= 0; i-- {
+		earliest[i] = chain[i].createdOn
+		if i+1 < len(chain) && earliest[i+1].Before(earliest[i]) {
+			earliest[i] = earliest[i+1]
+		}
+	}
+	return earliest
+}
+
+// canonical returns the blocks of the canonical chain by height.
+// Reorganizations replace the slice rather than modifying it,
+// and appended blocks are beyond its length, so it's never
+// modified once it's returned.
+func (b *Blockchain) canonical() []*block {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.chain[:len(b.chain):len(b.chain)]
+}
@@ -0,0 +1,106 @@
+package db
+
+import (
+	"reflect"
+	"testing"
+	"time"
+	"tradesim/src/trade"
+
+	"github.com/google/uuid"
+)
+
+// TestIterator asserts that the canonical chain is iterated from the head
+// and from the tail, from a height, and within a range of creation times.
+func TestIterator(t *testing.T) {
+	b := NewBlockchain()
+	start := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	ids := make([]uuid.UUID, 4)
+	for i := range ids {
+		ids[i] = uuid.New()
+		b.Append(NewBlockAt(&trade.Transaction{ID: ids[i]}, start.Add(time.Duration(i)*time.Hour)))
+	}
+
+	var forward, backward []int
+	for it := b.Forward(); it.Next(); {
+		forward = append(forward, it.Block().Height)
+	}
+	for it := b.Backward(); it.Next(); {
+		backward = append(backward, it.Header().Height)
+	}
+	if expected, actual := []int{0, 1, 2, 3, 4}, forward; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("forward: expected: %v actual: %v", expected, actual)
+	}
+	if expected, actual := []int{4, 3, 2, 1, 0}, backward; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("backward: expected: %v actual: %v", expected, actual)
+	}
+
+	if it := b.ForwardFrom(3); !it.Next() || it.Block().Transactions[0].ID != ids[2] {
+		t.Errorf("forward from 3: expected transaction: %s", ids[2])
+	}
+
+	var ranged []uuid.UUID
+	for it := b.Range(start.Add(time.Hour), start.Add(3*time.Hour)); it.Next(); {
+		ranged = append(ranged, it.Block().Transactions[0].ID)
+	}
+	if len(ranged) != 2 || ranged[0] != ids[1] || ranged[1] != ids[2] {
+		t.Errorf("range: expected: %s %s actual: %v", ids[1], ids[2], ranged)
+	}
+}
+
+// TestRangeEnd asserts that a range stops before the blocks created after
+// its end that follow every block created before it, and still iterates
+// blocks appended out of order of creation.
+func TestRangeEnd(t *testing.T) {
+	b := NewBlockchain()
+	start := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 4; i++ {
+		b.Append(NewBlockAt(&trade.Transaction{ID: uuid.New()}, start.Add(time.Duration(i)*time.Hour)))
+	}
+	if expected, actual := 3, b.Range(start, start.Add(2*time.Hour)).end; expected != actual {
+		t.Errorf("end: expected: %d actual: %d", expected, actual)
+	}
+
+	late := uuid.New()
+	b.Append(NewBlockAt(&trade.Transaction{ID: late}, start.Add(90*time.Minute)))
+	it := b.Range(start.Add(time.Hour), start.Add(2*time.Hour))
+	if expected, actual := 6, it.end; expected != actual {
+		t.Errorf("end: expected: %d actual: %d", expected, actual)
+	}
+	var ranged []uuid.UUID
+	for it.Next() {
+		ranged = append(ranged, it.Block().Transactions[0].ID)
+	}
+	if len(ranged) != 2 || ranged[1] != late {
+		t.Errorf("range: expected: 2 blocks ending in %s actual: %v", late, ranged)
+	}
+}
+
+// TestIteratorReorg asserts that an iterator iterates over the canonical
+// chain as of when it was created, and lookups by height follow a
+// reorganization.
+func TestIteratorReorg(t *testing.T) {
+	b := NewBlockchain()
+	gen := b.Last().Hash
+	b.Append(NewBlock(&trade.Transaction{ID: uuid.New()}))
+	old := b.Last().Hash
+	it := b.Forward()
+
+	b.Extend(gen, NewBlock(&trade.Transaction{ID: uuid.New()}))
+	b.Extend(b.Tips()[1], NewBlock(&trade.Transaction{ID: uuid.New()}))
+	if expected, actual := 3, b.Len(); expected != actual {
+		t.Fatalf("length: expected: %d actual: %d", expected, actual)
+	}
+	var hashes []string
+	for it.Next() {
+		hashes = append(hashes, it.Block().Hash)
+	}
+	if len(hashes) != 2 || hashes[1] != old {
+		t.Errorf("iterated: expected: 2 blocks ending in %s actual: %v", old, hashes)
+	}
+	if blk, ok := b.Block(2); !ok || blk.Hash != b.Last().Hash {
+		t.Errorf("block 2: expected: %s actual: %+v", b.Last().Hash, blk)
+	}
+	if blk, _ := b.Block(1); blk.Hash == old {
+		t.Errorf("block 1: expected: new branch actual: %s", blk.Hash)
+	}
+}
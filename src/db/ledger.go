@@ -0,0 +1,119 @@
+package db
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+	"tradesim/src/trade"
+
+	"github.com/google/uuid"
+)
+
+var ErrLedger = errors.New("failed to read ledger")
+
+// blockLine matches a line written by Blockchain.Write.
+var blockLine = regexp.MustCompile(`^block created on=(\S+) tree size=(\d+) root hash=\S* first transaction=\[(.*)\]$`)
+
+// txnLine matches a transaction formatted by trade.Transaction.String.
+var txnLine = regexp.MustCompile(`^transaction id=(\S+) ` +
+	`credit trader id=(\S+) credit item id=(\S+) credit item name=(.*) credit price=(\S+) credit quantity=(\S+) ` +
+	`debit trader id=(\S+) debit item id=(\S+) debit item name=(.*) debit price=(\S+) debit quantity=(\S+)$`)
+
+// LedgerEntry represents a transaction read from a written blockchain,
+// along with the time its block was initialized.
+type LedgerEntry struct {
+	CreatedOn   time.Time
+	Transaction trade.Transaction
+}
+
+// ReadLedger reads the transactions of a blockchain written by
+// Blockchain.Write from the file at the provided path, in the order
+// their blocks were appended. Blocks without transactions are skipped.
+func ReadLedger(filepath string) ([]LedgerEntry, error) {
+	f, err := os.Open(filepath)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrLedger, err)
+	}
+	defer f.Close()
+
+	var entries []LedgerEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		m := blockLine.FindStringSubmatch(scanner.Text())
+		if m == nil {
+			// Lines other than blocks, such as regime
+			// state changes, aren't part of the ledger.
+			continue
+		}
+		if m[2] == "0" {
+			continue
+		}
+		createdOn, err := time.Parse(time.RFC3339Nano, m[1])
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d: %v", ErrLedger, n, err)
+		}
+		txn, err := parseTransaction(m[3])
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d: %v", ErrLedger, n, err)
+		}
+		entries = append(entries, LedgerEntry{CreatedOn: createdOn, Transaction: txn})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrLedger, err)
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].CreatedOn.Before(entries[j].CreatedOn) })
+	return entries, nil
+}
+
+func parseTransaction(s string) (trade.Transaction, error) {
+	m := txnLine.FindStringSubmatch(s)
+	if m == nil {
+		return trade.Transaction{}, fmt.Errorf("malformed transaction: %s", s)
+	}
+	id, err := uuid.Parse(m[1])
+	if err != nil {
+		return trade.Transaction{}, err
+	}
+	credit, err := parseTransactionRecord(m[2:7])
+	if err != nil {
+		return trade.Transaction{}, err
+	}
+	debit, err := parseTransactionRecord(m[7:12])
+	if err != nil {
+		return trade.Transaction{}, err
+	}
+	return trade.Transaction{ID: id, Credit: credit, Debit: debit}, nil
+}
+
+// parseTransactionRecord parses the trader ID, item ID,
+// item name, price and quantity of a transaction record.
+func parseTransactionRecord(fields []string) (trade.TransactionRecord, error) {
+	traderID, err := uuid.Parse(fields[0])
+	if err != nil {
+		return trade.TransactionRecord{}, err
+	}
+	itemID, err := uuid.Parse(fields[1])
+	if err != nil {
+		return trade.TransactionRecord{}, err
+	}
+	price, err := strconv.ParseFloat(fields[3], 64)
+	if err != nil {
+		return trade.TransactionRecord{}, err
+	}
+	quantity, err := strconv.ParseFloat(fields[4], 64)
+	if err != nil {
+		return trade.TransactionRecord{}, err
+	}
+	return trade.TransactionRecord{
+		TraderID: traderID,
+		Item:     trade.Item{ID: itemID, Name: fields[2]},
+		Price:    price,
+		Quantity: quantity,
+	}, nil
+}
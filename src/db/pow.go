@@ -0,0 +1,174 @@
+package db
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+	"time"
+)
+
+const (
+	// MaxDifficulty is the largest difficulty of a block, in leading zero
+	// bits of its hash, beyond which blocks can't practically be mined
+	// within a simulation.
+	MaxDifficulty = 32
+	// maxRetarget bounds the number of bits a difficulty
+	// changes by in a single retarget.
+	maxRetarget = 2
+)
+
+var ErrDifficulty = errors.New("invalid block difficulty")
+
+// ProofOfWork configures the proof of work of the blocks of a blockchain.
+//
+// A block is sealed by searching for a nonce such that its hash has at
+// least the difficulty's number of leading zero bits, so that every bit
+// of difficulty doubles the expected number of hashes to seal a block.
+//
+// If the retarget interval and target interval are positive, the
+// difficulty is retargeted every retarget interval of blocks, by the
+// number of bits that brings the time between the blocks of the last
+// interval closest to the target interval, changing by at most 2 bits
+// at a time and staying between the minimum and maximum difficulty.
+// Every block of a branch is sealed with the difficulty retargeted over
+// its own branch, so that its difficulty can be verified.
+type ProofOfWork struct {
+	Difficulty       int
+	TargetInterval   time.Duration
+	RetargetInterval int
+	MinDifficulty    int
+	// MaxDifficulty bounds retargeted difficulties;
+	// if 0, MaxDifficulty is used.
+	MaxDifficulty int
+}
+
+// MiningStats represents the statistics of the blocks mined
+// by a blockchain, and the difficulty of its next block.
+type MiningStats struct {
+	Blocks     int           `json:"blocks"`
+	Hashes     uint64        `json:"hashes"`
+	Duration   time.Duration `json:"duration"`
+	Difficulty int           `json:"difficulty"`
+}
+
+// HashRate returns the number of hashes per second of the mined blocks.
+func (s MiningStats) HashRate() float64 {
+	if s.Duration <= 0 {
+		return 0
+	}
+	return float64(s.Hashes) / s.Duration.Seconds()
+}
+
+// SetProofOfWork sets the proof of work of the blocks appended to the
+// blockchain, starting at its difficulty. The difficulty of every block
+// of the block tree is verified to be the difficulty retargeted over its
+// branch, and if one isn't, ErrDifficulty is returned and the proof of
+// work isn't set.
+func (b *Blockchain) SetProofOfWork(pow ProofOfWork) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if pow.MaxDifficulty <= 0 || pow.MaxDifficulty > MaxDifficulty {
+		pow.MaxDifficulty = MaxDifficulty
+	}
+	// Blocks are ordered after their parents,
+	// so every parent's next difficulty is set.
+	next := make(map[*block]int, len(b.order))
+	for _, blk := range b.order {
+		if blk.prevP == nil {
+			next[blk] = clampDifficulty(pow.Difficulty, pow.MinDifficulty, pow.MaxDifficulty)
+			continue
+		}
+		required := next[blk.prevP]
+		if blk.difficulty != required {
+			return fmt.Errorf("%w: block=%s height=%d expected=%d got=%d", ErrDifficulty, blk.id, blk.height, required, blk.difficulty)
+		}
+		next[blk] = retarget(pow, blk, required)
+	}
+	for blk, d := range next {
+		blk.next = d
+	}
+	b.pow = pow
+	b.mining.Difficulty = b.tail.next
+	return nil
+}
+
+// NonceSeed returns the nonce the nonce search of every block
+// sealed by the blockchain starts from.
+func (b *Blockchain) NonceSeed() uint64 {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.nonceSeed
+}
+
+// SetNonceSeed sets the nonce the nonce search of every block sealed by
+// the blockchain starts from, which is drawn at random when it's created,
+// so that it seals the blocks another blockchain of the seed seals
+// identically.
+func (b *Blockchain) SetNonceSeed(seed uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.nonceSeed = seed
+}
+
+// Mining returns the statistics of the blocks
+// mined since the blockchain was created or opened.
+func (b *Blockchain) Mining() MiningStats {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.mining
+}
+
+// retarget returns the provided difficulty of the provided block,
+// retargeted by the provided proof of work if the block completes
+// a retarget interval of its branch.
+func retarget(pow ProofOfWork, blk *block, difficulty int) int {
+	n := pow.RetargetInterval
+	if n <= 0 || pow.TargetInterval <= 0 || blk.height == 0 || blk.height%n != 0 {
+		return difficulty
+	}
+	first := blk
+	for i := 0; i < n && first.prevP != nil; i++ {
+		first = first.prevP
+	}
+	actual := blk.createdOn.Sub(first.createdOn)
+	if actual <= 0 {
+		actual = time.Nanosecond
+	}
+	expected := pow.TargetInterval * time.Duration(n)
+	delta := int(math.Round(math.Log2(float64(expected) / float64(actual))))
+	if delta > maxRetarget {
+		delta = maxRetarget
+	} else if delta < -maxRetarget {
+		delta = -maxRetarget
+	}
+	return clampDifficulty(difficulty+delta, pow.MinDifficulty, pow.MaxDifficulty)
+}
+
+func clampDifficulty(d, min, max int) int {
+	if d < min {
+		d = min
+	}
+	if d > max {
+		d = max
+	}
+	if d < 0 {
+		d = 0
+	}
+	return d
+}
+
+// meetsDifficulty returns whether the provided hash has
+// at least the provided number of leading zero bits.
+func meetsDifficulty(hash [sha256.Size]byte, difficulty int) bool {
+	zeros := 0
+	for _, c := range hash {
+		if c != 0 {
+			zeros += bits.LeadingZeros8(c)
+			break
+		}
+		zeros += 8
+	}
+	return zeros >= difficulty
+}
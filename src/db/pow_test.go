@@ -0,0 +1,142 @@
+package db
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"path"
+	"strings"
+	"testing"
+	"time"
+	"tradesim/src/trade"
+
+	"github.com/google/uuid"
+)
+
+// TestProofOfWork asserts that appended blocks are sealed with hashes
+// meeting the difficulty, which is verified when they're reloaded.
+func TestProofOfWork(t *testing.T) {
+	filepath := path.Join(t.TempDir(), "chain.db")
+	b, err := Open(filepath)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if err := b.SetProofOfWork(ProofOfWork{Difficulty: 8}); err != nil {
+		t.Fatalf("set proof of work: %v", err)
+	}
+	for i := 0; i < 4; i++ {
+		if ok := b.Append(NewBlock(&trade.Transaction{ID: uuid.New()})); !ok {
+			t.Fatalf("append block %d", i)
+		}
+	}
+	for curr := b.tail; curr.prevP != nil; curr = curr.prevP {
+		if h, _ := curr.hash(); h[0] != 0 || curr.difficulty != 8 {
+			t.Errorf("block hash: expected: difficulty 8 actual: %x difficulty %d", h, curr.difficulty)
+		}
+	}
+	stats := b.Mining()
+	if stats.Blocks != 4 || stats.Hashes < 4 || stats.Difficulty != 8 {
+		t.Errorf("mining stats: expected: 4 blocks of difficulty 8 actual: %+v", stats)
+	}
+	// Tampering with the transactions of a sealed
+	// block invalidates its proof of work.
+	b.tail.txnTree.Root.hash = strings.Repeat("ab", sha256.Size)
+	if err := b.Save(filepath); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	b.Close()
+	if _, err := Load(filepath); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("load tampered: expected: %v actual: %v", ErrCorrupt, err)
+	}
+}
+
+// TestRetarget asserts that the difficulty is retargeted by the time
+// between the blocks of every retarget interval.
+func TestRetarget(t *testing.T) {
+	b := NewBlockchain()
+	if err := b.SetProofOfWork(ProofOfWork{Difficulty: 2, TargetInterval: time.Second, RetargetInterval: 2, MaxDifficulty: 4}); err != nil {
+		t.Fatalf("set proof of work: %v", err)
+	}
+	start := b.head.createdOn
+	appendAt := func(d time.Duration) {
+		blk := NewBlock(&trade.Transaction{ID: uuid.New()})
+		blk.createdOn = start.Add(d)
+		if ok := b.Append(blk); !ok {
+			t.Fatalf("append block")
+		}
+	}
+
+	tests := []struct {
+		name       string
+		interval   time.Duration
+		difficulty int
+	}{
+		{name: "on target", interval: time.Second, difficulty: 2},
+		{name: "twice as fast", interval: 500 * time.Millisecond, difficulty: 3},
+		{name: "much faster", interval: time.Millisecond, difficulty: 4},
+		{name: "much slower", interval: time.Minute, difficulty: 2},
+	}
+	elapsed := time.Duration(0)
+	for _, test := range tests {
+		for i := 0; i < 2; i++ {
+			elapsed += test.interval
+			appendAt(elapsed)
+		}
+		if expected, actual := test.difficulty, b.Mining().Difficulty; expected != actual {
+			t.Errorf("%s: difficulty: expected: %d actual: %d", test.name, expected, actual)
+		}
+	}
+}
+
+// TestVerifyDifficulty asserts that a reloaded blockchain whose blocks
+// weren't sealed with the difficulty retargeted over their branch is
+// rejected by its proof of work.
+func TestVerifyDifficulty(t *testing.T) {
+	filepath := path.Join(t.TempDir(), "chain.db")
+	b, err := Open(filepath)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	pow := ProofOfWork{Difficulty: 4}
+	if err := b.SetProofOfWork(pow); err != nil {
+		t.Fatalf("set proof of work: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if ok := b.Append(NewBlock(&trade.Transaction{ID: uuid.New()})); !ok {
+			t.Fatalf("append block %d", i)
+		}
+	}
+	b.Close()
+
+	b, err = Load(filepath)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if err := b.SetProofOfWork(pow); err != nil {
+		t.Errorf("verify: expected: nil actual: %v", err)
+	}
+	if err := b.SetProofOfWork(ProofOfWork{Difficulty: 8}); !errors.Is(err, ErrDifficulty) {
+		t.Errorf("verify harder: expected: %v actual: %v", ErrDifficulty, err)
+	}
+	if stats := b.Mining(); stats.Difficulty != 4 {
+		t.Errorf("rejected difficulty: expected: 4 actual: %d", stats.Difficulty)
+	}
+}
+
+// TestAppendCanceled asserts that mining a block stops once its
+// context is canceled, and that the block isn't appended.
+func TestAppendCanceled(t *testing.T) {
+	b := NewBlockchain()
+	if err := b.SetProofOfWork(ProofOfWork{Difficulty: MaxDifficulty}); err != nil {
+		t.Fatalf("set proof of work: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := b.AppendContext(ctx, NewBlock(&trade.Transaction{ID: uuid.New()}))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("append: expected: %v actual: %v", context.DeadlineExceeded, err)
+	}
+	if b.Len() != 1 {
+		t.Errorf("length: expected: 1 actual: %d", b.Len())
+	}
+}
@@ -0,0 +1,134 @@
+package db
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"tradesim/src/trade"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrSignature = errors.New("invalid transaction signature")
+	ErrQuote     = errors.New("quoted quantity exceeded")
+)
+
+// quoteEpsilon is the quantity by which the transactions of a quote may
+// exceed its quoted quantity, which absorbs the rounding of partial fills.
+const quoteEpsilon = 1e-9
+
+// RegisterKey registers the public key of the trader with the provided ID.
+// Once any key is registered, blocks are only added to the blockchain if
+// every transaction of the block is signed by both of its traders with
+// their registered keys, and the transactions of every quote on the
+// block's branch don't execute more than its quoted quantity.
+func (b *Blockchain) RegisterKey(traderID uuid.UUID, key ed25519.PublicKey) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.keys == nil {
+		b.keys = make(map[uuid.UUID]ed25519.PublicKey)
+	}
+	b.keys[traderID] = key
+}
+
+// Verify returns an error if keys are registered with the blockchain, and
+// the provided transaction isn't signed by both of its traders with them,
+// or ErrQuote if it executes more than the quoted quantity of its quote
+// along with the transactions of the canonical chain.
+func (b *Blockchain) Verify(t *trade.Transaction) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if err := b.verify(t); err != nil {
+		return err
+	}
+	return b.verifyQuotes([]*trade.Transaction{t}, b.tail)
+}
+
+// verify verifies the provided transaction. The caller must hold the lock.
+func (b *Blockchain) verify(t *trade.Transaction) error {
+	if len(b.keys) == 0 {
+		return nil
+	}
+	credit, ok := b.keys[t.Credit.TraderID]
+	if !ok {
+		return fmt.Errorf("%w: transaction %s: unregistered credit trader %s", ErrSignature, t.ID, t.Credit.TraderID)
+	}
+	debit, ok := b.keys[t.Debit.TraderID]
+	if !ok {
+		return fmt.Errorf("%w: transaction %s: unregistered debit trader %s", ErrSignature, t.ID, t.Debit.TraderID)
+	}
+	if !t.Verify(credit, debit) {
+		return fmt.Errorf("%w: transaction %s", ErrSignature, t.ID)
+	}
+	return nil
+}
+
+// verifyBlock verifies every transaction of the provided block.
+func (b *Blockchain) verifyBlock(blk *block) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, t := range blk.txnTree.Transactions() {
+		if err := b.verify(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// verifyQuotes returns ErrQuote if keys are registered with the blockchain,
+// and the provided transactions, along with the transactions of the branch
+// ending in the provided block, execute more than the quoted quantity of
+// any of their quotes. The caller must hold the lock.
+func (b *Blockchain) verifyQuotes(txns []*trade.Transaction, tip *block) error {
+	if len(b.keys) == 0 {
+		return nil
+	}
+	executed := make(map[uuid.UUID]float64)
+	for _, t := range txns {
+		if t.QuoteID == uuid.Nil {
+			continue
+		}
+		if _, ok := executed[t.QuoteID]; !ok {
+			executed[t.QuoteID] = b.executed(t.QuoteID, tip)
+		}
+		executed[t.QuoteID] += t.Credit.Quantity
+		if executed[t.QuoteID] > t.Quoted+quoteEpsilon {
+			return fmt.Errorf("%w: transaction %s: quote %s: quoted=%f executed=%f", ErrQuote, t.ID, t.QuoteID, t.Quoted, executed[t.QuoteID])
+		}
+	}
+	return nil
+}
+
+// executed returns the quantity of the quote with the provided ID executed
+// by the transactions of the branch ending in the provided block.
+// The caller must hold the lock.
+func (b *Blockchain) executed(quoteID uuid.UUID, tip *block) float64 {
+	var quantity float64
+	for _, blk := range b.quotes[quoteID] {
+		if !b.inBranch(blk, tip) {
+			continue
+		}
+		for _, t := range blk.txnTree.Transactions() {
+			if t.QuoteID == quoteID {
+				quantity += t.Credit.Quantity
+			}
+		}
+	}
+	return quantity
+}
+
+// inBranch returns whether the provided block is in the branch ending
+// in the provided tip. The caller must hold the lock.
+func (b *Blockchain) inBranch(blk, tip *block) bool {
+	if blk.height > tip.height {
+		return false
+	}
+	if tip.height < len(b.chain) && b.chain[tip.height] == tip {
+		return b.chain[blk.height] == blk
+	}
+	for tip.height > blk.height {
+		tip = tip.prevP
+	}
+	return tip == blk
+}
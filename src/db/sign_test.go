@@ -0,0 +1,131 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"tradesim/src/trade"
+
+	"github.com/google/uuid"
+)
+
+// TestVerify asserts that once keys are registered, only blocks of
+// transactions signed by both of their traders are added, and that the
+// signatures cover the transaction's terms and the quote's quantity.
+func TestVerify(t *testing.T) {
+	item := trade.NewItem("a")
+	var traders [3]*trade.Trader
+	for i := range traders {
+		trader, err := trade.NewTrader(nil, nil, nil)
+		if err != nil {
+			t.Fatalf("new trader: %v", err)
+		}
+		traders[i] = trader
+	}
+	buyer, seller, other := traders[0], traders[1], traders[2]
+	b := NewBlockchain()
+	b.RegisterKey(buyer.ID, buyer.PublicKey())
+	b.RegisterKey(seller.ID, seller.PublicKey())
+
+	signed := func(fill float64, chooser *trade.Trader) trade.Transaction {
+		resp := trade.Response{ID: uuid.New(), TraderID: seller.ID}
+		resp.Request = trade.Request{ID: uuid.New(), TraderID: buyer.ID, Item: item, Quantity: 2, Side: trade.SideBuy}
+		resp.OrderBook.Ask.Item, resp.OrderBook.Ask.Price, resp.OrderBook.Ask.Quantity = item, 1.5, 2
+		seller.SignQuote(&resp)
+		resp.Fill = fill
+		chooser.SignChoice(&resp)
+		txn := resp.Transaction()
+		txn.ID = uuid.New()
+		return txn
+	}
+
+	partial := signed(1, buyer)
+	if err := b.Verify(&partial); err != nil {
+		t.Errorf("partial fill: expected: verified actual: %v", err)
+	}
+	if !b.Append(NewBlock(&partial)) {
+		t.Errorf("append: expected: appended actual: rejected")
+	}
+
+	tests := []struct {
+		name   string
+		tamper func(*trade.Transaction)
+	}{
+		{name: "price", tamper: func(txn *trade.Transaction) { txn.Debit.Price = 1 }},
+		{name: "item name", tamper: func(txn *trade.Transaction) { txn.Credit.Item.Name = "b" }},
+		{name: "quoted", tamper: func(txn *trade.Transaction) { txn.Quoted = 3 }},
+		{name: "over quote", tamper: func(txn *trade.Transaction) { txn.Credit.Quantity, txn.Debit.Quantity = 3, 3 }},
+		{name: "unregistered", tamper: func(txn *trade.Transaction) { txn.Credit.TraderID = other.ID }},
+		{name: "unsigned", tamper: func(txn *trade.Transaction) { txn.DebitSignature = nil }},
+	}
+	for _, test := range tests {
+		txn := signed(0, buyer)
+		test.tamper(&txn)
+		if err := b.Verify(&txn); !errors.Is(err, ErrSignature) {
+			t.Errorf("%s: expected: %v actual: %v", test.name, ErrSignature, err)
+		}
+		if b.Append(NewBlock(&txn)) {
+			t.Errorf("%s: append: expected: rejected actual: appended", test.name)
+		}
+	}
+	forged := signed(0, other)
+	if _, err := b.Extend(b.Last().Hash, NewBlock(&forged)); !errors.Is(err, ErrSignature) {
+		t.Errorf("forged choice: expected: %v actual: %v", ErrSignature, err)
+	}
+	if expected, actual := 2, b.Len(); expected != actual {
+		t.Errorf("length: expected: %d actual: %d", expected, actual)
+	}
+}
+
+// TestVerifyQuote asserts that once keys are registered, the transactions
+// of a quote don't execute more than its quoted quantity on any branch,
+// while a competing branch may execute the quantity again.
+func TestVerifyQuote(t *testing.T) {
+	item := trade.NewItem("a")
+	buyer, err := trade.NewTrader(nil, nil, nil)
+	if err != nil {
+		t.Fatalf("new trader: %v", err)
+	}
+	seller, err := trade.NewTrader(nil, nil, nil)
+	if err != nil {
+		t.Fatalf("new trader: %v", err)
+	}
+	b := NewBlockchain()
+	b.RegisterKey(buyer.ID, buyer.PublicKey())
+	b.RegisterKey(seller.ID, seller.PublicKey())
+	genesis, _ := b.Block(0)
+
+	resp := trade.Response{ID: uuid.New(), TraderID: seller.ID}
+	resp.Request = trade.Request{ID: uuid.New(), TraderID: buyer.ID, Item: item, Quantity: 2, Side: trade.SideBuy}
+	resp.OrderBook.Ask.Item, resp.OrderBook.Ask.Price, resp.OrderBook.Ask.Quantity = item, 1.5, 2
+	seller.SignQuote(&resp)
+	fill := func(quantity float64) trade.Transaction {
+		r := resp
+		r.Fill = quantity
+		buyer.SignChoice(&r)
+		txn := r.Transaction()
+		txn.ID = uuid.New()
+		return txn
+	}
+
+	first, second := fill(1.5), fill(1)
+	if !b.Append(NewBlock(&first)) {
+		t.Fatalf("append: expected: appended actual: rejected")
+	}
+	if err := b.Verify(&second); !errors.Is(err, ErrQuote) {
+		t.Errorf("verify: expected: %v actual: %v", ErrQuote, err)
+	}
+	if err := b.AppendContext(context.Background(), NewBlock(&second)); !errors.Is(err, ErrQuote) {
+		t.Errorf("append: expected: %v actual: %v", ErrQuote, err)
+	}
+	if _, err := b.Extend(genesis.Hash, NewBlock(&second)); err != nil {
+		t.Errorf("extend competing branch: expected: added actual: %v", err)
+	}
+	rest := fill(0.5)
+	if !b.Append(NewBlock(&rest)) {
+		t.Errorf("append rest: expected: appended actual: rejected")
+	}
+	if expected, actual := 3, b.Len(); expected != actual {
+		t.Errorf("length: expected: %d actual: %d", expected, actual)
+	}
+}
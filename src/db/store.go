@@ -0,0 +1,305 @@
+package db
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"time"
+)
+
+const (
+	// storeMagic identifies a blockchain file, followed by its format version.
+	storeMagic   = "tradesim"
+	storeVersion = uint32(2)
+	// storeHeaderLen is the length of the file header.
+	storeHeaderLen = len(storeMagic) + 4
+	// recordHeaderLen is the length of a record's
+	// payload length and payload checksum.
+	recordHeaderLen = 8
+	// maxRecordLen bounds the payload length of a record,
+	// so that a corrupt length isn't allocated.
+	maxRecordLen = 1 << 30
+)
+
+var (
+	ErrStore   = errors.New("failed to access blockchain file")
+	ErrCorrupt = errors.New("corrupt blockchain file")
+)
+
+// store is an append-only file of the blocks of a blockchain.
+//
+// A blockchain file has the form
+//
+//     [magic] [version] [record] [record] ...
+//
+// where every record is a single block, in the order the blocks were
+// added, starting with the genesis block, so that every block follows
+// its parent. A record has the form
+//
+//     [length] [checksum] [payload]
+//
+// where the length is the number of bytes of the payload, the checksum
+// is the CRC-32 (IEEE) of the payload, both are big-endian uint32s,
+// and the payload is the canonical encoding of the block, including the
+// full structure of its transaction tree, as returned by EncodeBlock.
+type store struct {
+	f *os.File
+}
+
+// Open returns the blockchain stored in the file at the provided path,
+// to which every block appended to the blockchain is persisted.
+// If the file doesn't exist, it's created with a new blockchain.
+//
+// A record at the end of the file that was only partially written,
+// such as by a crash during an append, is truncated.
+func Open(filepath string) (*Blockchain, error) {
+	return OpenAt(filepath, time.Now().UTC())
+}
+
+// OpenAt is like Open, but a new blockchain's genesis block is created on
+// the provided simulated time, so that a simulation's blockchain file has
+// the genesis block of its epoch.
+func OpenAt(filepath string, at time.Time) (*Blockchain, error) {
+	f, err := os.OpenFile(filepath, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrStore, err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%w: %v", ErrStore, err)
+	}
+
+	s := &store{f: f}
+	if info.Size() == 0 {
+		b := NewBlockchainAt(at)
+		if err := s.writeHeader(); err != nil {
+			f.Close()
+			return nil, err
+		}
+		if err := s.append(b.head); err != nil {
+			f.Close()
+			return nil, err
+		}
+		b.store = s
+		return b, nil
+	}
+
+	b, end, err := load(bufio.NewReader(f))
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if end < info.Size() {
+		if err := f.Truncate(end); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("%w: %v", ErrStore, err)
+		}
+	}
+	if _, err := f.Seek(end, io.SeekStart); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%w: %v", ErrStore, err)
+	}
+	b.store = s
+	return b, nil
+}
+
+// Load returns the blockchain stored in the file at the provided path,
+// without modifying the file. A record at the end of the file that was
+// only partially written is ignored.
+func Load(filepath string) (*Blockchain, error) {
+	f, err := os.Open(filepath)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrStore, err)
+	}
+	defer f.Close()
+	b, _, err := load(bufio.NewReader(f))
+	return b, err
+}
+
+// Save writes every block of the block tree to a new blockchain file at
+// the provided path, replacing any existing file only once it's complete,
+// so that a crash while saving leaves the existing file intact.
+func (b *Blockchain) Save(filepath string) error {
+	b.mu.RLock()
+	blocks := append([]*block{}, b.order...)
+	b.mu.RUnlock()
+
+	tmp := filepath + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrStore, err)
+	}
+	s := &store{f: f}
+	if err := s.writeHeader(); err != nil {
+		f.Close()
+		return err
+	}
+	for _, blk := range blocks {
+		if err := s.append(blk); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("%w: %v", ErrStore, err)
+	}
+	if err := os.Rename(tmp, filepath); err != nil {
+		return fmt.Errorf("%w: %v", ErrStore, err)
+	}
+	return nil
+}
+
+// load reads the blocks of a blockchain file, verifying every record
+// checksum, block hash pointer and proof of work, and returns the
+// blockchain, with the canonical chain chosen from its block tree,
+// along with the offset of the end of its last complete record.
+func load(r io.Reader) (*Blockchain, int64, error) {
+	header := make([]byte, storeHeaderLen)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, 0, fmt.Errorf("%w: header: %v", ErrCorrupt, err)
+	}
+	if string(header[:len(storeMagic)]) != storeMagic {
+		return nil, 0, fmt.Errorf("%w: not a blockchain file", ErrCorrupt)
+	}
+	version := binary.BigEndian.Uint32(header[len(storeMagic):])
+	if version != storeVersion {
+		return nil, 0, fmt.Errorf("%w: unsupported version: supported=%d got=%d", ErrCorrupt, storeVersion, version)
+	}
+
+	var b *Blockchain
+	end := int64(storeHeaderLen)
+	for {
+		payload, err := readRecord(r)
+		if err == io.EOF {
+			break
+		} else if errors.Is(err, io.ErrUnexpectedEOF) {
+			// The final record is torn; everything before it is intact.
+			break
+		} else if err != nil {
+			return nil, 0, err
+		}
+
+		blk, parentID, err := decodeBlock(payload)
+		if err != nil {
+			return nil, 0, fmt.Errorf("%w: block %d: %v", ErrCorrupt, blockCount(b), err)
+		}
+		hash, err := blk.hash()
+		if err != nil {
+			return nil, 0, fmt.Errorf("%w: block %d: %v", ErrCorrupt, blockCount(b), err)
+		}
+		blk.id = fmt.Sprintf("%x", hash)
+		if b == nil {
+			b = newBlockchain(blk)
+		} else {
+			parent, ok := b.blocks[parentID]
+			if !ok {
+				return nil, 0, fmt.Errorf("%w: block %d: %v: %s", ErrCorrupt, blockCount(b), ErrUnknownBlock, parentID)
+			}
+			if !blk.validPrev(parent) {
+				return nil, 0, fmt.Errorf("%w: block %d: invalid hash pointer", ErrCorrupt, blockCount(b))
+			}
+			if blk.height != parent.height+1 {
+				return nil, 0, fmt.Errorf("%w: block %d: height: expected=%d got=%d", ErrCorrupt, blockCount(b), parent.height+1, blk.height)
+			}
+			if !meetsDifficulty(hash, blk.difficulty) {
+				return nil, 0, fmt.Errorf("%w: block %d: hash doesn't meet difficulty %d", ErrCorrupt, blockCount(b), blk.difficulty)
+			}
+			blk.prevP = parent
+			b.insert(blk)
+		}
+		end += int64(recordHeaderLen + len(payload))
+	}
+	if b == nil {
+		return nil, 0, fmt.Errorf("%w: no genesis block", ErrCorrupt)
+	}
+	return b, end, nil
+}
+
+// readRecord reads the payload of the next record. It returns io.EOF
+// if there are no more records, and io.ErrUnexpectedEOF if the record
+// is incomplete.
+func readRecord(r io.Reader) ([]byte, error) {
+	header := make([]byte, recordHeaderLen)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(header[:4])
+	checksum := binary.BigEndian.Uint32(header[4:])
+	if length > maxRecordLen {
+		return nil, fmt.Errorf("%w: record length: max=%d got=%d", ErrCorrupt, maxRecordLen, length)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err == io.EOF {
+		return nil, io.ErrUnexpectedEOF
+	} else if err != nil {
+		return nil, err
+	}
+	if crc32.ChecksumIEEE(payload) != checksum {
+		// A record with a bad checksum at the end of the
+		// file was torn, while one followed by more records
+		// was corrupted after it was written.
+		if n, _ := r.Read(make([]byte, 1)); n == 0 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, fmt.Errorf("%w: record checksum mismatch", ErrCorrupt)
+	}
+	return payload, nil
+}
+
+func (s *store) writeHeader() error {
+	header := make([]byte, storeHeaderLen)
+	copy(header, storeMagic)
+	binary.BigEndian.PutUint32(header[len(storeMagic):], storeVersion)
+	if _, err := s.f.Write(header); err != nil {
+		return fmt.Errorf("%w: %v", ErrStore, err)
+	}
+	return nil
+}
+
+// append writes a record of the provided block and syncs it to disk.
+func (s *store) append(b *block) error {
+	payload, err := encodeBlock(b)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrStore, err)
+	}
+	var record bytes.Buffer
+	header := make([]byte, recordHeaderLen)
+	binary.BigEndian.PutUint32(header[:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(header[4:], crc32.ChecksumIEEE(payload))
+	record.Write(header)
+	record.Write(payload)
+	if _, err := s.f.Write(record.Bytes()); err != nil {
+		return fmt.Errorf("%w: %v", ErrStore, err)
+	}
+	if err := s.f.Sync(); err != nil {
+		return fmt.Errorf("%w: %v", ErrStore, err)
+	}
+	return nil
+}
+
+func (s *store) close() error {
+	return s.f.Close()
+}
+
+// parentID returns the hash of the provided block's parent,
+// or an empty string for the genesis block.
+func parentID(b *block) string {
+	if b.prevP == nil {
+		return ""
+	}
+	return b.prevP.id
+}
+
+// blockCount returns the number of blocks of a blockchain being loaded.
+func blockCount(b *Blockchain) int {
+	if b == nil {
+		return 0
+	}
+	return len(b.order)
+}
@@ -0,0 +1,166 @@
+package db
+
+import (
+	"errors"
+	"os"
+	"path"
+	"testing"
+	"time"
+	"tradesim/src/trade"
+
+	"github.com/google/uuid"
+)
+
+// openWithBlocks returns the path to a new blockchain file
+// with the provided number of blocks appended after the genesis block.
+func openWithBlocks(t *testing.T, n int) string {
+	filepath := path.Join(t.TempDir(), "chain.db")
+	b, err := Open(filepath)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	for i := 0; i < n; i++ {
+		txn := &trade.Transaction{ID: uuid.New(), Credit: trade.TransactionRecord{Price: float64(i)}}
+		if ok := b.Append(NewBlock(txn)); !ok {
+			t.Fatalf("append block %d", i)
+		}
+	}
+	if err := b.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	return filepath
+}
+
+// TestOpenReload asserts that reopening a blockchain file rebuilds
+// every block with its hash pointer and transaction tree.
+func TestOpenReload(t *testing.T) {
+	filepath := openWithBlocks(t, 5)
+
+	b, err := Open(filepath)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer b.Close()
+	if expected, actual := 6, b.Len(); expected != actual {
+		t.Errorf("blockchain length: expected: %d actual: %d", expected, actual)
+	}
+	price := 4.0
+	for curr := b.tail; curr.prevP != nil; curr = curr.prevP {
+		if expected, actual := curr.prevP.id, curr.prev; expected != actual {
+			t.Errorf("hash pointer: expected: %s actual: %s", expected, actual)
+		}
+		txn := curr.txnTree.Root.leftP.txn
+		if txn.Credit.Price != price || curr.txnTree.Root.leftP.hash != txn.Hash() {
+			t.Errorf("transaction: expected price: %f actual: %+v", price, txn)
+		}
+		price--
+	}
+
+	if ok := b.Append(NewBlock(&trade.Transaction{ID: uuid.New()})); !ok {
+		t.Fatalf("append after reopen")
+	}
+	reloaded, err := Load(filepath)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if expected, actual := 7, reloaded.Len(); expected != actual {
+		t.Errorf("reloaded blockchain length: expected: %d actual: %d", expected, actual)
+	}
+}
+
+// TestOpenAt asserts that a new blockchain file has a genesis block
+// created on the provided time, and that a reopened file keeps its own.
+func TestOpenAt(t *testing.T) {
+	filepath := path.Join(t.TempDir(), "chain.db")
+	epoch := time.Date(2024, 1, 2, 9, 30, 0, 0, time.UTC)
+	b, err := OpenAt(filepath, epoch)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	expected := NewBlockchainAt(epoch).head.id
+	if actual := b.head.id; expected != actual {
+		t.Errorf("genesis: expected: %s actual: %s", expected, actual)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	b, err = OpenAt(filepath, epoch.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer b.Close()
+	if actual := b.head.id; expected != actual {
+		t.Errorf("reopened genesis: expected: %s actual: %s", expected, actual)
+	}
+}
+
+// TestOpenTruncatesTornTail asserts that opening a blockchain file whose
+// last record was partially written truncates the record.
+func TestOpenTruncatesTornTail(t *testing.T) {
+	filepath := openWithBlocks(t, 3)
+	info, _ := os.Stat(filepath)
+
+	f, err := os.OpenFile(filepath, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("open file: %v", err)
+	}
+	f.Write([]byte{0, 0, 1, 0, 0xde, 0xad, 0xbe, 0xef, '{', '"'})
+	f.Close()
+
+	b, err := Open(filepath)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer b.Close()
+	if expected, actual := 4, b.Len(); expected != actual {
+		t.Errorf("blockchain length: expected: %d actual: %d", expected, actual)
+	}
+	truncated, _ := os.Stat(filepath)
+	if expected, actual := info.Size(), truncated.Size(); expected != actual {
+		t.Errorf("file size: expected: %d actual: %d", expected, actual)
+	}
+}
+
+// TestLoadCorrupt asserts that loading a blockchain file
+// with a corrupted record before its last record fails.
+func TestLoadCorrupt(t *testing.T) {
+	filepath := openWithBlocks(t, 3)
+
+	content, _ := os.ReadFile(filepath)
+	// Flip a byte of the genesis block's payload.
+	content[storeHeaderLen+recordHeaderLen+2] ^= 0xff
+	if err := os.WriteFile(filepath, content, 0644); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+
+	if _, err := Load(filepath); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("error: expected: %v actual: %v", ErrCorrupt, err)
+	}
+}
+
+// TestSave asserts that saving an in-memory blockchain
+// writes a blockchain file that loads every block.
+func TestSave(t *testing.T) {
+	b := NewBlockchain()
+	for i := 0; i < 3; i++ {
+		if ok := b.Append(NewBlock(&trade.Transaction{ID: uuid.New()})); !ok {
+			t.Fatalf("append block %d", i)
+		}
+	}
+	filepath := path.Join(t.TempDir(), "chain.db")
+	if err := b.Save(filepath); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	saved, err := Load(filepath)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if expected, actual := b.Len(), saved.Len(); expected != actual {
+		t.Errorf("saved blockchain length: expected: %d actual: %d", expected, actual)
+	}
+	if expected, actual := b.tail.prev, saved.tail.prev; expected != actual {
+		t.Errorf("tail hash pointer: expected: %s actual: %s", expected, actual)
+	}
+}
@@ -0,0 +1,398 @@
+package exchange
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"tradesim/src/trade"
+
+	"github.com/google/uuid"
+)
+
+// MarketMode represents how the orders of a market are matched.
+type MarketMode uint8
+
+const (
+	// MarketContinuous matches every order against the quotes of the other
+	// traders of its market as it's requested, between the opening and
+	// closing auctions of the exchange's sessions, if the market has them.
+	// MarketAuction collects orders and clears them in a call auction
+	// every AuctionTicks ticks of the exchange's clock.
+	MarketContinuous MarketMode = iota
+	MarketAuction
+)
+
+// crossNamespace derives the quote IDs of crosses from their orders and
+// auction, so that replayed auctions cross with their recorded quote IDs.
+var crossNamespace = uuid.MustParse("9c41e2d7-3a6b-4f08-b5d2-7e1f0a8c6b39")
+
+// Auction represents the result of a call auction of a market, which
+// crosses the market's buy and sell orders at a single uniform price.
+type Auction struct {
+	Item trade.Item
+	// Sequence is the number of auctions of the market before it.
+	Sequence uint64
+	// Price is the price every cross of the auction executes at, and
+	// Volume is the quantity crossed at it, which are 0 if no orders
+	// cross. Imbalance is the quantity demanded at the price less the
+	// quantity supplied at it.
+	Price     float64
+	Volume    float64
+	Imbalance float64
+}
+
+// book represents the orders collected for the auctions
+// of a market, in the order they were collected.
+type book struct {
+	orders   []*order
+	auctions uint64
+}
+
+// cross represents a fill of a buy order from a sell order allocated by
+// an auction. Its response is delivered to its seller, which signs it as
+// a quote, and then to its buyer, which executes it by choosing it.
+type cross struct {
+	resp   trade.Response
+	sell   *order
+	signed bool
+}
+
+// level represents the open quantity of an order of an auction at its
+// limit price, where a market order has a limit price of 0.
+type level struct {
+	o        *order
+	limit    float64
+	quantity float64
+}
+
+// auctioning returns whether the provided market is in an auction, where
+// its orders are collected for the auction rather than routed for quotes.
+// An exchange that's replaying is never in an auction, since the orders
+// collected for auctions are replayed by their recorded collections.
+func (e *Exchange) auctioning(m Market) bool {
+	if e.replaying {
+		return false
+	}
+	e.ordersLock.Lock()
+	defer e.ordersLock.Unlock()
+	return e.inAuction(m, e.tick)
+}
+
+// inAuction returns whether the provided market is in an auction on the
+// provided tick, which a market in auction mode always is, and a continuous
+// market is during the opening and closing ticks of every session.
+func (e *Exchange) inAuction(m Market, tick uint64) bool {
+	if m.Mode == MarketAuction {
+		return true
+	}
+	if e.SessionTicks == 0 {
+		return false
+	}
+	s := tick % e.SessionTicks
+	return s < m.OpeningTicks || (m.ClosingTicks > 0 && s+m.ClosingTicks >= e.SessionTicks)
+}
+
+// clears returns whether the auction of the provided market is cleared on
+// the provided tick, which is every AuctionTicks ticks for a market in
+// auction mode, and at the end of every opening and closing auction of a
+// continuous market, where a closing auction ends at the session's close.
+func (e *Exchange) clears(m Market, tick uint64) bool {
+	if tick == 0 {
+		return false
+	}
+	if m.Mode == MarketAuction {
+		return m.AuctionTicks > 0 && tick%m.AuctionTicks == 0
+	}
+	if e.SessionTicks == 0 {
+		return false
+	}
+	return e.inAuction(m, tick-1) && (!e.inAuction(m, tick) || tick%e.SessionTicks == 0)
+}
+
+// clearDue clears the auctions of every market
+// whose auction is cleared on the provided tick.
+func (e *Exchange) clearDue(ctx context.Context, tick uint64) error {
+	var due []uuid.UUID
+	for id, m := range e.Markets {
+		if e.clears(m, tick) {
+			due = append(due, id)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].String() < due[j].String() })
+	for _, id := range due {
+		if err := e.clear(ctx, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// book returns the book of the market of the provided item.
+// The orders lock must be held.
+func (e *Exchange) book(itemID uuid.UUID) *book {
+	b, ok := e.books[itemID]
+	if !ok {
+		b = &book{}
+		e.books[itemID] = b
+	}
+	return b
+}
+
+// validateAuction returns why the provided order request
+// is rejected from an auction, if it is.
+func validateAuction(r trade.Request) string {
+	if r.TimeInForce == trade.TimeInForceFOK {
+		return "fill-or-kill orders aren't supported in auctions"
+	}
+	return validate(r)
+}
+
+// collect collects the provided order request for the next auction of the
+// provided market, reporting it to its trader, or rejects it if it's
+// invalid. Unlike the orders routed for quotes, the orders collected for
+// an auction aren't routed to the market's traders.
+func (e *Exchange) collect(ctx context.Context, m Market, r trade.Request) error {
+	o := newOrder(r)
+	o.auction = true
+	e.ordersLock.Lock()
+	// The request is recorded whether it's collected or rejected,
+	// so that replaying it collects or rejects it again.
+	pos := e.reserve()
+	reason := e.register(o, validateAuction(r))
+	if reason == "" {
+		b := e.book(m.Item.ID)
+		b.orders = append(b.orders, o)
+	}
+	e.ordersLock.Unlock()
+	if err := e.recordReserved(pos, MessageOrder, r.TraderID, uuid.Nil, m.Item.ID, r); err != nil {
+		return err
+	}
+
+	if reason != "" {
+		o.leaves = 0
+		rep := o.report(trade.ExecRejected)
+		rep.Reason = reason
+		return e.report(ctx, rep)
+	}
+	return e.report(ctx, o.report(trade.ExecNew))
+}
+
+// clear clears the auction of the market of the provided item, crossing
+// its buy and sell orders at their uniform price from the highest buy and
+// lowest sell limit prices, with market orders first and in the order they
+// were collected at equal prices, and delivers the crosses to their sellers.
+// The quantity of the auction's orders that isn't crossed is canceled,
+// unless they rest, in which case it's collected for the next auction.
+func (e *Exchange) clear(ctx context.Context, itemID uuid.UUID) error {
+	e.ordersLock.Lock()
+	b := e.book(itemID)
+	var buys, sells []level
+	live := b.orders[:0]
+	for _, o := range b.orders {
+		if o.leaves <= quantityEpsilon {
+			continue
+		}
+		live = append(live, o)
+		open := o.leaves - o.pending()
+		if open <= quantityEpsilon {
+			continue
+		}
+		l := level{o: o, quantity: open}
+		if o.request.Type == trade.OrderLimit {
+			l.limit = o.request.Price
+		}
+		if o.request.Side == trade.SideSell {
+			sells = append(sells, l)
+		} else {
+			buys = append(buys, l)
+		}
+	}
+	b.orders = live
+	if len(buys)+len(sells) == 0 {
+		e.ordersLock.Unlock()
+		return nil
+	}
+
+	a := Auction{Item: e.Markets[itemID].Item, Sequence: b.auctions}
+	b.auctions++
+	a.Price, a.Volume, a.Imbalance = uniformPrice(buys, sells, e.reference[itemID])
+	sortLevels(buys, sells)
+	var crosses []trade.Response
+	remaining := a.Volume
+	for i, j := 0, 0; remaining > quantityEpsilon && i < len(buys) && j < len(sells); {
+		buy, sell := buys[i].o, sells[j].o
+		q := math.Min(remaining, math.Min(buys[i].quantity, sells[j].quantity))
+		resp := trade.Response{
+			ID:       uuid.NewSHA1(crossNamespace, []byte(fmt.Sprintf("%s %s %d", buy.id, sell.id, a.Sequence))),
+			Request:  buy.request,
+			TraderID: sell.request.TraderID,
+			Fill:     q,
+		}
+		resp.OrderBook.Ask.Item = a.Item
+		resp.OrderBook.Ask.Price = a.Price
+		resp.OrderBook.Ask.Quantity = q
+		buy.allocated[resp.ID] = q
+		sell.allocated[resp.ID] = q
+		e.crosses[resp.ID] = &cross{resp: resp, sell: sell}
+		crosses = append(crosses, resp)
+
+		remaining -= q
+		buys[i].quantity -= q
+		sells[j].quantity -= q
+		if buys[i].quantity <= quantityEpsilon {
+			i++
+		}
+		if sells[j].quantity <= quantityEpsilon {
+			j++
+		}
+	}
+
+	var reps []trade.ExecutionReport
+	for _, levels := range [][]level{buys, sells} {
+		for _, l := range levels {
+			o := l.o
+			o.matched = true
+			if l.quantity <= quantityEpsilon || o.rests() {
+				continue
+			}
+			o.leaves -= l.quantity
+			o.canceled += l.quantity
+			rep := o.report(trade.ExecCanceled)
+			rep.Reason = "quantity not crossed by the auction canceled"
+			reps = append(reps, rep)
+			e.prune(o)
+		}
+	}
+	e.stats.Auctions++
+	pos := e.reserve()
+	e.ordersLock.Unlock()
+	if err := e.recordReserved(pos, MessageAuction, uuid.Nil, uuid.Nil, itemID, a); err != nil {
+		return err
+	}
+
+	for _, c := range crosses {
+		if err := e.deliverCross(ctx, c); err != nil {
+			return err
+		}
+		e.awaitChoices(c.Request, trade.Responses{c})
+	}
+	for _, rep := range reps {
+		if err := e.report(ctx, rep); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// uniformPrice returns the price that maximizes the quantity crossed
+// between the provided buy and sell orders, and the quantity crossed and
+// imbalance at it. Among the prices of equal volume, the price of the
+// smallest absolute imbalance is chosen, then the price closest to the
+// provided reference price, if it's positive, and then the lowest price.
+// The candidate prices are the limit prices of the orders and the reference
+// price, so that market orders cross at the reference price on their own,
+// and 0 is returned for every value if no candidate price crosses any.
+func uniformPrice(buys, sells []level, reference float64) (float64, float64, float64) {
+	var candidates []float64
+	for _, levels := range [][]level{buys, sells} {
+		for _, l := range levels {
+			if l.limit > 0 {
+				candidates = append(candidates, l.limit)
+			}
+		}
+	}
+	if reference > 0 {
+		candidates = append(candidates, reference)
+	}
+	sort.Float64s(candidates)
+
+	var price, volume, imbalance float64
+	for _, p := range candidates {
+		demand, supply := 0.0, 0.0
+		for _, l := range buys {
+			if l.limit == 0 || l.limit >= p {
+				demand += l.quantity
+			}
+		}
+		for _, l := range sells {
+			if l.limit == 0 || l.limit <= p {
+				supply += l.quantity
+			}
+		}
+		v, imb := math.Min(demand, supply), demand-supply
+		better := false
+		switch {
+		case v > volume+quantityEpsilon:
+			better = true
+		case v < volume-quantityEpsilon || volume <= quantityEpsilon:
+		case math.Abs(imb) < math.Abs(imbalance)-quantityEpsilon:
+			better = true
+		case math.Abs(imb) > math.Abs(imbalance)+quantityEpsilon:
+		case reference > 0 && math.Abs(p-reference) < math.Abs(price-reference):
+			better = true
+		}
+		if better {
+			price, volume, imbalance = p, v, imb
+		}
+	}
+	return price, volume, imbalance
+}
+
+// sortLevels sorts the provided buy orders from the highest limit price
+// and sell orders from the lowest, with market orders first, keeping the
+// order they were collected in at equal prices.
+func sortLevels(buys, sells []level) {
+	sort.SliceStable(buys, func(i, j int) bool {
+		if buys[i].limit == 0 || buys[j].limit == 0 {
+			return buys[i].limit == 0 && buys[j].limit != 0
+		}
+		return buys[i].limit > buys[j].limit
+	})
+	sort.SliceStable(sells, func(i, j int) bool {
+		if sells[i].limit == 0 || sells[j].limit == 0 {
+			return sells[i].limit == 0 && sells[j].limit != 0
+		}
+		return sells[i].limit < sells[j].limit
+	})
+}
+
+// deliverCross delivers the provided cross to its seller for signing.
+// A replaying exchange only records it, since a clear may deliver more
+// crosses to a seller than its channel buffers before it's drained.
+func (e *Exchange) deliverCross(ctx context.Context, resp trade.Response) error {
+	t, ok := e.Trader(resp.TraderID)
+	if !ok {
+		return nil
+	}
+	resps := trade.Responses{resp}
+	if err := e.record(MessageResponses, uuid.Nil, t.ID, resp.Request.Item.ID, resps); err != nil {
+		return err
+	}
+	if e.replaying {
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case t.ResponseRecv <- resps:
+	}
+	return nil
+}
+
+// sign delivers the provided cross, signed by the provided response of its
+// seller, to its buyer, unless the response isn't its seller's or the cross
+// is already signed. The cross keeps its terms, so that its seller can't
+// change them by signing it.
+func (e *Exchange) sign(ctx context.Context, c *cross, resp trade.Response) error {
+	e.ordersLock.Lock()
+	if c.signed || resp.TraderID != c.resp.TraderID {
+		e.ordersLock.Unlock()
+		return nil
+	}
+	c.signed = true
+	signed := c.resp
+	signed.Signature = resp.Signature
+	e.ordersLock.Unlock()
+	return e.routeResponses(ctx, uuid.Nil, trade.Responses{signed})
+}
@@ -0,0 +1,343 @@
+package exchange
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+	"tradesim/src/time/clock"
+	"tradesim/src/trade"
+
+	"github.com/google/uuid"
+)
+
+// TestUniformPrice asserts that an auction's price maximizes the crossed
+// volume, and breaks ties by the smallest imbalance, then the closest
+// price to the reference price, and then the lowest price.
+func TestUniformPrice(t *testing.T) {
+	tests := []struct {
+		name                     string
+		buys, sells              []level
+		reference                float64
+		price, volume, imbalance float64
+	}{
+		{
+			name:   "volume",
+			buys:   []level{{limit: 3, quantity: 2}, {limit: 2, quantity: 2}},
+			sells:  []level{{limit: 1, quantity: 1}, {limit: 2, quantity: 2}, {limit: 3, quantity: 2}},
+			price:  2,
+			volume: 3, imbalance: 1,
+		},
+		{
+			name:   "imbalance",
+			buys:   []level{{limit: 3, quantity: 2}, {limit: 1, quantity: 2}},
+			sells:  []level{{limit: 1, quantity: 2}, {limit: 3, quantity: 1}},
+			price:  3,
+			volume: 2, imbalance: -1,
+		},
+		{
+			name:   "lowest",
+			buys:   []level{{limit: 3, quantity: 1}},
+			sells:  []level{{limit: 1, quantity: 1}},
+			price:  1,
+			volume: 1,
+		},
+		{
+			name:      "reference",
+			buys:      []level{{limit: 3, quantity: 1}},
+			sells:     []level{{limit: 1, quantity: 1}},
+			reference: 2.5,
+			price:     2.5,
+			volume:    1,
+		},
+		{
+			name:      "market",
+			buys:      []level{{quantity: 1}},
+			sells:     []level{{quantity: 2}},
+			reference: 2,
+			price:     2,
+			volume:    1, imbalance: -1,
+		},
+		{
+			name:  "no reference",
+			buys:  []level{{quantity: 1}},
+			sells: []level{{quantity: 1}},
+		},
+		{
+			name:  "no cross",
+			buys:  []level{{limit: 1, quantity: 1}},
+			sells: []level{{limit: 2, quantity: 1}},
+		},
+	}
+	for _, test := range tests {
+		price, volume, imbalance := uniformPrice(test.buys, test.sells, test.reference)
+		if price != test.price || volume != test.volume || imbalance != test.imbalance {
+			t.Errorf("%s: expected: price %v volume %v imbalance %v actual: price %v volume %v imbalance %v",
+				test.name, test.price, test.volume, test.imbalance, price, volume, imbalance)
+		}
+	}
+}
+
+// TestAuction asserts that a market in auction mode collects buy and sell
+// orders and clears them on its auction ticks, that a cross is executed
+// once its seller signs it and its buyer chooses it, that the uncrossed
+// quantity of a resting order is collected for the next auction, and that
+// replaying the auction produces no divergence.
+func TestAuction(t *testing.T) {
+	ctx := context.Background()
+	e, buyer, sellers, item := orderExchange(t, [2]float64{1, 3})
+	seller := sellers[0]
+	m := e.Markets[item.ID]
+	m.Mode = MarketAuction
+	m.AuctionTicks = 2
+	e.Markets[item.ID] = m
+	var buf bytes.Buffer
+	e.Log = NewEventLog(&buf)
+
+	buy := trade.Request{ID: uuid.New(), TraderID: buyer.ID, Item: item, Quantity: 2, Side: trade.SideBuy, Type: trade.OrderLimit, Price: 2, TimeInForce: trade.TimeInForceIOC}
+	sell := trade.Request{ID: uuid.New(), TraderID: seller.ID, Item: item, Quantity: 3, Side: trade.SideSell, Type: trade.OrderLimit, Price: 1.5, TimeInForce: trade.TimeInForceGTC}
+	fok := trade.Request{ID: uuid.New(), TraderID: buyer.ID, Item: item, Quantity: 1, Side: trade.SideBuy, Type: trade.OrderMarket, TimeInForce: trade.TimeInForceFOK}
+	for _, r := range []trade.Request{buy, sell, fok} {
+		if err := e.routeRequest(ctx, r); err != nil {
+			t.Fatalf("route request: %v", err)
+		}
+	}
+	select {
+	case r := <-seller.RequestRecv:
+		t.Errorf("collected order: expected: not routed actual: %+v", r)
+	default:
+	}
+	for tick := 1; tick <= 2; tick++ {
+		if err := e.advance(ctx); err != nil {
+			t.Fatalf("advance: %v", err)
+		}
+	}
+
+	var crosses trade.Responses
+	select {
+	case crosses = <-seller.ResponseRecv:
+	default:
+		t.Fatalf("cross: expected: delivered to seller actual: none")
+	}
+	if len(crosses) != 1 || crosses[0].Fill != 2 || crosses[0].OrderBook.Ask.Price != 1.5 {
+		t.Fatalf("cross: expected: 2 at 1.5 actual: %+v", crosses)
+	}
+	c := crosses[0]
+	seller.SignQuote(&c)
+	if err := e.routeResponse(ctx, c); err != nil {
+		t.Fatalf("route response: %v", err)
+	}
+	if expected, actual := 1, chooseFills(t, e, buyer); expected != actual {
+		t.Fatalf("fills: expected: %d actual: %d", expected, actual)
+	}
+	if expected, actual := 2, e.DB.Len(); expected != actual {
+		t.Errorf("blockchain length: expected: %d actual: %d", expected, actual)
+	}
+
+	buys := reports(buyer)
+	if len(buys) != 3 || buys[0].Type != trade.ExecNew || buys[1].Type != trade.ExecRejected {
+		t.Fatalf("buyer reports: expected: new and rejected actual: %+v", buys)
+	}
+	if rep := buys[2]; rep.Type != trade.ExecTrade || rep.LastPrice != 1.5 || rep.Filled != 2 || rep.Leaves != 0 {
+		t.Errorf("buyer trade: expected: filled 2 at 1.5 actual: %+v", rep)
+	}
+	sells := reports(seller)
+	if len(sells) != 2 || sells[0].Type != trade.ExecNew {
+		t.Fatalf("seller reports: expected: new and trade actual: %+v", sells)
+	}
+	if rep := sells[1]; rep.Type != trade.ExecTrade || rep.LastPrice != 1.5 || rep.Filled != 2 || rep.Leaves != 1 {
+		t.Errorf("seller trade: expected: filled 2 leaves 1 actual: %+v", rep)
+	}
+
+	for tick := 3; tick <= 4; tick++ {
+		if err := e.advance(ctx); err != nil {
+			t.Fatalf("advance: %v", err)
+		}
+	}
+	if reps := reports(seller); len(reps) != 0 {
+		t.Errorf("resting sell: expected: no reports actual: %+v", reps)
+	}
+	if stats := e.Orders(); stats.Auctions != 2 || stats.Trades != 2 || stats.Rejected != 1 {
+		t.Errorf("stats: expected: 2 auctions 2 trades 1 rejected actual: %+v", stats)
+	}
+
+	events, err := ReadEvents(&buf)
+	if err != nil {
+		t.Fatalf("read events: %v", err)
+	}
+	replay, err := NewReplayExchange(events)
+	if err != nil {
+		t.Fatalf("replay exchange: %v", err)
+	}
+	divergences, err := replay.Replay(events)
+	if err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	if len(divergences) != 0 {
+		t.Errorf("divergences: expected: 0 actual: %v", divergences)
+	}
+}
+
+// TestAuctionSessions asserts that a continuous market collects orders in
+// the opening and closing auctions of every session, clears them at their
+// ends, and routes orders for quotes in between.
+func TestAuctionSessions(t *testing.T) {
+	ctx := context.Background()
+	e, buyer, sellers, item := orderExchange(t, [2]float64{1, 1})
+	m := e.Markets[item.ID]
+	m.OpeningTicks = 2
+	m.ClosingTicks = 2
+	e.Markets[item.ID] = m
+	e.SessionTicks = 10
+
+	for tick := uint64(0); tick <= 20; tick++ {
+		s := tick % 10
+		if expected, actual := s < 2 || s >= 8, e.inAuction(m, tick); expected != actual {
+			t.Errorf("tick %d: in auction: expected: %t actual: %t", tick, expected, actual)
+		}
+		if expected, actual := tick > 0 && (s == 2 || s == 0), e.clears(m, tick); expected != actual {
+			t.Errorf("tick %d: clears: expected: %t actual: %t", tick, expected, actual)
+		}
+	}
+
+	r := trade.Request{ID: uuid.New(), TraderID: buyer.ID, Item: item, Quantity: 1, Side: trade.SideBuy, Type: trade.OrderMarket, TimeInForce: trade.TimeInForceIOC}
+	if err := e.routeRequest(ctx, r); err != nil {
+		t.Fatalf("route request: %v", err)
+	}
+	for tick := 1; tick <= 2; tick++ {
+		if err := e.advance(ctx); err != nil {
+			t.Fatalf("advance: %v", err)
+		}
+	}
+	reps := reports(buyer)
+	if len(reps) != 2 || reps[0].Type != trade.ExecNew || reps[1].Type != trade.ExecCanceled || reps[1].Canceled != 1 {
+		t.Errorf("reports: expected: new and canceled actual: %+v", reps)
+	}
+
+	r.ID = uuid.New()
+	if err := e.routeRequest(ctx, r); err != nil {
+		t.Fatalf("route request: %v", err)
+	}
+	select {
+	case <-sellers[0].RequestRecv:
+	default:
+		t.Errorf("continuous order: expected: routed for quotes actual: not routed")
+	}
+}
+
+// TestAuctionClose asserts that day orders collected in the closing
+// auction of a session are crossed when it clears, and that the cross is
+// still executed once they expire at the session's close.
+func TestAuctionClose(t *testing.T) {
+	ctx := context.Background()
+	e, buyer, sellers, item := orderExchange(t, [2]float64{1, 1})
+	seller := sellers[0]
+	clk := clock.NewClock(time.Second, 0)
+	e.Clock = &clk
+	e.SessionTicks = 4
+	m := e.Markets[item.ID]
+	m.ClosingTicks = 2
+	e.Markets[item.ID] = m
+
+	for tick := 1; tick <= 2; tick++ {
+		if err := e.advance(ctx); err != nil {
+			t.Fatalf("advance: %v", err)
+		}
+	}
+	buy := trade.Request{ID: uuid.New(), TraderID: buyer.ID, Item: item, Quantity: 1, Side: trade.SideBuy, Type: trade.OrderLimit, Price: 2, TimeInForce: trade.TimeInForceDay}
+	sell := trade.Request{ID: uuid.New(), TraderID: seller.ID, Item: item, Quantity: 1, Side: trade.SideSell, Type: trade.OrderLimit, Price: 1, TimeInForce: trade.TimeInForceDay}
+	for _, r := range []trade.Request{buy, sell} {
+		if err := e.routeRequest(ctx, r); err != nil {
+			t.Fatalf("route request: %v", err)
+		}
+	}
+	for tick := 3; tick <= 4; tick++ {
+		if err := e.advance(ctx); err != nil {
+			t.Fatalf("advance: %v", err)
+		}
+	}
+
+	var crosses trade.Responses
+	select {
+	case crosses = <-seller.ResponseRecv:
+	default:
+		t.Fatalf("cross: expected: delivered to seller actual: none")
+	}
+	c := crosses[0]
+	seller.SignQuote(&c)
+	if err := e.routeResponse(ctx, c); err != nil {
+		t.Fatalf("route response: %v", err)
+	}
+	if expected, actual := 1, chooseFills(t, e, buyer); expected != actual {
+		t.Fatalf("fills: expected: %d actual: %d", expected, actual)
+	}
+	if expected, actual := 2, e.DB.Len(); expected != actual {
+		t.Errorf("blockchain length: expected: %d actual: %d", expected, actual)
+	}
+
+	for name, tr := range map[string]*trade.Trader{"buyer": buyer, "seller": seller} {
+		reps := reports(tr)
+		types := []trade.ExecType{trade.ExecNew, trade.ExecExpired, trade.ExecTrade}
+		if len(reps) != len(types) {
+			t.Fatalf("%s reports: expected: %d actual: %+v", name, len(types), reps)
+		}
+		for i, rep := range reps {
+			if rep.Type != types[i] {
+				t.Errorf("%s report %d: expected: %d actual: %+v", name, i, types[i], rep)
+			}
+		}
+		if rep := reps[2]; rep.Filled != 1 || rep.Canceled != 0 || rep.Leaves != 0 {
+			t.Errorf("%s trade: expected: filled 1 actual: %+v", name, rep)
+		}
+	}
+	if n := len(e.orders); n != 0 {
+		t.Errorf("orders: expected: 0 actual: %d", n)
+	}
+}
+
+// TestAuctionCancel asserts that canceling an order of a cross releases
+// the cross's quantity of its counterparty, which is canceled unless it
+// rests, and that the cross isn't executed once it's signed.
+func TestAuctionCancel(t *testing.T) {
+	ctx := context.Background()
+	e, buyer, sellers, item := orderExchange(t, [2]float64{1, 1})
+	seller := sellers[0]
+	m := e.Markets[item.ID]
+	m.Mode = MarketAuction
+	m.AuctionTicks = 1
+	e.Markets[item.ID] = m
+
+	buy := trade.Request{ID: uuid.New(), TraderID: buyer.ID, Item: item, Quantity: 1, Side: trade.SideBuy, Type: trade.OrderLimit, Price: 2, TimeInForce: trade.TimeInForceIOC}
+	sell := trade.Request{ID: uuid.New(), TraderID: seller.ID, Item: item, Quantity: 1, Side: trade.SideSell, Type: trade.OrderLimit, Price: 1, TimeInForce: trade.TimeInForceIOC}
+	for _, r := range []trade.Request{buy, sell} {
+		if err := e.routeRequest(ctx, r); err != nil {
+			t.Fatalf("route request: %v", err)
+		}
+	}
+	if err := e.advance(ctx); err != nil {
+		t.Fatalf("advance: %v", err)
+	}
+	crosses := <-seller.ResponseRecv
+	if err := e.cancel(ctx, trade.Cancel{ID: uuid.New(), TraderID: seller.ID, OrderID: sell.ID}); err != nil {
+		t.Fatalf("cancel: %v", err)
+	}
+
+	reps := reports(buyer)
+	if len(reps) != 2 || reps[1].Type != trade.ExecCanceled || reps[1].Canceled != 1 || reps[1].Leaves != 0 {
+		t.Fatalf("buyer reports: expected: new and canceled actual: %+v", reps)
+	}
+	c := crosses[0]
+	seller.SignQuote(&c)
+	if err := e.routeResponse(ctx, c); err != nil {
+		t.Fatalf("route response: %v", err)
+	}
+	if n := chooseFills(t, e, buyer); n != 0 {
+		t.Errorf("fills: expected: 0 actual: %d", n)
+	}
+	if expected, actual := 1, e.DB.Len(); expected != actual {
+		t.Errorf("blockchain length: expected: %d actual: %d", expected, actual)
+	}
+	if n := len(e.orders); n != 0 {
+		t.Errorf("orders: expected: 0 actual: %d", n)
+	}
+}
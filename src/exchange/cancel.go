@@ -0,0 +1,204 @@
+package exchange
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"tradesim/src/trade"
+
+	"github.com/google/uuid"
+)
+
+var ErrLifecycle = errors.New("failed to trace order lifecycle")
+
+// OrderStats represents the number of execution reports
+// of every type the exchange has sent for orders.
+type OrderStats struct {
+	Accepted       int
+	Rejected       int
+	Trades         int
+	Canceled       int
+	Replaced       int
+	CancelRejected int
+	Expired        int
+	// Auctions is the number of auctions the exchange has cleared.
+	Auctions int
+}
+
+func (s OrderStats) String() string {
+	return fmt.Sprintf("orders accepted=%d rejected=%d trades=%d canceled=%d replaced=%d cancel rejected=%d expired=%d auctions=%d",
+		s.Accepted, s.Rejected, s.Trades, s.Canceled, s.Replaced, s.CancelRejected, s.Expired, s.Auctions)
+}
+
+// count counts the provided execution report.
+func (s *OrderStats) count(rep trade.ExecutionReport) {
+	switch rep.Type {
+	case trade.ExecNew:
+		s.Accepted++
+	case trade.ExecRejected:
+		s.Rejected++
+	case trade.ExecTrade:
+		s.Trades++
+	case trade.ExecCanceled:
+		s.Canceled++
+	case trade.ExecReplaced:
+		s.Replaced++
+	case trade.ExecCancelRejected:
+		s.CancelRejected++
+	case trade.ExecExpired:
+		s.Expired++
+	}
+}
+
+// Orders returns the number of execution reports of every
+// type the exchange has sent. It's safe to call while the
+// exchange runs.
+func (e *Exchange) Orders() OrderStats {
+	e.ordersLock.Lock()
+	defer e.ordersLock.Unlock()
+	return e.stats
+}
+
+// trace writes the provided execution report to the
+// exchange's lifecycle writer, if it has one.
+func (e *Exchange) trace(rep trade.ExecutionReport) error {
+	if e.Lifecycle == nil {
+		return nil
+	}
+	e.lifecycleLock.Lock()
+	defer e.lifecycleLock.Unlock()
+	if _, err := fmt.Fprintln(e.Lifecycle, rep.String()); err != nil {
+		return fmt.Errorf("%w: %v", ErrLifecycle, err)
+	}
+	return nil
+}
+
+// lookup returns the order of the trader with the provided ID whose
+// request ID or order ID is the provided ID, and whether it has one.
+// The orders lock must be held.
+func (e *Exchange) lookup(traderID, id uuid.UUID) (*order, bool) {
+	o, ok := e.orders[id]
+	if !ok {
+		o, ok = e.byOrderID[id]
+	}
+	if !ok || o.request.TraderID != traderID {
+		return nil, false
+	}
+	return o, true
+}
+
+// live returns why the order can't be canceled or replaced, if it can't.
+// The quantity of the fills being executed is no longer live.
+func (o *order) live() string {
+	if o.leaves-o.executing <= quantityEpsilon {
+		return "order is not live"
+	}
+	return ""
+}
+
+// cancel cancels the live quantity of the order of the provided cancel,
+// and acknowledges it to the order's trader, or rejects it if the order
+// isn't live. The quotes of an order that isn't matched are discarded,
+// and the fills allocated to a matched order that haven't been chosen
+// aren't executed once they're chosen. The crosses among them are
+// unallocated from their counterparties, whose unallocated quantity is
+// canceled unless it rests. The fills already chosen are executed, and
+// remain in the order's leaves quantity until they are.
+func (e *Exchange) cancel(ctx context.Context, c trade.Cancel) error {
+	e.ordersLock.Lock()
+	o, ok := e.lookup(c.TraderID, c.OrderID)
+	market := uuid.Nil
+	if ok {
+		market = o.request.Item.ID
+	}
+	pos := e.reserve()
+	var rep trade.ExecutionReport
+	var uncrossed []trade.ExecutionReport
+	switch {
+	case !ok:
+		rep = trade.ExecutionReport{TraderID: c.TraderID, Type: trade.ExecCancelRejected, Reason: "order not found"}
+	case o.live() != "":
+		rep = o.report(trade.ExecCancelRejected)
+		rep.Reason = o.live()
+	default:
+		o.matched = true
+		uncrossed = e.uncross(o)
+		o.allocated = make(map[uuid.UUID]float64)
+		o.canceled += o.leaves - o.executing
+		o.leaves = o.executing
+		rep = o.report(trade.ExecCanceled)
+		rep.Reason = "canceled by trader"
+		e.prune(o)
+	}
+	e.ordersLock.Unlock()
+	if err := e.recordReserved(pos, MessageCancel, c.TraderID, uuid.Nil, market, c); err != nil {
+		return err
+	}
+	rep.CancelID = c.ID
+	if err := e.report(ctx, rep); err != nil {
+		return err
+	}
+	for _, rep := range uncrossed {
+		if err := e.report(ctx, rep); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replace replaces the quantity and limit price of the order of the
+// provided replace, and acknowledges it to the order's trader, or rejects
+// it if the order isn't live, is already matched, or the replaced order
+// is invalid. A replaced order keeps the quotes collected for it, which
+// are allocated to it as replaced once it's matched. An order that rests
+// may be replaced once it's matched, unless fills allocated to it haven't
+// been executed, and its quantity includes its filled quantity.
+func (e *Exchange) replace(ctx context.Context, r trade.Replace) error {
+	e.ordersLock.Lock()
+	o, ok := e.lookup(r.TraderID, r.OrderID)
+	market := uuid.Nil
+	if ok {
+		market = o.request.Item.ID
+	}
+	pos := e.reserve()
+	var rep trade.ExecutionReport
+	if !ok {
+		rep = trade.ExecutionReport{TraderID: r.TraderID, Type: trade.ExecCancelRejected, Reason: "order not found"}
+	} else {
+		req := o.request
+		req.Quantity = r.Quantity
+		if req.Type == trade.OrderLimit {
+			req.Price = r.Price
+		}
+		leaves := req.Quantity - o.filled - o.canceled
+		reason := o.live()
+		if reason == "" && o.expired {
+			reason = "order expired"
+		}
+		if reason == "" && o.matched && (!req.TimeInForce.Rests() || o.pending() > quantityEpsilon) {
+			reason = "order is already matched"
+		}
+		if reason == "" && o.auction {
+			reason = validateAuction(req)
+		} else if reason == "" {
+			reason = validate(req)
+		}
+		if reason == "" && leaves <= quantityEpsilon {
+			reason = "quantity must exceed the filled quantity"
+		}
+		if reason == "" {
+			o.request = req
+			o.leaves = leaves
+			rep = o.report(trade.ExecReplaced)
+		} else {
+			rep = o.report(trade.ExecCancelRejected)
+			rep.Reason = reason
+		}
+	}
+	e.ordersLock.Unlock()
+	if err := e.recordReserved(pos, MessageReplace, r.TraderID, uuid.Nil, market, r); err != nil {
+		return err
+	}
+	rep.CancelID = r.ID
+	return e.report(ctx, rep)
+}
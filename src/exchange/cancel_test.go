@@ -0,0 +1,215 @@
+package exchange
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"tradesim/src/trade"
+
+	"github.com/google/uuid"
+)
+
+// TestCancel asserts that an order is canceled by its request ID before
+// it's matched, discarding its quotes, and by its order ID once it's
+// matched, discarding its unexecuted fills, and that a cancel of an
+// order that isn't live or isn't the trader's is rejected.
+func TestCancel(t *testing.T) {
+	ctx := context.Background()
+	asks := [][2]float64{{1, 1}}
+	e, buyer, sellers, item := orderExchange(t, asks...)
+
+	r := trade.Request{ID: uuid.New(), TraderID: buyer.ID, Item: item, Quantity: 1, Side: trade.SideBuy, Type: trade.OrderMarket, TimeInForce: trade.TimeInForceIOC}
+	if err := e.routeRequest(ctx, r); err != nil {
+		t.Fatalf("route request: %v", err)
+	}
+	c := trade.Cancel{ID: uuid.New(), TraderID: buyer.ID, OrderID: r.ID}
+	if err := e.cancel(ctx, c); err != nil {
+		t.Fatalf("cancel: %v", err)
+	}
+	quoteOrder(t, e, r, sellers, asks...)
+	if expected, actual := 0, chooseFills(t, e, buyer); expected != actual {
+		t.Errorf("fills: expected: %d actual: %d", expected, actual)
+	}
+	reps := reports(buyer)
+	if len(reps) != 2 || reps[1].Type != trade.ExecCanceled || reps[1].Canceled != 1 || reps[1].CancelID != c.ID {
+		t.Errorf("reports: expected: new and canceled 1 actual: %+v", reps)
+	}
+	if err := e.cancel(ctx, trade.Cancel{ID: uuid.New(), TraderID: buyer.ID, OrderID: r.ID}); err != nil {
+		t.Fatalf("cancel: %v", err)
+	}
+	if reps := reports(buyer); len(reps) != 1 || reps[0].Type != trade.ExecCancelRejected || reps[0].Reason != "order not found" {
+		t.Errorf("reports: expected: cancel rejected actual: %+v", reps)
+	}
+
+	<-sellers[0].RequestRecv
+	r = trade.Request{ID: uuid.New(), TraderID: buyer.ID, Item: item, Quantity: 1, Side: trade.SideBuy, Type: trade.OrderMarket, TimeInForce: trade.TimeInForceIOC}
+	if err := e.routeRequest(ctx, r); err != nil {
+		t.Fatalf("route request: %v", err)
+	}
+	quoteOrder(t, e, r, sellers, asks...)
+	orderID := reports(buyer)[0].OrderID
+	if err := e.cancel(ctx, trade.Cancel{ID: uuid.New(), TraderID: sellers[0].ID, OrderID: orderID}); err != nil {
+		t.Fatalf("cancel: %v", err)
+	}
+	if reps := reports(sellers[0]); len(reps) != 1 || reps[0].Type != trade.ExecCancelRejected || reps[0].Reason != "order not found" {
+		t.Errorf("reports: expected: cancel rejected actual: %+v", reps)
+	}
+	if err := e.cancel(ctx, trade.Cancel{ID: uuid.New(), TraderID: buyer.ID, OrderID: orderID}); err != nil {
+		t.Fatalf("cancel: %v", err)
+	}
+	chooseFills(t, e, buyer)
+	if expected, actual := 1, e.DB.Len(); expected != actual {
+		t.Errorf("blockchain length: expected: %d actual: %d", expected, actual)
+	}
+	if reps := reports(buyer); len(reps) != 1 || reps[0].Type != trade.ExecCanceled || reps[0].Canceled != 1 {
+		t.Errorf("reports: expected: canceled 1 actual: %+v", reps)
+	}
+	stats := e.Orders()
+	if stats.Accepted != 2 || stats.Canceled != 2 || stats.CancelRejected != 2 || stats.Trades != 0 {
+		t.Errorf("stats: expected: 2 accepted 2 canceled 2 cancel rejected actual: %+v", stats)
+	}
+}
+
+// TestLifecycle asserts that every execution report
+// is traced as a line of the exchange's lifecycle writer.
+func TestLifecycle(t *testing.T) {
+	ctx := context.Background()
+	var buf bytes.Buffer
+	e, buyer, _, item := orderExchange(t, [2]float64{1, 1})
+	e.Lifecycle = &buf
+
+	r := trade.Request{ID: uuid.New(), TraderID: buyer.ID, Item: item, Quantity: 1, Side: trade.SideBuy, Type: trade.OrderMarket, TimeInForce: trade.TimeInForceIOC}
+	if err := e.routeRequest(ctx, r); err != nil {
+		t.Fatalf("route request: %v", err)
+	}
+	if err := e.cancel(ctx, trade.Cancel{ID: uuid.New(), TraderID: buyer.ID, OrderID: r.ID}); err != nil {
+		t.Fatalf("cancel: %v", err)
+	}
+	reps := reports(buyer)
+	var expected bytes.Buffer
+	for i := range reps {
+		expected.WriteString(reps[i].String() + "\n")
+	}
+	if len(reps) != 2 || buf.String() != expected.String() {
+		t.Errorf("lifecycle: expected: %q actual: %q", expected.String(), buf.String())
+	}
+	if !strings.Contains(buf.String(), `type="canceled"`) {
+		t.Errorf("lifecycle: expected canceled order actual: %q", buf.String())
+	}
+}
+
+// TestCancelWhileMining asserts that an order is canceled while the block
+// of a chosen fill is mined, without waiting on it, canceling the quantity
+// that isn't being executed, and that the chosen fill is then executed.
+func TestCancelWhileMining(t *testing.T) {
+	ctx := context.Background()
+	asks := [][2]float64{{1, 1}, {1, 1}}
+	e, buyer, sellers, item := orderExchange(t, asks...)
+	r := trade.Request{ID: uuid.New(), TraderID: buyer.ID, Item: item, Quantity: 2, Side: trade.SideBuy, Type: trade.OrderMarket, TimeInForce: trade.TimeInForceIOC}
+	if err := e.routeRequest(ctx, r); err != nil {
+		t.Fatalf("route request: %v", err)
+	}
+	quoteOrder(t, e, r, sellers, asks...)
+	fills := <-buyer.ResponseRecv
+	reports(buyer)
+
+	// Holding the execution lock stalls the fill once it's claimed.
+	e.execLock.Lock()
+	done := make(chan error, 1)
+	go func() {
+		f := fills[0]
+		buyer.SignChoice(&f)
+		done <- e.routeChoice(ctx, buyer.ID, f)
+	}()
+	for executing := 0.0; executing == 0; {
+		e.ordersLock.Lock()
+		executing = e.orders[r.ID].executing
+		e.ordersLock.Unlock()
+	}
+	if err := e.cancel(ctx, trade.Cancel{ID: uuid.New(), TraderID: buyer.ID, OrderID: r.ID}); err != nil {
+		t.Fatalf("cancel: %v", err)
+	}
+	e.execLock.Unlock()
+	if err := <-done; err != nil {
+		t.Fatalf("route choice: %v", err)
+	}
+	f := fills[1]
+	buyer.SignChoice(&f)
+	if err := e.routeChoice(ctx, buyer.ID, f); err != nil {
+		t.Fatalf("route choice: %v", err)
+	}
+	if expected, actual := 2, e.DB.Len(); expected != actual {
+		t.Errorf("blockchain length: expected: %d actual: %d", expected, actual)
+	}
+	reps := reports(buyer)
+	if len(reps) != 2 || reps[0].Type != trade.ExecCanceled || reps[0].Canceled != 1 || reps[0].Leaves != 1 ||
+		reps[1].Type != trade.ExecTrade || reps[1].Filled != 1 || reps[1].Leaves != 0 {
+		t.Errorf("reports: expected: canceled 1 and filled 1 actual: %+v", reps)
+	}
+}
+
+// TestReplace asserts that the quantity and limit price of an order are
+// replaced before it's matched, and that a replace once it's matched or
+// of an invalid quantity is rejected.
+func TestReplace(t *testing.T) {
+	ctx := context.Background()
+	asks := [][2]float64{{2, 2}, {1, 1}}
+	e, buyer, sellers, item := orderExchange(t, asks...)
+	var buf bytes.Buffer
+	e.Log = NewEventLog(&buf)
+
+	r := trade.Request{ID: uuid.New(), TraderID: buyer.ID, Item: item, Quantity: 1, Side: trade.SideBuy, Type: trade.OrderLimit, Price: 1, TimeInForce: trade.TimeInForceIOC}
+	if err := e.routeRequest(ctx, r); err != nil {
+		t.Fatalf("route request: %v", err)
+	}
+	if err := e.replace(ctx, trade.Replace{ID: uuid.New(), TraderID: buyer.ID, OrderID: r.ID, Quantity: -1, Price: 2}); err != nil {
+		t.Fatalf("replace: %v", err)
+	}
+	if err := e.replace(ctx, trade.Replace{ID: uuid.New(), TraderID: buyer.ID, OrderID: r.ID, Quantity: 3, Price: 2}); err != nil {
+		t.Fatalf("replace: %v", err)
+	}
+	quoteOrder(t, e, r, sellers, asks...)
+	if err := e.replace(ctx, trade.Replace{ID: uuid.New(), TraderID: buyer.ID, OrderID: r.ID, Quantity: 1, Price: 2}); err != nil {
+		t.Fatalf("replace: %v", err)
+	}
+	if expected, actual := 2, chooseFills(t, e, buyer); expected != actual {
+		t.Fatalf("fills: expected: %d actual: %d", expected, actual)
+	}
+
+	reps := reports(buyer)
+	types := []trade.ExecType{trade.ExecNew, trade.ExecCancelRejected, trade.ExecReplaced, trade.ExecCancelRejected, trade.ExecTrade, trade.ExecTrade}
+	if len(reps) != len(types) {
+		t.Fatalf("reports: expected: %d actual: %+v", len(types), reps)
+	}
+	for i, rep := range reps {
+		if rep.Type != types[i] {
+			t.Errorf("report %d: expected: %d actual: %+v", i, types[i], rep)
+		}
+	}
+	if rep := reps[2]; rep.Quantity != 3 || rep.Leaves != 3 {
+		t.Errorf("replaced: expected: quantity 3 actual: %+v", rep)
+	}
+	if rep := reps[3]; rep.Reason != "order is already matched" {
+		t.Errorf("rejected replace: expected: matched actual: %+v", rep)
+	}
+	if rep := reps[5]; rep.Filled != 3 || rep.Leaves != 0 {
+		t.Errorf("last trade: expected: filled 3 actual: %+v", rep)
+	}
+
+	events, err := ReadEvents(&buf)
+	if err != nil {
+		t.Fatalf("read events: %v", err)
+	}
+	replay, err := NewReplayExchange(events)
+	if err != nil {
+		t.Fatalf("replay exchange: %v", err)
+	}
+	divergences, err := replay.Replay(events)
+	if err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	if len(divergences) != 0 {
+		t.Errorf("divergences: expected: 0 actual: %v", divergences)
+	}
+}
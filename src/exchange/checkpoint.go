@@ -0,0 +1,272 @@
+package exchange
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+	"tradesim/src/trade"
+
+	"github.com/google/uuid"
+)
+
+var ErrCheckpoint = errors.New("failed to checkpoint exchange")
+
+// Checkpoint represents the state of an exchange stepped by a simulation
+// between two of its events, from which the simulation can be resumed.
+type Checkpoint struct {
+	// Tick is the number of ticks the exchange has seen,
+	// and Clock the position of its clock, if it has one.
+	Tick  uint64 `json:"tick"`
+	Clock uint64 `json:"clock,omitempty"`
+	// Orders are the live orders of the exchange, and the orders that are
+	// no longer live but are still referenced by its books and crosses.
+	Orders    []OrderCheckpoint     `json:"orders,omitempty"`
+	Books     []BookCheckpoint      `json:"books,omitempty"`
+	Crosses   []CrossCheckpoint     `json:"crosses,omitempty"`
+	Reference map[uuid.UUID]float64 `json:"reference,omitempty"`
+	Stats     OrderStats            `json:"stats"`
+	// Timers are the quote and choose windows of the exchange's
+	// orders that haven't elapsed, in the order they elapse.
+	Timers []TimerCheckpoint `json:"timers,omitempty"`
+}
+
+// OrderCheckpoint represents the state of an order,
+// and whether it's live on the exchange.
+type OrderCheckpoint struct {
+	Request   trade.Request         `json:"request"`
+	Awaiting  []uuid.UUID           `json:"awaiting,omitempty"`
+	Quotes    trade.Responses       `json:"quotes,omitempty"`
+	Matched   bool                  `json:"matched,omitempty"`
+	Allocated map[uuid.UUID]float64 `json:"allocated,omitempty"`
+	Filled    float64               `json:"filled,omitempty"`
+	Leaves    float64               `json:"leaves,omitempty"`
+	Canceled  float64               `json:"canceled,omitempty"`
+	Expires   uint64                `json:"expires,omitempty"`
+	Expired   bool                  `json:"expired,omitempty"`
+	Quoted    uint64                `json:"quoted,omitempty"`
+	Auction   bool                  `json:"auction,omitempty"`
+	Live      bool                  `json:"live,omitempty"`
+}
+
+// BookCheckpoint represents the orders collected for the auctions of the
+// market of an item, as indexes into the checkpoint's orders.
+type BookCheckpoint struct {
+	Item     uuid.UUID `json:"item"`
+	Orders   []int     `json:"orders,omitempty"`
+	Auctions uint64    `json:"auctions,omitempty"`
+}
+
+// CrossCheckpoint represents a cross of an auction, whose
+// sell order is an index into the checkpoint's orders.
+type CrossCheckpoint struct {
+	Response trade.Response `json:"response"`
+	Sell     int            `json:"sell"`
+	Signed   bool           `json:"signed,omitempty"`
+}
+
+// TimerCheckpoint represents a quote window of the order of a
+// request, or a choose window of the fills of a lapse.
+type TimerCheckpoint struct {
+	At        time.Time `json:"at"`
+	RequestID uuid.UUID `json:"request_id,omitempty"`
+	Lapse     *Lapse    `json:"lapse,omitempty"`
+}
+
+// Checkpoint returns the state of the simulation's exchange, or an error
+// if the simulation has external traders, whose messages aren't part of
+// it. It must not be called while the simulation runs.
+func (s *Simulation) Checkpoint() (Checkpoint, error) {
+	if len(s.external) > 0 {
+		return Checkpoint{}, fmt.Errorf("%w: messages of external traders can't be checkpointed", ErrCheckpoint)
+	}
+	return s.exchange.checkpoint()
+}
+
+// Restore resumes the simulation's exchange from the provided checkpoint,
+// or returns an error if the checkpoint is inconsistent. It must be called
+// before the simulation runs.
+func (s *Simulation) Restore(c Checkpoint) error {
+	return s.exchange.restore(c)
+}
+
+// checkpoint returns the state of the exchange, whose orders are in the
+// order of their request IDs, followed by the orders only referenced by
+// its books and crosses, or an error if the fills of an order are being
+// executed.
+func (e *Exchange) checkpoint() (Checkpoint, error) {
+	e.ordersLock.Lock()
+	defer e.ordersLock.Unlock()
+	c := Checkpoint{
+		Tick:      e.tick,
+		Reference: make(map[uuid.UUID]float64, len(e.reference)),
+		Stats:     e.stats,
+	}
+	if e.Clock != nil {
+		c.Clock = e.Clock.Position()
+	}
+	for id, p := range e.reference {
+		c.Reference[id] = p
+	}
+
+	indexes := make(map[*order]int)
+	var failed error
+	add := func(o *order) int {
+		if i, ok := indexes[o]; ok {
+			return i
+		}
+		if o.executing > quantityEpsilon {
+			failed = fmt.Errorf("%w: fills of order are executing: %s", ErrCheckpoint, o.id)
+		}
+		indexes[o] = len(c.Orders)
+		c.Orders = append(c.Orders, o.checkpoint(e.orders[o.request.ID] == o))
+		return indexes[o]
+	}
+	live := make([]*order, 0, len(e.orders))
+	for _, o := range e.orders {
+		live = append(live, o)
+	}
+	sort.Slice(live, func(i, j int) bool { return live[i].request.ID.String() < live[j].request.ID.String() })
+	for _, o := range live {
+		add(o)
+	}
+
+	items := make([]uuid.UUID, 0, len(e.books))
+	for id := range e.books {
+		items = append(items, id)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].String() < items[j].String() })
+	for _, id := range items {
+		b := e.books[id]
+		bc := BookCheckpoint{Item: id, Auctions: b.auctions}
+		for _, o := range b.orders {
+			bc.Orders = append(bc.Orders, add(o))
+		}
+		c.Books = append(c.Books, bc)
+	}
+
+	quotes := make([]uuid.UUID, 0, len(e.crosses))
+	for id := range e.crosses {
+		quotes = append(quotes, id)
+	}
+	sort.Slice(quotes, func(i, j int) bool { return quotes[i].String() < quotes[j].String() })
+	for _, id := range quotes {
+		x := e.crosses[id]
+		c.Crosses = append(c.Crosses, CrossCheckpoint{Response: x.resp, Sell: add(x.sell), Signed: x.signed})
+	}
+
+	for _, t := range e.timers {
+		c.Timers = append(c.Timers, TimerCheckpoint{At: t.at, RequestID: t.requestID, Lapse: t.lapse})
+	}
+	if failed != nil {
+		return Checkpoint{}, failed
+	}
+	return c, nil
+}
+
+// checkpoint returns the state of the order, which is live if it's
+// registered with its exchange.
+func (o *order) checkpoint(live bool) OrderCheckpoint {
+	c := OrderCheckpoint{
+		Request:  o.request,
+		Quotes:   o.quotes,
+		Matched:  o.matched,
+		Filled:   o.filled,
+		Leaves:   o.leaves,
+		Canceled: o.canceled,
+		Expires:  o.expires,
+		Expired:  o.expired,
+		Quoted:   o.quoted,
+		Auction:  o.auction,
+		Live:     live,
+	}
+	for id := range o.awaiting {
+		c.Awaiting = append(c.Awaiting, id)
+	}
+	sort.Slice(c.Awaiting, func(i, j int) bool { return c.Awaiting[i].String() < c.Awaiting[j].String() })
+	if len(o.allocated) > 0 {
+		c.Allocated = make(map[uuid.UUID]float64, len(o.allocated))
+		for id, fill := range o.allocated {
+			c.Allocated[id] = fill
+		}
+	}
+	return c
+}
+
+// restore restores the exchange to the provided state, or returns an
+// error if its books or crosses refer to orders it doesn't have.
+func (e *Exchange) restore(c Checkpoint) error {
+	orders := make([]*order, len(c.Orders))
+	for i, oc := range c.Orders {
+		o := newOrder(oc.Request)
+		o.quotes = oc.Quotes
+		o.matched = oc.Matched
+		o.filled = oc.Filled
+		o.leaves = oc.Leaves
+		o.canceled = oc.Canceled
+		o.expires = oc.Expires
+		o.expired = oc.Expired
+		o.quoted = oc.Quoted
+		o.auction = oc.Auction
+		for _, id := range oc.Awaiting {
+			o.awaiting[id] = true
+		}
+		for id, fill := range oc.Allocated {
+			o.allocated[id] = fill
+		}
+		orders[i] = o
+	}
+	lookup := func(i int) (*order, error) {
+		if i < 0 || i >= len(orders) {
+			return nil, fmt.Errorf("%w: order index out of range: max=%d got=%d", ErrCheckpoint, len(orders)-1, i)
+		}
+		return orders[i], nil
+	}
+	books := make(map[uuid.UUID]*book, len(c.Books))
+	for _, bc := range c.Books {
+		b := &book{auctions: bc.Auctions}
+		for _, i := range bc.Orders {
+			o, err := lookup(i)
+			if err != nil {
+				return err
+			}
+			b.orders = append(b.orders, o)
+		}
+		books[bc.Item] = b
+	}
+	crosses := make(map[uuid.UUID]*cross, len(c.Crosses))
+	for _, cc := range c.Crosses {
+		sell, err := lookup(cc.Sell)
+		if err != nil {
+			return err
+		}
+		crosses[cc.Response.ID] = &cross{resp: cc.Response, sell: sell, signed: cc.Signed}
+	}
+
+	e.ordersLock.Lock()
+	defer e.ordersLock.Unlock()
+	e.tick = c.Tick
+	if e.Clock != nil {
+		e.Clock.SetPosition(c.Clock)
+	}
+	e.orders = make(map[uuid.UUID]*order, len(orders))
+	e.byOrderID = make(map[uuid.UUID]*order, len(orders))
+	for i, o := range orders {
+		if c.Orders[i].Live {
+			e.orders[o.request.ID] = o
+			e.byOrderID[o.id] = o
+		}
+	}
+	e.books = books
+	e.crosses = crosses
+	e.reference = make(map[uuid.UUID]float64, len(c.Reference))
+	for id, p := range c.Reference {
+		e.reference[id] = p
+	}
+	e.stats = c.Stats
+	e.timers = make([]timer, 0, len(c.Timers))
+	for _, t := range c.Timers {
+		e.timers = append(e.timers, timer{at: t.At, requestID: t.RequestID, lapse: t.Lapse})
+	}
+	return nil
+}
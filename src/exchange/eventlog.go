@@ -0,0 +1,176 @@
+package exchange
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var ErrEventLog = errors.New("failed to record event")
+
+type MessageType = string
+
+const (
+	MessageRequest     MessageType = "request"
+	MessageResponse    MessageType = "response"
+	MessageResponses   MessageType = "responses"
+	MessageChoice      MessageType = "choice"
+	MessageTransaction MessageType = "transaction"
+	// MessageReport is an execution report of an order, and MessageMatch
+	// is the match of an order, whose payload is the order's request.
+	MessageReport MessageType = "report"
+	MessageMatch  MessageType = "match"
+	// MessageCancel and MessageReplace are a cancel and a replace of an
+	// order, whose market is nil if the order isn't found.
+	MessageCancel  MessageType = "cancel"
+	MessageReplace MessageType = "replace"
+	// MessageExpire is the expiry of an order, and MessageRequote is the
+	// routing of an order that rests for quotes again, whose payloads are
+	// the order's request.
+	MessageExpire  MessageType = "expire"
+	MessageRequote MessageType = "requote"
+	// MessageOrder is the collection of an order for an auction, whose
+	// payload is the order's request, and MessageAuction is the clearing
+	// of an auction, whose payload is the auction's result.
+	MessageOrder   MessageType = "order"
+	MessageAuction MessageType = "auction"
+	// MessageRejection is a choice the exchange didn't execute,
+	// whose payload is the rejection.
+	MessageRejection MessageType = "rejection"
+	// MessageLapse is the lapse of the fills of an order that weren't
+	// chosen within the exchange's choose window, whose payload is the
+	// lapse.
+	MessageLapse MessageType = "lapse"
+	// MessageGenesis is the genesis of the exchange's blockchain, whose
+	// payload is its genesis, and MessageKey is the registration of the
+	// public key of a trader of a market, whose payload is the
+	// registration.
+	MessageGenesis MessageType = "genesis"
+	MessageKey     MessageType = "key"
+)
+
+// Event represents a message passing through the exchange.
+//
+// The exchange itself is identified by the nil UUID, so an event with
+// a nil receiver is a message to the exchange, such as a trader's choice,
+// and an event with a nil sender is a message from it, such as the
+// transaction executed from a choice.
+type Event struct {
+	// Sequence is the position of the event among the events recorded by
+	// its log, which orders events whatever their time, and Time is the
+	// simulated time the message passed through the exchange, read from
+	// the exchange's Now.
+	Sequence uint64    `json:"sequence"`
+	Time     time.Time `json:"time"`
+	// Type is the type of the message.
+	Type MessageType `json:"type"`
+	// Sender is the ID of the trader that sent the message.
+	Sender uuid.UUID `json:"sender"`
+	// Receiver is the ID of the trader the message was delivered to.
+	Receiver uuid.UUID `json:"receiver"`
+	// Market is the item ID of the market the message belongs to.
+	Market uuid.UUID `json:"market"`
+	// Payload is the JSON encoding of the message.
+	Payload json.RawMessage `json:"payload"`
+}
+
+// EventLog is an append-only log of events, written as JSON Lines
+// with one event per line. An event log is safe for concurrent use.
+//
+// Every event has a position in the log, which is reserved when it's
+// recorded, or beforehand with reserve, and events are written in the
+// order of their positions. An event is only written once every event
+// before it is, so every reserved position must be recorded.
+type EventLog struct {
+	// mu guards enc, next and written, so that concurrent events
+	// aren't interleaved, and turn signals that written changed.
+	mu   sync.Mutex
+	turn *sync.Cond
+	enc  *json.Encoder
+	// next is the position of the next reserved event,
+	// and written is the number of events written.
+	next    uint64
+	written uint64
+	// closer closes the underlying writer, if the log owns it.
+	closer io.Closer
+}
+
+// NewEventLog returns an event log that writes to the provided writer.
+func NewEventLog(w io.Writer) *EventLog {
+	l := &EventLog{enc: json.NewEncoder(w)}
+	l.turn = sync.NewCond(&l.mu)
+	return l
+}
+
+// OpenEventLog returns an event log that writes to the file at the
+// provided path, which is created or appended to.
+func OpenEventLog(filepath string) (*EventLog, error) {
+	f, err := os.OpenFile(filepath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	l := NewEventLog(f)
+	l.closer = f
+	return l, nil
+}
+
+// Record appends an event with the provided message as its payload.
+func (l *EventLog) Record(at time.Time, msgType MessageType, sender, receiver, market uuid.UUID, msg interface{}) error {
+	return l.recordAt(l.reserve(), at, msgType, sender, receiver, market, msg)
+}
+
+// reserve reserves the position of the next event of the log, which is
+// written once it's recorded by recordAt, after the events reserved before
+// it, so that events are ordered without writing them while reserving.
+func (l *EventLog) reserve() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	pos := l.next
+	l.next++
+	return pos
+}
+
+// recordAt writes an event with the provided message as its payload at
+// the provided reserved position, once the events before it are written.
+// The position is consumed even if the event isn't written.
+func (l *EventLog) recordAt(pos uint64, at time.Time, msgType MessageType, sender, receiver, market uuid.UUID, msg interface{}) error {
+	payload, err := json.Marshal(msg)
+	e := Event{
+		Sequence: pos,
+		Time:     at,
+		Type:     msgType,
+		Sender:   sender,
+		Receiver: receiver,
+		Market:   market,
+		Payload:  payload,
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for l.written != pos {
+		l.turn.Wait()
+	}
+	defer l.turn.Broadcast()
+	l.written++
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrEventLog, err)
+	}
+	if err := l.enc.Encode(e); err != nil {
+		return fmt.Errorf("%w: %v", ErrEventLog, err)
+	}
+	return nil
+}
+
+// Close closes the underlying file of the log, if it was opened by OpenEventLog.
+func (l *EventLog) Close() error {
+	if l.closer == nil {
+		return nil
+	}
+	return l.closer.Close()
+}
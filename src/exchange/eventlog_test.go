@@ -0,0 +1,87 @@
+package exchange
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+	"tradesim/src/trade"
+
+	"github.com/google/uuid"
+)
+
+// TestEventLogRecord asserts that every recorded event
+// is written as a single line of JSON with its payload.
+func TestEventLogRecord(t *testing.T) {
+	var buf bytes.Buffer
+	l := NewEventLog(&buf)
+
+	item := trade.NewItem("a")
+	req := trade.Request{ID: uuid.New(), TraderID: uuid.New(), Item: item, Quantity: 2, Side: trade.SideBuy}
+	for i := 0; i < 3; i++ {
+		if err := l.Record(time.Now(), MessageRequest, req.TraderID, uuid.New(), item.ID, req); err != nil {
+			t.Fatalf("record: %v", err)
+		}
+	}
+
+	n := 0
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var e Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("unmarshal event: %v", err)
+		}
+		var r trade.Request
+		if err := json.Unmarshal(e.Payload, &r); err != nil {
+			t.Fatalf("unmarshal payload: %v", err)
+		}
+		if e.Type != MessageRequest || e.Sender != req.TraderID || e.Market != item.ID || r != req {
+			t.Errorf("event: expected request %+v actual: %+v", req, e)
+		}
+		n++
+	}
+	if expected := 3; expected != n {
+		t.Errorf("event count: expected: %d actual: %d", expected, n)
+	}
+}
+
+// TestEventLogReserve asserts that events are written in the order
+// their positions were reserved, whatever order they're recorded in,
+// with their positions as their sequences.
+func TestEventLogReserve(t *testing.T) {
+	var buf bytes.Buffer
+	l := NewEventLog(&buf)
+
+	item := trade.NewItem("a")
+	first, second := l.reserve(), l.reserve()
+	done := make(chan error, 1)
+	go func() {
+		done <- l.recordAt(second, time.Now(), MessageCancel, uuid.Nil, uuid.Nil, item.ID, trade.Cancel{})
+	}()
+	if err := l.recordAt(first, time.Now(), MessageOrder, uuid.Nil, uuid.Nil, item.ID, trade.Request{}); err != nil {
+		t.Fatalf("record: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("record: %v", err)
+	}
+	if err := l.Record(time.Now(), MessageReport, uuid.Nil, uuid.Nil, item.ID, trade.ExecutionReport{}); err != nil {
+		t.Fatalf("record: %v", err)
+	}
+
+	var types []MessageType
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var e Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("unmarshal event: %v", err)
+		}
+		if expected := uint64(len(types)); e.Sequence != expected {
+			t.Errorf("event sequence: expected: %d actual: %d", expected, e.Sequence)
+		}
+		types = append(types, e.Type)
+	}
+	if len(types) != 3 || types[0] != MessageOrder || types[1] != MessageCancel || types[2] != MessageReport {
+		t.Errorf("event types: expected: order cancel report actual: %v", types)
+	}
+}
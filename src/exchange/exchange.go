@@ -2,13 +2,19 @@ package exchange
 
 import (
 	"context"
+	"crypto/ed25519"
+	"errors"
 	"fmt"
+	"io"
+	"sort"
 	"sync"
+	"time"
 	"tradesim/src/db"
+	"tradesim/src/prob"
+	"tradesim/src/time/clock"
 	"tradesim/src/trade"
 
 	"github.com/google/uuid"
-	"golang.org/x/sync/errgroup"
 )
 
 // Market represents a tradable item on an exchange,
@@ -16,6 +22,15 @@ import (
 type Market struct {
 	Item       trade.Item
 	TraderByID map[uuid.UUID]*trade.Trader
+	// Mode is how the market's orders are matched, and AuctionTicks is the
+	// number of ticks of the exchange's clock between the call auctions of
+	// a market in auction mode. OpeningTicks and ClosingTicks are the number
+	// of ticks at the open and close of every session of a continuous market
+	// during which orders are collected for its opening and closing auctions.
+	Mode         MarketMode
+	AuctionTicks uint64
+	OpeningTicks uint64
+	ClosingTicks uint64
 }
 
 func NewMarket(item trade.Item, traders ...*trade.Trader) Market {
@@ -34,121 +49,383 @@ func NewMarket(item trade.Item, traders ...*trade.Trader) Market {
 type Exchange struct {
 	Markets map[uuid.UUID]Market
 	DB      *db.Blockchain
-	dbLock  sync.Mutex
+	// execLock serializes the execution of claimed fills, so that their
+	// transactions are identified, mined, appended and recorded in the
+	// same order. It isn't held while orders are claimed, canceled or
+	// replaced, which don't wait on mining, nor while their fills are
+	// reported, which waits on their traders.
+	execLock sync.Mutex
+	// Log records every message passing through the exchange, if not nil.
+	Log *EventLog
+	// Lifecycle receives a line for every execution report the exchange
+	// sends, tracing the lifecycle of every order, if not nil. It must
+	// not be set once the exchange is simulated. lifecycleLock guards
+	// it, so that the lines of concurrent reports aren't interleaved.
+	Lifecycle     io.Writer
+	lifecycleLock sync.Mutex
+	// excitations are the processes excited by trades, by market item ID.
+	excitations map[uuid.UUID][]excitation
+	// Now returns the current simulated time, which stamps the blocks
+	// the exchange appends and the events it records. It's the wall-clock
+	// time unless it's set, and must not be set once the exchange is
+	// simulated.
+	Now func() time.Time
+	// stamp returns the ID and simulated time of a new transaction,
+	// which is the time its block is created on and it's recorded at.
+	stamp func() (uuid.UUID, time.Time)
+	// feed publishes trades and blocks to subscribers.
+	feed feed
+	// QuoteWindow bounds how long the quotes of an order are collected
+	// before it's matched, where 0 collects them until every trader of
+	// its market has quoted it. It must not be set once the exchange
+	// is simulated.
+	QuoteWindow time.Duration
+	// ChooseWindow bounds how long the fills allocated to an order may
+	// take to be chosen once they're delivered, after which they lapse,
+	// where 0 waits for them indefinitely. It must not be set once the
+	// exchange is simulated.
+	ChooseWindow time.Duration
+	// orders are the live orders of traders by request ID and byOrderID
+	// by order ID, which are guarded by ordersLock along with stats.
+	orders     map[uuid.UUID]*order
+	byOrderID  map[uuid.UUID]*order
+	stats      OrderStats
+	ordersLock sync.Mutex
+	// books are the orders collected for auctions by market item ID,
+	// crosses are the crosses of auctions by quote ID, and reference
+	// is the price of the last trade by market item ID, which are
+	// guarded by ordersLock.
+	books     map[uuid.UUID]*book
+	crosses   map[uuid.UUID]*cross
+	reference map[uuid.UUID]float64
+	// Clock ticks the expiry of orders and requotes the orders that rest,
+	// if not nil, SessionTicks is the number of its ticks per session, at
+	// whose close day orders expire, and RequoteTicks is the number of its
+	// ticks between the requotes of an order, where 0 requotes it on every
+	// tick. They must not be set once the exchange is simulated. tick is
+	// the number of ticks the exchange has seen, guarded by ordersLock.
+	Clock        *clock.Clock
+	SessionTicks uint64
+	RequoteTicks uint64
+	tick         uint64
+	// replaying is whether the exchange is replaying recorded events,
+	// where orders are only matched by their recorded matches.
+	replaying bool
+	// timers are the quote and choose windows of orders that haven't
+	// elapsed in the order they elapse, guarded by ordersLock.
+	timers []timer
+}
+
+// excitation represents a process that is excited
+// with a weight by every trade in a market.
+type excitation struct {
+	exciter prob.Exciter
+	weight  float64
 }
 
 func NewExchange(markets []Market) *Exchange {
 	e := &Exchange{
-		Markets: make(map[uuid.UUID]Market, len(markets)),
-		DB:      db.NewBlockchain(),
+		Markets:      make(map[uuid.UUID]Market, len(markets)),
+		DB:           db.NewBlockchain(),
+		excitations:  make(map[uuid.UUID][]excitation),
+		Now:          func() time.Time { return time.Now().UTC() },
+		QuoteWindow:  DefaultQuoteWindow,
+		ChooseWindow: DefaultChooseWindow,
+		RequoteTicks: DefaultRequoteTicks,
+		orders:       make(map[uuid.UUID]*order),
+		byOrderID:    make(map[uuid.UUID]*order),
+		books:        make(map[uuid.UUID]*book),
+		crosses:      make(map[uuid.UUID]*cross),
+		reference:    make(map[uuid.UUID]float64),
 	}
+	e.stamp = func() (uuid.UUID, time.Time) { return uuid.New(), e.Now() }
 	for _, m := range markets {
 		e.Markets[m.Item.ID] = m
 	}
+	e.registerKeys()
 	return e
 }
 
-func (e *Exchange) Start(ctx context.Context) error {
-	wg, c := errgroup.WithContext(ctx)
+// AddExcitation registers the provided exciter to be excited with the
+// provided weight by every trade in the market of the provided item,
+// so that activity in one market can raise activity in others.
+// It must not be called once the exchange is simulated.
+func (e *Exchange) AddExcitation(itemID uuid.UUID, exciter prob.Exciter, weight float64) {
+	e.excitations[itemID] = append(e.excitations[itemID], excitation{exciter: exciter, weight: weight})
+}
+
+// Genesis represents the genesis block of the exchange's blockchain and
+// the nonce its blocks are sealed from, from which a replay builds an
+// identical ledger.
+type Genesis struct {
+	CreatedOn time.Time
+	NonceSeed uint64
+}
+
+// Registration represents the public key of a trader of a market.
+type Registration struct {
+	Item      trade.Item
+	TraderID  uuid.UUID
+	PublicKey ed25519.PublicKey
+}
+
+// RegisterKeys registers the public key of every trader of the exchange
+// with its blockchain, so that it only appends transactions signed by
+// their traders, and records the blockchain's genesis and the keys in the
+// event log, so that a replay verifies the recorded signatures on an
+// identical ledger. It must be called again if the blockchain or the
+// event log is replaced.
+func (e *Exchange) RegisterKeys() error {
+	e.registerKeys()
+	if e.Log == nil {
+		return nil
+	}
+	gen, _ := e.DB.Block(0)
+	if err := e.record(MessageGenesis, uuid.Nil, uuid.Nil, uuid.Nil, Genesis{CreatedOn: gen.CreatedOn, NonceSeed: e.DB.NonceSeed()}); err != nil {
+		return err
+	}
+	// Keys are recorded in the order of their market and trader
+	// IDs, so that their events are recorded in a reproducible order.
+	markets := make([]Market, 0, len(e.Markets))
 	for _, m := range e.Markets {
+		markets = append(markets, m)
+	}
+	sort.Slice(markets, func(i, j int) bool { return markets[i].Item.ID.String() < markets[j].Item.ID.String() })
+	for _, m := range markets {
+		traders := make([]*trade.Trader, 0, len(m.TraderByID))
 		for _, t := range m.TraderByID {
-			_t := t
-			wg.Go(func() error { return e.recvRequest(c, _t) })
-			wg.Go(func() error { return e.recvResponse(c, _t) })
-			wg.Go(func() error { return e.sendResponse(c, _t) })
-			wg.Go(func() error { return e.recvChoice(c, _t) })
+			traders = append(traders, t)
+		}
+		sort.Slice(traders, func(i, j int) bool { return traders[i].ID.String() < traders[j].ID.String() })
+		for _, t := range traders {
+			reg := Registration{Item: m.Item, TraderID: t.ID, PublicKey: t.PublicKey()}
+			if err := e.record(MessageKey, t.ID, uuid.Nil, m.Item.ID, reg); err != nil {
+				return err
+			}
 		}
 	}
-	return wg.Wait()
+	return nil
 }
 
-func (e *Exchange) recvRequest(ctx context.Context, t *trade.Trader) error {
-	select {
-	case <-ctx.Done():
-		return ctx.Err()
-	case r := <-t.RequestSend:
-		m, ok := e.Markets[r.Item.ID]
-		if !ok {
-			return fmt.Errorf("no market found for item: %+v", r.Item)
-		}
+// registerKeys registers the public key of every
+// trader of the exchange with its blockchain.
+func (e *Exchange) registerKeys() {
+	for _, m := range e.Markets {
 		for _, t := range m.TraderByID {
-			t.RequestRecv <- r
+			e.DB.RegisterKey(t.ID, t.PublicKey())
 		}
 	}
-	return nil
 }
 
-func (e *Exchange) recvResponse(ctx context.Context, t *trade.Trader) error {
-	select {
-	case <-ctx.Done():
-		return ctx.Err()
-	case resp := <-t.ResponseRecv:
-		r := resp[0]
-		m, ok := e.Markets[r.Request.Item.ID]
-		if !ok {
-			return fmt.Errorf("no market found for item: %+v", r.Request.Item.ID)
+// Trader returns the trader with the provided ID in any market
+// of the exchange, and whether it has one.
+func (e *Exchange) Trader(id uuid.UUID) (*trade.Trader, bool) {
+	for _, m := range e.Markets {
+		if t, ok := m.TraderByID[id]; ok {
+			return t, true
 		}
-		for _, t := range m.TraderByID {
-			if t.ID == r.Request.TraderID {
-				t.ResponseRecv <- resp
-			}
+	}
+	return nil, false
+}
+
+// routeRequest delivers a request to every trader
+// in the market of the requested item, once it's
+// accepted if it's an order, or collects it for the
+// market's auction if the market is in an auction.
+func (e *Exchange) routeRequest(ctx context.Context, r trade.Request) error {
+	m, ok := e.Markets[r.Item.ID]
+	if !ok {
+		return fmt.Errorf("no market found for item: %+v", r.Item)
+	}
+	if r.IsOrder() && e.auctioning(m) {
+		return e.collect(ctx, m, r)
+	}
+	if r.IsOrder() {
+		return e.accept(ctx, m, r)
+	}
+	return e.deliverRequest(ctx, m, r)
+}
+
+// deliverRequest delivers a request to every trader of the provided market.
+func (e *Exchange) deliverRequest(ctx context.Context, m Market, r trade.Request) error {
+	for _, t := range m.TraderByID {
+		if err := e.record(MessageRequest, r.TraderID, t.ID, m.Item.ID, r); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case t.RequestRecv <- r:
 		}
 	}
 	return nil
 }
 
-func (e *Exchange) sendResponse(ctx context.Context, t *trade.Trader) error {
-	select {
-	case <-ctx.Done():
-		return ctx.Err()
-	case resp := <-t.ResponseSend:
-		m, ok := e.Markets[resp.Request.Item.ID]
-		if !ok {
-			return fmt.Errorf("no market found for item: %+v", resp.Request.Item.ID)
+// routeResponses delivers a batch of responses sent by the trader
+// with the provided ID to the trader of their request.
+func (e *Exchange) routeResponses(ctx context.Context, sender uuid.UUID, resp trade.Responses) error {
+	r := resp[0]
+	m, ok := e.Markets[r.Request.Item.ID]
+	if !ok {
+		return fmt.Errorf("no market found for item: %+v", r.Request.Item.ID)
+	}
+	for _, t := range m.TraderByID {
+		if t.ID == r.Request.TraderID {
+			if err := e.record(MessageResponses, sender, t.ID, m.Item.ID, resp); err != nil {
+				return err
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case t.ResponseRecv <- resp:
+			}
 		}
-		r := []trade.Response{resp}
-		for _, t := range m.TraderByID {
-			if t.ID == resp.Request.TraderID {
-				t.ResponseRecv <- r
+	}
+	return nil
+}
+
+// routeResponse delivers a response to the trader of its request,
+// or collects it as a quote of the order of its request.
+func (e *Exchange) routeResponse(ctx context.Context, resp trade.Response) error {
+	m, ok := e.Markets[resp.Request.Item.ID]
+	if !ok {
+		return fmt.Errorf("no market found for item: %+v", resp.Request.Item.ID)
+	}
+	if resp.Request.IsOrder() {
+		return e.quote(ctx, resp)
+	}
+	r := []trade.Response{resp}
+	for _, t := range m.TraderByID {
+		if t.ID == resp.Request.TraderID {
+			if err := e.record(MessageResponse, resp.TraderID, t.ID, m.Item.ID, resp); err != nil {
+				return err
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case t.ResponseRecv <- r:
 			}
 		}
 	}
 	return nil
 }
 
-func (e *Exchange) recvChoice(ctx context.Context, t *trade.Trader) error {
-	select {
-	case <-ctx.Done():
-		return ctx.Err()
-	case c := <-t.Choice:
-		if err := e.execute(c); err != nil {
+// routeChoice executes the choice of a response
+// by the trader with the provided ID.
+func (e *Exchange) routeChoice(ctx context.Context, sender uuid.UUID, c trade.Response) error {
+	if err := e.record(MessageChoice, sender, uuid.Nil, c.Request.Item.ID, c); err != nil {
+		return err
+	}
+	return e.execute(ctx, c)
+}
+
+// Rejection represents a choice the exchange didn't execute, and why.
+type Rejection struct {
+	Choice trade.Response
+	Reason string
+}
+
+// execute executes the provided choice as a transaction appended to
+// the exchange's blockchain. A choice whose signatures don't verify
+// with the keys registered with the blockchain is rejected, as is a
+// choice that executes more than its quote's quantity, and a choice of
+// a response to an order that isn't allocated to it. Rejected choices
+// are recorded in the event log.
+// The choice's fill is claimed before its block is mined, so that its
+// order can be canceled while it's mined, and mining stops once the
+// provided context is done, releasing the fill.
+func (e *Exchange) execute(ctx context.Context, choice trade.Response) error {
+	t := choice.Transaction()
+	if err := e.DB.Verify(&t); err != nil {
+		return e.reject(choice, err.Error())
+	}
+	if !e.claim(choice.Request, &t) {
+		return e.reject(choice, "fill is not allocated")
+	}
+	reps, err := e.commit(ctx, choice, &t)
+	if err != nil {
+		return err
+	}
+	for _, rep := range reps {
+		if err := e.report(ctx, rep); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func (e *Exchange) execute(choice trade.Response) error {
-	e.dbLock.Lock()
-	defer e.dbLock.Unlock()
-
-	t := trade.Transaction{
-		ID: uuid.New(),
-		Credit: trade.TransactionRecord{
-			TraderID: choice.Request.TraderID,
-			Item:     choice.Request.Item,
-			Price:    choice.OrderBook.Ask.Price,
-			Quantity: choice.OrderBook.Ask.Quantity,
-		},
-		Debit: trade.TransactionRecord{
-			TraderID: choice.TraderID,
-			Item:     choice.Request.Item,
-			Price:    choice.OrderBook.Ask.Price,
-			Quantity: choice.OrderBook.Ask.Quantity,
-		},
-	}
-	if ok := e.DB.Append(db.NewBlock(&t)); !ok {
-		return fmt.Errorf("failed to persist transaction: %+v", t)
+// commit mines the block of the provided transaction of the provided
+// claimed choice, appends it to the exchange's blockchain and records it,
+// and returns the execution reports of the fills of its orders, which are
+// delivered once execLock is released, so that a trader slow to receive
+// them doesn't hold up the execution of other choices.
+func (e *Exchange) commit(ctx context.Context, choice trade.Response, t *trade.Transaction) ([]trade.ExecutionReport, error) {
+	e.execLock.Lock()
+	defer e.execLock.Unlock()
+
+	var at time.Time
+	t.ID, at = e.stamp()
+	blk := db.NewBlockAt(t, at)
+	if err := e.DB.AppendContext(ctx, blk); err != nil {
+		e.release(choice.Request.ID, t)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		// A choice of a quote executed since it was verified is rejected.
+		if errors.Is(err, db.ErrQuote) || errors.Is(err, db.ErrSignature) {
+			return nil, e.reject(choice, err.Error())
+		}
+		return nil, fmt.Errorf("failed to persist transaction: %+v: %v", *t, err)
+	}
+	if err := e.recordOn(at, MessageTransaction, uuid.Nil, uuid.Nil, choice.Request.Item.ID, *t); err != nil {
+		return nil, err
+	}
+	e.publish(t, blk.Info())
+	for _, x := range e.excitations[choice.Request.Item.ID] {
+		x.exciter.Excite(x.weight)
+	}
+	return e.fill(choice.Request.ID, t), nil
+}
+
+// reject records the rejection of the provided choice
+// for the provided reason in the event log, if it has one.
+func (e *Exchange) reject(choice trade.Response, reason string) error {
+	rej := Rejection{Choice: choice, Reason: reason}
+	return e.record(MessageRejection, uuid.Nil, choice.Request.TraderID, choice.Request.Item.ID, rej)
+}
+
+// record records a message passing through the exchange
+// in its event log, if it has one.
+func (e *Exchange) record(msgType MessageType, sender, receiver, market uuid.UUID, msg interface{}) error {
+	return e.recordOn(e.Now(), msgType, sender, receiver, market, msg)
+}
+
+// recordOn records a message passing through the exchange on the
+// provided simulated time in its event log, if it has one.
+func (e *Exchange) recordOn(at time.Time, msgType MessageType, sender, receiver, market uuid.UUID, msg interface{}) error {
+	if e.Log == nil {
+		return nil
 	}
-	return nil
+	return e.Log.Record(at, msgType, sender, receiver, market, msg)
+}
+
+// reserve reserves the position of a message in the exchange's event log,
+// if it has one, which is recorded by recordReserved. It's called while
+// the orders are locked, so that messages are recorded in the order they
+// change the orders, without writing them while the orders are locked.
+func (e *Exchange) reserve() uint64 {
+	if e.Log == nil {
+		return 0
+	}
+	return e.Log.reserve()
+}
+
+// recordReserved records a message passing through the exchange in its
+// event log, if it has one, at the provided position reserved by reserve.
+// Every reserved position must be recorded, or later messages aren't.
+func (e *Exchange) recordReserved(pos uint64, msgType MessageType, sender, receiver, market uuid.UUID, msg interface{}) error {
+	if e.Log == nil {
+		return nil
+	}
+	return e.Log.recordAt(pos, e.Now(), msgType, sender, receiver, market, msg)
 }
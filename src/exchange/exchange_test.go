@@ -0,0 +1,108 @@
+package exchange
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"tradesim/src/db"
+	"tradesim/src/trade"
+
+	"github.com/google/uuid"
+)
+
+// exciter records the weights it's excited with.
+type exciter struct {
+	mu      sync.Mutex
+	weights []float64
+}
+
+func (x *exciter) Excite(weight float64) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.weights = append(x.weights, weight)
+}
+
+// TestExcitation asserts that every trade in a market excites the
+// processes registered for it, and only those, with their weights.
+func TestExcitation(t *testing.T) {
+	a, b := trade.NewItem("a"), trade.NewItem("b")
+	buyer, seller := uuid.New(), uuid.New()
+	e := NewExchange(nil)
+	ax, bx := &exciter{}, &exciter{}
+	e.AddExcitation(a.ID, ax, 0.5)
+	e.AddExcitation(a.ID, bx, 0.25)
+	e.AddExcitation(b.ID, bx, 2)
+
+	for _, c := range []trade.Response{choice(a, buyer, seller), choice(a, buyer, seller), choice(b, buyer, seller)} {
+		if err := e.execute(context.Background(), c); err != nil {
+			t.Fatalf("execute: %v", err)
+		}
+	}
+	if expected := []float64{0.5, 0.5}; !reflect.DeepEqual(expected, ax.weights) {
+		t.Errorf("market a weights: expected: %v actual: %v", expected, ax.weights)
+	}
+	if expected := []float64{0.25, 0.25, 2}; !reflect.DeepEqual(expected, bx.weights) {
+		t.Errorf("market a and b weights: expected: %v actual: %v", expected, bx.weights)
+	}
+}
+
+// TestRejection asserts that a choice whose signatures don't verify, and
+// a choice that executes a quote again, are rejected and recorded in the
+// event log rather than executed.
+func TestRejection(t *testing.T) {
+	item := trade.NewItem("a")
+	buyer := newTrader(t, nil, nil)
+	seller := newTrader(t, nil, nil)
+	e := NewExchange([]Market{NewMarket(item, buyer, seller)})
+	var buf bytes.Buffer
+	e.Log = NewEventLog(&buf)
+	if err := e.RegisterKeys(); err != nil {
+		t.Fatalf("register keys: %v", err)
+	}
+
+	c := choice(item, buyer.ID, seller.ID)
+	if err := e.execute(context.Background(), c); err != nil {
+		t.Fatalf("execute unsigned: %v", err)
+	}
+	seller.SignQuote(&c)
+	buyer.SignChoice(&c)
+	for i := 0; i < 2; i++ {
+		if err := e.execute(context.Background(), c); err != nil {
+			t.Fatalf("execute signed: %v", err)
+		}
+	}
+	if expected, actual := 2, e.DB.Len(); expected != actual {
+		t.Errorf("blockchain length: expected: %d actual: %d", expected, actual)
+	}
+
+	events, err := ReadEvents(&buf)
+	if err != nil {
+		t.Fatalf("read events: %v", err)
+	}
+	var reasons []error
+	for _, ev := range events {
+		if ev.Type != MessageRejection {
+			continue
+		}
+		var r Rejection
+		if err := json.Unmarshal(ev.Payload, &r); err != nil {
+			t.Fatalf("unmarshal rejection: %v", err)
+		}
+		if r.Choice.ID != c.ID || ev.Receiver != buyer.ID {
+			t.Errorf("rejection: expected: choice %s of %s actual: %+v", c.ID, buyer.ID, ev)
+		}
+		switch {
+		case strings.Contains(r.Reason, db.ErrSignature.Error()):
+			reasons = append(reasons, db.ErrSignature)
+		case strings.Contains(r.Reason, db.ErrQuote.Error()):
+			reasons = append(reasons, db.ErrQuote)
+		}
+	}
+	if expected := []error{db.ErrSignature, db.ErrQuote}; !reflect.DeepEqual(expected, reasons) {
+		t.Errorf("rejections: expected: %v actual: %v", expected, reasons)
+	}
+}
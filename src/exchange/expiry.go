@@ -0,0 +1,130 @@
+package exchange
+
+import (
+	"context"
+	"sort"
+	"tradesim/src/trade"
+
+	"github.com/google/uuid"
+)
+
+// advance advances the exchange's tick, clears the auctions of the markets
+// whose auctions close on it, expires the orders due on it, and requotes
+// the orders that rest and are due for a requote. Auctions are cleared first, so that the orders
+// expiring at the close of a session take part in its closing auction,
+// whose crosses are still executed once the orders expire.
+func (e *Exchange) advance(ctx context.Context) error {
+	e.ordersLock.Lock()
+	e.tick++
+	tick := e.tick
+	e.ordersLock.Unlock()
+	if err := e.clearDue(ctx, tick); err != nil {
+		return err
+	}
+	if err := e.expireDue(ctx, tick); err != nil {
+		return err
+	}
+	return e.requoteResting(ctx, tick)
+}
+
+// expireDue expires every live order that
+// expires on or before the provided tick.
+func (e *Exchange) expireDue(ctx context.Context, tick uint64) error {
+	e.ordersLock.Lock()
+	var due []uuid.UUID
+	for id, o := range e.orders {
+		if o.expires != 0 && o.expires <= tick && !o.expired && o.live() == "" {
+			due = append(due, id)
+		}
+	}
+	e.ordersLock.Unlock()
+	// Orders are expired in the order of their request IDs, so that
+	// the expiries of a tick are recorded in a reproducible order.
+	sort.Slice(due, func(i, j int) bool { return due[i].String() < due[j].String() })
+	for _, id := range due {
+		if err := e.expire(ctx, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expire expires the open quantity of the order of the request with the
+// provided ID, and reports it to the order's trader. Unlike a cancel, the
+// fills allocated to the order are still executed once they're chosen,
+// such as the crosses of the closing auction cleared on the tick a day
+// order expires, and their quantity is canceled if they lapse.
+func (e *Exchange) expire(ctx context.Context, requestID uuid.UUID) error {
+	e.ordersLock.Lock()
+	o, ok := e.orders[requestID]
+	if !ok || o.expired || o.live() != "" {
+		e.ordersLock.Unlock()
+		return nil
+	}
+	o.matched = true
+	o.expired = true
+	o.quotes = nil
+	o.canceled += o.leaves - o.pending()
+	o.leaves = o.pending()
+	rep := o.report(trade.ExecExpired)
+	rep.Reason = "order expired"
+	e.prune(o)
+	r := o.request
+	pos := e.reserve()
+	e.ordersLock.Unlock()
+	if err := e.recordReserved(pos, MessageExpire, uuid.Nil, uuid.Nil, r.Item.ID, r); err != nil {
+		return err
+	}
+	return e.report(ctx, rep)
+}
+
+// requoteResting requotes every matched order that rests with quantity
+// that isn't allocated, and that was last routed for quotes at least the
+// exchange's requote ticks before the provided tick, except the orders
+// collected for auctions, which rest until the next auction of their
+// market.
+func (e *Exchange) requoteResting(ctx context.Context, tick uint64) error {
+	e.ordersLock.Lock()
+	var resting []uuid.UUID
+	for id, o := range e.orders {
+		if tick-o.quoted < e.RequoteTicks {
+			continue
+		}
+		if o.matched && !o.auction && o.rests() && o.leaves-o.pending() > quantityEpsilon {
+			resting = append(resting, id)
+		}
+	}
+	e.ordersLock.Unlock()
+	sort.Slice(resting, func(i, j int) bool { return resting[i].String() < resting[j].String() })
+	for _, id := range resting {
+		if err := e.requote(ctx, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// requote routes the order of the request with the provided ID to every
+// trader of its market for quotes again, discarding its previous quotes,
+// if it's matched and still live.
+func (e *Exchange) requote(ctx context.Context, requestID uuid.UUID) error {
+	e.ordersLock.Lock()
+	o, ok := e.orders[requestID]
+	if !ok || !o.matched || o.auction || o.leaves-o.pending() <= quantityEpsilon {
+		e.ordersLock.Unlock()
+		return nil
+	}
+	m := e.Markets[o.request.Item.ID]
+	o.matched = false
+	o.quoted = e.tick
+	o.quotes = nil
+	o.awaiting = make(map[uuid.UUID]bool, len(m.TraderByID))
+	alone := o.await(m)
+	r := o.request
+	pos := e.reserve()
+	e.ordersLock.Unlock()
+	if err := e.recordReserved(pos, MessageRequote, uuid.Nil, uuid.Nil, r.Item.ID, r); err != nil {
+		return err
+	}
+	return e.solicit(ctx, m, r, alone)
+}
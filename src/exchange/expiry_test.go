@@ -0,0 +1,144 @@
+package exchange
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+	"tradesim/src/time/clock"
+	"tradesim/src/trade"
+
+	"github.com/google/uuid"
+)
+
+// TestExpireGTT asserts that a good-till-time order rests once it's
+// matched, is quoted again on every tick, and expires after its ticks,
+// and that replaying it produces no divergence.
+func TestExpireGTT(t *testing.T) {
+	ctx := context.Background()
+	asks := [][2]float64{{1, 1}}
+	e, buyer, sellers, item := orderExchange(t, asks...)
+	clk := clock.NewClock(time.Second, 0)
+	e.Clock = &clk
+	var buf bytes.Buffer
+	e.Log = NewEventLog(&buf)
+
+	r := trade.Request{ID: uuid.New(), TraderID: buyer.ID, Item: item, Quantity: 3, Side: trade.SideBuy, Type: trade.OrderLimit, Price: 1, TimeInForce: trade.TimeInForceGTT, ExpireTicks: 2}
+	if err := e.routeRequest(ctx, r); err != nil {
+		t.Fatalf("route request: %v", err)
+	}
+	for tick := 1; tick <= 2; tick++ {
+		<-sellers[0].RequestRecv
+		quoteOrder(t, e, r, sellers, asks...)
+		if expected, actual := 1, chooseFills(t, e, buyer); expected != actual {
+			t.Fatalf("tick %d: fills: expected: %d actual: %d", tick, expected, actual)
+		}
+		if err := e.advance(ctx); err != nil {
+			t.Fatalf("advance: %v", err)
+		}
+	}
+	select {
+	case r := <-sellers[0].RequestRecv:
+		t.Errorf("expired order: expected: not requoted actual: %+v", r)
+	default:
+	}
+
+	reps := reports(buyer)
+	types := []trade.ExecType{trade.ExecNew, trade.ExecTrade, trade.ExecTrade, trade.ExecExpired}
+	if len(reps) != len(types) {
+		t.Fatalf("reports: expected: %d actual: %+v", len(types), reps)
+	}
+	for i, rep := range reps {
+		if rep.Type != types[i] {
+			t.Errorf("report %d: expected: %d actual: %+v", i, types[i], rep)
+		}
+	}
+	if rep := reps[3]; rep.Filled != 2 || rep.Canceled != 1 || rep.Leaves != 0 {
+		t.Errorf("expired: expected: filled 2 canceled 1 actual: %+v", rep)
+	}
+
+	events, err := ReadEvents(&buf)
+	if err != nil {
+		t.Fatalf("read events: %v", err)
+	}
+	replay, err := NewReplayExchange(events)
+	if err != nil {
+		t.Fatalf("replay exchange: %v", err)
+	}
+	divergences, err := replay.Replay(events)
+	if err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	if len(divergences) != 0 {
+		t.Errorf("divergences: expected: 0 actual: %v", divergences)
+	}
+}
+
+// TestExpireDay asserts that a day order expires at the close of the
+// session it was placed in, and that good-till-time and day orders are
+// rejected by an exchange without a clock or sessions.
+func TestExpireDay(t *testing.T) {
+	ctx := context.Background()
+	e, buyer, _, item := orderExchange(t)
+	r := trade.Request{ID: uuid.New(), TraderID: buyer.ID, Item: item, Quantity: 1, Side: trade.SideBuy, Type: trade.OrderMarket, TimeInForce: trade.TimeInForceDay}
+	if err := e.routeRequest(ctx, r); err != nil {
+		t.Fatalf("route request: %v", err)
+	}
+	if reps := reports(buyer); len(reps) != 1 || reps[0].Type != trade.ExecRejected {
+		t.Errorf("reports: expected: rejected actual: %+v", reps)
+	}
+
+	clk := clock.NewClock(time.Second, 0)
+	e.Clock = &clk
+	e.SessionTicks = 3
+	if err := e.advance(ctx); err != nil {
+		t.Fatalf("advance: %v", err)
+	}
+	r.ID = uuid.New()
+	if err := e.routeRequest(ctx, r); err != nil {
+		t.Fatalf("route request: %v", err)
+	}
+	for tick := 2; tick <= 3; tick++ {
+		if reps := reports(buyer); len(reps) != 0 && reps[0].Type == trade.ExecExpired {
+			t.Errorf("tick %d: expected: live actual: %+v", tick, reps)
+		}
+		if err := e.advance(ctx); err != nil {
+			t.Fatalf("advance: %v", err)
+		}
+	}
+	if reps := reports(buyer); len(reps) != 1 || reps[0].Type != trade.ExecExpired || reps[0].Canceled != 1 {
+		t.Errorf("reports: expected: expired actual: %+v", reps)
+	}
+}
+
+// TestRequoteTicks asserts that an order that rests is only quoted
+// again once the exchange's requote ticks have passed.
+func TestRequoteTicks(t *testing.T) {
+	ctx := context.Background()
+	e, buyer, sellers, item := orderExchange(t, [2]float64{3, 1})
+	clk := clock.NewClock(time.Second, 0)
+	e.Clock = &clk
+	e.RequoteTicks = 2
+
+	r := trade.Request{ID: uuid.New(), TraderID: buyer.ID, Item: item, Quantity: 1, Side: trade.SideBuy, Type: trade.OrderLimit, Price: 1, TimeInForce: trade.TimeInForceGTC}
+	if err := e.routeRequest(ctx, r); err != nil {
+		t.Fatalf("route request: %v", err)
+	}
+	<-sellers[0].RequestRecv
+	quoteOrder(t, e, r, sellers, [2]float64{3, 1})
+	for tick := 1; tick <= 4; tick++ {
+		if err := e.advance(ctx); err != nil {
+			t.Fatalf("advance: %v", err)
+		}
+		requoted := false
+		select {
+		case <-sellers[0].RequestRecv:
+			requoted = true
+			quoteOrder(t, e, r, sellers, [2]float64{3, 1})
+		default:
+		}
+		if expected := tick%2 == 0; requoted != expected {
+			t.Errorf("tick %d: requoted: expected: %t actual: %t", tick, expected, requoted)
+		}
+	}
+}
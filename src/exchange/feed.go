@@ -0,0 +1,135 @@
+package exchange
+
+import (
+	"sync"
+	"sync/atomic"
+	"tradesim/src/db"
+	"tradesim/src/trade"
+
+	"github.com/google/uuid"
+)
+
+// FeedEventType represents the type of event published to subscribers.
+type FeedEventType string
+
+const (
+	// FeedTrade is published for every executed transaction.
+	FeedTrade FeedEventType = "trade"
+	// FeedBlock is published for every block appended to the blockchain.
+	FeedBlock FeedEventType = "block"
+)
+
+// FeedEvent represents an event published to subscribers,
+// with either a transaction or a block depending on its type.
+type FeedEvent struct {
+	Type        FeedEventType      `json:"type"`
+	Transaction *trade.Transaction `json:"transaction,omitempty"`
+	Block       *db.BlockInfo      `json:"block,omitempty"`
+}
+
+// Filter represents the events a subscriber receives. A trade matches
+// if it's in the market of the item with the provided ID, and the trader
+// with the provided ID is either of its parties, where nil IDs match every
+// trade. A block matches if any of its transactions matches.
+type Filter struct {
+	ItemID   uuid.UUID
+	TraderID uuid.UUID
+}
+
+func (f Filter) matches(t *trade.Transaction) bool {
+	if f.ItemID != uuid.Nil && t.Credit.Item.ID != f.ItemID && t.Debit.Item.ID != f.ItemID {
+		return false
+	}
+	if f.TraderID != uuid.Nil && t.Credit.TraderID != f.TraderID && t.Debit.TraderID != f.TraderID {
+		return false
+	}
+	return true
+}
+
+// Subscription represents a subscriber's stream of published events.
+//
+// Events are queued in a buffer of a fixed size; events published while
+// the buffer is full are dropped, so that a slow subscriber never
+// blocks the exchange.
+type Subscription struct {
+	// dropped is first so that it's aligned for atomic access.
+	dropped uint64
+	event   chan FeedEvent
+	filter  Filter
+}
+
+// Events returns the channel of events published to the subscriber,
+// which is closed once the subscription is cancelled.
+func (s *Subscription) Events() <-chan FeedEvent {
+	return s.event
+}
+
+// Dropped returns the number of events dropped because
+// the subscriber's buffer was full.
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// feed publishes events to a set of subscribers.
+type feed struct {
+	// mu guards subs.
+	mu   sync.RWMutex
+	subs map[*Subscription]struct{}
+}
+
+// Subscribe returns a subscription to the events of the exchange
+// matching the provided filter, with a buffer of the provided size.
+func (e *Exchange) Subscribe(f Filter, buffer int) *Subscription {
+	s := &Subscription{
+		event:  make(chan FeedEvent, buffer),
+		filter: f,
+	}
+	e.feed.mu.Lock()
+	defer e.feed.mu.Unlock()
+	if e.feed.subs == nil {
+		e.feed.subs = make(map[*Subscription]struct{})
+	}
+	e.feed.subs[s] = struct{}{}
+	return s
+}
+
+// Unsubscribe cancels the provided subscription.
+func (e *Exchange) Unsubscribe(s *Subscription) {
+	e.feed.mu.Lock()
+	defer e.feed.mu.Unlock()
+	if _, ok := e.feed.subs[s]; ok {
+		delete(e.feed.subs, s)
+		close(s.event)
+	}
+}
+
+// publish publishes an executed transaction, and the provided block
+// it was appended in, to every matching subscriber.
+func (e *Exchange) publish(t *trade.Transaction, b db.BlockInfo) {
+	e.feed.mu.RLock()
+	defer e.feed.mu.RUnlock()
+	if len(e.feed.subs) == 0 {
+		return
+	}
+
+	for s := range e.feed.subs {
+		if s.filter.matches(t) {
+			s.send(FeedEvent{Type: FeedTrade, Transaction: t})
+		}
+		for i := range b.Transactions {
+			if s.filter.matches(&b.Transactions[i]) {
+				s.send(FeedEvent{Type: FeedBlock, Block: &b})
+				break
+			}
+		}
+	}
+}
+
+// send queues the provided event unless the buffer is full.
+func (s *Subscription) send(ev FeedEvent) {
+	select {
+	case s.event <- ev:
+	default:
+		atomic.AddUint64(&s.dropped, 1)
+	}
+}
@@ -0,0 +1,80 @@
+package exchange
+
+import (
+	"context"
+	"testing"
+	"tradesim/src/trade"
+
+	"github.com/google/uuid"
+)
+
+// choice returns a response of the provided seller to a request
+// of the provided buyer for the provided item.
+func choice(item trade.Item, buyer, seller uuid.UUID) trade.Response {
+	req := trade.Request{ID: uuid.New(), TraderID: buyer, Item: item, Quantity: 1, Side: trade.SideBuy}
+	resp := trade.Response{ID: uuid.New(), Request: req, TraderID: seller}
+	resp.OrderBook.Ask.Item = item
+	resp.OrderBook.Ask.Price = 1
+	resp.OrderBook.Ask.Quantity = 1
+	return resp
+}
+
+// TestSubscribeFilter asserts that subscribers only receive the trades
+// and blocks of their market and trader.
+func TestSubscribeFilter(t *testing.T) {
+	a, b := trade.NewItem("a"), trade.NewItem("b")
+	buyer, seller := uuid.New(), uuid.New()
+	e := NewExchange(nil)
+	all := e.Subscribe(Filter{}, 8)
+	market := e.Subscribe(Filter{ItemID: b.ID}, 8)
+	trader := e.Subscribe(Filter{TraderID: uuid.New()}, 8)
+
+	for _, c := range []trade.Response{choice(a, buyer, seller), choice(b, buyer, seller)} {
+		if err := e.execute(context.Background(), c); err != nil {
+			t.Fatalf("execute: %v", err)
+		}
+	}
+	e.Unsubscribe(all)
+	e.Unsubscribe(market)
+	e.Unsubscribe(trader)
+
+	counts := func(s *Subscription) map[FeedEventType]int {
+		n := make(map[FeedEventType]int)
+		var last uuid.UUID
+		for ev := range s.Events() {
+			n[ev.Type]++
+			if ev.Type == FeedTrade {
+				last = ev.Transaction.ID
+			} else if ev.Block.Height == 0 || ev.Block.Transactions[0].ID != last {
+				t.Errorf("block event: expected: block of trade %s actual: %+v", last, ev.Block)
+			}
+		}
+		return n
+	}
+	if n := counts(all); n[FeedTrade] != 2 || n[FeedBlock] != 2 {
+		t.Errorf("unfiltered events: expected: 2 trades and 2 blocks actual: %v", n)
+	}
+	if n := counts(market); n[FeedTrade] != 1 || n[FeedBlock] != 1 {
+		t.Errorf("market events: expected: 1 trade and 1 block actual: %v", n)
+	}
+	if n := counts(trader); len(n) != 0 {
+		t.Errorf("other trader events: expected: none actual: %v", n)
+	}
+}
+
+// TestSubscribeSlow asserts that events published to a subscriber
+// whose buffer is full are dropped without blocking execution.
+func TestSubscribeSlow(t *testing.T) {
+	item := trade.NewItem("a")
+	e := NewExchange(nil)
+	s := e.Subscribe(Filter{}, 1)
+
+	for i := 0; i < 3; i++ {
+		if err := e.execute(context.Background(), choice(item, uuid.New(), uuid.New())); err != nil {
+			t.Fatalf("execute: %v", err)
+		}
+	}
+	if expected, actual := uint64(5), s.Dropped(); expected != actual {
+		t.Errorf("dropped events: expected: %d actual: %d", expected, actual)
+	}
+}
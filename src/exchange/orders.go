@@ -0,0 +1,591 @@
+package exchange
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+	"tradesim/src/trade"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// DefaultQuoteWindow is the default duration
+	// for which the quotes of an order are collected.
+	DefaultQuoteWindow = 100 * time.Millisecond
+	// DefaultChooseWindow is the default duration within
+	// which the fills of an order must be chosen.
+	DefaultChooseWindow = time.Second
+	// DefaultRequoteTicks is the default number of ticks
+	// between the requotes of an order that rests.
+	DefaultRequoteTicks = 5
+	// quantityEpsilon is the quantity below which
+	// an order is considered filled or canceled.
+	quantityEpsilon = 1e-9
+)
+
+// orderNamespace derives the IDs of orders from the IDs of their
+// requests, so that replayed orders have their recorded IDs.
+var orderNamespace = uuid.MustParse("5b0f3c1e-8f4a-4d1b-9c6e-2a7d9e0b4f13")
+
+// order represents an order of a trader, whose quotes are collected
+// from the other traders of its market until it's matched.
+//
+// An order is matched once every other trader of its market has quoted
+// it, or once the exchange's quote window has elapsed, whichever is first.
+// Its quantity is then allocated to its quotes from the best price, which
+// is the lowest ask for a buy order and the highest bid for a sell order,
+// and the fills are delivered to its trader, which executes them by
+// choosing them within the exchange's choose window. The quantity that
+// isn't allocated, or whose fills lapse, is canceled, unless the order's
+// time in force rests, in which case it's quoted again every requote
+// ticks of the exchange's clock. Once an order isn't live and none of its
+// fills are pending, it's removed from the exchange.
+type order struct {
+	id      uuid.UUID
+	request trade.Request
+	// awaiting are the IDs of the traders whose quotes are awaited,
+	// and quotes are the quotes received, in the order they arrived.
+	awaiting map[uuid.UUID]bool
+	quotes   trade.Responses
+	matched  bool
+	// allocated are the fill quantities allocated to the order
+	// that haven't been chosen, by quote ID, and executing is the
+	// quantity of the chosen fills whose blocks are being mined.
+	allocated map[uuid.UUID]float64
+	executing float64
+	filled    float64
+	leaves    float64
+	canceled  float64
+	// expires is the tick of the exchange's clock on which the
+	// order expires, or 0 if it doesn't expire, and expired is
+	// whether it has expired, after which it no longer rests.
+	expires uint64
+	expired bool
+	// quoted is the tick of the exchange's clock on
+	// which the order was last routed for quotes.
+	quoted uint64
+	// auction is whether the order is collected
+	// for the call auctions of its market.
+	auction bool
+}
+
+// newOrder returns an order of the provided request.
+func newOrder(r trade.Request) *order {
+	return &order{
+		id:        uuid.NewSHA1(orderNamespace, r.ID[:]),
+		request:   r,
+		awaiting:  make(map[uuid.UUID]bool),
+		allocated: make(map[uuid.UUID]float64),
+		leaves:    r.Quantity,
+	}
+}
+
+// rests returns whether the order's quantity that isn't allocated stays
+// live, which it does if its time in force rests until it expires.
+func (o *order) rests() bool {
+	return o.request.TimeInForce.Rests() && !o.expired
+}
+
+// pending returns the quantity allocated to the
+// order that hasn't been executed.
+func (o *order) pending() float64 {
+	pending := o.executing
+	for _, fill := range o.allocated {
+		pending += fill
+	}
+	return pending
+}
+
+// report returns an execution report of the order of the provided type.
+func (o *order) report(execType trade.ExecType) trade.ExecutionReport {
+	return trade.ExecutionReport{
+		OrderID:   o.id,
+		RequestID: o.request.ID,
+		TraderID:  o.request.TraderID,
+		Item:      o.request.Item,
+		Type:      execType,
+		Quantity:  o.request.Quantity,
+		Filled:    o.filled,
+		Leaves:    o.leaves,
+		Canceled:  o.canceled,
+	}
+}
+
+// allocate allocates the provided quantity of the order to its quotes
+// from the best price, in the order they arrived at equal prices, and
+// returns the responses with their fills. A limit order is only allocated
+// quotes whose price it accepts, and a fill-or-kill order is allocated all
+// of the quantity or none of it.
+func (o *order) allocate(quantity float64) trade.Responses {
+	quotes := append(trade.Responses{}, o.quotes...)
+	sell := o.request.Side == trade.SideSell
+	sort.SliceStable(quotes, func(i, j int) bool {
+		if sell {
+			return quotes[i].Quote().Price > quotes[j].Quote().Price
+		}
+		return quotes[i].Quote().Price < quotes[j].Quote().Price
+	})
+	var fills trade.Responses
+	leaves := quantity
+	for _, q := range quotes {
+		quote := q.Quote()
+		if leaves <= quantityEpsilon || !o.request.Accepts(quote.Price) {
+			break
+		}
+		q.Request = o.request
+		q.Fill = math.Min(quote.Quantity, leaves)
+		leaves -= q.Fill
+		fills = append(fills, q)
+	}
+	if o.request.TimeInForce == trade.TimeInForceFOK && leaves > quantityEpsilon {
+		return nil
+	}
+	return fills
+}
+
+// validate returns why the provided order request is rejected, if it is.
+func validate(r trade.Request) string {
+	switch {
+	case r.Side != trade.SideBuy && r.Side != trade.SideSell:
+		return fmt.Sprintf("unsupported side: %d", r.Side)
+	case r.Quantity <= 0 || math.IsNaN(r.Quantity) || math.IsInf(r.Quantity, 0):
+		return "quantity must be positive"
+	case r.Type != trade.OrderMarket && r.Type != trade.OrderLimit:
+		return fmt.Sprintf("unsupported order type: %d", r.Type)
+	case r.Type == trade.OrderLimit && (r.Price <= 0 || math.IsNaN(r.Price) || math.IsInf(r.Price, 0)):
+		return "limit orders must have a positive price"
+	case r.TimeInForce == trade.TimeInForceUnset:
+		return "orders must have a time in force"
+	case r.TimeInForce != trade.TimeInForceIOC && r.TimeInForce != trade.TimeInForceFOK && !r.TimeInForce.Rests():
+		return fmt.Sprintf("unsupported time in force: %d", r.TimeInForce)
+	case r.TimeInForce == trade.TimeInForceGTT && r.ExpireTicks == 0:
+		return "good-till-time orders must expire after a positive number of ticks"
+	}
+	return ""
+}
+
+// accept accepts the provided order request, reporting it to its trader,
+// and routes it to every trader of its market for quotes. An invalid
+// order is rejected, and isn't routed. Good-till-time and day orders are
+// rejected by an exchange without a clock or sessions to expire them,
+// unless it's replaying, where orders only expire by their recorded
+// expiries.
+func (e *Exchange) accept(ctx context.Context, m Market, r trade.Request) error {
+	o := newOrder(r)
+	e.ordersLock.Lock()
+	reason := e.register(o, validate(r))
+	alone := true
+	if reason == "" {
+		alone = o.await(m)
+	}
+	e.ordersLock.Unlock()
+
+	if reason != "" {
+		o.leaves = 0
+		rep := o.report(trade.ExecRejected)
+		rep.Reason = reason
+		return e.report(ctx, rep)
+	}
+	if err := e.report(ctx, o.report(trade.ExecNew)); err != nil {
+		return err
+	}
+	return e.solicit(ctx, m, r, alone)
+}
+
+// register registers the provided order, unless the provided reason it's
+// rejected for isn't empty, and returns why it's rejected, if it is. The
+// request IDs of orders that were removed once they finished aren't
+// checked for duplicates. The orders lock must be held.
+func (e *Exchange) register(o *order, reason string) string {
+	r := o.request
+	if _, dup := e.orders[r.ID]; dup && reason == "" {
+		reason = "duplicate request ID"
+	}
+	if reason == "" && !e.replaying {
+		switch r.TimeInForce {
+		case trade.TimeInForceGTT:
+			if e.Clock == nil {
+				reason = "good-till-time orders require the exchange's clock"
+			} else {
+				o.expires = e.tick + r.ExpireTicks
+			}
+		case trade.TimeInForceDay:
+			if e.Clock == nil || e.SessionTicks == 0 {
+				reason = "day orders require the exchange's sessions"
+			} else {
+				o.expires = (e.tick/e.SessionTicks + 1) * e.SessionTicks
+			}
+		}
+	}
+	if reason == "" {
+		o.quoted = e.tick
+		e.orders[r.ID] = o
+		e.byOrderID[o.id] = o
+	}
+	return reason
+}
+
+// await awaits the quotes of every other trader of the provided market,
+// and returns whether the order's trader is alone in it. The orders lock
+// must be held.
+func (o *order) await(m Market) bool {
+	alone := true
+	for id := range m.TraderByID {
+		if id != o.request.TraderID {
+			o.awaiting[id] = true
+			alone = false
+		}
+	}
+	return alone
+}
+
+// solicit routes the provided order request to every trader of the
+// provided market for quotes, and matches it once its quote window
+// elapses in simulated time, or right away if its trader is alone in
+// the market.
+func (e *Exchange) solicit(ctx context.Context, m Market, r trade.Request, alone bool) error {
+	if err := e.deliverRequest(ctx, m, r); err != nil {
+		return err
+	}
+	if alone && !e.replaying {
+		return e.match(ctx, r.ID)
+	}
+	if e.QuoteWindow > 0 && !e.replaying {
+		e.schedule(timer{at: e.Now().Add(e.QuoteWindow), requestID: r.ID})
+	}
+	return nil
+}
+
+// quote collects the provided response as a quote of the order of its
+// request, and matches the order once every awaited trader has quoted.
+// Quotes of the order's own trader, without a quote of the order's side,
+// which is an ask for a buy order and a bid for a sell order, or that
+// arrive once the order is matched, are discarded, and a response that
+// signs a cross of an auction is delivered to the cross's buyer.
+func (e *Exchange) quote(ctx context.Context, resp trade.Response) error {
+	if err := e.record(MessageResponse, resp.TraderID, uuid.Nil, resp.Request.Item.ID, resp); err != nil {
+		return err
+	}
+	e.ordersLock.Lock()
+	if c, ok := e.crosses[resp.ID]; ok {
+		e.ordersLock.Unlock()
+		return e.sign(ctx, c, resp)
+	}
+	o, ok := e.orders[resp.Request.ID]
+	if !ok || o.matched || !o.awaiting[resp.TraderID] {
+		e.ordersLock.Unlock()
+		return nil
+	}
+	delete(o.awaiting, resp.TraderID)
+	resp.Request = o.request
+	if q := resp.Quote(); q.Quantity > 0 && q.Price > 0 && q.Item.ID == o.request.Item.ID {
+		o.quotes = append(o.quotes, resp)
+	}
+	done := len(o.awaiting) == 0
+	e.ordersLock.Unlock()
+	if done && !e.replaying {
+		return e.match(ctx, resp.Request.ID)
+	}
+	return nil
+}
+
+// match matches the order of the request with the provided ID, unless
+// it's already matched, delivering the fills allocated to it to its
+// trader and canceling its unallocated quantity, unless it rests.
+func (e *Exchange) match(ctx context.Context, requestID uuid.UUID) error {
+	e.ordersLock.Lock()
+	o, ok := e.orders[requestID]
+	if !ok || o.matched {
+		e.ordersLock.Unlock()
+		return nil
+	}
+	o.matched = true
+	open := o.leaves - o.pending()
+	fills := o.allocate(open)
+	allocated := 0.0
+	for _, f := range fills {
+		o.allocated[f.ID] = f.Fill
+		allocated += f.Fill
+	}
+	canceled := 0.0
+	if !o.rests() {
+		canceled = open - allocated
+		o.leaves -= canceled
+		o.canceled += canceled
+	}
+	r := o.request
+	rep := o.report(trade.ExecCanceled)
+	if len(fills) == 0 {
+		rep.Reason = "no quotes fill the order"
+	} else {
+		rep.Reason = "unfilled quantity canceled"
+	}
+	e.prune(o)
+	// The match is reserved while the orders are locked, so that it's
+	// recorded in the same order as the cancels and replaces of the order.
+	pos := e.reserve()
+	e.ordersLock.Unlock()
+	if err := e.recordReserved(pos, MessageMatch, uuid.Nil, uuid.Nil, r.Item.ID, r); err != nil {
+		return err
+	}
+	if len(fills) > 0 {
+		if err := e.routeResponses(ctx, uuid.Nil, fills); err != nil {
+			return err
+		}
+		e.awaitChoices(r, fills)
+	}
+	if canceled > quantityEpsilon {
+		return e.report(ctx, rep)
+	}
+	return nil
+}
+
+// Lapse represents the fills allocated to the order of a request,
+// by quote ID, whose choose window elapsed.
+type Lapse struct {
+	Request  trade.Request
+	QuoteIDs []uuid.UUID
+}
+
+// awaitChoices lapses the provided fills of the order of the provided
+// request that aren't chosen once the exchange's choose window elapses
+// in simulated time.
+func (e *Exchange) awaitChoices(r trade.Request, fills trade.Responses) {
+	if e.ChooseWindow <= 0 || e.replaying {
+		return
+	}
+	l := Lapse{Request: r, QuoteIDs: make([]uuid.UUID, len(fills))}
+	for i, f := range fills {
+		l.QuoteIDs[i] = f.ID
+	}
+	e.schedule(timer{at: e.Now().Add(e.ChooseWindow), lapse: &l})
+}
+
+// lapse lapses the fills of the provided lapse that are still allocated,
+// so that they aren't executed once they're chosen, along with the fills
+// of the sell orders of the crosses among them, whose sellers didn't sign
+// them or whose buyers didn't choose them. The lapsed quantity of an order
+// that rests is open to be quoted or crossed again, and is canceled and
+// reported to its trader otherwise. A lapse is only recorded if any of its
+// fills lapsed.
+func (e *Exchange) lapse(ctx context.Context, l Lapse) error {
+	e.ordersLock.Lock()
+	var u unallocation
+	o, ok := e.orders[l.Request.ID]
+	for _, id := range l.QuoteIDs {
+		if ok {
+			u.unallocate(o, id)
+		}
+		// A claimed cross is no longer allocated to its sell
+		// order, and is kept until it's filled or released.
+		if c, ok := e.crosses[id]; ok {
+			if _, allocated := c.sell.allocated[id]; allocated {
+				u.unallocate(c.sell, id)
+				delete(e.crosses, id)
+			}
+		}
+	}
+	if len(u.orders) == 0 {
+		e.ordersLock.Unlock()
+		return nil
+	}
+	reps := e.settle(u, "fills not chosen in time canceled")
+	pos := e.reserve()
+	e.ordersLock.Unlock()
+	if err := e.recordReserved(pos, MessageLapse, uuid.Nil, uuid.Nil, l.Request.Item.ID, l); err != nil {
+		return err
+	}
+	for _, rep := range reps {
+		if err := e.report(ctx, rep); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unallocation represents the fills unallocated from orders,
+// by order, in the order they were first unallocated from.
+type unallocation struct {
+	orders     []*order
+	quantities map[*order]float64
+}
+
+// unallocate unallocates the fill of the quote with the provided ID
+// from the provided order, if it's allocated to it.
+func (u *unallocation) unallocate(o *order, quoteID uuid.UUID) {
+	fill, ok := o.allocated[quoteID]
+	if !ok {
+		return
+	}
+	delete(o.allocated, quoteID)
+	if u.quantities == nil {
+		u.quantities = make(map[*order]float64)
+	}
+	if _, ok := u.quantities[o]; !ok {
+		u.orders = append(u.orders, o)
+	}
+	u.quantities[o] += fill
+}
+
+// settle cancels the unallocated quantity of every order of the provided
+// unallocation that doesn't rest for the provided reason, leaving it open
+// to be quoted or crossed again otherwise, and returns the execution
+// reports of the cancellations. The orders lock must be held.
+func (e *Exchange) settle(u unallocation, reason string) []trade.ExecutionReport {
+	var reps []trade.ExecutionReport
+	for _, o := range u.orders {
+		if !o.rests() {
+			o.leaves -= u.quantities[o]
+			o.canceled += u.quantities[o]
+			rep := o.report(trade.ExecCanceled)
+			rep.Reason = reason
+			reps = append(reps, rep)
+		}
+		e.prune(o)
+	}
+	return reps
+}
+
+// uncross unallocates the crosses allocated to the provided order that
+// haven't been claimed from its counterparties, whose unallocated quantity
+// is settled, and removes them, returning the execution reports of their
+// cancellations. The orders lock must be held.
+func (e *Exchange) uncross(o *order) []trade.ExecutionReport {
+	ids := make([]uuid.UUID, 0, len(o.allocated))
+	for id := range o.allocated {
+		ids = append(ids, id)
+	}
+	// Crosses are uncrossed in the order of their quote IDs, so that
+	// the cancellations are reported in a reproducible order.
+	sort.Slice(ids, func(i, j int) bool { return ids[i].String() < ids[j].String() })
+	var u unallocation
+	for _, id := range ids {
+		c, ok := e.crosses[id]
+		if !ok {
+			continue
+		}
+		delete(e.crosses, id)
+		if c.sell != o {
+			u.unallocate(c.sell, id)
+		} else if buy, ok := e.orders[c.resp.Request.ID]; ok {
+			u.unallocate(buy, id)
+		}
+	}
+	return e.settle(u, "cross canceled by counterparty")
+}
+
+// claim claims the fill of the provided transaction for execution, if
+// it's allocated to the order of the provided request, if the request is
+// an order, and to the sell order of its cross, if it's one, and returns
+// whether it's claimed. A claimed fill is no longer allocated, so it isn't
+// claimed twice, and its quantity is executing until it's filled or
+// released. The fills of an order that isn't live aren't claimed.
+func (e *Exchange) claim(r trade.Request, t *trade.Transaction) bool {
+	e.ordersLock.Lock()
+	defer e.ordersLock.Unlock()
+	if _, ok := e.orders[r.ID]; !ok && r.IsOrder() {
+		return false
+	}
+	claimed := e.claimed(r.ID, t)
+	for _, o := range claimed {
+		fill, ok := o.allocated[t.QuoteID]
+		if !ok || t.Credit.Quantity > fill+quantityEpsilon {
+			return false
+		}
+	}
+	for _, o := range claimed {
+		delete(o.allocated, t.QuoteID)
+		o.executing += t.Credit.Quantity
+	}
+	return true
+}
+
+// release releases the claimed fill of the provided transaction, which
+// wasn't executed, so that its quantity is no longer executing.
+func (e *Exchange) release(requestID uuid.UUID, t *trade.Transaction) {
+	e.ordersLock.Lock()
+	defer e.ordersLock.Unlock()
+	for _, o := range e.claimed(requestID, t) {
+		o.executing = math.Max(o.executing-t.Credit.Quantity, 0)
+		e.prune(o)
+	}
+	delete(e.crosses, t.QuoteID)
+}
+
+// claimed returns the order of the request with the provided ID, if the
+// request is an order, and the sell order of the provided transaction's
+// cross, if it's one. The orders lock must be held.
+func (e *Exchange) claimed(requestID uuid.UUID, t *trade.Transaction) []*order {
+	o, ok := e.orders[requestID]
+	if !ok {
+		return nil
+	}
+	orders := []*order{o}
+	if c, ok := e.crosses[t.QuoteID]; ok {
+		orders = append(orders, c.sell)
+	}
+	return orders
+}
+
+// fill records the provided executed transaction as a fill of the order
+// of the request with the provided ID, if the request is an order, and of
+// the sell order of its cross, if it's one, and returns the execution
+// reports of the fills to the orders' traders. The transaction's price is
+// the reference price of the next auction of its market.
+func (e *Exchange) fill(requestID uuid.UUID, t *trade.Transaction) []trade.ExecutionReport {
+	e.ordersLock.Lock()
+	e.reference[t.Credit.Item.ID] = t.Credit.Price
+	filled := e.claimed(requestID, t)
+	delete(e.crosses, t.QuoteID)
+	reps := make([]trade.ExecutionReport, 0, len(filled))
+	for _, o := range filled {
+		o.executing = math.Max(o.executing-t.Credit.Quantity, 0)
+		o.filled += t.Credit.Quantity
+		o.leaves = math.Max(o.leaves-t.Credit.Quantity, 0)
+		rep := o.report(trade.ExecTrade)
+		rep.LastPrice = t.Credit.Price
+		rep.LastQuantity = t.Credit.Quantity
+		reps = append(reps, rep)
+		e.prune(o)
+	}
+	e.ordersLock.Unlock()
+	return reps
+}
+
+// prune removes the provided order from the exchange once it isn't live
+// and none of its fills are pending, so that finished orders don't
+// accumulate. The orders lock must be held.
+func (e *Exchange) prune(o *order) {
+	if o.leaves > quantityEpsilon || o.pending() > quantityEpsilon {
+		return
+	}
+	delete(e.orders, o.request.ID)
+	delete(e.byOrderID, o.id)
+}
+
+// report records and traces the provided execution report, and delivers
+// it to the trader of its order. The orders lock must not be held.
+func (e *Exchange) report(ctx context.Context, rep trade.ExecutionReport) error {
+	e.ordersLock.Lock()
+	e.stats.count(rep)
+	e.ordersLock.Unlock()
+	if err := e.record(MessageReport, uuid.Nil, rep.TraderID, rep.Item.ID, rep); err != nil {
+		return err
+	}
+	if err := e.trace(rep); err != nil {
+		return err
+	}
+	t, ok := e.Trader(rep.TraderID)
+	if !ok {
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case t.ReportRecv <- rep:
+	}
+	return nil
+}
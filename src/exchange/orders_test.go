@@ -0,0 +1,378 @@
+package exchange
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+	"tradesim/src/trade"
+
+	"github.com/google/uuid"
+)
+
+// newTrader returns a trader with the provided haves and wants.
+func newTrader(t *testing.T, haves []trade.Have, wants []trade.Want) *trade.Trader {
+	trader, err := trade.NewTrader(haves, wants, nil)
+	if err != nil {
+		t.Fatalf("new trader: %v", err)
+	}
+	return trader
+}
+
+// orderExchange returns an exchange of one market with a buyer and
+// a seller for every provided ask price and quantity pair, which
+// collects the quotes of orders until every seller has quoted them.
+func orderExchange(t *testing.T, asks ...[2]float64) (*Exchange, *trade.Trader, []*trade.Trader, trade.Item) {
+	item := trade.NewItem("a")
+	buyer := newTrader(t, nil, []trade.Want{{Item: item, PriceMax: 2, Quantity: 1}})
+	traders := []*trade.Trader{buyer}
+	var sellers []*trade.Trader
+	for _, a := range asks {
+		s := newTrader(t, []trade.Have{{Item: item, Price: a[0], Quantity: a[1]}}, nil)
+		sellers = append(sellers, s)
+		traders = append(traders, s)
+	}
+	e := NewExchange([]Market{NewMarket(item, traders...)})
+	e.QuoteWindow = 0
+	e.ChooseWindow = 0
+	e.RequoteTicks = 0
+	return e, buyer, sellers, item
+}
+
+// quoteOrder routes the quote of every provided seller of the provided
+// ask price and quantity pairs to the provided order.
+func quoteOrder(t *testing.T, e *Exchange, r trade.Request, sellers []*trade.Trader, asks ...[2]float64) {
+	for i, s := range sellers {
+		resp := trade.Response{ID: uuid.New(), Request: r, TraderID: s.ID}
+		resp.OrderBook.Ask.Item = r.Item
+		resp.OrderBook.Ask.Price = asks[i][0]
+		resp.OrderBook.Ask.Quantity = asks[i][1]
+		s.SignQuote(&resp)
+		if err := e.routeResponse(context.Background(), resp); err != nil {
+			t.Fatalf("route response: %v", err)
+		}
+	}
+}
+
+// chooseFills chooses every fill delivered to the provided buyer,
+// and returns the number of fills.
+func chooseFills(t *testing.T, e *Exchange, buyer *trade.Trader) int {
+	n := 0
+	for {
+		select {
+		case fills := <-buyer.ResponseRecv:
+			for _, f := range fills {
+				buyer.SignChoice(&f)
+				if err := e.routeChoice(context.Background(), buyer.ID, f); err != nil {
+					t.Fatalf("route choice: %v", err)
+				}
+				n++
+			}
+		default:
+			return n
+		}
+	}
+}
+
+// reports returns the execution reports delivered to the provided trader.
+func reports(t *trade.Trader) []trade.ExecutionReport {
+	var reps []trade.ExecutionReport
+	for {
+		select {
+		case rep := <-t.ReportRecv:
+			reps = append(reps, rep)
+		default:
+			return reps
+		}
+	}
+}
+
+// TestOrderMarket asserts that a market order is filled from the lowest
+// ask prices, that its unfilled quantity is canceled, and that every
+// fill is reported to its trader.
+func TestOrderMarket(t *testing.T) {
+	asks := [][2]float64{{2, 2}, {1, 1}, {3, 1}}
+	e, buyer, sellers, item := orderExchange(t, asks...)
+	r := trade.Request{ID: uuid.New(), TraderID: buyer.ID, Item: item, Quantity: 5, Side: trade.SideBuy, Type: trade.OrderMarket, TimeInForce: trade.TimeInForceIOC}
+	if err := e.routeRequest(context.Background(), r); err != nil {
+		t.Fatalf("route request: %v", err)
+	}
+	quoteOrder(t, e, r, sellers, asks...)
+	if expected, actual := 3, chooseFills(t, e, buyer); expected != actual {
+		t.Fatalf("fills: expected: %d actual: %d", expected, actual)
+	}
+
+	reps := reports(buyer)
+	if len(reps) != 5 {
+		t.Fatalf("reports: expected: 5 actual: %+v", reps)
+	}
+	if rep := reps[0]; rep.Type != trade.ExecNew || rep.Leaves != 5 || rep.RequestID != r.ID {
+		t.Errorf("new: expected: leaves 5 actual: %+v", rep)
+	}
+	if rep := reps[1]; rep.Type != trade.ExecCanceled || rep.Leaves != 4 || rep.Canceled != 1 {
+		t.Errorf("canceled: expected: leaves 4 canceled 1 actual: %+v", rep)
+	}
+	prices := []float64{1, 2, 3}
+	for i, rep := range reps[2:] {
+		if rep.Type != trade.ExecTrade || rep.LastPrice != prices[i] || rep.OrderID != reps[0].OrderID {
+			t.Errorf("trade %d: expected: price %v actual: %+v", i, prices[i], rep)
+		}
+	}
+	if rep := reps[4]; rep.Filled != 4 || rep.Leaves != 0 {
+		t.Errorf("last trade: expected: filled 4 leaves 0 actual: %+v", rep)
+	}
+	if expected, actual := 4, e.DB.Len(); expected != actual {
+		t.Errorf("blockchain length: expected: %d actual: %d", expected, actual)
+	}
+}
+
+// TestOrderLimit asserts that a limit order is only filled at or below
+// its price, and that a choice of a quote that isn't allocated to an
+// order isn't executed.
+func TestOrderLimit(t *testing.T) {
+	asks := [][2]float64{{2, 2}, {1, 1}}
+	e, buyer, sellers, item := orderExchange(t, asks...)
+	r := trade.Request{ID: uuid.New(), TraderID: buyer.ID, Item: item, Quantity: 3, Side: trade.SideBuy, Type: trade.OrderLimit, Price: 1.5, TimeInForce: trade.TimeInForceIOC}
+	if err := e.routeRequest(context.Background(), r); err != nil {
+		t.Fatalf("route request: %v", err)
+	}
+	quoteOrder(t, e, r, sellers, asks...)
+	if expected, actual := 1, chooseFills(t, e, buyer); expected != actual {
+		t.Fatalf("fills: expected: %d actual: %d", expected, actual)
+	}
+
+	// The quote above the limit price isn't executed if it's chosen.
+	resp := trade.Response{ID: uuid.New(), Request: r, TraderID: sellers[0].ID}
+	resp.OrderBook.Ask.Item = item
+	resp.OrderBook.Ask.Price = 2
+	resp.OrderBook.Ask.Quantity = 2
+	sellers[0].SignQuote(&resp)
+	buyer.SignChoice(&resp)
+	if err := e.routeChoice(context.Background(), buyer.ID, resp); err != nil {
+		t.Fatalf("route choice: %v", err)
+	}
+	if expected, actual := 2, e.DB.Len(); expected != actual {
+		t.Errorf("blockchain length: expected: %d actual: %d", expected, actual)
+	}
+	reps := reports(buyer)
+	if last := reps[len(reps)-1]; last.Type != trade.ExecTrade || last.Filled != 1 || last.Canceled != 2 || last.Leaves != 0 {
+		t.Errorf("last report: expected: filled 1 canceled 2 actual: %+v", last)
+	}
+}
+
+// TestOrderFOK asserts that a fill-or-kill order that can't be filled
+// in full isn't filled, and that an invalid order is rejected without
+// being routed.
+func TestOrderFOK(t *testing.T) {
+	asks := [][2]float64{{1, 1}}
+	e, buyer, sellers, item := orderExchange(t, asks...)
+	r := trade.Request{ID: uuid.New(), TraderID: buyer.ID, Item: item, Quantity: 2, Side: trade.SideBuy, Type: trade.OrderMarket, TimeInForce: trade.TimeInForceFOK}
+	if err := e.routeRequest(context.Background(), r); err != nil {
+		t.Fatalf("route request: %v", err)
+	}
+	quoteOrder(t, e, r, sellers, asks...)
+	if expected, actual := 0, chooseFills(t, e, buyer); expected != actual {
+		t.Errorf("fills: expected: %d actual: %d", expected, actual)
+	}
+	reps := reports(buyer)
+	if len(reps) != 2 || reps[1].Type != trade.ExecCanceled || reps[1].Canceled != 2 {
+		t.Errorf("reports: expected: new and canceled 2 actual: %+v", reps)
+	}
+
+	<-sellers[0].RequestRecv
+	invalid := trade.Request{ID: uuid.New(), TraderID: buyer.ID, Item: item, Quantity: 1, Side: trade.SideBuy, Type: trade.OrderLimit, TimeInForce: trade.TimeInForceIOC}
+	if err := e.routeRequest(context.Background(), invalid); err != nil {
+		t.Fatalf("route request: %v", err)
+	}
+	if reps := reports(buyer); len(reps) != 1 || reps[0].Type != trade.ExecRejected || reps[0].Reason == "" {
+		t.Errorf("reports: expected: rejected actual: %+v", reps)
+	}
+	select {
+	case r := <-sellers[0].RequestRecv:
+		t.Errorf("rejected order: expected: not routed actual: %+v", r)
+	default:
+	}
+}
+
+// TestOrderSell asserts that a limit sell order is filled from the highest
+// bid prices at or above its price, executing its trader's sales, and that
+// an order without a time in force is rejected.
+func TestOrderSell(t *testing.T) {
+	bids := [][2]float64{{1, 1}, {3, 1}, {2, 2}}
+	e, seller, buyers, item := orderExchange(t, bids...)
+	r := trade.Request{ID: uuid.New(), TraderID: seller.ID, Item: item, Quantity: 3, Side: trade.SideSell, Type: trade.OrderLimit, Price: 1.5, TimeInForce: trade.TimeInForceIOC}
+	if err := e.routeRequest(context.Background(), r); err != nil {
+		t.Fatalf("route request: %v", err)
+	}
+	for i, b := range buyers {
+		resp := trade.Response{ID: uuid.New(), Request: r, TraderID: b.ID}
+		resp.OrderBook.Bid.Item = item
+		resp.OrderBook.Bid.Price = bids[i][0]
+		resp.OrderBook.Bid.Quantity = bids[i][1]
+		b.SignQuote(&resp)
+		if err := e.routeResponse(context.Background(), resp); err != nil {
+			t.Fatalf("route response: %v", err)
+		}
+	}
+	if expected, actual := 2, chooseFills(t, e, seller); expected != actual {
+		t.Fatalf("fills: expected: %d actual: %d", expected, actual)
+	}
+	reps := reports(seller)
+	prices := []float64{3, 2}
+	for i, rep := range reps[len(reps)-2:] {
+		if rep.Type != trade.ExecTrade || rep.LastPrice != prices[i] {
+			t.Errorf("trade %d: expected: price %v actual: %+v", i, prices[i], rep)
+		}
+	}
+	if last := reps[len(reps)-1]; last.Filled != 3 || last.Leaves != 0 {
+		t.Errorf("last trade: expected: filled 3 leaves 0 actual: %+v", last)
+	}
+	if expected, actual := 3, e.DB.Len(); expected != actual {
+		t.Fatalf("blockchain length: expected: %d actual: %d", expected, actual)
+	}
+	for it := e.DB.Forward(); it.Next(); {
+		for _, txn := range it.Block().Transactions {
+			if txn.Debit.TraderID != seller.ID {
+				t.Errorf("transaction: expected: debit %s actual: %+v", seller.ID, txn)
+			}
+		}
+	}
+
+	unset := trade.Request{ID: uuid.New(), TraderID: seller.ID, Item: item, Quantity: 1, Side: trade.SideSell, Type: trade.OrderMarket}
+	if err := e.routeRequest(context.Background(), unset); err != nil {
+		t.Fatalf("route request: %v", err)
+	}
+	if reps := reports(seller); len(reps) != 1 || reps[0].Type != trade.ExecRejected {
+		t.Errorf("reports: expected: rejected actual: %+v", reps)
+	}
+}
+
+// elapseTimer elapses the first timer of the provided exchange,
+// which must elapse at the provided time.
+func elapseTimer(t *testing.T, e *Exchange, at time.Time) {
+	e.ordersLock.Lock()
+	if len(e.timers) == 0 {
+		e.ordersLock.Unlock()
+		t.Fatalf("timers: expected: 1 actual: 0")
+	}
+	tm := e.timers[0]
+	e.timers = e.timers[1:]
+	e.ordersLock.Unlock()
+	if !tm.at.Equal(at) {
+		t.Errorf("timer: expected: %v actual: %v", at, tm.at)
+	}
+	if err := e.elapse(context.Background(), tm); err != nil {
+		t.Fatalf("elapse: %v", err)
+	}
+}
+
+// TestOrderQuoteWindow asserts that an order is matched once its
+// quote window elapses, without the quotes of every trader.
+func TestOrderQuoteWindow(t *testing.T) {
+	e, buyer, _, item := orderExchange(t, [2]float64{1, 1})
+	e.QuoteWindow = time.Second
+	now := time.Date(2024, 1, 2, 9, 30, 0, 0, time.UTC)
+	e.Now = func() time.Time { return now }
+
+	r := trade.Request{ID: uuid.New(), TraderID: buyer.ID, Item: item, Quantity: 1, Side: trade.SideBuy, Type: trade.OrderMarket, TimeInForce: trade.TimeInForceIOC}
+	if err := e.routeRequest(context.Background(), r); err != nil {
+		t.Fatalf("route request: %v", err)
+	}
+	if reps := reports(buyer); len(reps) != 1 || reps[0].Type != trade.ExecNew {
+		t.Fatalf("reports: expected: new actual: %+v", reps)
+	}
+	elapseTimer(t, e, now.Add(e.QuoteWindow))
+	if reps := reports(buyer); len(reps) != 1 || reps[0].Type != trade.ExecCanceled || reps[0].Canceled != 1 {
+		t.Errorf("reports: expected: canceled 1 actual: %+v", reps)
+	}
+}
+
+// TestOrderLapse asserts that the fills of an order that aren't chosen
+// within the choose window lapse, canceling their quantity, that a lapsed
+// fill isn't executed once it's chosen, that the finished order is removed
+// from the exchange, and that the lapse is replayed.
+func TestOrderLapse(t *testing.T) {
+	asks := [][2]float64{{1, 1}}
+	e, buyer, sellers, item := orderExchange(t, asks...)
+	e.ChooseWindow = time.Second
+	now := time.Date(2024, 1, 2, 9, 30, 0, 0, time.UTC)
+	e.Now = func() time.Time { return now }
+	var buf bytes.Buffer
+	e.Log = NewEventLog(&buf)
+	ctx := context.Background()
+
+	r := trade.Request{ID: uuid.New(), TraderID: buyer.ID, Item: item, Quantity: 1, Side: trade.SideBuy, Type: trade.OrderMarket, TimeInForce: trade.TimeInForceIOC}
+	if err := e.routeRequest(ctx, r); err != nil {
+		t.Fatalf("route request: %v", err)
+	}
+	quoteOrder(t, e, r, sellers, asks...)
+	fills := <-buyer.ResponseRecv
+	<-buyer.ReportRecv
+	elapseTimer(t, e, now.Add(e.ChooseWindow))
+	if reps := reports(buyer); len(reps) != 1 || reps[0].Type != trade.ExecCanceled || reps[0].Canceled != 1 || reps[0].Leaves != 0 {
+		t.Errorf("reports: expected: canceled 1 actual: %+v", reps)
+	}
+	buyer.SignChoice(&fills[0])
+	if err := e.routeChoice(ctx, buyer.ID, fills[0]); err != nil {
+		t.Fatalf("route choice: %v", err)
+	}
+	if expected, actual := 1, e.DB.Len(); expected != actual {
+		t.Errorf("blockchain length: expected: %d actual: %d", expected, actual)
+	}
+	e.ordersLock.Lock()
+	orders, byOrderID := len(e.orders), len(e.byOrderID)
+	e.ordersLock.Unlock()
+	if orders != 0 || byOrderID != 0 {
+		t.Errorf("orders: expected: 0 actual: %d by order ID: %d", orders, byOrderID)
+	}
+
+	events, err := ReadEvents(&buf)
+	if err != nil {
+		t.Fatalf("read events: %v", err)
+	}
+	replay, err := NewReplayExchange(events)
+	if err != nil {
+		t.Fatalf("replay exchange: %v", err)
+	}
+	divergences, err := replay.Replay(events)
+	if err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	if len(divergences) != 0 {
+		t.Errorf("divergences: expected: 0 actual: %v", divergences)
+	}
+}
+
+// TestOrderReplay asserts that replaying the recorded events of
+// an order produces an identical ledger without divergence.
+func TestOrderReplay(t *testing.T) {
+	asks := [][2]float64{{2, 2}, {1, 1}}
+	e, buyer, sellers, item := orderExchange(t, asks...)
+	var buf bytes.Buffer
+	e.Log = NewEventLog(&buf)
+	r := trade.Request{ID: uuid.New(), TraderID: buyer.ID, Item: item, Quantity: 2, Side: trade.SideBuy, Type: trade.OrderMarket, TimeInForce: trade.TimeInForceIOC}
+	if err := e.routeRequest(context.Background(), r); err != nil {
+		t.Fatalf("route request: %v", err)
+	}
+	quoteOrder(t, e, r, sellers, asks...)
+	chooseFills(t, e, buyer)
+	events, err := ReadEvents(&buf)
+	if err != nil {
+		t.Fatalf("read events: %v", err)
+	}
+
+	replay, err := NewReplayExchange(events)
+	if err != nil {
+		t.Fatalf("replay exchange: %v", err)
+	}
+	divergences, err := replay.Replay(events)
+	if err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	if len(divergences) != 0 {
+		t.Errorf("divergences: expected: 0 actual: %v", divergences)
+	}
+	if expected, actual := 3, replay.DB.Len(); expected != actual {
+		t.Errorf("blockchain length: expected: %d actual: %d", expected, actual)
+	}
+}
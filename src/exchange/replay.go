@@ -0,0 +1,427 @@
+package exchange
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+	"tradesim/src/db"
+	"tradesim/src/trade"
+
+	"github.com/google/uuid"
+)
+
+var ErrReplay = errors.New("failed to replay events")
+
+// ReadEvents reads the events of an event log in the order they were recorded.
+func ReadEvents(r io.Reader) ([]Event, error) {
+	var events []Event
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var e Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("%w: line %d: %v", ErrReplay, n, err)
+		}
+		events = append(events, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrReplay, err)
+	}
+	return events, nil
+}
+
+// Divergence represents a difference between
+// a recorded event and the replay of its message.
+type Divergence struct {
+	// Expected is the recorded event, or nil if
+	// the replay produced an unrecorded event.
+	Expected *Event
+	// Actual is the replayed event, or nil if
+	// the replay didn't produce the recorded event.
+	Actual *Event
+}
+
+func (d Divergence) String() string {
+	describe := func(e *Event) string {
+		if e == nil {
+			return "none"
+		}
+		return fmt.Sprintf("type=%s sender=%s receiver=%s market=%s payload=%s",
+			e.Type, e.Sender, e.Receiver, e.Market, e.Payload)
+	}
+	return fmt.Sprintf("divergence expected=[%s] actual=[%s]", describe(d.Expected), describe(d.Actual))
+}
+
+// NewReplayExchange returns an exchange with the markets and traders that
+// took part in the provided recorded events, for replaying them. The traders
+// are never simulated; their messages are fed to the exchange by Replay.
+//
+// The exchange's blockchain has the recorded genesis, and the recorded keys
+// of the traders registered, so that it verifies the recorded signatures
+// and builds the recorded ledger. Without a recorded genesis and keys, its
+// genesis is created on the current time, and signatures aren't verified.
+func NewReplayExchange(events []Event) (*Exchange, error) {
+	items := make(map[uuid.UUID]trade.Item)
+	members := make(map[uuid.UUID]map[uuid.UUID]struct{})
+	var genesis *Genesis
+	var keys []Registration
+	for _, e := range events {
+		switch e.Type {
+		case MessageGenesis:
+			var g Genesis
+			if err := json.Unmarshal(e.Payload, &g); err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrReplay, err)
+			}
+			genesis = &g
+		case MessageKey:
+			var reg Registration
+			if err := json.Unmarshal(e.Payload, &reg); err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrReplay, err)
+			}
+			keys = append(keys, reg)
+		}
+		// The cancels and replaces of orders that aren't
+		// found aren't part of any market.
+		if e.Market == uuid.Nil {
+			continue
+		}
+		item, err := eventItem(e)
+		if err != nil {
+			return nil, err
+		}
+		if item.ID != uuid.Nil {
+			items[e.Market] = item
+		}
+		if _, ok := members[e.Market]; !ok {
+			members[e.Market] = make(map[uuid.UUID]struct{})
+		}
+		for _, id := range []uuid.UUID{e.Sender, e.Receiver} {
+			if id != uuid.Nil {
+				members[e.Market][id] = struct{}{}
+			}
+		}
+	}
+
+	traders := make(map[uuid.UUID]*trade.Trader)
+	markets := make([]Market, 0, len(items))
+	for id, item := range items {
+		ts := make([]*trade.Trader, 0, len(members[id]))
+		for traderID := range members[id] {
+			t, ok := traders[traderID]
+			if !ok {
+				var err error
+				if t, err = trade.NewTrader(nil, nil, nil); err != nil {
+					return nil, fmt.Errorf("%w: %v", ErrReplay, err)
+				}
+				t.ID = traderID
+				traders[traderID] = t
+			}
+			ts = append(ts, t)
+		}
+		markets = append(markets, NewMarket(item, ts...))
+	}
+	// The replayed traders don't hold the keys of the recorded traders,
+	// whose recorded public keys are registered in their place.
+	e := NewExchange(markets)
+	e.DB = db.NewBlockchain()
+	if genesis != nil {
+		e.DB = db.NewBlockchainAt(genesis.CreatedOn)
+		e.DB.SetNonceSeed(genesis.NonceSeed)
+	}
+	for _, reg := range keys {
+		e.DB.RegisterKey(reg.TraderID, reg.PublicKey)
+	}
+	return e, nil
+}
+
+// Replay feeds the trader messages of the provided recorded events
+// to the exchange in their recorded order, in place of live traders,
+// and returns every divergence of the replayed messages, including
+// the executed transactions, from the recorded ones.
+//
+// Replayed events carry their recorded times, and executed transactions
+// carry their recorded IDs and times, so that an exchange that routes and executes
+// messages as it did when they were recorded produces an identical ledger.
+// The exchange must not be simulated.
+func (e *Exchange) Replay(events []Event) ([]Divergence, error) {
+	var buf bytes.Buffer
+	log := e.Log
+	e.Log = NewEventLog(&buf)
+	e.replaying = true
+	defer func() {
+		e.Log = log
+		e.replaying = false
+	}()
+
+	var txns []Event
+	var ids []uuid.UUID
+	for _, ev := range events {
+		if ev.Type != MessageTransaction {
+			continue
+		}
+		var t trade.Transaction
+		if err := json.Unmarshal(ev.Payload, &t); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrReplay, err)
+		}
+		txns = append(txns, ev)
+		ids = append(ids, t.ID)
+	}
+	stamp := e.stamp
+	defer func() { e.stamp = stamp }()
+	e.stamp = func() (uuid.UUID, time.Time) {
+		if len(ids) == 0 {
+			return stamp()
+		}
+		id, at := ids[0], txns[0].Time
+		ids, txns = ids[1:], txns[1:]
+		return id, at
+	}
+
+	// A request is recorded once for every trader it was delivered to,
+	// but must only be fed to the exchange once.
+	routed := make(map[uuid.UUID]bool)
+	ctx := context.Background()
+	for i := range events {
+		ev := events[i]
+		e.Now = func() time.Time { return ev.Time }
+		var err error
+		switch ev.Type {
+		case MessageRequest:
+			var r trade.Request
+			if err = json.Unmarshal(ev.Payload, &r); err == nil && !routed[r.ID] {
+				routed[r.ID] = true
+				err = e.routeRequest(ctx, r)
+			}
+		case MessageResponses:
+			// The fills delivered by the exchange are delivered
+			// again when their order's match is replayed.
+			var resp trade.Responses
+			if err = json.Unmarshal(ev.Payload, &resp); err == nil && ev.Sender != uuid.Nil {
+				err = e.routeResponses(ctx, ev.Sender, resp)
+			}
+		case MessageResponse:
+			var resp trade.Response
+			if err = json.Unmarshal(ev.Payload, &resp); err == nil {
+				err = e.routeResponse(ctx, resp)
+			}
+		case MessageChoice:
+			var c trade.Response
+			if err = json.Unmarshal(ev.Payload, &c); err == nil {
+				err = e.routeChoice(ctx, ev.Sender, c)
+			}
+		case MessageMatch:
+			var r trade.Request
+			if err = json.Unmarshal(ev.Payload, &r); err == nil {
+				err = e.match(ctx, r.ID)
+			}
+		case MessageExpire:
+			var r trade.Request
+			if err = json.Unmarshal(ev.Payload, &r); err == nil {
+				err = e.expire(ctx, r.ID)
+			}
+		case MessageRequote:
+			var r trade.Request
+			if err = json.Unmarshal(ev.Payload, &r); err == nil {
+				err = e.requote(ctx, r.ID)
+			}
+		case MessageOrder:
+			var r trade.Request
+			if err = json.Unmarshal(ev.Payload, &r); err == nil {
+				err = e.collect(ctx, e.Markets[r.Item.ID], r)
+			}
+		case MessageAuction:
+			var a Auction
+			if err = json.Unmarshal(ev.Payload, &a); err == nil {
+				err = e.clear(ctx, a.Item.ID)
+			}
+		case MessageCancel:
+			var c trade.Cancel
+			if err = json.Unmarshal(ev.Payload, &c); err == nil {
+				err = e.cancel(ctx, c)
+			}
+		case MessageReplace:
+			var r trade.Replace
+			if err = json.Unmarshal(ev.Payload, &r); err == nil {
+				err = e.replace(ctx, r)
+			}
+		case MessageLapse:
+			var l Lapse
+			if err = json.Unmarshal(ev.Payload, &l); err == nil {
+				err = e.lapse(ctx, l)
+			}
+		case MessageGenesis, MessageKey:
+			// The genesis and keys were set up by NewReplayExchange.
+			err = e.record(ev.Type, ev.Sender, ev.Receiver, ev.Market, ev.Payload)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: event %d: %v", ErrReplay, i, err)
+		}
+		e.drain()
+	}
+
+	replayed, err := ReadEvents(&buf)
+	if err != nil {
+		return nil, err
+	}
+	if log != nil {
+		for _, ev := range replayed {
+			if err := log.Record(ev.Time, ev.Type, ev.Sender, ev.Receiver, ev.Market, ev.Payload); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return diverge(events, replayed), nil
+}
+
+// drain discards the messages delivered to every trader of the exchange,
+// since traders being replayed don't consume them.
+func (e *Exchange) drain() {
+	for _, m := range e.Markets {
+		for _, t := range m.TraderByID {
+			for drained := false; !drained; {
+				select {
+				case <-t.RequestRecv:
+				case <-t.ResponseRecv:
+				case <-t.ReportRecv:
+				default:
+					drained = true
+				}
+			}
+		}
+	}
+}
+
+// diverge returns the divergences between the expected and actual events.
+// Transactions must occur in the same order, but messages delivered to
+// traders may be delivered in any order, since concurrent traders and the
+// iteration order of markets don't determine the order of deliveries.
+func diverge(expected, actual []Event) []Divergence {
+	var divergences []Divergence
+
+	var expectedTxns, actualTxns []Event
+	expectedMsgs := make(map[string][]Event)
+	for _, ev := range expected {
+		if ev.Type == MessageTransaction {
+			expectedTxns = append(expectedTxns, ev)
+		} else {
+			k := eventKey(ev)
+			expectedMsgs[k] = append(expectedMsgs[k], ev)
+		}
+	}
+	var unexpected []Event
+	for _, ev := range actual {
+		if ev.Type == MessageTransaction {
+			actualTxns = append(actualTxns, ev)
+			continue
+		}
+		k := eventKey(ev)
+		if len(expectedMsgs[k]) > 0 {
+			expectedMsgs[k] = expectedMsgs[k][1:]
+		} else {
+			unexpected = append(unexpected, ev)
+		}
+	}
+
+	for i := 0; i < len(expectedTxns) || i < len(actualTxns); i++ {
+		d := Divergence{}
+		if i < len(expectedTxns) {
+			d.Expected = &expectedTxns[i]
+		}
+		if i < len(actualTxns) {
+			d.Actual = &actualTxns[i]
+		}
+		if d.Expected == nil || d.Actual == nil || eventKey(*d.Expected) != eventKey(*d.Actual) {
+			divergences = append(divergences, d)
+		}
+	}
+
+	keys := make([]string, 0, len(expectedMsgs))
+	for k := range expectedMsgs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for i := range expectedMsgs[k] {
+			divergences = append(divergences, Divergence{Expected: &expectedMsgs[k][i]})
+		}
+	}
+	for i := range unexpected {
+		divergences = append(divergences, Divergence{Actual: &unexpected[i]})
+	}
+	return divergences
+}
+
+// eventKey returns a key of every field of an event except its time.
+func eventKey(e Event) string {
+	var payload bytes.Buffer
+	if err := json.Compact(&payload, e.Payload); err != nil {
+		payload.Write(e.Payload)
+	}
+	return strings.Join([]string{e.Type, e.Sender.String(), e.Receiver.String(), e.Market.String(), payload.String()}, " ")
+}
+
+// eventItem returns the item of the market of an event's message.
+func eventItem(e Event) (trade.Item, error) {
+	var (
+		item trade.Item
+		err  error
+	)
+	switch e.Type {
+	case MessageRequest, MessageMatch, MessageExpire, MessageRequote, MessageOrder:
+		var r trade.Request
+		err = json.Unmarshal(e.Payload, &r)
+		item = r.Item
+	case MessageResponse, MessageChoice:
+		var r trade.Response
+		err = json.Unmarshal(e.Payload, &r)
+		item = r.Request.Item
+	case MessageResponses:
+		var r trade.Responses
+		if err = json.Unmarshal(e.Payload, &r); err == nil && len(r) > 0 {
+			item = r[0].Request.Item
+		}
+	case MessageAuction:
+		var a Auction
+		err = json.Unmarshal(e.Payload, &a)
+		item = a.Item
+	case MessageTransaction:
+		var t trade.Transaction
+		err = json.Unmarshal(e.Payload, &t)
+		item = t.Credit.Item
+	case MessageReport:
+		var r trade.ExecutionReport
+		err = json.Unmarshal(e.Payload, &r)
+		item = r.Item
+	case MessageRejection:
+		var r Rejection
+		err = json.Unmarshal(e.Payload, &r)
+		item = r.Choice.Request.Item
+	case MessageLapse:
+		var l Lapse
+		err = json.Unmarshal(e.Payload, &l)
+		item = l.Request.Item
+	case MessageKey:
+		var reg Registration
+		err = json.Unmarshal(e.Payload, &reg)
+		item = reg.Item
+	case MessageCancel, MessageReplace:
+		// The item of a cancel or replace is the item of the order's
+		// request, which is recorded before it.
+	default:
+		err = fmt.Errorf("unsupported message type: %s", e.Type)
+	}
+	if err != nil {
+		return trade.Item{}, fmt.Errorf("%w: %v", ErrReplay, err)
+	}
+	return item, nil
+}
@@ -0,0 +1,161 @@
+package exchange
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+	"tradesim/src/db"
+	"tradesim/src/trade"
+
+	"github.com/google/uuid"
+)
+
+// recordTrade returns the events of an exchange with two traders in one market
+// where one trader requests the item, the other responds, and the first
+// chooses the response, on a simulated clock that advances a second
+// whenever it's read, along with the exchange.
+func recordTrade(t *testing.T) ([]Event, *Exchange) {
+	item := trade.NewItem("a")
+	buyer := newTrader(t, nil, []trade.Want{{Item: item, PriceMax: 2, Quantity: 1}})
+	seller := newTrader(t, []trade.Have{{Item: item, Price: 1.5, Quantity: 1}}, nil)
+	e := NewExchange([]Market{NewMarket(item, buyer, seller)})
+	var buf bytes.Buffer
+	e.Log = NewEventLog(&buf)
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	e.Now = func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+	if err := e.RegisterKeys(); err != nil {
+		t.Fatalf("register keys: %v", err)
+	}
+
+	req := trade.Request{ID: uuid.New(), TraderID: buyer.ID, Item: item, Quantity: 1, Side: trade.SideBuy}
+	resp := trade.Response{ID: uuid.New(), Request: req, TraderID: seller.ID}
+	resp.OrderBook.Ask.Item = item
+	resp.OrderBook.Ask.Price = 1.5
+	resp.OrderBook.Ask.Quantity = 1
+	seller.SignQuote(&resp)
+	if err := e.routeRequest(context.Background(), req); err != nil {
+		t.Fatalf("route request: %v", err)
+	}
+	if err := e.routeResponse(context.Background(), resp); err != nil {
+		t.Fatalf("route response: %v", err)
+	}
+	buyer.SignChoice(&resp)
+	if err := e.routeChoice(context.Background(), buyer.ID, resp); err != nil {
+		t.Fatalf("route choice: %v", err)
+	}
+
+	events, err := ReadEvents(&buf)
+	if err != nil {
+		t.Fatalf("read events: %v", err)
+	}
+	return events, e
+}
+
+// ledger returns every block of the blockchain of the provided exchange,
+// whose hashes chain it to its genesis block.
+func ledger(e *Exchange) []db.BlockInfo {
+	blocks := make([]db.BlockInfo, e.DB.Len())
+	for i := range blocks {
+		blocks[i], _ = e.DB.Block(i)
+	}
+	return blocks
+}
+
+// TestReplayIdentical asserts that replaying recorded events
+// produces an identical ledger without divergence.
+func TestReplayIdentical(t *testing.T) {
+	events, recorded := recordTrade(t)
+
+	e, err := NewReplayExchange(events)
+	if err != nil {
+		t.Fatalf("replay exchange: %v", err)
+	}
+	divergences, err := e.Replay(events)
+	if err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	if len(divergences) != 0 {
+		t.Errorf("divergences: expected: %d actual: %v", 0, divergences)
+	}
+	if expected, actual := 2, e.DB.Len(); expected != actual {
+		t.Errorf("blockchain length: expected: %d actual: %d", expected, actual)
+	}
+	if expected, actual := ledger(recorded), ledger(e); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("ledger: expected: %v actual: %v", expected, actual)
+	}
+}
+
+// TestReplayDivergence asserts that replaying a recorded choice whose
+// transaction was recorded differently reports the transaction divergence.
+func TestReplayDivergence(t *testing.T) {
+	events, _ := recordTrade(t)
+	for i, ev := range events {
+		if ev.Type != MessageTransaction {
+			continue
+		}
+		var txn trade.Transaction
+		if err := json.Unmarshal(ev.Payload, &txn); err != nil {
+			t.Fatalf("unmarshal transaction: %v", err)
+		}
+		txn.Credit.Price = 3
+		events[i].Payload, _ = json.Marshal(txn)
+	}
+
+	e, err := NewReplayExchange(events)
+	if err != nil {
+		t.Fatalf("replay exchange: %v", err)
+	}
+	divergences, err := e.Replay(events)
+	if err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	if expected, actual := 1, len(divergences); expected != actual {
+		t.Fatalf("divergences: expected: %d actual: %v", expected, divergences)
+	}
+	if d := divergences[0]; d.Expected == nil || d.Actual == nil || d.Expected.Type != MessageTransaction {
+		t.Errorf("divergence: expected transaction actual: %s", d)
+	}
+}
+
+// TestReplayVerify asserts that a replay verifies the recorded choices
+// with the recorded keys of their traders, rejecting a forged choice
+// rather than executing it.
+func TestReplayVerify(t *testing.T) {
+	events, _ := recordTrade(t)
+	for i, ev := range events {
+		if ev.Type != MessageChoice {
+			continue
+		}
+		var c trade.Response
+		if err := json.Unmarshal(ev.Payload, &c); err != nil {
+			t.Fatalf("unmarshal choice: %v", err)
+		}
+		c.ChoiceSignature[0] ^= 0xff
+		events[i].Payload, _ = json.Marshal(c)
+	}
+
+	e, err := NewReplayExchange(events)
+	if err != nil {
+		t.Fatalf("replay exchange: %v", err)
+	}
+	divergences, err := e.Replay(events)
+	if err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	if expected, actual := 1, e.DB.Len(); expected != actual {
+		t.Errorf("blockchain length: expected: %d actual: %d", expected, actual)
+	}
+	rejected := false
+	for _, d := range divergences {
+		rejected = rejected || d.Actual != nil && d.Actual.Type == MessageRejection
+	}
+	if !rejected {
+		t.Errorf("divergences: expected: rejection actual: %v", divergences)
+	}
+}
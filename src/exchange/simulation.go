@@ -0,0 +1,419 @@
+package exchange
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+	"tradesim/src/prob"
+	"tradesim/src/trade"
+
+	"github.com/google/uuid"
+)
+
+var ErrSimulation = errors.New("failed to simulate exchange")
+
+// timer represents a quote or choose window of an order of an exchange
+// stepped in simulated time, which matches the order of its request ID
+// once it elapses at its time, or lapses its lapse if it has one.
+type timer struct {
+	at        time.Time
+	requestID uuid.UUID
+	lapse     *Lapse
+}
+
+// schedule schedules the provided timer to elapse after every
+// timer that elapses no later than it.
+func (e *Exchange) schedule(t timer) {
+	e.ordersLock.Lock()
+	defer e.ordersLock.Unlock()
+	i := sort.Search(len(e.timers), func(i int) bool { return e.timers[i].at.After(t.at) })
+	e.timers = append(e.timers, timer{})
+	copy(e.timers[i+1:], e.timers[i:])
+	e.timers[i] = t
+}
+
+// elapse elapses the provided timer, matching the order
+// of its request or lapsing its lapse if it has one.
+func (e *Exchange) elapse(ctx context.Context, t timer) error {
+	if t.lapse != nil {
+		return e.lapse(ctx, *t.lapse)
+	}
+	return e.match(ctx, t.requestID)
+}
+
+// Simulation represents a discrete-event simulation of an exchange and
+// the traders of its markets, which steps through their events in
+// simulated time, so that a simulation of the same seed and state builds
+// the same ledger however fast it runs, and can be checkpointed between
+// any two of its events.
+//
+// The events of a simulation are the steps of its regime chain, the ticks
+// of the exchange's clock, the quote and choose windows of the exchange's
+// orders and the candidate events of the processes of its simulated
+// traders, which are stepped in the order of their simulated times, and
+// in that order at equal times, with traders in the order they're
+// provided. Every event is settled before the next: the messages it
+// causes are passed between the exchange and the simulated traders in the
+// order they're sent until none are left, where the messages the exchange
+// delivers while it handles a message are received in the order of their
+// traders.
+//
+// A run of a simulation is paced by the wall clock if the simulation is
+// paced, so that a simulated second takes a second, and otherwise runs as
+// fast as it's computed. External traders are driven by their clients
+// rather than processes, and their messages are handled at the simulated
+// time a run has reached by the wall clock, so a simulation with external
+// traders is always paced. Their messages aren't part of a simulation's
+// checkpoints, so a simulation with external traders can't be
+// checkpointed.
+type Simulation struct {
+	// Paced is whether the simulation's runs are paced by the wall clock.
+	// It must not be set while the simulation runs.
+	Paced    bool
+	exchange *Exchange
+	traders  []*trade.Trader
+	external []*trade.Trader
+	regime   *prob.MarkovChain
+	epoch    time.Time
+	// mu guards elapsed, the simulated duration elapsed since the epoch.
+	mu      sync.Mutex
+	elapsed time.Duration
+	// queue are the messages of the current event that haven't been
+	// passed on, each of which is passed on by calling it.
+	queue []func(context.Context) error
+	// inbound are the channels the simulated traders receive on, which
+	// are their request, response and report channels by trader, and
+	// outbound are the channels the external traders send on.
+	inbound  []reflect.SelectCase
+	outbound []outbound
+	// wall and origin are the wall-clock time and the simulated duration
+	// elapsed when the current run started, which pace the run.
+	wall   time.Time
+	origin time.Duration
+}
+
+// outbound represents a channel an external trader sends messages to the
+// exchange on, and returns the handler of a message sent on it.
+type outbound struct {
+	ch      reflect.Value
+	handler func(msg reflect.Value) func(context.Context) error
+}
+
+// NewSimulation returns a simulation of the provided exchange, whose
+// provided simulated traders are driven by their processes, and whose provided external traders are driven by their
+// clients. The provided regime chain, which may be nil, drives the regime
+// processes of the traders. The simulated time of the simulation is the
+// provided epoch plus the simulated duration elapsed, which stamps the
+// blocks and events of the exchange. The exchange's Now must not be set
+// once the simulation is created.
+func NewSimulation(e *Exchange, traders, external []*trade.Trader, regime *prob.MarkovChain, epoch time.Time) (*Simulation, error) {
+	s := &Simulation{
+		exchange: e,
+		traders:  traders,
+		external: external,
+		regime:   regime,
+		epoch:    epoch,
+	}
+	for _, t := range traders {
+		if t.Process() == nil {
+			return nil, fmt.Errorf("%w: trader has no process: %s", ErrSimulation, t.ID)
+		}
+		for _, ch := range []interface{}{t.RequestRecv, t.ResponseRecv, t.ReportRecv} {
+			s.inbound = append(s.inbound, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ch)})
+		}
+	}
+	for _, t := range external {
+		s.outbound = append(s.outbound, s.externalOutbound(t)...)
+	}
+	e.Now = s.Now
+	if regime != nil {
+		regime.Begin(epoch)
+	}
+	return s, nil
+}
+
+// externalOutbound returns the channels the provided external trader
+// sends on, whose cancels and replaces are of its own orders.
+func (s *Simulation) externalOutbound(t *trade.Trader) []outbound {
+	e := s.exchange
+	return []outbound{
+		{reflect.ValueOf(t.RequestSend), func(msg reflect.Value) func(context.Context) error {
+			r := msg.Interface().(trade.Request)
+			return func(ctx context.Context) error { return e.routeRequest(ctx, r) }
+		}},
+		{reflect.ValueOf(t.ResponseSend), func(msg reflect.Value) func(context.Context) error {
+			resp := msg.Interface().(trade.Response)
+			return func(ctx context.Context) error { return e.routeResponse(ctx, resp) }
+		}},
+		{reflect.ValueOf(t.Choice), func(msg reflect.Value) func(context.Context) error {
+			c := msg.Interface().(trade.Response)
+			return func(ctx context.Context) error { return e.routeChoice(ctx, t.ID, c) }
+		}},
+		{reflect.ValueOf(t.CancelSend), func(msg reflect.Value) func(context.Context) error {
+			c := msg.Interface().(trade.Cancel)
+			c.TraderID = t.ID
+			return func(ctx context.Context) error { return e.cancel(ctx, c) }
+		}},
+		{reflect.ValueOf(t.ReplaceSend), func(msg reflect.Value) func(context.Context) error {
+			r := msg.Interface().(trade.Replace)
+			r.TraderID = t.ID
+			return func(ctx context.Context) error { return e.replace(ctx, r) }
+		}},
+	}
+}
+
+// Elapsed returns the simulated duration elapsed since the simulation's
+// epoch. It's safe to call while the simulation runs.
+func (s *Simulation) Elapsed() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.elapsed
+}
+
+// SetElapsed sets the simulated duration elapsed since the simulation's
+// epoch, so that a simulation can be resumed where it left off. It must
+// not be called while the simulation runs.
+func (s *Simulation) SetElapsed(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.elapsed = d
+}
+
+// Now returns the current simulated time. It's safe
+// to call while the simulation runs.
+func (s *Simulation) Now() time.Time {
+	return s.epoch.Add(s.Elapsed()).UTC()
+}
+
+// Run runs the simulation until the provided simulated duration has
+// elapsed, or until the provided context is done or a message fails. A
+// run without a duration runs indefinitely, unless the simulation isn't
+// paced and has no events left. Every event is settled once Run returns.
+func (s *Simulation) Run(ctx context.Context, until time.Duration) error {
+	paced := s.Paced || len(s.external) > 0
+	s.wall, s.origin = time.Now(), s.Elapsed()
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		now := s.Elapsed()
+		at, fire, ok := s.next(now)
+		stop := until > 0 && (!ok || at > until)
+		if stop {
+			at, ok = until, true
+		}
+		if paced {
+			handled, err := s.await(ctx, at, ok)
+			if err != nil {
+				return err
+			}
+			if handled {
+				continue
+			}
+		}
+		if stop {
+			s.SetElapsed(until)
+			return nil
+		}
+		if !ok {
+			// An unpaced run without a duration has nothing left to run.
+			return nil
+		}
+		if at < now {
+			at = now
+		}
+		s.SetElapsed(at)
+		if err := fire(ctx); err != nil {
+			return err
+		}
+		if err := s.settle(ctx); err != nil {
+			return err
+		}
+	}
+}
+
+// next returns the simulated time of the simulation's next event, and
+// a function that fires it, or false if the simulation has no events.
+// The provided duration is the simulated duration elapsed.
+func (s *Simulation) next(now time.Duration) (time.Duration, func(context.Context) error, bool) {
+	var (
+		next  time.Duration
+		fire  func(context.Context) error
+		found bool
+	)
+	consider := func(at time.Duration, ok bool, f func(context.Context) error) {
+		if ok && (!found || at < next) {
+			next, fire, found = at, f, true
+		}
+	}
+	e := s.exchange
+	if s.regime != nil {
+		at, ok := s.regime.Due()
+		consider(at, ok, func(context.Context) error {
+			s.regime.Advance(s.epoch.Add(at))
+			return nil
+		})
+	}
+	if e.Clock != nil {
+		at, ok := e.Clock.Next()
+		consider(at, ok, func(ctx context.Context) error {
+			e.Clock.Step()
+			return s.handle(ctx, e.advance)
+		})
+	}
+	e.ordersLock.Lock()
+	if len(e.timers) > 0 {
+		t := e.timers[0]
+		consider(t.at.Sub(s.epoch), true, func(ctx context.Context) error {
+			e.ordersLock.Lock()
+			e.timers = e.timers[1:]
+			e.ordersLock.Unlock()
+			return s.handle(ctx, func(ctx context.Context) error { return e.elapse(ctx, t) })
+		})
+	}
+	e.ordersLock.Unlock()
+	for _, t := range s.traders {
+		_t, p := t, t.Process()
+		at, ok := p.Next(now)
+		consider(at, ok, func(ctx context.Context) error {
+			if !p.Step() {
+				return nil
+			}
+			r, ok := _t.Act()
+			if !ok {
+				return nil
+			}
+			return s.handle(ctx, func(ctx context.Context) error { return e.routeRequest(ctx, r) })
+		})
+	}
+	return next, fire, found
+}
+
+// handle runs the provided handler of a message of the exchange, receiving
+// the messages it delivers to the simulated traders meanwhile, which are
+// queued once it returns in the order of their traders, and for every
+// trader its requests and then its responses in the order they were
+// delivered. Simulated traders don't act on their execution reports.
+func (s *Simulation) handle(ctx context.Context, handler func(context.Context) error) error {
+	done := make(chan error, 1)
+	go func() { done <- handler(ctx) }()
+	cases := append(s.inbound[:len(s.inbound):len(s.inbound)], reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(done)})
+	received := make([][]reflect.Value, len(s.inbound))
+	var err error
+	for {
+		i, msg, _ := reflect.Select(cases)
+		if i == len(s.inbound) {
+			err, _ = msg.Interface().(error)
+			break
+		}
+		received[i] = append(received[i], msg)
+	}
+	// The messages delivered just before the handler returned
+	// may not have been received yet.
+	for i, c := range s.inbound {
+		for {
+			msg, ok := c.Chan.TryRecv()
+			if !ok {
+				break
+			}
+			received[i] = append(received[i], msg)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	e := s.exchange
+	for i, t := range s.traders {
+		_t := t
+		for _, msg := range received[3*i] {
+			req := msg.Interface().(trade.Request)
+			s.queue = append(s.queue, func(context.Context) error {
+				if resp, ok := _t.Respond(req); ok {
+					s.send(func(ctx context.Context) error { return e.routeResponse(ctx, resp) })
+				}
+				return nil
+			})
+		}
+		for _, msg := range received[3*i+1] {
+			resps := msg.Interface().(trade.Responses)
+			s.queue = append(s.queue, func(context.Context) error {
+				crosses, choices := _t.Choose(resps)
+				for _, c := range crosses {
+					_c := c
+					s.send(func(ctx context.Context) error { return e.routeResponse(ctx, _c) })
+				}
+				for _, c := range choices {
+					_c := c
+					s.send(func(ctx context.Context) error { return e.routeChoice(ctx, _t.ID, _c) })
+				}
+				return nil
+			})
+		}
+	}
+	return nil
+}
+
+// send queues a message of a simulated trader to the exchange,
+// which is passed on by running the provided handler.
+func (s *Simulation) send(handler func(context.Context) error) {
+	s.queue = append(s.queue, func(ctx context.Context) error { return s.handle(ctx, handler) })
+}
+
+// settle passes on the queued messages, and the messages
+// they cause, in the order they're queued until none are left.
+func (s *Simulation) settle(ctx context.Context) error {
+	for len(s.queue) > 0 {
+		pass := s.queue[0]
+		s.queue = s.queue[1:]
+		if err := pass(ctx); err != nil {
+			s.queue = nil
+			return err
+		}
+	}
+	return nil
+}
+
+// await waits for the wall-clock time of the provided simulated duration
+// of a paced run, if it has one, handling the first message an external
+// trader sends meanwhile at the simulated duration the run has reached by
+// the wall clock, and returns whether it handled one.
+func (s *Simulation) await(ctx context.Context, at time.Duration, ok bool) (bool, error) {
+	cases := []reflect.SelectCase{
+		{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())},
+		{Dir: reflect.SelectRecv},
+	}
+	if ok {
+		t := time.NewTimer(time.Until(s.wall.Add(at - s.origin)))
+		defer t.Stop()
+		cases[1].Chan = reflect.ValueOf(t.C)
+	}
+	for _, o := range s.outbound {
+		cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: o.ch})
+	}
+	i, msg, _ := reflect.Select(cases)
+	switch i {
+	case 0:
+		return false, ctx.Err()
+	case 1:
+		return false, nil
+	}
+	// A message is handled no later than the next event,
+	// nor before the events already handled.
+	now := s.origin + time.Since(s.wall)
+	if ok && now > at {
+		now = at
+	}
+	if elapsed := s.Elapsed(); now < elapsed {
+		now = elapsed
+	}
+	s.SetElapsed(now)
+	if err := s.handle(ctx, s.outbound[i-2].handler(msg)); err != nil {
+		return true, err
+	}
+	return true, s.settle(ctx)
+}
@@ -0,0 +1,290 @@
+package exchange
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+	"tradesim/src/time/clock"
+	"tradesim/src/trade"
+
+	"github.com/google/uuid"
+)
+
+var epoch = time.Date(2024, 1, 2, 9, 30, 0, 0, time.UTC)
+
+// schedule represents a process whose events
+// are at the provided simulated durations.
+type schedule []time.Duration
+
+func (s *schedule) Next(now time.Duration) (time.Duration, bool) {
+	if len(*s) == 0 {
+		return 0, false
+	}
+	return (*s)[0], true
+}
+
+func (s *schedule) Step() bool {
+	*s = (*s)[1:]
+	return true
+}
+
+// scheduledTrader returns a trader with the provided haves and wants,
+// whose process has events at the provided simulated durations.
+func scheduledTrader(t *testing.T, haves []trade.Have, wants []trade.Want, events ...time.Duration) *trade.Trader {
+	s := schedule(events)
+	trader, err := trade.NewTrader(haves, wants, &s)
+	if err != nil {
+		t.Fatalf("new trader: %v", err)
+	}
+	return trader
+}
+
+// simulationExchange returns an exchange of one market with a buyer whose
+// process has events at the provided simulated durations and who places
+// limit orders, and a seller without events, whose orders are collected
+// until every trader has quoted them.
+func simulationExchange(t *testing.T, events ...time.Duration) (*Exchange, *trade.Trader, *trade.Trader) {
+	item := trade.NewItem("a")
+	buyer := scheduledTrader(t, nil, []trade.Want{{Item: item, PriceMax: 2, Quantity: 1}}, events...)
+	buyer.Orders = trade.OrderPolicy{Probability: 1, TimeInForce: trade.TimeInForceIOC}
+	seller := scheduledTrader(t, []trade.Have{{Item: item, Price: 1, Quantity: 1}}, nil)
+	e := NewExchange([]Market{NewMarket(item, buyer, seller)})
+	e.QuoteWindow = 0
+	e.ChooseWindow = 0
+	e.RequoteTicks = 0
+	return e, buyer, seller
+}
+
+// TestSimulationRun asserts that a run steps the processes of the
+// simulated traders in simulated time, that every event is settled
+// before the next, so that an order is quoted, matched, chosen and
+// executed on the event that places it, and that the run stops once its
+// duration has elapsed.
+func TestSimulationRun(t *testing.T) {
+	e, buyer, seller := simulationExchange(t, 2*time.Second)
+	s, err := NewSimulation(e, []*trade.Trader{buyer, seller}, nil, nil, epoch)
+	if err != nil {
+		t.Fatalf("new simulation: %v", err)
+	}
+	length := e.DB.Len()
+	if err := s.Run(context.Background(), 5*time.Second); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if expected, actual := 5*time.Second, s.Elapsed(); expected != actual {
+		t.Errorf("elapsed: expected: %v actual: %v", expected, actual)
+	}
+	if expected, actual := length+1, e.DB.Len(); expected != actual {
+		t.Fatalf("blockchain length: expected: %d actual: %d", expected, actual)
+	}
+	b, _ := e.DB.Block(length)
+	if expected := epoch.Add(2 * time.Second); !b.CreatedOn.Equal(expected) {
+		t.Errorf("created on: expected: %v actual: %v", expected, b.CreatedOn)
+	}
+	if stats := e.Orders(); stats.Accepted != 1 || stats.Trades != 1 {
+		t.Errorf("stats: expected: 1 accepted 1 trade actual: %+v", stats)
+	}
+}
+
+// TestSimulationNoProcess asserts that a simulated
+// trader without a process can't be simulated.
+func TestSimulationNoProcess(t *testing.T) {
+	e, buyer, seller := simulationExchange(t)
+	var trader trade.Trader
+	trader.ID = uuid.New()
+	if _, err := NewSimulation(e, []*trade.Trader{buyer, seller, &trader}, nil, nil, epoch); !errors.Is(err, ErrSimulation) {
+		t.Errorf("new simulation: expected: %v actual: %v", ErrSimulation, err)
+	}
+}
+
+// TestSimulationSettle asserts that settling passes on the queued
+// messages and the messages they queue in the order they're queued,
+// and that a failed message discards the messages left.
+func TestSimulationSettle(t *testing.T) {
+	e, _, _ := simulationExchange(t)
+	s, err := NewSimulation(e, nil, nil, nil, epoch)
+	if err != nil {
+		t.Fatalf("new simulation: %v", err)
+	}
+	var passed []int
+	pass := func(i int) func(context.Context) error {
+		return func(context.Context) error {
+			passed = append(passed, i)
+			return nil
+		}
+	}
+	s.queue = append(s.queue, func(ctx context.Context) error {
+		s.queue = append(s.queue, pass(3))
+		return pass(1)(ctx)
+	}, pass(2))
+	if err := s.settle(context.Background()); err != nil {
+		t.Fatalf("settle: %v", err)
+	}
+	if len(passed) != 3 || passed[0] != 1 || passed[1] != 2 || passed[2] != 3 {
+		t.Errorf("passed: expected: [1 2 3] actual: %v", passed)
+	}
+
+	errFailed := errors.New("failed")
+	s.queue = append(s.queue, func(context.Context) error { return errFailed }, pass(4))
+	if err := s.settle(context.Background()); !errors.Is(err, errFailed) {
+		t.Errorf("settle: expected: %v actual: %v", errFailed, err)
+	}
+	if len(s.queue) != 0 || len(passed) != 3 {
+		t.Errorf("queue: expected: empty actual: %d passed: %v", len(s.queue), passed)
+	}
+}
+
+// TestSimulationTimers asserts that the quote window of an order elapses
+// in simulated time, so that an order without the quotes of every trader
+// is matched once its window elapses.
+func TestSimulationTimers(t *testing.T) {
+	e, buyer, _ := simulationExchange(t, time.Second)
+	e.QuoteWindow = 3 * time.Second
+	// The seller isn't simulated, so it never quotes.
+	s, err := NewSimulation(e, []*trade.Trader{buyer}, nil, nil, epoch)
+	if err != nil {
+		t.Fatalf("new simulation: %v", err)
+	}
+	ctx := context.Background()
+	if err := s.Run(ctx, 3500*time.Millisecond); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if stats := e.Orders(); stats.Accepted != 1 || stats.Canceled != 0 {
+		t.Errorf("stats: expected: 1 accepted actual: %+v", stats)
+	}
+	if err := s.Run(ctx, 5*time.Second); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if stats := e.Orders(); stats.Canceled != 1 {
+		t.Errorf("stats: expected: 1 canceled actual: %+v", stats)
+	}
+}
+
+// TestSimulationClock asserts that the ticks of the exchange's clock
+// elapse in simulated time, expiring a good-till-time order on its tick.
+func TestSimulationClock(t *testing.T) {
+	item := trade.NewItem("a")
+	buyer := scheduledTrader(t, nil, []trade.Want{{Item: item, PriceMax: 2, Quantity: 1}}, 500*time.Millisecond)
+	buyer.Orders = trade.OrderPolicy{Probability: 1, TimeInForce: trade.TimeInForceGTT, ExpireTicks: 2}
+	// The buyer is alone in its market, so its order rests once it's placed.
+	e := NewExchange([]Market{NewMarket(item, buyer)})
+	clk := clock.NewClock(time.Second, 0)
+	e.Clock = &clk
+	s, err := NewSimulation(e, []*trade.Trader{buyer}, nil, nil, epoch)
+	if err != nil {
+		t.Fatalf("new simulation: %v", err)
+	}
+	ctx := context.Background()
+	if err := s.Run(ctx, 1500*time.Millisecond); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if stats := e.Orders(); stats.Accepted != 1 || stats.Expired != 0 {
+		t.Errorf("stats: expected: 1 accepted actual: %+v", stats)
+	}
+	if err := s.Run(ctx, 5*time.Second); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if stats := e.Orders(); stats.Expired != 1 {
+		t.Errorf("stats: expected: 1 expired actual: %+v", stats)
+	}
+	if expected, actual := uint64(5), clk.Position(); expected != actual {
+		t.Errorf("ticks: expected: %d actual: %d", expected, actual)
+	}
+}
+
+// TestSimulationPaced asserts that a paced run takes the wall-clock time
+// of its duration, and that a run that isn't paced doesn't.
+func TestSimulationPaced(t *testing.T) {
+	e, _, _ := simulationExchange(t)
+	s, err := NewSimulation(e, nil, nil, nil, epoch)
+	if err != nil {
+		t.Fatalf("new simulation: %v", err)
+	}
+	ctx := context.Background()
+	start := time.Now()
+	if err := s.Run(ctx, time.Hour); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Minute {
+		t.Errorf("unpaced run: expected: immediate actual: %v", elapsed)
+	}
+	s.Paced = true
+	start = time.Now()
+	if err := s.Run(ctx, time.Hour+50*time.Millisecond); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("paced run: expected: 50ms actual: %v", elapsed)
+	}
+	if expected, actual := epoch.Add(time.Hour+50*time.Millisecond), s.Now(); !expected.Equal(actual) {
+		t.Errorf("now: expected: %v actual: %v", expected, actual)
+	}
+}
+
+// TestSimulationExternal asserts that the messages of an external trader
+// are handled during a run, which is paced, at the simulated time the run
+// has reached, and that an external trader may only cancel its own orders.
+func TestSimulationExternal(t *testing.T) {
+	e, buyer, seller := simulationExchange(t)
+	s, err := NewSimulation(e, nil, []*trade.Trader{buyer, seller}, nil, epoch)
+	if err != nil {
+		t.Fatalf("new simulation: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.Run(ctx, 0) }()
+	defer func() {
+		cancel()
+		if err := <-done; !errors.Is(err, context.Canceled) {
+			t.Errorf("run: expected: %v actual: %v", context.Canceled, err)
+		}
+	}()
+	timeout := time.After(5 * time.Second)
+
+	r, ok := buyer.Act()
+	if !ok {
+		t.Fatalf("act: expected: order actual: none")
+	}
+	buyer.RequestSend <- r
+	select {
+	case <-seller.RequestRecv:
+	case <-timeout:
+		t.Fatalf("request: expected: order actual: none")
+	}
+	// The seller's cancel of the buyer's order is of its own order.
+	seller.CancelSend <- trade.Cancel{ID: uuid.New(), TraderID: buyer.ID, OrderID: r.ID}
+	select {
+	case rep := <-seller.ReportRecv:
+		if rep.Type != trade.ExecCancelRejected || rep.TraderID != seller.ID || rep.Reason != "order not found" {
+			t.Errorf("report: expected: cancel rejected actual: %+v", rep)
+		}
+	case <-timeout:
+		t.Fatalf("report: expected: cancel rejected actual: none")
+	}
+	resp, _ := seller.Respond(r)
+	seller.ResponseSend <- resp
+	var fills trade.Responses
+	select {
+	case fills = <-buyer.ResponseRecv:
+	case <-timeout:
+		t.Fatalf("fills: expected: 1 actual: none")
+	}
+	_, choices := buyer.Choose(fills)
+	for _, c := range choices {
+		buyer.Choice <- c
+	}
+	for _, expected := range []trade.ExecType{trade.ExecNew, trade.ExecTrade} {
+		select {
+		case rep := <-buyer.ReportRecv:
+			if rep.Type != expected {
+				t.Errorf("report: expected: %v actual: %+v", expected, rep)
+			}
+		case <-timeout:
+			t.Fatalf("report: expected: %v actual: none", expected)
+		}
+	}
+	b, _ := e.DB.Block(e.DB.Len() - 1)
+	if now := s.Now(); !b.CreatedOn.After(epoch) || b.CreatedOn.After(now) {
+		t.Errorf("created on: expected: between %v and %v actual: %v", epoch, now, b.CreatedOn)
+	}
+}
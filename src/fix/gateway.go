@@ -0,0 +1,659 @@
+// Package fix is a FIX 4.4 order entry gateway to an exchange.
+//
+// A FIX client drives one of the exchange's traders in place of its
+// process, logging on with the trader's configuration ID as its
+// SenderCompID and the gateway's CompID as its TargetCompID.
+//
+// The session layer supports Logon, Heartbeat, TestRequest,
+// ResendRequest, SequenceReset, Reject and Logout. Sequence numbers
+// persist across the connections of a trader unless a Logon resets them.
+// Messages received with a sequence number higher than expected are
+// discarded, and a resend of the gap is requested. Messages whose
+// checksum or fields are invalid, or that are missing a required field,
+// are rejected with a Reject, and messages of unsupported application
+// types with a BusinessMessageReject. A client whose messages can't be
+// delimited, or that doesn't log on, is sent a Logout stating why and
+// disconnected.
+//
+// Order entry supports NewOrderSingle, OrderCancelRequest,
+// ExecutionReport and OrderCancelReject, mapped onto the exchange's
+// orders:
+//
+//   - A NewOrderSingle places a market or limit, buy or sell order of its
+//     symbol, which is an item name or ID, with the exchange. Its
+//     TimeInForce is day, good-till-cancel, immediate-or-cancel or
+//     fill-or-kill, and immediate-or-cancel if it's absent.
+//   - The fills the exchange allocates to an order are chosen if the
+//     order accepts their price, and the crosses of auctions delivered to
+//     the trader as a seller are signed while it has a live sell order of
+//     their item. The trader doesn't quote the requests of other traders.
+//   - Every execution report of an order by the exchange is reported as
+//     an ExecutionReport, and an OrderCancelRequest cancels its order with
+//     the exchange, which is reported as an ExecutionReport, or as an
+//     OrderCancelReject if the exchange rejects it.
+//
+// Live orders are canceled with the exchange once their client disconnects.
+package fix
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+	"tradesim/src/exchange"
+	"tradesim/src/trade"
+
+	"github.com/google/uuid"
+)
+
+// CompID is the CompID of the gateway.
+const CompID = "TRADESIM"
+
+const (
+	// logonTimeout bounds how long a client may take to log on.
+	logonTimeout = 10 * time.Second
+	// writeTimeout bounds how long a message may take to write,
+	// after which the client is disconnected.
+	writeTimeout = 5 * time.Second
+	// deliveryBuffer is the number of messages delivered to a trader
+	// that are buffered for its session, beyond which they're dropped.
+	deliveryBuffer = 64
+	// quantityEpsilon is the tolerance of quantity comparisons.
+	quantityEpsilon = 1e-9
+)
+
+// Values of the enumerated fields used by the gateway.
+const (
+	sideBuy  = "1"
+	sideSell = "2"
+
+	ordTypeMarket = "1"
+	ordTypeLimit  = "2"
+
+	tifDay = "0"
+	tifGTC = "1"
+	tifIOC = "3"
+	tifFOK = "4"
+
+	execNew      = "0"
+	execCanceled = "4"
+	execRejected = "8"
+	execExpired  = "C"
+	execTrade    = "F"
+
+	statusNew      = "0"
+	statusPartial  = "1"
+	statusFilled   = "2"
+	statusCanceled = "4"
+	statusRejected = "8"
+	statusExpired  = "C"
+
+	ordRejUnknownSymbol  = "1"
+	ordRejDuplicateOrder = "6"
+	ordRejOther          = "99"
+
+	cxlRejUnknownOrder = "1"
+	cxlRejOther        = "99"
+	cxlRejToCancel     = "1"
+
+	sessionRejRequiredTagMissing = "1"
+	sessionRejIncorrectFormat    = "6"
+	sessionRejInvalidMsgType     = "11"
+	sessionRejOther              = "99"
+
+	bizRejUnsupportedMsgType = "3"
+)
+
+// Gateway represents a FIX gateway for a set of an exchange's traders.
+type Gateway struct {
+	exchange *exchange.Exchange
+	// items are the items of the exchange's markets, by name and ID.
+	items map[string]trade.Item
+	// slots are the traders that clients may drive, by configuration ID.
+	slots map[string]*slot
+}
+
+// slot represents a trader and the state of its FIX session.
+type slot struct {
+	compID string
+	trader *trade.Trader
+	// mu guards session.
+	mu      sync.Mutex
+	session *session
+
+	// The remaining fields are only accessed by the connected session.
+
+	// inSeq is the next expected sequence number,
+	// and outSeq is the next sent one.
+	inSeq, outSeq int
+	// sent are the sent messages by sequence number, for resends.
+	sent map[int]Message
+	// orders are every order by ClOrdID, and byRequest are the live
+	// orders by the ID of their request.
+	orders    map[string]*order
+	byRequest map[uuid.UUID]*order
+	// cancels are the ClOrdIDs of the cancels sent to the
+	// exchange that it hasn't answered, by cancel ID.
+	cancels map[uuid.UUID]cancelRequest
+	execID  int
+}
+
+// order represents an order of a client.
+type order struct {
+	clOrdID string
+	// orderID is the ID the exchange assigned the
+	// order, or NONE until the exchange reports it.
+	orderID string
+	symbol  string
+	side    string
+	ordType string
+	qty     float64
+	price   float64
+	status  string
+	cum     float64
+	leaves  float64
+	expired bool
+	// notional is the sum of the price times quantity of every fill.
+	notional float64
+	// request is the request of the order placed with the exchange.
+	request trade.Request
+}
+
+// cancelRequest represents the ClOrdID of an OrderCancelRequest,
+// and the ClOrdID of the order it cancels.
+type cancelRequest struct {
+	clOrdID     string
+	origClOrdID string
+}
+
+// inbound represents a message read from a client, and the error
+// wrapping ErrInvalid or ErrMalformed it was read with, if any.
+type inbound struct {
+	m   Message
+	err error
+}
+
+// delivery represents a message delivered to a trader.
+type delivery struct {
+	responses trade.Responses
+	report    *trade.ExecutionReport
+}
+
+// session represents the connection of a logged on client.
+type session struct {
+	g  *Gateway
+	sl *slot
+	c  net.Conn
+	w  *bufio.Writer
+	// delivered receives the messages delivered to the trader.
+	delivered chan delivery
+	heartbeat time.Duration
+	lastRecv  time.Time
+	lastSent  time.Time
+	// testReqID is the ID of an unanswered test request, if any.
+	testReqID string
+}
+
+// NewGateway returns a gateway of the provided exchange for the provided
+// traders, by configuration ID. The traders must be simulated as external
+// traders.
+func NewGateway(e *exchange.Exchange, traders map[string]*trade.Trader) *Gateway {
+	g := &Gateway{
+		exchange: e,
+		items:    make(map[string]trade.Item),
+		slots:    make(map[string]*slot, len(traders)),
+	}
+	for _, m := range e.Markets {
+		g.items[m.Item.Name] = m.Item
+		g.items[m.Item.ID.String()] = m.Item
+	}
+	for id, t := range traders {
+		g.slots[id] = &slot{
+			compID:    id,
+			trader:    t,
+			inSeq:     1,
+			outSeq:    1,
+			sent:      make(map[int]Message),
+			orders:    make(map[string]*order),
+			byRequest: make(map[uuid.UUID]*order),
+			cancels:   make(map[uuid.UUID]cancelRequest),
+		}
+	}
+	return g
+}
+
+// Serve accepts clients on the provided listener until
+// the provided context is done.
+func (g *Gateway) Serve(ctx context.Context, ln net.Listener) error {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for _, sl := range g.slots {
+		_sl := sl
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_sl.deliver(ctx)
+		}()
+	}
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("%w: %v", ErrFIX, err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			g.handle(ctx, c)
+		}()
+	}
+}
+
+// deliver forwards the messages delivered to the slot's trader to its
+// session, or discards them if it has none, until the context is done.
+func (sl *slot) deliver(ctx context.Context) {
+	t := sl.trader
+	for {
+		var d delivery
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.RequestRecv:
+			// Sessions don't quote the requests of other traders.
+			continue
+		case resps := <-t.ResponseRecv:
+			d.responses = resps
+		case rep := <-t.ReportRecv:
+			d.report = &rep
+		}
+		sl.mu.Lock()
+		if s := sl.session; s != nil {
+			select {
+			case s.delivered <- d:
+			default:
+			}
+		}
+		sl.mu.Unlock()
+	}
+}
+
+// handle serves the client of the provided connection until
+// it logs out, disconnects or the provided context is done.
+func (g *Gateway) handle(ctx context.Context, c net.Conn) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+	c.SetReadDeadline(time.Now().Add(logonTimeout))
+	m, err := ReadMessage(r)
+	switch {
+	case errors.Is(err, ErrMalformed), errors.Is(err, ErrInvalid):
+		refuse(c, m, err.Error())
+		return
+	case err != nil:
+		return
+	case m.Type() != MsgLogon:
+		refuse(c, m, "first message isn't a logon")
+		return
+	}
+	c.SetReadDeadline(time.Time{})
+	sender, _ := m.Get(TagSenderCompID)
+	target, _ := m.Get(TagTargetCompID)
+	sl, ok := g.slots[sender]
+	heartbeat := m.Int(TagHeartBtInt)
+	s := &session{
+		g:         g,
+		sl:        sl,
+		c:         c,
+		w:         bufio.NewWriter(c),
+		delivered: make(chan delivery, deliveryBuffer),
+		heartbeat: time.Duration(heartbeat) * time.Second,
+	}
+	if !ok || target != CompID || heartbeat <= 0 {
+		refuse(c, m, fmt.Sprintf("unknown SenderCompID or TargetCompID, or HeartBtInt not positive: %s %s %d", sender, target, heartbeat))
+		return
+	}
+	if !sl.claim(s) {
+		refuse(c, m, fmt.Sprintf("already logged on: %s", sender))
+		return
+	}
+	defer sl.release(s)
+	s.run(ctx, r, m)
+}
+
+// refuse writes a Logout with the provided text to the client of the
+// provided connection, whose provided message wasn't a valid logon.
+// Without a session there's no sequence to number the Logout in, so
+// it's written with the first sequence number.
+func refuse(c net.Conn, m Message, text string) {
+	sender, _ := m.Get(TagSenderCompID)
+	logout := NewMessage(MsgLogout,
+		TagSenderCompID, CompID,
+		TagTargetCompID, sender,
+		TagMsgSeqNum, 1,
+		TagSendingTime, time.Now().UTC().Format(timestampLayout),
+		TagText, text)
+	c.SetWriteDeadline(time.Now().Add(writeTimeout))
+	c.Write(logout.Encode())
+}
+
+// claim connects the provided session to the slot,
+// unless another session is connected.
+func (sl *slot) claim(s *session) bool {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	if sl.session != nil {
+		return false
+	}
+	sl.session = s
+	return true
+}
+
+// release disconnects the provided session from the slot.
+func (sl *slot) release(s *session) {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	if sl.session == s {
+		sl.session = nil
+	}
+}
+
+// run runs the session, beginning with the provided logon message.
+func (s *session) run(ctx context.Context, r *bufio.Reader, logon Message) {
+	defer s.w.Flush()
+	defer s.cancelAll(ctx)
+
+	received := make(chan inbound)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		defer close(received)
+		for {
+			m, err := ReadMessage(r)
+			if err != nil && !errors.Is(err, ErrInvalid) && !errors.Is(err, ErrMalformed) {
+				return
+			}
+			select {
+			case received <- inbound{m: m, err: err}:
+			case <-done:
+				return
+			}
+			// The reader can't be read past a malformed message.
+			if errors.Is(err, ErrMalformed) {
+				return
+			}
+		}
+	}()
+
+	s.lastRecv = time.Now()
+	if !s.receive(ctx, inbound{m: logon}) {
+		return
+	}
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			s.send(NewMessage(MsgLogout, TagText, "gateway stopped"))
+			return
+		case in, ok := <-received:
+			if !ok || !s.receive(ctx, in) {
+				return
+			}
+		case d := <-s.delivered:
+			if d.report != nil {
+				s.execute(*d.report)
+			} else {
+				s.choose(ctx, d.responses)
+			}
+		case now := <-ticker.C:
+			if !s.keepAlive(now) {
+				return
+			}
+		}
+		if err := s.w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// keepAlive sends a heartbeat if nothing was sent for the heartbeat
+// interval, and a test request if nothing was received for it, and
+// returns whether the session is alive.
+func (s *session) keepAlive(now time.Time) bool {
+	if now.Sub(s.lastSent) >= s.heartbeat {
+		s.send(NewMessage(MsgHeartbeat))
+	}
+	silence := now.Sub(s.lastRecv)
+	if s.testReqID != "" && silence >= 2*s.heartbeat+s.heartbeat/5 {
+		return false
+	}
+	if s.testReqID == "" && silence >= s.heartbeat+s.heartbeat/5 {
+		s.testReqID = strconv.FormatInt(now.UnixNano(), 10)
+		s.send(NewMessage(MsgTestRequest, TagTestReqID, s.testReqID))
+	}
+	return true
+}
+
+// receive processes the provided message, or rejects it if it's
+// invalid, and returns whether the session continues.
+func (s *session) receive(ctx context.Context, in inbound) bool {
+	if errors.Is(in.err, ErrMalformed) {
+		s.send(NewMessage(MsgLogout, TagText, in.err.Error()))
+		return false
+	}
+	// Any message answers a test request.
+	s.lastRecv = time.Now()
+	s.testReqID = ""
+	sl, m := s.sl, in.m
+	// An invalid message is sequenced, but its type isn't trusted.
+	msgType := m.Type()
+	if in.err != nil {
+		msgType = ""
+	}
+	if msgType == MsgLogon {
+		if v, _ := m.Get(TagResetSeqNumFlag); v == "Y" {
+			sl.inSeq, sl.outSeq = 1, 1
+			sl.sent = make(map[int]Message)
+		}
+	}
+
+	seq := m.Int(TagMsgSeqNum)
+	if msgType == MsgSequenceReset {
+		if next := m.Int(TagNewSeqNo); next > sl.inSeq {
+			sl.inSeq = next
+		}
+		return true
+	}
+	if seq < sl.inSeq {
+		if v, _ := m.Get(TagPossDupFlag); v == "Y" {
+			return true
+		}
+		s.send(NewMessage(MsgLogout, TagText, fmt.Sprintf("MsgSeqNum too low, expecting %d but received %d", sl.inSeq, seq)))
+		return false
+	}
+	if seq > sl.inSeq {
+		if msgType == MsgLogon {
+			s.logon(m)
+		}
+		s.send(NewMessage(MsgResendRequest, TagBeginSeqNo, sl.inSeq, TagEndSeqNo, 0))
+		return true
+	}
+	sl.inSeq++
+	if in.err != nil {
+		s.reject(m, seq, 0, sessionRejOther, in.err.Error())
+		return true
+	}
+
+	switch msgType {
+	case MsgLogon:
+		s.logon(m)
+	case MsgHeartbeat:
+	case MsgTestRequest:
+		id, _ := m.Get(TagTestReqID)
+		s.send(NewMessage(MsgHeartbeat, TagTestReqID, id))
+	case MsgResendRequest:
+		s.resend(m.Int(TagBeginSeqNo), m.Int(TagEndSeqNo))
+	case MsgReject:
+	case MsgLogout:
+		s.send(NewMessage(MsgLogout))
+		return false
+	case MsgNewOrderSingle:
+		if s.check(m, seq) {
+			s.newOrder(ctx, m)
+		}
+	case MsgOrderCancelRequest:
+		if s.check(m, seq) {
+			s.cancel(ctx, m)
+		}
+	case "":
+		s.reject(m, seq, TagMsgType, sessionRejInvalidMsgType, "missing message type")
+	default:
+		s.send(NewMessage(MsgBusinessMessageReject,
+			TagRefSeqNum, seq,
+			TagRefMsgType, msgType,
+			TagBusinessRejectReason, bizRejUnsupportedMsgType,
+			TagText, "unsupported message type"))
+	}
+	return true
+}
+
+// required are the tags that the supported application messages must
+// have, and decimals are the tags whose values must be decimals.
+var (
+	required = map[string][]int{
+		MsgNewOrderSingle:     {TagClOrdID, TagSymbol, TagSide, TagOrderQty, TagOrdType},
+		MsgOrderCancelRequest: {TagClOrdID, TagOrigClOrdID},
+	}
+	decimals = []int{TagOrderQty, TagPrice}
+)
+
+// check rejects the provided message with the provided sequence number
+// if it's missing a required tag or has a decimal tag whose value isn't
+// a decimal, and returns whether it's valid.
+func (s *session) check(m Message, seq int) bool {
+	for _, tag := range required[m.Type()] {
+		if v, _ := m.Get(tag); v == "" {
+			s.reject(m, seq, tag, sessionRejRequiredTagMissing, fmt.Sprintf("required tag missing: %d", tag))
+			return false
+		}
+	}
+	for _, tag := range decimals {
+		if _, has := m.Get(tag); !has {
+			continue
+		}
+		if _, ok := m.Float(tag); !ok {
+			s.reject(m, seq, tag, sessionRejIncorrectFormat, fmt.Sprintf("incorrect data format for value: %d", tag))
+			return false
+		}
+	}
+	return true
+}
+
+// reject sends a Reject of the provided message with the provided
+// sequence number, with the provided reason and text, and referring
+// to the tag with the provided number if it isn't 0.
+func (s *session) reject(m Message, seq, tag int, reason, text string) {
+	r := NewMessage(MsgReject, TagRefSeqNum, seq)
+	if msgType := m.Type(); msgType != "" {
+		r = r.Set(TagRefMsgType, msgType)
+	}
+	if tag != 0 {
+		r = r.Set(TagRefTagID, strconv.Itoa(tag))
+	}
+	s.send(r.Set(TagSessionRejectReason, reason).Set(TagText, text))
+}
+
+func (s *session) logon(m Message) {
+	reply := NewMessage(MsgLogon, TagEncryptMethod, 0, TagHeartBtInt, int(s.heartbeat/time.Second))
+	if v, _ := m.Get(TagResetSeqNumFlag); v == "Y" {
+		reply = reply.Set(TagResetSeqNumFlag, "Y")
+	}
+	s.send(reply)
+}
+
+// send stamps the provided message with the session's header,
+// and writes it to the client.
+func (s *session) send(m Message) {
+	sl := s.sl
+	now := time.Now().UTC()
+	m = m.Set(TagSenderCompID, CompID).
+		Set(TagTargetCompID, sl.compID).
+		Set(TagMsgSeqNum, strconv.Itoa(sl.outSeq)).
+		Set(TagSendingTime, now.Format(timestampLayout))
+	sl.sent[sl.outSeq] = m
+	sl.outSeq++
+	s.write(m)
+}
+
+// write writes the provided message to the client without sequencing it.
+func (s *session) write(m Message) {
+	s.lastSent = time.Now()
+	s.c.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if _, err := s.w.Write(m.Encode()); err != nil {
+		s.c.Close()
+	}
+}
+
+// resend resends the sent messages from the provided begin sequence
+// number to the provided end, or to the last if end is 0. Session
+// messages aren't resent, but skipped with a gap fill.
+func (s *session) resend(begin, end int) {
+	sl := s.sl
+	if end == 0 || end >= sl.outSeq {
+		end = sl.outSeq - 1
+	}
+	gapFrom := 0
+	fill := func(next int) {
+		if gapFrom == 0 {
+			return
+		}
+		s.write(NewMessage(MsgSequenceReset,
+			TagSenderCompID, CompID,
+			TagTargetCompID, sl.compID,
+			TagMsgSeqNum, gapFrom,
+			TagPossDupFlag, "Y",
+			TagSendingTime, time.Now().UTC().Format(timestampLayout),
+			TagGapFillFlag, "Y",
+			TagNewSeqNo, next))
+		gapFrom = 0
+	}
+	for seq := begin; seq <= end; seq++ {
+		m, ok := sl.sent[seq]
+		if !ok || isSession(m.Type()) {
+			if gapFrom == 0 {
+				gapFrom = seq
+			}
+			continue
+		}
+		fill(seq)
+		orig, _ := m.Get(TagSendingTime)
+		dup := append(Message{}, m...)
+		dup = dup.Set(TagPossDupFlag, "Y").
+			Set(TagOrigSendingTime, orig).
+			Set(TagSendingTime, time.Now().UTC().Format(timestampLayout))
+		s.write(dup)
+	}
+	fill(end + 1)
+}
+
+// isSession returns whether the provided message type is a session message.
+func isSession(msgType string) bool {
+	switch msgType {
+	case MsgHeartbeat, MsgTestRequest, MsgResendRequest, MsgReject, MsgSequenceReset, MsgLogout, MsgLogon:
+		return true
+	}
+	return false
+}
+
+// timestampLayout is the layout of FIX UTCTimestamp fields.
+const timestampLayout = "20060102-15:04:05.000"
@@ -0,0 +1,236 @@
+package fix
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"testing"
+	"time"
+	"tradesim/src/exchange"
+	"tradesim/src/trade"
+
+	"github.com/google/uuid"
+)
+
+// client represents the connection of a test FIX client.
+type client struct {
+	t   *testing.T
+	c   net.Conn
+	r   *bufio.Reader
+	seq int
+}
+
+func dial(t *testing.T, addr string) *client {
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	return &client{t: t, c: c, r: bufio.NewReader(c), seq: 1}
+}
+
+// send sends the provided message with the next sequence number.
+func (c *client) send(m Message) {
+	c.sendSeq(m, c.seq)
+	c.seq++
+}
+
+func (c *client) sendSeq(m Message, seq int) {
+	m = m.Set(TagSenderCompID, "1").
+		Set(TagTargetCompID, CompID).
+		Set(TagMsgSeqNum, formatFloat(float64(seq))).
+		Set(TagSendingTime, time.Now().UTC().Format(timestampLayout))
+	if _, err := c.c.Write(m.Encode()); err != nil {
+		c.t.Fatalf("send %s: %v", m.Type(), err)
+	}
+}
+
+// recv returns the next message of the provided type, skipping heartbeats.
+func (c *client) recv(msgType string) Message {
+	for {
+		m, err := ReadMessage(c.r)
+		if err != nil {
+			c.t.Fatalf("recv %s: %v", msgType, err)
+		}
+		if m.Type() == MsgHeartbeat && msgType != MsgHeartbeat {
+			continue
+		}
+		if m.Type() != msgType {
+			c.t.Fatalf("recv: expected: %s actual: %v", msgType, m)
+		}
+		return m
+	}
+}
+
+func expectField(t *testing.T, name string, m Message, tag int, expected string) {
+	t.Helper()
+	if actual, _ := m.Get(tag); expected != actual {
+		t.Errorf("%s: tag %d: expected: %s actual: %s", name, tag, expected, actual)
+	}
+}
+
+// TestGateway asserts that a FIX client logs on, is sequenced, and
+// trades as its trader, receiving execution reports of its orders.
+func TestGateway(t *testing.T) {
+	item := trade.NewItem("a")
+	buyer, err := trade.NewTrader(nil, nil, nil)
+	if err != nil {
+		t.Fatalf("new trader: %v", err)
+	}
+	seller, err := trade.NewTrader([]trade.Have{{Item: item, Price: 2.5, Quantity: 5}}, nil, nil)
+	if err != nil {
+		t.Fatalf("new trader: %v", err)
+	}
+	e := exchange.NewExchange([]exchange.Market{exchange.NewMarket(item, buyer, seller)})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// The buyer is driven by the FIX client, and the seller by the test.
+	sim, err := exchange.NewSimulation(e, nil, []*trade.Trader{buyer, seller}, nil, time.Now())
+	if err != nil {
+		t.Fatalf("new simulation: %v", err)
+	}
+	go sim.Run(ctx, 0)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	served := make(chan error)
+	go func() { served <- NewGateway(e, map[string]*trade.Trader{"1": buyer}).Serve(ctx, ln) }()
+
+	c := dial(t, ln.Addr().String())
+	defer c.c.Close()
+	c.send(NewMessage(MsgLogon, TagEncryptMethod, 0, TagHeartBtInt, 30, TagResetSeqNumFlag, "Y"))
+	logon := c.recv(MsgLogon)
+	expectField(t, "logon", logon, TagMsgSeqNum, "1")
+	expectField(t, "logon", logon, TagHeartBtInt, "30")
+
+	c.send(NewMessage(MsgTestRequest, TagTestReqID, "ping"))
+	expectField(t, "test request", c.recv(MsgHeartbeat), TagTestReqID, "ping")
+
+	// A gap is discarded, and a resend of it is requested.
+	c.sendSeq(NewMessage(MsgTestRequest, TagTestReqID, "gap"), c.seq+2)
+	resend := c.recv(MsgResendRequest)
+	expectField(t, "resend request", resend, TagBeginSeqNo, formatFloat(float64(c.seq)))
+	expectField(t, "resend request", resend, TagEndSeqNo, "0")
+
+	c.send(NewMessage(MsgNewOrderSingle, TagClOrdID, "x", TagSymbol, "b", TagSide, sideBuy, TagOrderQty, 1, TagOrdType, ordTypeMarket))
+	rejected := c.recv(MsgExecutionReport)
+	expectField(t, "unknown symbol", rejected, TagExecType, execRejected)
+	expectField(t, "unknown symbol", rejected, TagOrdRejReason, ordRejUnknownSymbol)
+
+	// The seller answers the buy order's request, whose quote
+	// is chosen up to the order's quantity.
+	c.send(NewMessage(MsgNewOrderSingle, TagClOrdID, "b1", TagSymbol, "a", TagSide, sideBuy, TagOrderQty, 2, TagOrdType, ordTypeLimit, TagPrice, 3))
+	expectField(t, "new", c.recv(MsgExecutionReport), TagExecType, execNew)
+	r := <-seller.RequestRecv
+	quote := trade.Response{ID: uuid.New(), Request: r, TraderID: seller.ID}
+	quote.OrderBook.Ask.Item, quote.OrderBook.Ask.Price, quote.OrderBook.Ask.Quantity = item, 2.5, 5
+	seller.SignQuote(&quote)
+	seller.ResponseSend <- quote
+	filled := c.recv(MsgExecutionReport)
+	expectField(t, "fill", filled, TagClOrdID, "b1")
+	expectField(t, "fill", filled, TagExecType, execTrade)
+	expectField(t, "fill", filled, TagOrdStatus, statusFilled)
+	expectField(t, "fill", filled, TagLastQty, "2")
+	expectField(t, "fill", filled, TagLastPx, "2.5")
+	expectField(t, "fill", filled, TagLeavesQty, "0")
+
+	c.send(NewMessage(MsgOrderCancelRequest, TagClOrdID, "c1", TagOrigClOrdID, "b1", TagSide, sideBuy))
+	expectField(t, "cancel filled", c.recv(MsgOrderCancelReject), TagCxlRejReason, cxlRejUnknownOrder)
+
+	// The seller bids for the sell order, whose fill is chosen at the bid.
+	c.send(NewMessage(MsgNewOrderSingle, TagClOrdID, "s0", TagSymbol, "a", TagSide, sideSell, TagOrderQty, 1, TagOrdType, ordTypeLimit, TagPrice, 2))
+	expectField(t, "new", c.recv(MsgExecutionReport), TagExecType, execNew)
+	r = <-seller.RequestRecv
+	bid := trade.Response{ID: uuid.New(), Request: r, TraderID: seller.ID}
+	bid.OrderBook.Bid.Item, bid.OrderBook.Bid.Price, bid.OrderBook.Bid.Quantity = item, 2.25, 3
+	seller.SignQuote(&bid)
+	seller.ResponseSend <- bid
+	sold := c.recv(MsgExecutionReport)
+	expectField(t, "sell fill", sold, TagClOrdID, "s0")
+	expectField(t, "sell fill", sold, TagOrdStatus, statusFilled)
+	expectField(t, "sell fill", sold, TagLastPx, "2.25")
+
+	c.send(NewMessage(MsgNewOrderSingle, TagClOrdID, "t1", TagSymbol, "a", TagSide, sideSell, TagOrderQty, 1, TagOrdType, ordTypeMarket, TagTimeInForce, "6"))
+	expectField(t, "unsupported time in force", c.recv(MsgExecutionReport), TagExecType, execRejected)
+
+	// A good-till-cancel sell order rests with the exchange until it's canceled.
+	c.send(NewMessage(MsgNewOrderSingle, TagClOrdID, "s1", TagSymbol, "a", TagSide, sideSell, TagOrderQty, 1, TagOrdType, ordTypeLimit, TagPrice, 4, TagTimeInForce, tifGTC))
+	expectField(t, "new", c.recv(MsgExecutionReport), TagExecType, execNew)
+	c.send(NewMessage(MsgOrderCancelRequest, TagClOrdID, "c2", TagOrigClOrdID, "s1", TagSide, sideSell))
+	canceled := c.recv(MsgExecutionReport)
+	expectField(t, "cancel", canceled, TagExecType, execCanceled)
+	expectField(t, "cancel", canceled, TagOrdStatus, statusCanceled)
+	expectField(t, "cancel", canceled, TagClOrdID, "c2")
+	expectField(t, "cancel", canceled, TagOrigClOrdID, "s1")
+
+	c.send(NewMessage("Z"))
+	unsupported := c.recv(MsgBusinessMessageReject)
+	expectField(t, "unsupported message", unsupported, TagRefMsgType, "Z")
+	expectField(t, "unsupported message", unsupported, TagBusinessRejectReason, bizRejUnsupportedMsgType)
+
+	// Invalid messages are rejected, and the session continues.
+	c.send(NewMessage(MsgNewOrderSingle, TagSymbol, "a", TagSide, sideBuy, TagOrderQty, 1, TagOrdType, ordTypeMarket))
+	missing := c.recv(MsgReject)
+	expectField(t, "missing tag", missing, TagSessionRejectReason, sessionRejRequiredTagMissing)
+	expectField(t, "missing tag", missing, TagRefTagID, "11")
+	c.send(NewMessage(MsgNewOrderSingle, TagClOrdID, "q", TagSymbol, "a", TagSide, sideBuy, TagOrderQty, "x", TagOrdType, ordTypeMarket))
+	format := c.recv(MsgReject)
+	expectField(t, "incorrect format", format, TagSessionRejectReason, sessionRejIncorrectFormat)
+	expectField(t, "incorrect format", format, TagRefTagID, "38")
+	corrupt := NewMessage(MsgTestRequest, TagTestReqID, "corrupt").
+		Set(TagSenderCompID, "1").
+		Set(TagTargetCompID, CompID).
+		Set(TagMsgSeqNum, formatFloat(float64(c.seq))).
+		Encode()
+	c.seq++
+	corrupt[len(corrupt)-2]++
+	if _, err := c.c.Write(corrupt); err != nil {
+		t.Fatalf("send corrupt: %v", err)
+	}
+	checksum := c.recv(MsgReject)
+	expectField(t, "checksum", checksum, TagRefSeqNum, formatFloat(float64(c.seq-1)))
+	expectField(t, "checksum", checksum, TagSessionRejectReason, sessionRejOther)
+	c.send(NewMessage(MsgTestRequest, TagTestReqID, "after"))
+	expectField(t, "after reject", c.recv(MsgHeartbeat), TagTestReqID, "after")
+
+	c.send(NewMessage(MsgLogout))
+	c.recv(MsgLogout)
+
+	cancel()
+	if err := <-served; err != nil {
+		t.Errorf("serve: %v", err)
+	}
+}
+
+// TestGatewayRefuse asserts that a client that doesn't log on, or whose
+// messages can't be delimited, is sent a Logout stating why.
+func TestGatewayRefuse(t *testing.T) {
+	item := trade.NewItem("a")
+	tr, err := trade.NewTrader(nil, nil, nil)
+	if err != nil {
+		t.Fatalf("new trader: %v", err)
+	}
+	e := exchange.NewExchange([]exchange.Market{exchange.NewMarket(item, tr)})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	go NewGateway(e, map[string]*trade.Trader{"1": tr}).Serve(ctx, ln)
+
+	c := dial(t, ln.Addr().String())
+	c.send(NewMessage(MsgTestRequest, TagTestReqID, "ping"))
+	c.recv(MsgLogout)
+	c.c.Close()
+
+	c = dial(t, ln.Addr().String())
+	defer c.c.Close()
+	c.send(NewMessage(MsgLogon, TagEncryptMethod, 0, TagHeartBtInt, 30, TagResetSeqNumFlag, "Y"))
+	c.recv(MsgLogon)
+	if _, err := c.c.Write([]byte("8=FIX.4.4\x019=x\x01")); err != nil {
+		t.Fatalf("send malformed: %v", err)
+	}
+	c.recv(MsgLogout)
+}
@@ -0,0 +1,293 @@
+package fix
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// BeginString is the FIX version of every message.
+const BeginString = "FIX.4.4"
+
+// soh delimits the fields of a message.
+const soh = '\x01'
+
+// maxBodyLength bounds the body length of a message,
+// so that a corrupt length isn't allocated.
+const maxBodyLength = 1 << 16
+
+// maxFieldLength bounds the length of the header and trailer fields of a
+// message, which are read up to their delimiter, so that a client that
+// never sends one isn't buffered without bound.
+const maxFieldLength = 32
+
+var (
+	ErrFIX       = errors.New("failed to serve fix session")
+	ErrMalformed = errors.New("malformed fix message")
+	ErrInvalid   = errors.New("invalid fix message")
+)
+
+// Tags of the fields used by the gateway.
+const (
+	TagAvgPx                = 6
+	TagBeginSeqNo           = 7
+	TagBeginString          = 8
+	TagBodyLength           = 9
+	TagCheckSum             = 10
+	TagClOrdID              = 11
+	TagCumQty               = 14
+	TagEndSeqNo             = 16
+	TagExecID               = 17
+	TagLastPx               = 31
+	TagLastQty              = 32
+	TagMsgSeqNum            = 34
+	TagMsgType              = 35
+	TagNewSeqNo             = 36
+	TagOrderID              = 37
+	TagOrderQty             = 38
+	TagOrdStatus            = 39
+	TagOrdType              = 40
+	TagOrigClOrdID          = 41
+	TagPossDupFlag          = 43
+	TagPrice                = 44
+	TagRefSeqNum            = 45
+	TagSenderCompID         = 49
+	TagSendingTime          = 52
+	TagSide                 = 54
+	TagSymbol               = 55
+	TagTargetCompID         = 56
+	TagText                 = 58
+	TagTimeInForce          = 59
+	TagTransactTime         = 60
+	TagEncryptMethod        = 98
+	TagCxlRejReason         = 102
+	TagOrdRejReason         = 103
+	TagHeartBtInt           = 108
+	TagTestReqID            = 112
+	TagOrigSendingTime      = 122
+	TagGapFillFlag          = 123
+	TagResetSeqNumFlag      = 141
+	TagExecType             = 150
+	TagLeavesQty            = 151
+	TagRefTagID             = 371
+	TagRefMsgType           = 372
+	TagSessionRejectReason  = 373
+	TagBusinessRejectReason = 380
+	TagCxlRejResponseTo     = 434
+)
+
+// Message types used by the gateway.
+const (
+	MsgHeartbeat             = "0"
+	MsgTestRequest           = "1"
+	MsgResendRequest         = "2"
+	MsgReject                = "3"
+	MsgSequenceReset         = "4"
+	MsgLogout                = "5"
+	MsgExecutionReport       = "8"
+	MsgOrderCancelReject     = "9"
+	MsgLogon                 = "A"
+	MsgNewOrderSingle        = "D"
+	MsgOrderCancelRequest    = "F"
+	MsgBusinessMessageReject = "j"
+)
+
+// Field represents a tag and value pair of a message.
+type Field struct {
+	Tag   int
+	Value string
+}
+
+// Message represents the body of a FIX message, in order, starting with
+// its message type. The begin string, body length and checksum are added
+// when the message is encoded, and removed when it's read.
+type Message []Field
+
+// NewMessage returns a message of the provided type with the provided
+// fields, which are alternating tags and values.
+func NewMessage(msgType string, fields ...interface{}) Message {
+	m := Message{{Tag: TagMsgType, Value: msgType}}
+	for i := 0; i+1 < len(fields); i += 2 {
+		m = m.Set(fields[i].(int), fmt.Sprint(fields[i+1]))
+	}
+	return m
+}
+
+// Type returns the message type of the message.
+func (m Message) Type() string {
+	v, _ := m.Get(TagMsgType)
+	return v
+}
+
+// Get returns the value of the first field with the provided tag,
+// and whether the message has one.
+func (m Message) Get(tag int) (string, bool) {
+	for _, f := range m {
+		if f.Tag == tag {
+			return f.Value, true
+		}
+	}
+	return "", false
+}
+
+// Int returns the integer value of the field with the provided tag,
+// or 0 if the message has none or its value isn't an integer.
+func (m Message) Int(tag int) int {
+	v, _ := m.Get(tag)
+	n, _ := strconv.Atoi(v)
+	return n
+}
+
+// Float returns the decimal value of the field with the provided tag,
+// and whether the message has one with a valid value.
+func (m Message) Float(tag int) (float64, bool) {
+	v, ok := m.Get(tag)
+	if !ok {
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	return f, err == nil
+}
+
+// Set returns the message with the value of the field with the provided
+// tag replaced, or with the field appended if the message has none.
+func (m Message) Set(tag int, value string) Message {
+	for i := range m {
+		if m[i].Tag == tag {
+			m[i].Value = value
+			return m
+		}
+	}
+	return append(m, Field{Tag: tag, Value: value})
+}
+
+// Encode returns the encoding of the message, with its begin string,
+// body length and checksum.
+func (m Message) Encode() []byte {
+	var body bytes.Buffer
+	for _, f := range m {
+		body.WriteString(strconv.Itoa(f.Tag))
+		body.WriteByte('=')
+		body.WriteString(f.Value)
+		body.WriteByte(soh)
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%d=%s%c%d=%d%c", TagBeginString, BeginString, soh, TagBodyLength, body.Len(), soh)
+	b.Write(body.Bytes())
+	fmt.Fprintf(&b, "%d=%03d%c", TagCheckSum, checksum(b.Bytes()), soh)
+	return b.Bytes()
+}
+
+// ReadMessage reads the next message from the provided reader,
+// verifying its begin string, body length and checksum.
+//
+// A message whose begin string or body length is malformed can't be
+// delimited, so the reader can't be read past it. A message that's
+// delimited but whose checksum or body fields are invalid is read whole,
+// and returned with the fields that could be parsed, and an error
+// wrapping ErrInvalid, so that it can be rejected.
+func ReadMessage(r *bufio.Reader) (Message, error) {
+	var raw bytes.Buffer
+	begin, err := readField(r, &raw)
+	if err != nil {
+		return nil, err
+	}
+	if begin.Tag != TagBeginString || begin.Value != BeginString {
+		return nil, fmt.Errorf("%w: begin string: expected=%s got=%d=%s", ErrMalformed, BeginString, begin.Tag, begin.Value)
+	}
+	length, err := readField(r, &raw)
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	n, err := strconv.Atoi(length.Value)
+	if length.Tag != TagBodyLength || err != nil || n <= 0 || n > maxBodyLength {
+		return nil, fmt.Errorf("%w: body length: got=%d=%s", ErrMalformed, length.Tag, length.Value)
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	raw.Write(body)
+	sum := checksum(raw.Bytes())
+	trailer, err := readField(r, nil)
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if trailer.Tag != TagCheckSum {
+		return nil, fmt.Errorf("%w: body length doesn't end at checksum", ErrMalformed)
+	}
+
+	var m Message
+	var invalid error
+	for _, f := range bytes.Split(bytes.TrimSuffix(body, []byte{soh}), []byte{soh}) {
+		field, err := parseField(f)
+		if err != nil {
+			if invalid == nil {
+				invalid = fmt.Errorf("%w: %v", ErrInvalid, err)
+			}
+			continue
+		}
+		m = append(m, field)
+	}
+	if v, err := strconv.Atoi(trailer.Value); err != nil || v != sum {
+		return m, fmt.Errorf("%w: checksum: expected=%03d got=%s", ErrInvalid, sum, trailer.Value)
+	}
+	if invalid != nil {
+		return m, invalid
+	}
+	if len(m) == 0 || m[0].Tag != TagMsgType {
+		return m, fmt.Errorf("%w: message type isn't the first body field", ErrInvalid)
+	}
+	return m, nil
+}
+
+// readField reads the next field, writing its raw bytes to raw if not nil.
+// A field longer than maxFieldLength is malformed.
+func readField(r *bufio.Reader, raw *bytes.Buffer) (Field, error) {
+	var b []byte
+	for len(b) == 0 || b[len(b)-1] != soh {
+		if len(b) == maxFieldLength {
+			return Field{}, fmt.Errorf("%w: field longer than %d bytes: %q", ErrMalformed, maxFieldLength, b)
+		}
+		c, err := r.ReadByte()
+		if err != nil {
+			return Field{}, err
+		}
+		b = append(b, c)
+	}
+	if raw != nil {
+		raw.Write(b)
+	}
+	return parseField(b[:len(b)-1])
+}
+
+func parseField(b []byte) (Field, error) {
+	i := bytes.IndexByte(b, '=')
+	if i < 0 {
+		return Field{}, fmt.Errorf("%w: field without value: %q", ErrMalformed, b)
+	}
+	tag, err := strconv.Atoi(string(b[:i]))
+	if err != nil || tag <= 0 {
+		return Field{}, fmt.Errorf("%w: field tag: %q", ErrMalformed, b[:i])
+	}
+	return Field{Tag: tag, Value: string(b[i+1:])}, nil
+}
+
+// checksum returns the sum of the provided bytes modulo 256.
+func checksum(b []byte) int {
+	sum := 0
+	for _, c := range b {
+		sum += int(c)
+	}
+	return sum % 256
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
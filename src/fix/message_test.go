@@ -0,0 +1,79 @@
+package fix
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// TestMessageRoundTrip asserts that an encoded message
+// is read back with the same fields, in order.
+func TestMessageRoundTrip(t *testing.T) {
+	m := NewMessage(MsgNewOrderSingle,
+		TagClOrdID, "1",
+		TagSymbol, "a",
+		TagSide, sideBuy,
+		TagOrderQty, 2.5)
+	r := bufio.NewReader(bytes.NewReader(append(m.Encode(), m.Encode()...)))
+	for i := 0; i < 2; i++ {
+		actual, err := ReadMessage(r)
+		if err != nil {
+			t.Fatalf("read %d: %v", i, err)
+		}
+		if len(actual) != len(m) {
+			t.Fatalf("read %d: fields: expected: %v actual: %v", i, m, actual)
+		}
+		for j := range m {
+			if m[j] != actual[j] {
+				t.Errorf("read %d: field %d: expected: %v actual: %v", i, j, m[j], actual[j])
+			}
+		}
+	}
+	if _, err := ReadMessage(r); err != io.EOF {
+		t.Errorf("read past end: expected: %v actual: %v", io.EOF, err)
+	}
+}
+
+// TestMessageEncode asserts that a message is encoded
+// with its body length and checksum.
+func TestMessageEncode(t *testing.T) {
+	expected := "8=FIX.4.4|9=5|35=0|10=163|"
+	actual := strings.ReplaceAll(string(NewMessage(MsgHeartbeat).Encode()), "\x01", "|")
+	if expected != actual {
+		t.Errorf("encoding: expected: %s actual: %s", expected, actual)
+	}
+}
+
+// TestReadMalformed asserts that malformed messages are rejected, and
+// that invalid messages are read whole with the fields that parse.
+func TestReadMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		err  error
+	}{
+		{name: "begin string", raw: "8=FIX.4.2|9=5|35=0|10=159|", err: ErrMalformed},
+		{name: "body length", raw: "8=FIX.4.4|9=x|35=0|10=163|", err: ErrMalformed},
+		{name: "short body length", raw: "8=FIX.4.4|9=4|35=0|10=163|", err: ErrMalformed},
+		{name: "checksum", raw: "8=FIX.4.4|9=5|35=0|10=000|", err: ErrInvalid},
+		{name: "message type", raw: "8=FIX.4.4|9=5|34=1|10=163|", err: ErrInvalid},
+		{name: "field", raw: "8=FIX.4.4|9=8|35=0|x1|10=080|", err: ErrInvalid},
+		{name: "truncated", raw: "8=FIX.4.4|9=5|35=", err: io.ErrUnexpectedEOF},
+		{name: "unbounded field", raw: "8=FIX.4.4" + strings.Repeat("4", maxFieldLength), err: ErrMalformed},
+	}
+	for _, test := range tests {
+		r := bufio.NewReader(strings.NewReader(strings.ReplaceAll(test.raw, "|", "\x01")))
+		if _, err := ReadMessage(r); !errors.Is(err, test.err) {
+			t.Errorf("%s: expected: %v actual: %v", test.name, test.err, err)
+		}
+		// An invalid message is read whole.
+		if test.err == ErrInvalid {
+			if _, err := ReadMessage(r); err != io.EOF {
+				t.Errorf("%s: read past end: expected: %v actual: %v", test.name, io.EOF, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,312 @@
+package fix
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+	"tradesim/src/trade"
+
+	"github.com/google/uuid"
+)
+
+// sides, ordTypes and timesInForce map the values of the Side, OrdType and
+// TimeInForce fields onto the exchange's. An order without a TimeInForce
+// is immediate-or-cancel.
+var (
+	sides = map[string]trade.Side{
+		sideBuy:  trade.SideBuy,
+		sideSell: trade.SideSell,
+	}
+	ordTypes = map[string]trade.OrderType{
+		ordTypeMarket: trade.OrderMarket,
+		ordTypeLimit:  trade.OrderLimit,
+	}
+	timesInForce = map[string]trade.TimeInForce{
+		"":     trade.TimeInForceIOC,
+		tifDay: trade.TimeInForceDay,
+		tifGTC: trade.TimeInForceGTC,
+		tifIOC: trade.TimeInForceIOC,
+		tifFOK: trade.TimeInForceFOK,
+	}
+)
+
+// newOrder processes the provided NewOrderSingle message, placing its
+// order with the exchange, which reports on it. An invalid order is
+// rejected without being placed.
+func (s *session) newOrder(ctx context.Context, m Message) {
+	sl := s.sl
+	o := &order{orderID: "NONE", status: statusNew}
+	o.clOrdID, _ = m.Get(TagClOrdID)
+	o.symbol, _ = m.Get(TagSymbol)
+	o.side, _ = m.Get(TagSide)
+	o.ordType, _ = m.Get(TagOrdType)
+	o.qty, _ = m.Float(TagOrderQty)
+	o.price, _ = m.Float(TagPrice)
+	o.leaves = o.qty
+
+	if reason, text := s.validate(m, o); text != "" {
+		o.status = statusRejected
+		o.leaves = 0
+		if _, dup := sl.orders[o.clOrdID]; !dup {
+			sl.orders[o.clOrdID] = o
+		}
+		s.report(o, execRejected, TagOrdRejReason, reason, TagText, text)
+		return
+	}
+	sl.orders[o.clOrdID] = o
+	sl.byRequest[o.request.ID] = o
+	select {
+	case <-ctx.Done():
+	case sl.trader.RequestSend <- o.request:
+	}
+}
+
+// validate resolves the request of the provided order of the provided
+// message, and returns the reason and text of its rejection, if any.
+func (s *session) validate(m Message, o *order) (string, string) {
+	if _, ok := s.sl.orders[o.clOrdID]; ok {
+		return ordRejDuplicateOrder, "duplicate ClOrdID"
+	}
+	item, ok := s.g.items[o.symbol]
+	if !ok {
+		return ordRejUnknownSymbol, fmt.Sprintf("unknown symbol: %s", o.symbol)
+	}
+	if _, ok := s.g.exchange.Markets[item.ID].TraderByID[s.sl.trader.ID]; !ok {
+		return ordRejUnknownSymbol, fmt.Sprintf("trader not in market: %s", o.symbol)
+	}
+	side, ok := sides[o.side]
+	if !ok {
+		return ordRejOther, fmt.Sprintf("unsupported side: %s", o.side)
+	}
+	if o.qty <= 0 || math.IsNaN(o.qty) || math.IsInf(o.qty, 0) {
+		return ordRejOther, "OrderQty must be positive"
+	}
+	ordType, ok := ordTypes[o.ordType]
+	if !ok {
+		return ordRejOther, fmt.Sprintf("unsupported OrdType: %s", o.ordType)
+	}
+	if _, ok := m.Float(TagPrice); ordType == trade.OrderLimit && (!ok || o.price <= 0 || math.IsInf(o.price, 0)) {
+		return ordRejOther, "limit orders must have a positive Price"
+	}
+	v, _ := m.Get(TagTimeInForce)
+	tif, ok := timesInForce[v]
+	if !ok {
+		return ordRejOther, fmt.Sprintf("unsupported TimeInForce: %s", v)
+	}
+	o.request = trade.Request{
+		ID:          uuid.New(),
+		TraderID:    s.sl.trader.ID,
+		Item:        item,
+		Quantity:    o.qty,
+		Side:        side,
+		Type:        ordType,
+		TimeInForce: tif,
+	}
+	if ordType == trade.OrderLimit {
+		o.request.Price = o.price
+	}
+	return "", ""
+}
+
+// cancel processes the provided OrderCancelRequest message, canceling
+// the order of its OrigClOrdID with the exchange, which acknowledges
+// the cancel with an ExecutionReport or rejects it with an
+// OrderCancelReject. A cancel of an order that isn't live is rejected
+// without being sent.
+func (s *session) cancel(ctx context.Context, m Message) {
+	sl := s.sl
+	clOrdID, _ := m.Get(TagClOrdID)
+	origClOrdID, _ := m.Get(TagOrigClOrdID)
+	o, ok := sl.orders[origClOrdID]
+	if !ok || o.leaves <= quantityEpsilon {
+		s.cancelReject(o, clOrdID, origClOrdID, cxlRejUnknownOrder, "no live order found")
+		return
+	}
+	c := trade.Cancel{ID: uuid.New(), TraderID: sl.trader.ID, OrderID: o.request.ID}
+	sl.cancels[c.ID] = cancelRequest{clOrdID: clOrdID, origClOrdID: origClOrdID}
+	select {
+	case <-ctx.Done():
+	case sl.trader.CancelSend <- c:
+	}
+}
+
+// cancelReject sends an OrderCancelReject of the cancel with the provided
+// ClOrdID of the provided order, which may be nil if it's unknown.
+func (s *session) cancelReject(o *order, clOrdID, origClOrdID, reason, text string) {
+	status := statusRejected
+	orderID := "NONE"
+	if o != nil {
+		status, orderID = o.status, o.orderID
+	}
+	s.send(NewMessage(MsgOrderCancelReject,
+		TagOrderID, orderID,
+		TagClOrdID, clOrdID,
+		TagOrigClOrdID, origClOrdID,
+		TagOrdStatus, status,
+		TagCxlRejResponseTo, cxlRejToCancel,
+		TagCxlRejReason, reason,
+		TagText, text))
+}
+
+// cancelAll cancels every live order with the exchange, without
+// reporting it, since the client is disconnected.
+func (s *session) cancelAll(ctx context.Context) {
+	sl := s.sl
+	for _, o := range sl.byRequest {
+		s.close(o, statusCanceled)
+		c := trade.Cancel{ID: uuid.New(), TraderID: sl.trader.ID, OrderID: o.request.ID}
+		select {
+		case <-ctx.Done():
+			return
+		case sl.trader.CancelSend <- c:
+		}
+	}
+}
+
+// close closes the provided live order with the provided status.
+func (s *session) close(o *order, status string) {
+	o.status = status
+	o.leaves = 0
+	delete(s.sl.byRequest, o.request.ID)
+}
+
+// choose chooses every fill the exchange allocated to a live order of the
+// trader whose price the order accepts, and signs every cross of an
+// auction delivered to the trader as the seller of one of its live sell
+// orders, sending it back to the exchange.
+func (s *session) choose(ctx context.Context, resps trade.Responses) {
+	sl := s.sl
+	for _, resp := range resps {
+		var send chan trade.Response
+		switch {
+		case resp.Request.TraderID == sl.trader.ID:
+			o, ok := sl.byRequest[resp.Request.ID]
+			if !ok || !o.request.Accepts(resp.Quote().Price) {
+				continue
+			}
+			sl.trader.SignChoice(&resp)
+			send = sl.trader.Choice
+		case resp.TraderID == sl.trader.ID && s.selling(resp.Request.Item.ID):
+			sl.trader.SignQuote(&resp)
+			send = sl.trader.ResponseSend
+		default:
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case send <- resp:
+		}
+	}
+}
+
+// selling returns whether the trader has a live sell order of the
+// item with the provided ID.
+func (s *session) selling(itemID uuid.UUID) bool {
+	for _, o := range s.sl.byRequest {
+		if o.request.Item.ID == itemID && o.request.Side == trade.SideSell {
+			return true
+		}
+	}
+	return false
+}
+
+// execute reports the provided execution report of a live order of the
+// trader to the client. The reports of orders that aren't live, such as
+// the orders canceled when a previous client disconnected, are discarded.
+func (s *session) execute(rep trade.ExecutionReport) {
+	sl := s.sl
+	o, ok := sl.byRequest[rep.RequestID]
+	if !ok {
+		return
+	}
+	c, answers := sl.cancels[rep.CancelID]
+	if answers {
+		delete(sl.cancels, rep.CancelID)
+	}
+	if rep.Type == trade.ExecCancelRejected {
+		if answers {
+			s.cancelReject(o, c.clOrdID, c.origClOrdID, cxlRejOther, rep.Reason)
+		}
+		return
+	}
+
+	o.orderID = rep.OrderID.String()
+	o.cum, o.leaves = rep.Filled, rep.Leaves
+	if rep.Type == trade.ExecExpired {
+		o.expired = true
+	}
+	switch {
+	case rep.Type == trade.ExecRejected:
+		o.status = statusRejected
+	case o.leaves > quantityEpsilon && o.cum > quantityEpsilon:
+		o.status = statusPartial
+	case o.leaves > quantityEpsilon:
+		o.status = statusNew
+	case rep.Canceled > quantityEpsilon && o.expired:
+		o.status = statusExpired
+	case rep.Canceled > quantityEpsilon:
+		o.status = statusCanceled
+	default:
+		o.status = statusFilled
+	}
+	if o.leaves <= quantityEpsilon {
+		s.close(o, o.status)
+	}
+
+	switch rep.Type {
+	case trade.ExecNew:
+		s.report(o, execNew)
+	case trade.ExecRejected:
+		s.report(o, execRejected, TagOrdRejReason, ordRejOther, TagText, rep.Reason)
+	case trade.ExecTrade:
+		o.notional += rep.LastPrice * rep.LastQuantity
+		s.report(o, execTrade,
+			TagLastPx, formatFloat(rep.LastPrice),
+			TagLastQty, formatFloat(rep.LastQuantity))
+	case trade.ExecCanceled:
+		if answers {
+			s.report(o, execCanceled, TagClOrdID, c.clOrdID, TagOrigClOrdID, c.origClOrdID, TagText, rep.Reason)
+		} else {
+			s.report(o, execCanceled, TagText, rep.Reason)
+		}
+	case trade.ExecExpired:
+		s.report(o, execExpired, TagText, rep.Reason)
+	}
+}
+
+// report sends an ExecutionReport of the provided order with the
+// provided execution type and additional fields.
+func (s *session) report(o *order, execType string, fields ...interface{}) {
+	s.sl.execID++
+	avg := 0.0
+	if o.cum > 0 {
+		avg = o.notional / o.cum
+	}
+	m := NewMessage(MsgExecutionReport,
+		TagOrderID, o.orderID,
+		TagClOrdID, o.clOrdID,
+		TagExecID, s.sl.execID,
+		TagExecType, execType,
+		TagOrdStatus, o.status,
+		TagSymbol, o.symbol,
+		TagSide, o.side,
+		TagOrderQty, formatFloat(o.qty),
+		TagLeavesQty, formatFloat(o.leaves),
+		TagCumQty, formatFloat(o.cum),
+		TagAvgPx, formatFloat(avg),
+		TagTransactTime, time.Now().UTC().Format(timestampLayout))
+	if o.ordType == ordTypeLimit {
+		m = m.Set(TagPrice, formatFloat(o.price))
+	}
+	for i := 0; i+1 < len(fields); i += 2 {
+		m = m.Set(fields[i].(int), fmt.Sprint(fields[i+1]))
+	}
+	s.send(m)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
@@ -0,0 +1,214 @@
+package network
+
+import "time"
+
+// round identifies a round of a height of the BFT protocol.
+type round struct {
+	height, round int
+}
+
+// votes are the nodes voting for each block of a round, by block ID.
+type votes map[round]map[int]map[int]bool
+
+// add adds the vote of the provided node
+// for the provided block in the provided round.
+func (v votes) add(r round, blockID, voter int) {
+	if v[r] == nil {
+		v[r] = make(map[int]map[int]bool)
+	}
+	if v[r][blockID] == nil {
+		v[r][blockID] = make(map[int]bool)
+	}
+	v[r][blockID][voter] = true
+}
+
+// bftState represents the state of a node in the BFT protocol.
+type bftState struct {
+	// height is the height of the next block the node commits,
+	// and round is its current round of the height.
+	height, round int
+	// locked is the block the node precommitted in its height, which
+	// it votes for, and proposes as a leader, in every later round.
+	locked     *block
+	proposals  map[round]*block
+	prevotes   votes
+	precommits votes
+	prevoted   map[round]bool
+	// precommitted are the rounds the node precommitted in.
+	precommitted map[round]bool
+}
+
+// startBFT starts the first round of every live node.
+func (s *simulation) startBFT() {
+	s.committed = make(map[int]*block)
+	s.timeouts = make(map[round]bool)
+	for _, n := range s.nodes {
+		if !n.live {
+			continue
+		}
+		n.bft = &bftState{
+			height:       1,
+			proposals:    make(map[round]*block),
+			prevotes:     make(votes),
+			precommits:   make(votes),
+			prevoted:     make(map[round]bool),
+			precommitted: make(map[round]bool),
+		}
+		s.enterRound(n, 0)
+	}
+}
+
+// quorum returns the number of votes that commit a block, which is
+// more than two thirds of the nodes when at most the tolerated number
+// of them are faulty.
+func (s *simulation) quorum() int {
+	return s.cfg.Nodes - (s.cfg.Nodes-1)/3
+}
+
+// leader returns the index of the leader of the provided round.
+func (s *simulation) leader(r round) int {
+	return (r.height + r.round) % s.cfg.Nodes
+}
+
+// enterRound enters the provided node into the provided round of its
+// height, proposing a block if it leads the round, and timing it out.
+func (s *simulation) enterRound(n *node, r int) {
+	st := n.bft
+	st.round = r
+	curr := round{height: st.height, round: r}
+	// The first round of a height waits for the block interval
+	// before its proposal is due, and later rounds propose at once.
+	due := s.now
+	if r == 0 {
+		due += s.cfg.BlockInterval
+	}
+	if s.leader(curr) == n.index {
+		s.scheduleBounded(due, func() { s.propose(n, curr) })
+	}
+	// Timeouts grow with every round of a height, so that a height
+	// eventually has a round long enough to commit in.
+	s.scheduleBounded(due+s.cfg.RoundTimeout*time.Duration(r+1), func() {
+		if st.height != curr.height || st.round != curr.round {
+			return
+		}
+		if !s.timeouts[curr] {
+			s.timeouts[curr] = true
+			s.report.ViewChanges++
+		}
+		s.enterRound(n, curr.round+1)
+	})
+	s.advance(n)
+}
+
+// propose broadcasts the provided leader's proposal for the provided
+// round, which is its locked block if any, or a new block otherwise.
+func (s *simulation) propose(n *node, r round) {
+	st := n.bft
+	if st.height != r.height || st.round != r.round {
+		return
+	}
+	b := st.locked
+	if b == nil {
+		if b = s.newBlock(n.tip, n, n.pending(s.cfg.BlockSize)); b == nil {
+			return
+		}
+		s.report.Blocks++
+	}
+	s.broadcast(n, func(m *node) {
+		if _, ok := m.bft.proposals[r]; !ok {
+			m.bft.proposals[r] = b
+		}
+		s.advance(m)
+	})
+}
+
+// vote broadcasts the provided node's vote for the provided block
+// in the provided round, to be added to the provided votes of
+// every node.
+func (s *simulation) vote(n *node, r round, b *block, of func(*bftState) votes) {
+	from := n.index
+	s.broadcast(n, func(m *node) {
+		if r.height < m.bft.height {
+			return
+		}
+		of(m.bft).add(r, b.id, from)
+		s.advance(m)
+	})
+}
+
+// advance advances the provided node through the protocol with the
+// proposal and votes it has received for its height.
+func (s *simulation) advance(n *node) {
+	st := n.bft
+	curr := round{height: st.height, round: st.round}
+	if b := st.proposals[curr]; b != nil && !st.prevoted[curr] && (st.locked == nil || st.locked == b) {
+		st.prevoted[curr] = true
+		s.vote(n, curr, b, func(st *bftState) votes { return st.prevotes })
+	}
+	if !st.precommitted[curr] {
+		for id, voters := range st.prevotes[curr] {
+			if len(voters) >= s.quorum() {
+				st.precommitted[curr] = true
+				st.locked = s.blocks[id]
+				s.vote(n, curr, st.locked, func(st *bftState) votes { return st.precommits })
+				break
+			}
+		}
+	}
+	for r, byBlock := range st.precommits {
+		if r.height != st.height {
+			continue
+		}
+		for id, voters := range byBlock {
+			if len(voters) >= s.quorum() {
+				s.commit(n, s.blocks[id])
+				return
+			}
+		}
+	}
+}
+
+// commit commits the provided block to the provided node's blockchain,
+// and enters it into the first round of the next height. A block its
+// blockchain rejects fails the simulation.
+func (s *simulation) commit(n *node, b *block) {
+	st := n.bft
+	if _, err := n.chain.Receive(b.encoded); err != nil {
+		s.fail(err)
+		return
+	}
+	b.seen[n.index] = s.now
+	n.setTip(b)
+	if _, ok := s.committed[b.height]; !ok {
+		s.committed[b.height] = b
+	}
+	st.height++
+	st.locked = nil
+	for r := range st.proposals {
+		if r.height < st.height {
+			delete(st.proposals, r)
+			delete(st.prevoted, r)
+			delete(st.precommitted, r)
+		}
+	}
+	for _, v := range []votes{st.prevotes, st.precommits} {
+		for r := range v {
+			if r.height < st.height {
+				delete(v, r)
+			}
+		}
+	}
+	s.enterRound(n, 0)
+}
+
+// finalizeBFT reports the time to finality of the
+// transactions of the committed chain.
+func (s *simulation) finalizeBFT() {
+	var chain []*block
+	for h := 1; s.committed[h] != nil; h++ {
+		chain = append(chain, s.committed[h])
+	}
+	s.finality(chain, func(height int) (time.Duration, bool) {
+		return s.seenByAll(chain[height-1])
+	})
+}
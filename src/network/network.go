@@ -0,0 +1,548 @@
+// Package network simulates a network of ledger nodes that converge on
+// a blockchain of an exchange's transactions through a consensus rule.
+//
+// A simulation is a discrete-event simulation on a virtual clock, so that
+// it runs as fast as it's computed and is reproducible from the seed of
+// prob.Rng. Transactions arrive at the network at the simulated times they
+// were traded, and the network runs up to the time of every arrival. Every
+// node is connected to every other node, and every message between two
+// nodes is delayed by the network's minimum delay plus a random delay.
+// Each transaction arrives at a random node, which relays its canonical
+// encoding to the others, and every node includes the transactions it has
+// decoded that aren't yet in its chain in the blocks it produces.
+//
+// Every node holds a db.Blockchain of the same genesis block. A node seals
+// the blocks it produces with the network's proof of work, created on the
+// network's simulated time, and broadcasts their canonical encoding, which
+// every node verifies against its own blockchain before adding it. Nodes
+// share nothing but the encodings they exchange.
+//
+// Nodes converge through either of two consensus rules:
+//
+//   - ConsensusPoW is longest-chain proof of work, where blocks are
+//     found by a random node at exponentially distributed intervals,
+//     and every node follows the chain of the most cumulative work it
+//     has received, keeping the first received of competing chains of
+//     the same work. A transaction is final once the block including it
+//     has the finality depth of canonical blocks after it, and every node
+//     has received them.
+//   - ConsensusBFT is a leader-based Byzantine fault tolerant protocol,
+//     where the leader of each round proposes a block, and a block is
+//     committed by a node once a quorum of nodes has voted for it in
+//     two phases. A round without a committed block times out, and the
+//     next node leads the next round. A transaction is final once the
+//     block including it is committed by every live node.
+package network
+
+import (
+	"container/heap"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+	"tradesim/src/db"
+	"tradesim/src/prob"
+	"tradesim/src/trade"
+
+	"github.com/google/uuid"
+)
+
+// Consensus represents the consensus rule of a network.
+type Consensus = string
+
+const (
+	ConsensusPoW Consensus = "pow"
+	ConsensusBFT Consensus = "bft"
+)
+
+var Consensuses = []Consensus{
+	ConsensusPoW,
+	ConsensusBFT,
+}
+
+var ErrNetwork = errors.New("failed to simulate network")
+
+// Config configures a simulated network.
+type Config struct {
+	Nodes     int
+	Consensus Consensus
+	// MinDelay is the minimum delay of a message between two nodes, and
+	// Delay, if not nil, generates the additional delay in seconds.
+	MinDelay time.Duration
+	Delay    prob.Distribution
+	// BlockInterval is the mean time between blocks found by the network
+	// with proof of work, or the time a leader waits after a commit
+	// before proposing the next block with BFT.
+	BlockInterval time.Duration
+	// BlockSize is the maximum number of transactions of a block;
+	// if 0, blocks include every pending transaction.
+	BlockSize int
+	// FinalityDepth is the number of blocks after the block
+	// including a transaction that finalize it with proof of work.
+	FinalityDepth int
+	// RoundTimeout is the time the first BFT round of a height waits for
+	// a commit after its proposal is due, before moving to the next round,
+	// and every later round waits for it once more than the round before.
+	RoundTimeout time.Duration
+	// Faulty is the number of nodes that have crashed,
+	// which must be tolerable by BFT.
+	Faulty int
+	// Drain is the time the network runs after the last transaction
+	// arrives; if 0, it's 10 times the time to finality of a block.
+	Drain time.Duration
+	// ProofOfWork seals the blocks of every node, and Genesis is the
+	// creation time of the genesis block of their blockchains, from
+	// which the network's simulated time is measured.
+	ProofOfWork db.ProofOfWork
+	Genesis     time.Time
+	// Keys are the public keys of traders by ID, if not nil, which
+	// verify the signatures of the transactions of every block.
+	Keys map[uuid.UUID]ed25519.PublicKey
+}
+
+// Arrival represents a transaction arriving at the network,
+// at its simulated time since the network started.
+type Arrival struct {
+	At          time.Duration
+	Transaction trade.Transaction
+}
+
+// Validate returns an error if the configuration is invalid.
+func (c Config) Validate() error {
+	if c.Nodes < 1 {
+		return fmt.Errorf("%w: nodes must be positive: got=%d", ErrNetwork, c.Nodes)
+	}
+	switch c.Consensus {
+	case ConsensusPoW, ConsensusBFT:
+	default:
+		return fmt.Errorf("%w: unsupported consensus: supported=%s got=%s", ErrNetwork, strings.Join(Consensuses, ", "), c.Consensus)
+	}
+	if c.MinDelay < 0 || c.BlockSize < 0 || c.FinalityDepth < 0 || c.Drain < 0 {
+		return fmt.Errorf("%w: negative delay, block size, finality depth or drain", ErrNetwork)
+	}
+	if c.BlockInterval <= 0 {
+		return fmt.Errorf("%w: block interval must be positive: got=%s", ErrNetwork, c.BlockInterval)
+	}
+	if c.Consensus == ConsensusBFT {
+		if c.RoundTimeout <= 0 {
+			return fmt.Errorf("%w: round timeout must be positive: got=%s", ErrNetwork, c.RoundTimeout)
+		}
+		if f := (c.Nodes - 1) / 3; c.Faulty < 0 || c.Faulty > f {
+			return fmt.Errorf("%w: faulty nodes: min=0 max=%d got=%d", ErrNetwork, f, c.Faulty)
+		}
+	} else if c.Faulty < 0 || c.Faulty >= c.Nodes {
+		return fmt.Errorf("%w: faulty nodes: min=0 max=%d got=%d", ErrNetwork, c.Nodes-1, c.Faulty)
+	}
+	return nil
+}
+
+// block represents a block produced by a node, with the hash
+// and canonical encoding of its sealed db block.
+type block struct {
+	id       int
+	hash     string
+	encoded  []byte
+	parent   *block
+	height   int
+	producer int
+	txns     []uuid.UUID
+	// seen is the time every node received the block with proof of
+	// work, or committed it with BFT, by node index, or -1 if it hasn't.
+	seen []time.Duration
+}
+
+// node represents a ledger node, and chain is its blockchain.
+type node struct {
+	index int
+	live  bool
+	chain *db.Blockchain
+	// known are the IDs of the transactions received by the node, in the
+	// order they were received, txns are the transactions decoded from
+	// their encodings by ID, and inChain are those in its chain.
+	known   []uuid.UUID
+	txns    map[uuid.UUID]trade.Transaction
+	inChain map[uuid.UUID]bool
+	tip     *block
+	// blocks are the blocks received by the node, and waiting are the
+	// blocks received before their parent, by the ID of their parent.
+	blocks  map[int]bool
+	waiting map[int][]*block
+	bft     *bftState
+}
+
+// pending returns the transactions known by the node that
+// aren't in its chain, up to the provided maximum if positive.
+func (n *node) pending(max int) []uuid.UUID {
+	var txns []uuid.UUID
+	for _, id := range n.known {
+		if max > 0 && len(txns) == max {
+			break
+		}
+		if !n.inChain[id] {
+			txns = append(txns, id)
+		}
+	}
+	return txns
+}
+
+// setTip sets the node's chain to the chain ending in the provided block,
+// which is the last block of the canonical chain of its blockchain.
+func (n *node) setTip(b *block) {
+	old, curr := n.tip, b
+	var connected []*block
+	for old != curr {
+		if old.height >= curr.height {
+			for _, id := range old.txns {
+				delete(n.inChain, id)
+			}
+			old = old.parent
+		} else {
+			connected = append(connected, curr)
+			curr = curr.parent
+		}
+	}
+	for _, c := range connected {
+		for _, id := range c.txns {
+			n.inChain[id] = true
+		}
+	}
+	n.tip = b
+}
+
+// event represents a scheduled event of a simulation.
+type event struct {
+	at time.Duration
+	// seq orders events scheduled at the same time, and bounded is
+	// whether the event only runs up to the simulation's horizon.
+	seq     int
+	bounded bool
+	fn      func()
+}
+
+type eventQueue []event
+
+func (q eventQueue) Len() int { return len(q) }
+func (q eventQueue) Less(i, j int) bool {
+	if q[i].at != q[j].at {
+		return q[i].at < q[j].at
+	}
+	return q[i].seq < q[j].seq
+}
+func (q eventQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *eventQueue) Push(x interface{}) { *q = append(*q, x.(event)) }
+func (q *eventQueue) Pop() interface{} {
+	old := *q
+	e := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return e
+}
+
+// maxDuration is the horizon of a network
+// until the time of its last arrival is known.
+const maxDuration = time.Duration(math.MaxInt64)
+
+// simulation represents the state of a network simulation.
+type simulation struct {
+	cfg Config
+	now time.Duration
+	// horizon is the time the network stops producing blocks,
+	// and last is the time of the last arrival.
+	horizon time.Duration
+	last    time.Duration
+	queue   eventQueue
+	seq     int
+	nodes   []*node
+	genesis *block
+	// blocks are every produced block, in the order they were produced,
+	// and byHash are every block, including the genesis block, by hash.
+	blocks   []*block
+	byHash   map[string]*block
+	arrivals map[uuid.UUID]time.Duration
+	report   Report
+	// err is the first error of the simulation, which stops it.
+	err error
+	// committed are the first blocks committed at each height with BFT,
+	// and timeouts are the rounds that timed out.
+	committed map[int]*block
+	timeouts  map[round]bool
+}
+
+// Network represents a running network simulation,
+// at which transactions arrive as they're traded.
+type Network struct {
+	s *simulation
+}
+
+// New returns a network of the provided configuration,
+// whose simulated time starts at 0.
+func New(cfg Config) (*Network, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	s := &simulation{
+		cfg:      cfg,
+		horizon:  maxDuration,
+		nodes:    make([]*node, cfg.Nodes),
+		byHash:   make(map[string]*block),
+		arrivals: make(map[uuid.UUID]time.Duration),
+		report: Report{
+			Consensus: cfg.Consensus,
+			Nodes:     cfg.Nodes,
+		},
+	}
+	for i := range s.nodes {
+		chain := db.NewBlockchainAt(cfg.Genesis)
+		if err := chain.SetProofOfWork(cfg.ProofOfWork); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrNetwork, err)
+		}
+		for id, key := range cfg.Keys {
+			chain.RegisterKey(id, key)
+		}
+		s.nodes[i] = &node{
+			index:   i,
+			live:    i < cfg.Nodes-cfg.Faulty,
+			chain:   chain,
+			txns:    make(map[uuid.UUID]trade.Transaction),
+			inChain: make(map[uuid.UUID]bool),
+			blocks:  make(map[int]bool),
+			waiting: make(map[int][]*block),
+		}
+	}
+	s.genesis = &block{id: -1, hash: s.nodes[0].chain.Last().Hash, producer: -1, seen: make([]time.Duration, cfg.Nodes)}
+	s.byHash[s.genesis.hash] = s.genesis
+	for _, n := range s.nodes {
+		n.tip = s.genesis
+		n.blocks[s.genesis.id] = true
+	}
+
+	switch cfg.Consensus {
+	case ConsensusPoW:
+		s.startPoW()
+	case ConsensusBFT:
+		s.startBFT()
+	}
+	return &Network{s: s}, nil
+}
+
+// Arrive runs the network until the provided transaction arrives at it.
+// Arrivals earlier than the previous arrival arrive at its time.
+func (n *Network) Arrive(a Arrival) error {
+	s := n.s
+	at := a.At
+	if at < s.now {
+		at = s.now
+	}
+	if s.run(at); s.err != nil {
+		return s.err
+	}
+	s.now, s.last = at, at
+	id := a.Transaction.ID
+	if _, ok := s.arrivals[id]; !ok {
+		s.report.Transactions++
+		s.arrivals[id] = at
+	}
+	p, err := a.Transaction.MarshalBinary()
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrNetwork, err)
+	}
+	s.arrive(p)
+	return nil
+}
+
+// Stop runs the network for its drain time after the last
+// transaction arrived, and returns the report of the simulation.
+func (n *Network) Stop() (Report, error) {
+	s := n.s
+	drain := s.cfg.Drain
+	if drain == 0 {
+		drain = 10 * (time.Duration(s.cfg.FinalityDepth+1)*s.cfg.BlockInterval + s.cfg.RoundTimeout)
+	}
+	s.horizon = s.last + drain
+	if s.run(maxDuration); s.err != nil {
+		return Report{}, s.err
+	}
+	s.report.Duration = s.now
+
+	switch s.cfg.Consensus {
+	case ConsensusPoW:
+		s.finalizePoW()
+	case ConsensusBFT:
+		s.finalizeBFT()
+	}
+	for _, n := range s.nodes {
+		s.report.Hashes += n.chain.Mining().Hashes
+	}
+	return s.report, nil
+}
+
+// Simulate simulates the provided network receiving the provided
+// transactions, in the order of their arrival, and returns the
+// report of the simulation.
+func Simulate(cfg Config, arrivals []Arrival) (Report, error) {
+	n, err := New(cfg)
+	if err != nil {
+		return Report{}, err
+	}
+	arrivals = append([]Arrival{}, arrivals...)
+	sort.SliceStable(arrivals, func(i, j int) bool { return arrivals[i].At < arrivals[j].At })
+	for _, a := range arrivals {
+		if err := n.Arrive(a); err != nil {
+			return Report{}, err
+		}
+	}
+	return n.Stop()
+}
+
+// run runs the events scheduled before the provided time, skipping
+// the bounded events past the horizon, until the simulation fails.
+func (s *simulation) run(until time.Duration) {
+	for s.queue.Len() > 0 && s.queue[0].at < until && s.err == nil {
+		e := heap.Pop(&s.queue).(event)
+		if e.bounded && e.at > s.horizon {
+			continue
+		}
+		s.now = e.at
+		e.fn()
+	}
+}
+
+// fail stops the simulation with the provided error,
+// unless it has already failed.
+func (s *simulation) fail(err error) {
+	if s.err == nil {
+		s.err = fmt.Errorf("%w: %v", ErrNetwork, err)
+	}
+}
+
+// schedule schedules the provided function at the provided time.
+func (s *simulation) schedule(at time.Duration, fn func()) {
+	s.seq++
+	heap.Push(&s.queue, event{at: at, seq: s.seq, fn: fn})
+}
+
+// scheduleBounded schedules the provided function at the provided
+// time, unless it's past the horizon, which it only runs up to.
+func (s *simulation) scheduleBounded(at time.Duration, fn func()) {
+	if at > s.horizon {
+		return
+	}
+	s.seq++
+	heap.Push(&s.queue, event{at: at, seq: s.seq, bounded: true, fn: fn})
+}
+
+// delay returns the delay of a message between two nodes.
+func (s *simulation) delay() time.Duration {
+	d := s.cfg.MinDelay
+	if s.cfg.Delay != nil {
+		if x := s.cfg.Delay.Generate(); x > 0 && !math.IsInf(x, 0) {
+			d += time.Duration(x * float64(time.Second))
+		}
+	}
+	return d
+}
+
+// broadcast delivers a message from the provided node to every live
+// node, including itself without delay, by calling the provided function
+// with the receiving node.
+func (s *simulation) broadcast(from *node, fn func(*node)) {
+	for _, n := range s.nodes {
+		if !n.live {
+			continue
+		}
+		_n := n
+		at := s.now
+		if n != from {
+			at += s.delay()
+		}
+		s.schedule(at, func() { fn(_n) })
+	}
+}
+
+// arrive delivers the transaction of the provided canonical encoding to a
+// random live node, which relays it to the other live nodes, each of which
+// decodes it. A transaction that can't be decoded fails the simulation.
+func (s *simulation) arrive(p []byte) {
+	var live []*node
+	for _, n := range s.nodes {
+		if n.live {
+			live = append(live, n)
+		}
+	}
+	from := live[prob.Rand.Intn(len(live))]
+	s.broadcast(from, func(n *node) {
+		var t trade.Transaction
+		if err := t.UnmarshalBinary(p); err != nil {
+			s.fail(err)
+			return
+		}
+		if _, ok := n.txns[t.ID]; !ok {
+			n.txns[t.ID] = t
+			n.known = append(n.known, t.ID)
+		}
+	})
+}
+
+// newBlock returns a new block of the provided producer and transactions
+// after the provided parent, sealed by the producer's blockchain, or nil
+// if sealing it fails, which fails the simulation.
+func (s *simulation) newBlock(parent *block, producer *node, txns []uuid.UUID) *block {
+	blockTxns := make([]*trade.Transaction, len(txns))
+	for i, id := range txns {
+		t := producer.txns[id]
+		blockTxns[i] = &t
+	}
+	blk := db.NewBlockOf(blockTxns, s.cfg.Genesis.Add(s.now))
+	p, err := producer.chain.Seal(parent.hash, blk)
+	if err != nil {
+		s.fail(err)
+		return nil
+	}
+	b := &block{
+		id:       len(s.blocks),
+		hash:     blk.Info().Hash,
+		encoded:  p,
+		parent:   parent,
+		height:   parent.height + 1,
+		producer: producer.index,
+		txns:     txns,
+		seen:     make([]time.Duration, s.cfg.Nodes),
+	}
+	for i := range b.seen {
+		b.seen[i] = -1
+	}
+	s.blocks = append(s.blocks, b)
+	s.byHash[b.hash] = b
+	return b
+}
+
+// finality records the time to finality of the
+// transactions of the provided canonical chain.
+func (s *simulation) finality(chain []*block, finalAt func(height int) (time.Duration, bool)) {
+	var times []time.Duration
+	for _, b := range chain {
+		at, ok := finalAt(b.height)
+		if !ok {
+			continue
+		}
+		for _, id := range b.txns {
+			times = append(times, at-s.arrivals[id])
+		}
+	}
+	s.report.CanonicalBlocks = len(chain)
+	s.report.Orphans = s.report.Blocks - len(chain)
+	s.report.Finalized = len(times)
+	s.report.Finality = newDurationStats(times)
+}
+
+// canonical returns the chain ending in the provided
+// block, from the block after the genesis block.
+func canonical(tip *block) []*block {
+	chain := make([]*block, tip.height)
+	for b := tip; b.parent != nil; b = b.parent {
+		chain[b.height-1] = b
+	}
+	return chain
+}
@@ -0,0 +1,154 @@
+package network
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"testing"
+	"time"
+	"tradesim/src/db"
+	"tradesim/src/prob"
+	"tradesim/src/trade"
+
+	"github.com/google/uuid"
+)
+
+// arrivals returns the provided number of transactions
+// arriving the provided interval apart.
+func arrivals(n int, interval time.Duration) []Arrival {
+	as := make([]Arrival, n)
+	for i := range as {
+		as[i] = Arrival{At: time.Duration(i) * interval, Transaction: trade.Transaction{ID: uuid.New()}}
+	}
+	return as
+}
+
+// TestPoW asserts that without network delay, nodes following the
+// longest proof of work chain never fork, and finalize every transaction.
+func TestPoW(t *testing.T) {
+	prob.Rng.Seed(1)
+	r, err := Simulate(Config{
+		Nodes:         4,
+		Consensus:     ConsensusPoW,
+		BlockInterval: time.Second,
+		FinalityDepth: 3,
+	}, arrivals(50, 100*time.Millisecond))
+	if err != nil {
+		t.Fatalf("simulate: %v", err)
+	}
+	if r.Forks != 0 || r.Orphans != 0 || r.Reorgs != 0 {
+		t.Errorf("forks: expected: none actual: %+v", r)
+	}
+	if r.Finalized != r.Transactions {
+		t.Errorf("finalized: expected: %d actual: %d", r.Transactions, r.Finalized)
+	}
+	if r.Finality.Mean < 3*time.Second/2 {
+		t.Errorf("finality: expected: several block intervals actual: %+v", r.Finality)
+	}
+}
+
+// TestPoWForks asserts that with network delays near the block
+// interval, nodes fork and reorganize, orphaning blocks, but still
+// converge on a chain of every transaction.
+func TestPoWForks(t *testing.T) {
+	prob.Rng.Seed(2)
+	r, err := Simulate(Config{
+		Nodes:         8,
+		Consensus:     ConsensusPoW,
+		MinDelay:      200 * time.Millisecond,
+		Delay:         prob.NewExponential(0, 2),
+		BlockInterval: time.Second,
+		FinalityDepth: 6,
+	}, arrivals(200, 50*time.Millisecond))
+	if err != nil {
+		t.Fatalf("simulate: %v", err)
+	}
+	if r.Forks == 0 || r.Orphans == 0 || r.Reorgs == 0 {
+		t.Errorf("forks: expected: some actual: %+v", r)
+	}
+	if expected, actual := r.Blocks, r.CanonicalBlocks+r.Orphans; expected != actual {
+		t.Errorf("blocks: expected: %d actual: %d", expected, actual)
+	}
+	if r.Finalized != r.Transactions {
+		t.Errorf("finalized: expected: %d actual: %d", r.Transactions, r.Finalized)
+	}
+}
+
+// TestBFT asserts that BFT nodes commit every transaction without forks,
+// changing views past the rounds led by crashed nodes.
+func TestBFT(t *testing.T) {
+	tests := []struct {
+		name   string
+		faulty int
+	}{
+		{name: "live", faulty: 0},
+		{name: "crashed", faulty: 2},
+	}
+	for _, test := range tests {
+		prob.Rng.Seed(3)
+		r, err := Simulate(Config{
+			Nodes:         7,
+			Consensus:     ConsensusBFT,
+			MinDelay:      10 * time.Millisecond,
+			Delay:         prob.NewExponential(0, 20),
+			BlockInterval: 500 * time.Millisecond,
+			RoundTimeout:  time.Second,
+			Faulty:        test.faulty,
+		}, arrivals(100, 50*time.Millisecond))
+		if err != nil {
+			t.Fatalf("%s: simulate: %v", test.name, err)
+		}
+		if r.Forks != 0 || r.Reorgs != 0 {
+			t.Errorf("%s: forks: expected: none actual: %+v", test.name, r)
+		}
+		if r.Finalized != r.Transactions {
+			t.Errorf("%s: finalized: expected: %d actual: %d", test.name, r.Transactions, r.Finalized)
+		}
+		if crashed := test.faulty > 0; crashed != (r.ViewChanges > 0) {
+			t.Errorf("%s: view changes: expected crashed leaders: %t actual: %d", test.name, crashed, r.ViewChanges)
+		}
+	}
+}
+
+// TestSealed asserts that the blocks of every node are sealed with the
+// network's proof of work, and that a transaction whose signatures don't
+// verify with the network's keys fails the simulation.
+func TestSealed(t *testing.T) {
+	prob.Rng.Seed(4)
+	cfg := Config{
+		Nodes:         4,
+		Consensus:     ConsensusPoW,
+		BlockInterval: time.Second,
+		FinalityDepth: 2,
+		ProofOfWork:   db.ProofOfWork{Difficulty: 8},
+	}
+	r, err := Simulate(cfg, arrivals(20, 100*time.Millisecond))
+	if err != nil {
+		t.Fatalf("simulate: %v", err)
+	}
+	if r.Blocks == 0 || r.Hashes < uint64(r.Blocks) {
+		t.Errorf("hashes: expected: at least one per block actual: %+v", r)
+	}
+	if r.Finalized != r.Transactions {
+		t.Errorf("finalized: expected: %d actual: %d", r.Transactions, r.Finalized)
+	}
+
+	trader, err := trade.NewTrader(nil, nil, nil)
+	if err != nil {
+		t.Fatalf("new trader: %v", err)
+	}
+	cfg.Keys = map[uuid.UUID]ed25519.PublicKey{trader.ID: trader.PublicKey()}
+	unsigned := arrivals(1, 0)
+	unsigned[0].Transaction.Credit.TraderID = trader.ID
+	unsigned[0].Transaction.Debit.TraderID = trader.ID
+	if _, err := Simulate(cfg, unsigned); !errors.Is(err, ErrNetwork) {
+		t.Errorf("unsigned: expected: %v actual: %v", ErrNetwork, err)
+	}
+}
+
+// TestValidate asserts that BFT networks must tolerate their faulty nodes.
+func TestValidate(t *testing.T) {
+	cfg := Config{Nodes: 4, Consensus: ConsensusBFT, BlockInterval: time.Second, RoundTimeout: time.Second, Faulty: 2}
+	if _, err := Simulate(cfg, nil); !errors.Is(err, ErrNetwork) {
+		t.Errorf("faulty: expected: %v actual: %v", ErrNetwork, err)
+	}
+}
@@ -0,0 +1,121 @@
+package network
+
+import (
+	"time"
+	"tradesim/src/prob"
+)
+
+// startPoW schedules the first block found by the network.
+func (s *simulation) startPoW() {
+	s.scheduleMining()
+}
+
+// scheduleMining schedules the next block found by the network,
+// unless the network has stopped.
+func (s *simulation) scheduleMining() {
+	at := s.now + time.Duration(prob.Rand.ExpFloat64()*float64(s.cfg.BlockInterval))
+	s.scheduleBounded(at, func() {
+		s.mine()
+		s.scheduleMining()
+	})
+}
+
+// mine produces a block on the chain of a random live node, with equal
+// hash power, and broadcasts it. The time the block is found is simulated,
+// and the found block is then sealed with the network's proof of work.
+func (s *simulation) mine() {
+	var live []*node
+	for _, n := range s.nodes {
+		if n.live {
+			live = append(live, n)
+		}
+	}
+	miner := live[prob.Rand.Intn(len(live))]
+	b := s.newBlock(miner.tip, miner, miner.pending(s.cfg.BlockSize))
+	if b == nil {
+		return
+	}
+	s.report.Blocks++
+	s.broadcast(miner, func(n *node) { s.receive(n, b) })
+}
+
+// receive adds the provided block to the blockchain of the provided node,
+// along with the blocks waiting for it, following the canonical chain of
+// its blockchain. A block its blockchain rejects fails the simulation.
+func (s *simulation) receive(n *node, b *block) {
+	if n.blocks[b.id] || s.err != nil {
+		return
+	}
+	if !n.blocks[b.parent.id] {
+		n.waiting[b.parent.id] = append(n.waiting[b.parent.id], b)
+		return
+	}
+	reorg, err := n.chain.Receive(b.encoded)
+	if err != nil {
+		s.fail(err)
+		return
+	}
+	n.blocks[b.id] = true
+	b.seen[n.index] = s.now
+	if reorg != nil {
+		s.report.Reorgs++
+	}
+	n.setTip(s.byHash[n.chain.Last().Hash])
+	children := n.waiting[b.id]
+	delete(n.waiting, b.id)
+	for _, c := range children {
+		s.receive(n, c)
+	}
+}
+
+// finalizePoW reports the forks of the produced blocks, and the time to
+// finality of the transactions of the chain followed by most live nodes.
+func (s *simulation) finalizePoW() {
+	heights := make(map[int]int)
+	for _, b := range s.blocks {
+		heights[b.height]++
+	}
+	for _, count := range heights {
+		if count > 1 {
+			s.report.Forks++
+		}
+	}
+
+	votes := make(map[*block]int)
+	var tip *block
+	for _, n := range s.nodes {
+		if !n.live {
+			continue
+		}
+		votes[n.tip]++
+		if tip == nil || votes[n.tip] > votes[tip] || (votes[n.tip] == votes[tip] && n.tip.id < tip.id) {
+			tip = n.tip
+		}
+	}
+	chain := canonical(tip)
+	s.finality(chain, func(height int) (time.Duration, bool) {
+		i := height - 1 + s.cfg.FinalityDepth
+		if i >= len(chain) {
+			return 0, false
+		}
+		return s.seenByAll(chain[i])
+	})
+}
+
+// seenByAll returns the time the last live node received
+// the provided block, and whether every live node has.
+func (s *simulation) seenByAll(b *block) (time.Duration, bool) {
+	last := time.Duration(0)
+	for _, n := range s.nodes {
+		if !n.live {
+			continue
+		}
+		if b.seen[n.index] < 0 {
+			return 0, false
+		}
+		if b.seen[n.index] > last {
+			last = b.seen[n.index]
+		}
+	}
+	return last, true
+}
@@ -0,0 +1,69 @@
+package network
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Report represents the outcome of a network simulation.
+type Report struct {
+	Consensus Consensus `json:"consensus"`
+	Nodes     int       `json:"nodes"`
+	// Duration is the simulated time the network ran for.
+	Duration time.Duration `json:"duration"`
+	// Blocks is the number of blocks produced, of which CanonicalBlocks
+	// are in the canonical chain and Orphans aren't.
+	Blocks          int `json:"blocks"`
+	CanonicalBlocks int `json:"canonical_blocks"`
+	Orphans         int `json:"orphans"`
+	// Hashes is the number of hashes computed sealing the blocks.
+	Hashes uint64 `json:"hashes"`
+	// Forks is the number of heights with competing blocks,
+	// and Reorgs is the number of times a node switched to
+	// a chain that didn't extend its own.
+	Forks  int `json:"forks"`
+	Reorgs int `json:"reorgs"`
+	// ViewChanges is the number of BFT rounds that timed out.
+	ViewChanges int `json:"view_changes"`
+	// Transactions is the number of transactions that arrived,
+	// of which Finalized were final by the end of the simulation.
+	Transactions int           `json:"transactions"`
+	Finalized    int           `json:"finalized"`
+	Finality     DurationStats `json:"finality"`
+}
+
+// DurationStats represents the summary statistics of a set of durations.
+type DurationStats struct {
+	Mean time.Duration `json:"mean"`
+	P50  time.Duration `json:"p50"`
+	P95  time.Duration `json:"p95"`
+	Max  time.Duration `json:"max"`
+}
+
+func newDurationStats(ds []time.Duration) DurationStats {
+	if len(ds) == 0 {
+		return DurationStats{}
+	}
+	sort.Slice(ds, func(i, j int) bool { return ds[i] < ds[j] })
+	var sum time.Duration
+	for _, d := range ds {
+		sum += d
+	}
+	percentile := func(p float64) time.Duration {
+		return ds[int(p*float64(len(ds)-1))]
+	}
+	return DurationStats{
+		Mean: sum / time.Duration(len(ds)),
+		P50:  percentile(0.5),
+		P95:  percentile(0.95),
+		Max:  ds[len(ds)-1],
+	}
+}
+
+func (r Report) String() string {
+	return fmt.Sprintf("network consensus=%s nodes=%d duration=%s blocks=%d canonical blocks=%d orphans=%d hashes=%d forks=%d reorgs=%d view changes=%d "+
+		"transactions=%d finalized=%d finality mean=%s p50=%s p95=%s max=%s",
+		r.Consensus, r.Nodes, r.Duration, r.Blocks, r.CanonicalBlocks, r.Orphans, r.Hashes, r.Forks, r.Reorgs, r.ViewChanges,
+		r.Transactions, r.Finalized, r.Finality.Mean, r.Finality.P50, r.Finality.P95, r.Finality.Max)
+}
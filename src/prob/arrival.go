@@ -0,0 +1,317 @@
+package prob
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Arrivals represents a continuous-time point process that
+// generates event times directly, rather than testing for
+// an event on every tick of a clock.
+//
+// Times are measured in units elapsed since the origin of the process,
+// and rates are expressed in events per unit.
+type Arrivals interface {
+	// Next returns the time of the first event strictly after t,
+	// or positive infinity if the process has no further events.
+	Next(t float64) float64
+}
+
+// Poisson represents a homogeneous Poisson process,
+// whose inter-arrival times are exponentially distributed.
+type Poisson struct {
+	Rate float64
+}
+
+func NewPoisson(rate float64) Poisson {
+	return Poisson{Rate: rate}
+}
+
+func (p Poisson) Next(t float64) float64 {
+	if p.Rate <= 0 {
+		return math.Inf(1)
+	}
+	return t + Rand.ExpFloat64()/p.Rate
+}
+
+// Intensity represents the time-varying rate function
+// of a non-homogeneous Poisson process.
+type Intensity interface {
+	// At returns the rate of the process at time t.
+	At(t float64) float64
+	// Max returns an upper bound of the rate over all time.
+	Max() float64
+}
+
+var ErrIntensity = errors.New("invalid intensity")
+
+type IntensityType = string
+
+const (
+	IntensityPiecewise  IntensityType = "piecewise"
+	IntensitySinusoidal IntensityType = "sinusoidal"
+)
+
+var IntensityTypes = []IntensityType{
+	IntensityPiecewise,
+	IntensitySinusoidal,
+}
+
+type IntensityTypeError struct {
+	Type string
+}
+
+func NewIntensityTypeError(intensityType string) *IntensityTypeError {
+	return &IntensityTypeError{Type: intensityType}
+}
+
+func (e IntensityTypeError) Error() string {
+	return fmt.Sprintf("unsupported intensity type: supported=%s got=%s", strings.Join(IntensityTypes, ", "), e.Type)
+}
+
+// PiecewiseIntensity represents a piecewise-constant rate function,
+// where Rates[i] holds from Breaks[i] until the next break.
+// The rate before the first break is 0.
+//
+// If Period is positive, the rate function repeats with that period,
+// which models recurring patterns such as time-of-day order flow.
+type PiecewiseIntensity struct {
+	Breaks []float64
+	Rates  []float64
+	Period float64
+}
+
+// NewPiecewiseIntensity returns a piecewise intensity with the provided
+// breaks and rates, or an error if they differ in length. Breaks are
+// sorted in ascending order along with their rates.
+func NewPiecewiseIntensity(breaks, rates []float64, period float64) (PiecewiseIntensity, error) {
+	if len(breaks) != len(rates) {
+		return PiecewiseIntensity{}, fmt.Errorf("%w: breaks and rates differ in length: breaks=%d rates=%d",
+			ErrIntensity, len(breaks), len(rates))
+	}
+	idx := make([]int, len(breaks))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(i, j int) bool { return breaks[idx[i]] < breaks[idx[j]] })
+	p := PiecewiseIntensity{
+		Breaks: make([]float64, len(idx)),
+		Rates:  make([]float64, len(idx)),
+		Period: period,
+	}
+	for i, j := range idx {
+		p.Breaks[i] = breaks[j]
+		p.Rates[i] = rates[j]
+	}
+	return p, nil
+}
+
+func (p PiecewiseIntensity) At(t float64) float64 {
+	if p.Period > 0 {
+		t = math.Mod(t, p.Period)
+	}
+	// i is the index of the first break after t.
+	i := sort.Search(len(p.Breaks), func(i int) bool { return p.Breaks[i] > t })
+	if i == 0 {
+		return 0
+	}
+	return math.Max(p.Rates[i-1], 0)
+}
+
+func (p PiecewiseIntensity) Max() float64 {
+	max := 0.0
+	for _, r := range p.Rates {
+		max = math.Max(max, r)
+	}
+	return max
+}
+
+// exhausted returns whether the rate is 0 for all time after t.
+func (p PiecewiseIntensity) exhausted(t float64) bool {
+	if p.Period > 0 || len(p.Breaks) == 0 {
+		return p.Max() <= 0
+	}
+	last := len(p.Breaks) - 1
+	return t >= p.Breaks[last] && p.Rates[last] <= 0
+}
+
+// SinusoidalIntensity represents the rate function
+//
+//     max(0, Base + Amplitude * sin(2*pi*t/Period + Phase))
+type SinusoidalIntensity struct {
+	Base      float64
+	Amplitude float64
+	Period    float64
+	Phase     float64
+}
+
+func NewSinusoidalIntensity(base, amplitude, period, phase float64) SinusoidalIntensity {
+	return SinusoidalIntensity{
+		Base:      base,
+		Amplitude: amplitude,
+		Period:    period,
+		Phase:     phase,
+	}
+}
+
+func (s SinusoidalIntensity) At(t float64) float64 {
+	if s.Period <= 0 {
+		return math.Max(s.Base, 0)
+	}
+	return math.Max(s.Base+s.Amplitude*math.Sin(2*math.Pi*t/s.Period+s.Phase), 0)
+}
+
+func (s SinusoidalIntensity) Max() float64 {
+	return math.Max(s.Base+math.Abs(s.Amplitude), 0)
+}
+
+// NHPoisson represents a non-homogeneous Poisson process,
+// whose event times are generated by thinning a homogeneous
+// Poisson process at the maximum rate of its intensity.
+type NHPoisson struct {
+	Intensity Intensity
+}
+
+func NewNHPoisson(intensity Intensity) NHPoisson {
+	return NHPoisson{Intensity: intensity}
+}
+
+func (p NHPoisson) Next(t float64) float64 {
+	max := p.Intensity.Max()
+	if max <= 0 {
+		return math.Inf(1)
+	}
+	piecewise, isPiecewise := p.Intensity.(PiecewiseIntensity)
+	for s := t; ; {
+		if isPiecewise && piecewise.exhausted(s) {
+			return math.Inf(1)
+		}
+		s += Rand.ExpFloat64() / max
+		if Rand.Float64()*max <= p.Intensity.At(s) {
+			return s
+		}
+	}
+}
+
+// Superposition represents the union of the events of a set of processes.
+// Events of several processes at the same time are each returned, so Next
+// returns t again while another process has a pending event at t.
+type Superposition struct {
+	arrivals []Arrivals
+	// pending is the next event time of each process that has been
+	// generated but not yet returned, or NaN once it's returned.
+	pending []float64
+}
+
+func NewSuperposition(arrivals ...Arrivals) *Superposition {
+	s := &Superposition{
+		arrivals: arrivals,
+		pending:  make([]float64, len(arrivals)),
+	}
+	for i := range s.pending {
+		s.pending[i] = math.NaN()
+	}
+	return s
+}
+
+func (s *Superposition) Next(t float64) float64 {
+	next, first := math.Inf(1), -1
+	for i, a := range s.arrivals {
+		// A pending time that has been returned, or passed without
+		// being returned, is replaced by the process's next event.
+		if math.IsNaN(s.pending[i]) || s.pending[i] < t {
+			s.pending[i] = a.Next(t)
+		}
+		if s.pending[i] < next {
+			next, first = s.pending[i], i
+		}
+	}
+	if first >= 0 {
+		s.pending[first] = math.NaN()
+	}
+	return next
+}
+
+// Thinning represents a process that independently retains
+// each event of an underlying process with a fixed probability.
+type Thinning struct {
+	Arrivals Arrivals
+	Prob     float64
+}
+
+func NewThinning(arrivals Arrivals, prob float64) Thinning {
+	return Thinning{
+		Arrivals: arrivals,
+		Prob:     prob,
+	}
+}
+
+func (th Thinning) Next(t float64) float64 {
+	if th.Prob <= 0 {
+		return math.Inf(1)
+	}
+	for s := t; ; {
+		s = th.Arrivals.Next(s)
+		if math.IsInf(s, 1) || Rand.Float64() < th.Prob {
+			return s
+		}
+	}
+}
+
+// ArrivalProcess steps a point process through its events.
+// Unlike a BernoulliProcess, any number of events
+// may occur within an arbitrarily small interval.
+type ArrivalProcess struct {
+	// arrivals generates the event times of the process.
+	arrivals Arrivals
+	// unit represents the duration of one unit of process time.
+	unit time.Duration
+	// elapsed is the process time of the process's last event.
+	elapsed float64
+	// candidate is the process time of the next event of the
+	// process once it's generated, which is infinite if it has none.
+	candidate *float64
+}
+
+func NewArrivalProcess(arrivals Arrivals, unit time.Duration) *ArrivalProcess {
+	return &ArrivalProcess{
+		arrivals: arrivals,
+		unit:     unit,
+	}
+}
+
+// Next returns the process's next event, which is drawn
+// from its arrivals once the previous event is stepped.
+func (p *ArrivalProcess) Next(time.Duration) (time.Duration, bool) {
+	if p.candidate == nil {
+		t := p.arrivals.Next(p.elapsed)
+		p.candidate = &t
+	}
+	return processDuration(*p.candidate, p.unit)
+}
+
+// Step steps the process to its next event,
+// which is always an event of the process.
+func (p *ArrivalProcess) Step() bool {
+	if p.candidate == nil {
+		return false
+	}
+	p.elapsed = *p.candidate
+	p.candidate = nil
+	return true
+}
+
+// processDuration returns the duration of the provided process time
+// in the provided unit, and false if it's infinite or too long to be
+// represented.
+func processDuration(t float64, unit time.Duration) (time.Duration, bool) {
+	offset := t * float64(unit)
+	if math.IsInf(t, 1) || offset > math.MaxInt64 {
+		return 0, false
+	}
+	return time.Duration(offset), true
+}
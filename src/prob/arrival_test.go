@@ -0,0 +1,168 @@
+package prob
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+)
+
+// count returns the number of events of the provided arrivals within [0, horizon).
+func count(a Arrivals, horizon float64) int {
+	n := 0
+	for t := a.Next(0); t < horizon; t = a.Next(t) {
+		n++
+	}
+	return n
+}
+
+// within returns whether actual is within the provided
+// relative tolerance of expected.
+func within(expected, actual, tolerance float64) bool {
+	return math.Abs(expected-actual) <= tolerance*expected
+}
+
+// TestPoissonRate asserts that a homogeneous Poisson process
+// generates events at its rate on average.
+func TestPoissonRate(t *testing.T) {
+	Rng.Seed(1)
+	horizon := 10000.0
+	p := NewPoisson(2)
+
+	expected := p.Rate * horizon
+	if actual := float64(count(p, horizon)); !within(expected, actual, 0.05) {
+		t.Errorf("event count: expected: %f actual: %f", expected, actual)
+	}
+}
+
+// TestPoissonIncreasing asserts that every event time
+// generated by a Poisson process is after the previous one.
+func TestPoissonIncreasing(t *testing.T) {
+	Rng.Seed(1)
+	p := NewPoisson(5)
+
+	prev := 0.0
+	for i := 0; i < 1000; i++ {
+		next := p.Next(prev)
+		if next <= prev {
+			t.Fatalf("event time: expected: >%f actual: %f", prev, next)
+		}
+		prev = next
+	}
+}
+
+// TestNHPoissonPiecewise asserts that a non-homogeneous Poisson process
+// with a periodic piecewise intensity generates events at the rate
+// of each piece on average.
+func TestNHPoissonPiecewise(t *testing.T) {
+	Rng.Seed(1)
+	intensity, err := NewPiecewiseIntensity([]float64{5, 0}, []float64{4, 1}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := NewNHPoisson(intensity)
+
+	var low, high float64
+	for s := p.Next(0); s < 10000; s = p.Next(s) {
+		if math.Mod(s, 10) < 5 {
+			low++
+		} else {
+			high++
+		}
+	}
+	if expected := 1 * 5000.0; !within(expected, low, 0.05) {
+		t.Errorf("low intensity event count: expected: %f actual: %f", expected, low)
+	}
+	if expected := 4 * 5000.0; !within(expected, high, 0.05) {
+		t.Errorf("high intensity event count: expected: %f actual: %f", expected, high)
+	}
+}
+
+// TestNHPoissonExhausted asserts that a non-homogeneous Poisson process
+// whose intensity is 0 after its last break has no further events.
+func TestNHPoissonExhausted(t *testing.T) {
+	Rng.Seed(1)
+	intensity, err := NewPiecewiseIntensity([]float64{0, 10}, []float64{3, 0}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := NewNHPoisson(intensity)
+
+	for s := p.Next(0); !math.IsInf(s, 1); s = p.Next(s) {
+		if s >= 10 {
+			t.Fatalf("event time: expected: <%f actual: %f", 10.0, s)
+		}
+	}
+}
+
+// TestNHPoissonSinusoidal asserts that a non-homogeneous Poisson process
+// with a sinusoidal intensity generates events at its base rate on average
+// over whole periods.
+func TestNHPoissonSinusoidal(t *testing.T) {
+	Rng.Seed(1)
+	p := NewNHPoisson(NewSinusoidalIntensity(3, 2, 20, 0))
+
+	expected := 3 * 10000.0
+	if actual := float64(count(p, 10000)); !within(expected, actual, 0.05) {
+		t.Errorf("event count: expected: %f actual: %f", expected, actual)
+	}
+}
+
+// TestSuperpositionRate asserts that the superposition of Poisson processes
+// generates events at the sum of their rates on average.
+func TestSuperpositionRate(t *testing.T) {
+	Rng.Seed(1)
+	s := NewSuperposition(NewPoisson(1), NewPoisson(2), NewPoisson(0))
+
+	expected := 3 * 10000.0
+	if actual := float64(count(s, 10000)); !within(expected, actual, 0.05) {
+		t.Errorf("event count: expected: %f actual: %f", expected, actual)
+	}
+}
+
+// fixed represents a process with events at a fixed set of ascending times.
+type fixed []float64
+
+func (f fixed) Next(t float64) float64 {
+	for _, s := range f {
+		if s > t {
+			return s
+		}
+	}
+	return math.Inf(1)
+}
+
+// TestSuperpositionTies asserts that the superposition of processes
+// returns every event of each process when their event times tie.
+func TestSuperpositionTies(t *testing.T) {
+	s := NewSuperposition(fixed{1, 2, 3}, fixed{2, 3}, fixed{3})
+
+	expected := []float64{1, 2, 2, 3, 3, 3}
+	actual := make([]float64, 0, len(expected))
+	for u := s.Next(0); !math.IsInf(u, 1); u = s.Next(u) {
+		actual = append(actual, u)
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("event times: expected: %v actual: %v", expected, actual)
+	}
+}
+
+// TestPiecewiseIntensityLength asserts that a piecewise intensity
+// isn't returned if its breaks and rates differ in length.
+func TestPiecewiseIntensityLength(t *testing.T) {
+	if _, err := NewPiecewiseIntensity([]float64{0, 5}, []float64{1}, 0); !errors.Is(err, ErrIntensity) {
+		t.Errorf("error: expected: %v actual: %v", ErrIntensity, err)
+	}
+}
+
+// TestThinningRate asserts that thinning a Poisson process generates
+// events at the product of its rate and retention probability on average.
+func TestThinningRate(t *testing.T) {
+	Rng.Seed(1)
+	th := NewThinning(NewPoisson(4), 0.25)
+
+	expected := 1 * 10000.0
+	if actual := float64(count(th, 10000)); !within(expected, actual, 0.05) {
+		t.Errorf("event count: expected: %f actual: %f", expected, actual)
+	}
+}
@@ -0,0 +1,261 @@
+package prob
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+var ErrCheckpoint = errors.New("failed to checkpoint process")
+
+// ProcessCheckpoint represents the position of a process between its steps,
+// from which it can be resumed.
+type ProcessCheckpoint struct {
+	// Tick is the clock position of a Bernoulli process.
+	Tick uint64 `json:"tick,omitempty"`
+	// Elapsed is the process time elapsed of an arrival or Hawkes process.
+	Elapsed float64 `json:"elapsed,omitempty"`
+	// Excitation and ExcitedAt are the excited part of the intensity
+	// of a Hawkes process, and the process time it was last excited.
+	Excitation float64 `json:"excitation,omitempty"`
+	ExcitedAt  float64 `json:"excited_at,omitempty"`
+	// Candidate is the process time of the candidate event of a stepped
+	// arrival or Hawkes process, if it has one, formatted so that the
+	// infinite time of an arrival process without further events is kept,
+	// and Bound is the intensity the candidate of a Hawkes process was
+	// drawn at.
+	Candidate string  `json:"candidate,omitempty"`
+	Bound     float64 `json:"bound,omitempty"`
+	// Arrivals is the state of the point process of an arrival process.
+	Arrivals *ArrivalsCheckpoint `json:"arrivals,omitempty"`
+	// Regimes are the checkpoints of the processes of a regime process.
+	Regimes []*ProcessCheckpoint `json:"regimes,omitempty"`
+}
+
+// ArrivalsCheckpoint represents the state of a point process, which is the
+// excitation of a Hawkes process, and the pending event times of the
+// components of a superposition, formatted like a candidate, along with
+// their own states.
+type ArrivalsCheckpoint struct {
+	Excitation float64               `json:"excitation,omitempty"`
+	ExcitedAt  float64               `json:"excited_at,omitempty"`
+	Pending    []string              `json:"pending,omitempty"`
+	Components []*ArrivalsCheckpoint `json:"components,omitempty"`
+}
+
+// Checkpointer represents a process whose position can be saved and
+// restored, so that a simulation can be resumed from a checkpoint.
+type Checkpointer interface {
+	// Checkpoint returns the position of the process, or an error if it
+	// has excitations that haven't been applied, which aren't part of its
+	// position. The process must not be stepped meanwhile.
+	Checkpoint() (ProcessCheckpoint, error)
+	// Restore resumes the process from the provided checkpoint when it's
+	// next stepped, or returns an error if the checkpoint isn't of the
+	// process. The process must not be stepped meanwhile.
+	Restore(c ProcessCheckpoint) error
+}
+
+// ChainCheckpoint represents the position of a Markov chain between its steps.
+type ChainCheckpoint struct {
+	State int           `json:"state"`
+	Tick  uint64        `json:"tick"`
+	Path  []StateChange `json:"path"`
+}
+
+func (p *BernoulliProcess) Checkpoint() (ProcessCheckpoint, error) {
+	return ProcessCheckpoint{Tick: p.clock.Position()}, nil
+}
+
+func (p *BernoulliProcess) Restore(c ProcessCheckpoint) error {
+	p.clock.SetPosition(c.Tick)
+	return nil
+}
+
+func (p *ArrivalProcess) Checkpoint() (ProcessCheckpoint, error) {
+	return ProcessCheckpoint{
+		Elapsed:   p.elapsed,
+		Candidate: formatCandidate(p.candidate),
+		Arrivals:  checkpointArrivals(p.arrivals),
+	}, nil
+}
+
+func (p *ArrivalProcess) Restore(c ProcessCheckpoint) error {
+	candidate, err := parseCandidate(c.Candidate)
+	if err != nil {
+		return err
+	}
+	if err := restoreArrivals(p.arrivals, c.Arrivals); err != nil {
+		return err
+	}
+	p.elapsed = c.Elapsed
+	p.candidate = candidate
+	return nil
+}
+
+func (p *HawkesProcess) Checkpoint() (ProcessCheckpoint, error) {
+	p.mu.Lock()
+	pending := len(p.pending)
+	p.mu.Unlock()
+	if pending > 0 {
+		return ProcessCheckpoint{}, fmt.Errorf("%w: excitations haven't been applied: %d", ErrCheckpoint, pending)
+	}
+	return ProcessCheckpoint{
+		Elapsed:    p.elapsed,
+		Excitation: p.hawkes.excitation,
+		ExcitedAt:  p.hawkes.at,
+		Candidate:  formatCandidate(p.candidate),
+		Bound:      p.bound,
+	}, nil
+}
+
+func (p *HawkesProcess) Restore(c ProcessCheckpoint) error {
+	candidate, err := parseCandidate(c.Candidate)
+	if err != nil {
+		return err
+	}
+	p.elapsed = c.Elapsed
+	p.hawkes.excitation = c.Excitation
+	p.hawkes.at = c.ExcitedAt
+	p.candidate = candidate
+	p.bound = c.Bound
+	return nil
+}
+
+func (p *RegimeProcess) Checkpoint() (ProcessCheckpoint, error) {
+	c := ProcessCheckpoint{Regimes: make([]*ProcessCheckpoint, len(p.processes))}
+	for i, proc := range p.processes {
+		if cp, ok := proc.(Checkpointer); ok {
+			rc, err := cp.Checkpoint()
+			if err != nil {
+				return ProcessCheckpoint{}, err
+			}
+			c.Regimes[i] = &rc
+		}
+	}
+	return c, nil
+}
+
+func (p *RegimeProcess) Restore(c ProcessCheckpoint) error {
+	if len(c.Regimes) != len(p.processes) {
+		return fmt.Errorf("%w: regimes of checkpoint don't match process: want=%d got=%d", ErrCheckpoint, len(p.processes), len(c.Regimes))
+	}
+	for i, proc := range p.processes {
+		cp, ok := proc.(Checkpointer)
+		if ok != (c.Regimes[i] != nil) {
+			return fmt.Errorf("%w: regime %d of checkpoint doesn't match process", ErrCheckpoint, i)
+		}
+		if !ok {
+			continue
+		}
+		if err := cp.Restore(*c.Regimes[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Checkpoint returns the position of the chain,
+// which must not be stepped meanwhile.
+func (m *MarkovChain) Checkpoint() ChainCheckpoint {
+	return ChainCheckpoint{
+		State: m.State(),
+		Tick:  m.clock.Position(),
+		Path:  m.Path(),
+	}
+}
+
+// Restore resumes the chain from the provided checkpoint when it's next
+// stepped, or returns an error if the checkpoint's state isn't one of
+// the chain's. The chain must not be stepped meanwhile.
+func (m *MarkovChain) Restore(c ChainCheckpoint) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if c.State < 0 || c.State >= len(m.states) {
+		return fmt.Errorf("%w: state out of range: max=%d got=%d", ErrCheckpoint, len(m.states)-1, c.State)
+	}
+	m.state = c.State
+	m.path = c.Path
+	m.clock.SetPosition(c.Tick)
+	return nil
+}
+
+// checkpointArrivals returns the state of the provided point process,
+// or nil if it has none.
+func checkpointArrivals(a Arrivals) *ArrivalsCheckpoint {
+	switch a := a.(type) {
+	case *Hawkes:
+		return &ArrivalsCheckpoint{Excitation: a.excitation, ExcitedAt: a.at}
+	case *Superposition:
+		c := &ArrivalsCheckpoint{
+			Pending:    make([]string, len(a.pending)),
+			Components: make([]*ArrivalsCheckpoint, len(a.arrivals)),
+		}
+		for i, t := range a.pending {
+			c.Pending[i] = strconv.FormatFloat(t, 'g', -1, 64)
+		}
+		for i, component := range a.arrivals {
+			c.Components[i] = checkpointArrivals(component)
+		}
+		return c
+	case Thinning:
+		return checkpointArrivals(a.Arrivals)
+	}
+	return nil
+}
+
+// restoreArrivals restores the provided point process to the provided
+// state, if it has one, or returns an error if the state isn't of it.
+func restoreArrivals(a Arrivals, c *ArrivalsCheckpoint) error {
+	if c == nil {
+		return nil
+	}
+	switch a := a.(type) {
+	case *Hawkes:
+		a.excitation, a.at = c.Excitation, c.ExcitedAt
+	case *Superposition:
+		if len(c.Pending) != len(a.pending) || len(c.Components) != len(a.arrivals) {
+			return fmt.Errorf("%w: components of checkpoint don't match superposition: want=%d got=%d", ErrCheckpoint, len(a.arrivals), len(c.Components))
+		}
+		pending := make([]float64, len(c.Pending))
+		for i, s := range c.Pending {
+			t, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				return fmt.Errorf("%w: %v", ErrCheckpoint, err)
+			}
+			pending[i] = t
+		}
+		for i, component := range a.arrivals {
+			if err := restoreArrivals(component, c.Components[i]); err != nil {
+				return err
+			}
+		}
+		copy(a.pending, pending)
+	case Thinning:
+		return restoreArrivals(a.Arrivals, c)
+	}
+	return nil
+}
+
+// formatCandidate returns the checkpoint of the provided candidate
+// event time, which is empty if there's no candidate.
+func formatCandidate(t *float64) string {
+	if t == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*t, 'g', -1, 64)
+}
+
+// parseCandidate returns the candidate event time of the provided
+// checkpoint, or nil if there's no candidate, or an error if it
+// isn't a time.
+func parseCandidate(s string) (*float64, error) {
+	if s == "" {
+		return nil, nil
+	}
+	t, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCheckpoint, err)
+	}
+	return &t, nil
+}
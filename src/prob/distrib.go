@@ -3,7 +3,6 @@ package prob
 import (
 	"fmt"
 	"math"
-	"math/rand"
 	"strings"
 )
 
@@ -57,7 +56,7 @@ func NewExponential(prob, lambda float64) Exponential {
 }
 
 func (e Exponential) Generate() float64 {
-	return rand.ExpFloat64() / e.Lambda
+	return Rand.ExpFloat64() / e.Lambda
 }
 
 func (e Exponential) Indicate() bool {
@@ -79,7 +78,7 @@ func NewNormal(prob, mean, stdDev float64) Normal {
 }
 
 func (n Normal) Generate() float64 {
-	return rand.NormFloat64()*n.StdDev + n.Mean
+	return Rand.NormFloat64()*n.StdDev + n.Mean
 }
 
 func (n Normal) Indicate() bool {
@@ -95,7 +94,7 @@ func NewUniform(prob float64) Uniform {
 }
 
 func (u Uniform) Generate() float64 {
-	return rand.Float64()
+	return Rand.Float64()
 }
 
 func (u Uniform) Indicate() bool {
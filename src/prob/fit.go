@@ -0,0 +1,215 @@
+package prob
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+var ErrSamples = errors.New("insufficient samples to fit distribution")
+
+type FitMethod = string
+
+const (
+	// FitMLE fits by maximum likelihood estimation.
+	FitMLE FitMethod = "mle"
+	// FitMoments fits by the method of moments.
+	FitMoments FitMethod = "moments"
+)
+
+var FitMethods = []FitMethod{
+	FitMLE,
+	FitMoments,
+}
+
+type FitMethodError struct {
+	Method string
+}
+
+func NewFitMethodError(method string) *FitMethodError {
+	return &FitMethodError{Method: method}
+}
+
+func (e FitMethodError) Error() string {
+	return fmt.Sprintf("unsupported fit method: supported=%s got=%s", strings.Join(FitMethods, ", "), e.Method)
+}
+
+// Param represents a named parameter of a fitted distribution.
+type Param struct {
+	Name  string
+	Value float64
+}
+
+// Fit represents the parameters of a distribution fitted
+// to a set of samples, and its goodness of fit to them.
+type Fit struct {
+	Type   DistribType
+	Method FitMethod
+	Params []Param
+	// N is the number of samples fitted.
+	N int
+	// KS is the Kolmogorov-Smirnov statistic of the fit, the greatest
+	// distance between the empirical and fitted distribution functions.
+	KS float64
+	// PValue is the asymptotic p-value of the Kolmogorov-Smirnov statistic.
+	PValue float64
+}
+
+func (f Fit) String() string {
+	var s strings.Builder
+	s.WriteString(fmt.Sprintf("distribution=%s method=%s n=%d ", f.Type, f.Method, f.N))
+	for _, p := range f.Params {
+		s.WriteString(fmt.Sprintf("%s=%f ", p.Name, p.Value))
+	}
+	s.WriteString(fmt.Sprintf("ks=%f p=%f", f.KS, f.PValue))
+	return s.String()
+}
+
+// FitDistribution fits a distribution of the provided type
+// to the provided samples by the provided method.
+//
+// Exponential distributions are fitted by their rate lambda,
+// normal distributions by their mean and standard deviation,
+// and uniform distributions by their lower and upper bounds.
+func FitDistribution(distribType DistribType, method FitMethod, samples []float64) (Fit, error) {
+	if method != FitMLE && method != FitMoments {
+		return Fit{}, NewFitMethodError(method)
+	}
+	if len(samples) < 2 {
+		return Fit{}, fmt.Errorf("%w: min=%d got=%d", ErrSamples, 2, len(samples))
+	}
+	mean, stdDev := moments(samples)
+	f := Fit{Type: distribType, Method: method, N: len(samples)}
+	var cdf func(float64) float64
+
+	switch distribType {
+	case DistribExp:
+		// The maximum likelihood estimate and first moment estimate
+		// of lambda are both the reciprocal of the sample mean.
+		if mean <= 0 {
+			return Fit{}, fmt.Errorf("%w: exponential samples must have a positive mean", ErrSamples)
+		}
+		lambda := 1 / mean
+		f.Params = []Param{{Name: "lambda", Value: lambda}}
+		cdf = func(x float64) float64 {
+			if x < 0 {
+				return 0
+			}
+			return 1 - math.Exp(-lambda*x)
+		}
+	case DistribNorm:
+		// The maximum likelihood estimate of the standard deviation
+		// is biased, while the moment estimate is corrected.
+		if method == FitMoments {
+			n := float64(len(samples))
+			stdDev *= math.Sqrt(n / (n - 1))
+		}
+		if stdDev <= 0 {
+			return Fit{}, fmt.Errorf("%w: normal samples must not be constant", ErrSamples)
+		}
+		f.Params = []Param{{Name: "mean", Value: mean}, {Name: "standard_deviation", Value: stdDev}}
+		cdf = func(x float64) float64 {
+			return 0.5 * math.Erfc(-(x-mean)/(stdDev*math.Sqrt2))
+		}
+	case DistribUni:
+		var lo, hi float64
+		if method == FitMLE {
+			lo, hi = math.Inf(1), math.Inf(-1)
+			for _, x := range samples {
+				lo, hi = math.Min(lo, x), math.Max(hi, x)
+			}
+		} else {
+			lo, hi = mean-math.Sqrt(3)*stdDev, mean+math.Sqrt(3)*stdDev
+		}
+		if hi <= lo {
+			return Fit{}, fmt.Errorf("%w: uniform samples must not be constant", ErrSamples)
+		}
+		f.Params = []Param{{Name: "min", Value: lo}, {Name: "max", Value: hi}}
+		cdf = func(x float64) float64 {
+			return math.Min(math.Max((x-lo)/(hi-lo), 0), 1)
+		}
+	default:
+		return Fit{}, NewDistribTypeError(distribType)
+	}
+
+	f.KS = KolmogorovSmirnov(samples, cdf)
+	f.PValue = kolmogorovPValue(f.KS, len(samples))
+	return f, nil
+}
+
+// FitDistributions fits every supported distribution type to the
+// provided samples by the provided method, ordered from best to worst fit
+// by Kolmogorov-Smirnov statistic. Distribution types that can't be
+// fitted to the samples are omitted.
+func FitDistributions(method FitMethod, samples []float64) ([]Fit, error) {
+	fits := make([]Fit, 0, len(DistribTypes))
+	for _, t := range DistribTypes {
+		f, err := FitDistribution(t, method, samples)
+		if errors.Is(err, ErrSamples) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		fits = append(fits, f)
+	}
+	if len(fits) == 0 {
+		return nil, fmt.Errorf("%w: n=%d", ErrSamples, len(samples))
+	}
+	sort.SliceStable(fits, func(i, j int) bool { return fits[i].KS < fits[j].KS })
+	return fits, nil
+}
+
+// KolmogorovSmirnov returns the Kolmogorov-Smirnov statistic of the
+// provided samples against the provided cumulative distribution function.
+func KolmogorovSmirnov(samples []float64, cdf func(float64) float64) float64 {
+	sorted := make([]float64, len(samples))
+	copy(sorted, samples)
+	sort.Float64s(sorted)
+
+	n := float64(len(sorted))
+	d := 0.0
+	for i, x := range sorted {
+		f := cdf(x)
+		d = math.Max(d, math.Max(f-float64(i)/n, float64(i+1)/n-f))
+	}
+	return d
+}
+
+// kolmogorovPValue returns the asymptotic probability that the
+// Kolmogorov-Smirnov statistic of n samples drawn from the fitted
+// distribution is at least d, with Stephens' small sample correction.
+func kolmogorovPValue(d float64, n int) float64 {
+	sqrtN := math.Sqrt(float64(n))
+	lambda := (sqrtN + 0.12 + 0.11/sqrtN) * d
+	if lambda < 1e-3 {
+		return 1
+	}
+	sum, sign := 0.0, 1.0
+	for j := 1; j <= 100; j++ {
+		term := sign * math.Exp(-2*float64(j*j)*lambda*lambda)
+		sum += term
+		if math.Abs(term) < 1e-12 {
+			break
+		}
+		sign = -sign
+	}
+	return math.Min(math.Max(2*sum, 0), 1)
+}
+
+// moments returns the mean and the maximum likelihood
+// estimate of the standard deviation of the provided samples.
+func moments(samples []float64) (float64, float64) {
+	n := float64(len(samples))
+	mean := 0.0
+	for _, x := range samples {
+		mean += x
+	}
+	mean /= n
+	variance := 0.0
+	for _, x := range samples {
+		variance += (x - mean) * (x - mean)
+	}
+	return mean, math.Sqrt(variance / n)
+}
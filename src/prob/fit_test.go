@@ -0,0 +1,86 @@
+package prob
+
+import (
+	"errors"
+	"testing"
+)
+
+// TestFitExponential asserts that fitting an exponential distribution to
+// exponentially distributed samples recovers their rate with a good fit.
+func TestFitExponential(t *testing.T) {
+	Rng.Seed(1)
+	samples := make([]float64, 5000)
+	for i := range samples {
+		samples[i] = NewExponential(0, 3).Generate()
+	}
+
+	for _, method := range FitMethods {
+		f, err := FitDistribution(DistribExp, method, samples)
+		if err != nil {
+			t.Fatalf("fit: %v", err)
+		}
+		if expected, actual := 3.0, f.Params[0].Value; !within(expected, actual, 0.05) {
+			t.Errorf("%s lambda: expected: %f actual: %f", method, expected, actual)
+		}
+		if f.PValue < 0.01 {
+			t.Errorf("%s p-value: expected: >=%f actual: %f", method, 0.01, f.PValue)
+		}
+	}
+}
+
+// TestFitNormal asserts that fitting a normal distribution to normally
+// distributed samples recovers their mean and standard deviation.
+func TestFitNormal(t *testing.T) {
+	Rng.Seed(1)
+	samples := make([]float64, 5000)
+	for i := range samples {
+		samples[i] = NewNormal(0, 10, 2).Generate()
+	}
+
+	f, err := FitDistribution(DistribNorm, FitMLE, samples)
+	if err != nil {
+		t.Fatalf("fit: %v", err)
+	}
+	if expected, actual := 10.0, f.Params[0].Value; !within(expected, actual, 0.01) {
+		t.Errorf("mean: expected: %f actual: %f", expected, actual)
+	}
+	if expected, actual := 2.0, f.Params[1].Value; !within(expected, actual, 0.05) {
+		t.Errorf("standard deviation: expected: %f actual: %f", expected, actual)
+	}
+}
+
+// TestFitDistributionsOrder asserts that the best fit to uniformly
+// distributed samples is the uniform distribution.
+func TestFitDistributionsOrder(t *testing.T) {
+	Rng.Seed(1)
+	samples := make([]float64, 5000)
+	for i := range samples {
+		samples[i] = NewUniform(0).Generate()
+	}
+
+	fits, err := FitDistributions(FitMLE, samples)
+	if err != nil {
+		t.Fatalf("fit: %v", err)
+	}
+	if expected, actual := DistribUni, fits[0].Type; expected != actual {
+		t.Errorf("best fit: expected: %s actual: %s", expected, actual)
+	}
+}
+
+// TestFitInsufficientSamples asserts that fitting fewer than two samples fails.
+func TestFitInsufficientSamples(t *testing.T) {
+	if _, err := FitDistribution(DistribNorm, FitMLE, []float64{1}); !errors.Is(err, ErrSamples) {
+		t.Errorf("error: expected: %v actual: %v", ErrSamples, err)
+	}
+}
+
+// TestKolmogorovSmirnov asserts the statistic of samples
+// against a distribution function they match exactly.
+func TestKolmogorovSmirnov(t *testing.T) {
+	samples := []float64{0.125, 0.375, 0.625, 0.875}
+	d := KolmogorovSmirnov(samples, func(x float64) float64 { return x })
+
+	if expected := 0.125; !within(expected, d, 1e-9) {
+		t.Errorf("statistic: expected: %f actual: %f", expected, d)
+	}
+}
@@ -0,0 +1,163 @@
+package prob
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Exciter represents a process whose intensity
+// can be raised by events external to it.
+type Exciter interface {
+	// Excite raises the intensity of the process as if an event with the
+	// provided weight occurred now, where the weight is the expected
+	// number of additional events the excitation triggers.
+	Excite(weight float64)
+}
+
+// Hawkes represents a self-exciting Hawkes process with an exponential
+// excitation kernel, whose conditional intensity at time t is
+//
+//     Baseline + sum over events t_i < t of w_i * Decay * exp(-Decay * (t - t_i))
+//
+// where w_i is the weight of event i. Every event of the process itself
+// has a weight of BranchingRatio, the expected number of events directly
+// triggered by each event, which must be less than 1 for the process
+// to be stationary.
+//
+// Event times are generated by Ogata's thinning algorithm.
+// A Hawkes process is stateful and not safe for concurrent use.
+type Hawkes struct {
+	Baseline       float64
+	Decay          float64
+	BranchingRatio float64
+	// excitation is the excited part of the intensity at time at.
+	excitation float64
+	at         float64
+}
+
+func NewHawkes(baseline, decay, branchingRatio float64) *Hawkes {
+	return &Hawkes{
+		Baseline:       baseline,
+		Decay:          decay,
+		BranchingRatio: branchingRatio,
+	}
+}
+
+// Intensity returns the conditional intensity of the process at time t,
+// which must not be before the last event or excitation.
+func (h *Hawkes) Intensity(t float64) float64 {
+	return h.Baseline + h.excitation*math.Exp(-h.Decay*(t-h.at))
+}
+
+// Excite raises the intensity of the process at time t by an event
+// with the provided weight.
+func (h *Hawkes) Excite(t, weight float64) {
+	h.excitation = h.Intensity(t) - h.Baseline + weight*h.Decay
+	h.at = t
+}
+
+func (h *Hawkes) Next(t float64) float64 {
+	for s := t; ; {
+		// The intensity decays between events, so its
+		// value at s bounds it until the next event.
+		bound := h.Intensity(s)
+		if bound <= 0 {
+			return math.Inf(1)
+		}
+		s += Rand.ExpFloat64() / bound
+		if Rand.Float64()*bound <= h.Intensity(s) {
+			h.Excite(s, h.BranchingRatio)
+			return s
+		}
+	}
+}
+
+// HawkesProcess steps a Hawkes process through its events,
+// and accepts excitations from other processes.
+type HawkesProcess struct {
+	// hawkes generates the event times of the process.
+	hawkes *Hawkes
+	// unit represents the duration of one unit of process time.
+	unit time.Duration
+	// mu guards pending.
+	mu sync.Mutex
+	// pending are the weights of the excitations that have not yet been
+	// applied, which are applied once the process is next stepped.
+	pending []float64
+	// elapsed is the process time that thinning restarts from.
+	elapsed float64
+	// candidate is the process time of the candidate event of the
+	// process once it's drawn, and bound is the intensity it was drawn
+	// at, against which it's thinned.
+	candidate *float64
+	bound     float64
+}
+
+func NewHawkesProcess(hawkes *Hawkes, unit time.Duration) *HawkesProcess {
+	return &HawkesProcess{
+		hawkes: hawkes,
+		unit:   unit,
+	}
+}
+
+// Excite raises the intensity of the process by an event with the
+// provided weight. It never blocks, and is safe for concurrent use.
+func (p *HawkesProcess) Excite(weight float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pending = append(p.pending, weight)
+}
+
+// Next applies the pending excitations of the process at the provided
+// time, and returns the process's next candidate event, drawn from the
+// intensity at its last candidate or excitation, by Ogata's thinning.
+// An excitation replaces the candidate, since thinning restarts from it,
+// which is valid because candidate inter-arrival times are exponentially
+// distributed, and so memoryless.
+func (p *HawkesProcess) Next(now time.Duration) (time.Duration, bool) {
+	at := float64(now) / float64(p.unit)
+	if p.applyPending(at) {
+		p.candidate = nil
+		p.elapsed = math.Max(p.elapsed, at)
+	}
+	if p.candidate == nil {
+		bound := p.hawkes.Intensity(p.elapsed)
+		if bound <= 0 {
+			return 0, false
+		}
+		t := p.elapsed + Rand.ExpFloat64()/bound
+		p.candidate, p.bound = &t, bound
+	}
+	return processDuration(*p.candidate, p.unit)
+}
+
+// Step thins the process's candidate event, which is an event of the
+// process with the probability of its intensity over the bound it was
+// drawn at, and excites the process if it is.
+func (p *HawkesProcess) Step() bool {
+	if p.candidate == nil {
+		return false
+	}
+	w := *p.candidate
+	p.candidate = nil
+	p.elapsed = w
+	if Rand.Float64()*p.bound > p.hawkes.Intensity(w) {
+		return false
+	}
+	p.hawkes.Excite(w, p.hawkes.BranchingRatio)
+	return true
+}
+
+// applyPending applies the pending excitations to the Hawkes process
+// at the provided process time, and returns whether any were pending.
+func (p *HawkesProcess) applyPending(at float64) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, weight := range p.pending {
+		p.hawkes.Excite(math.Max(at, p.hawkes.at), weight)
+	}
+	applied := len(p.pending) > 0
+	p.pending = p.pending[:0]
+	return applied
+}
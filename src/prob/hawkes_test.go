@@ -0,0 +1,63 @@
+package prob
+
+import (
+	"testing"
+	"time"
+)
+
+// TestHawkesRate asserts that a stationary Hawkes process generates events
+// at its baseline intensity divided by one minus its branching ratio on average.
+func TestHawkesRate(t *testing.T) {
+	Rng.Seed(1)
+	h := NewHawkes(1, 2, 0.5)
+
+	expected := 1 / (1 - 0.5) * 20000.0
+	if actual := float64(count(h, 20000)); !within(expected, actual, 0.05) {
+		t.Errorf("event count: expected: %f actual: %f", expected, actual)
+	}
+}
+
+// TestHawkesExcite asserts that exciting a Hawkes process raises its
+// intensity by the weight times the decay rate, which then decays back
+// towards the baseline.
+func TestHawkesExcite(t *testing.T) {
+	h := NewHawkes(1, 2, 0.5)
+	h.Excite(10, 3)
+
+	if expected, actual := 7.0, h.Intensity(10); !within(expected, actual, 1e-9) {
+		t.Errorf("excited intensity: expected: %f actual: %f", expected, actual)
+	}
+	if actual := h.Intensity(20); !within(1, actual, 1e-6) {
+		t.Errorf("decayed intensity: expected: %f actual: %f", 1.0, actual)
+	}
+}
+
+// TestHawkesWithoutBaseline asserts that a Hawkes process
+// with no baseline intensity and no excitation has no events.
+func TestHawkesWithoutBaseline(t *testing.T) {
+	h := NewHawkes(0, 2, 0.5)
+
+	if n := count(h, 1000); n != 0 {
+		t.Errorf("event count: expected: %d actual: %d", 0, n)
+	}
+}
+
+// TestHawkesProcessExcite asserts that a stepped Hawkes process without a
+// baseline intensity only has events once it's excited, from the time it's
+// excited.
+func TestHawkesProcessExcite(t *testing.T) {
+	Rng.Seed(1)
+	p := NewHawkesProcess(NewHawkes(0, 1, 0), time.Millisecond)
+
+	if _, ok := p.Next(0); ok {
+		t.Fatalf("event: expected: none before excitation")
+	}
+	p.Excite(100)
+	at, ok := p.Next(20 * time.Millisecond)
+	if !ok {
+		t.Fatalf("event: expected: event after excitation actual: none")
+	}
+	if at < 20*time.Millisecond {
+		t.Errorf("event time: expected: after %s actual: %s", 20*time.Millisecond, at)
+	}
+}
@@ -0,0 +1,168 @@
+package prob
+
+import (
+	"fmt"
+	"sync"
+	"time"
+	"tradesim/src/time/clock"
+)
+
+// StateChange represents the entry of a Markov chain into a state.
+type StateChange struct {
+	Time  time.Time
+	State string
+}
+
+func (s StateChange) String() string {
+	return fmt.Sprintf("regime state=%s time=%s", s.State, s.Time.Format(time.RFC3339Nano))
+}
+
+// MarkovChain represents a discrete-time Markov chain on a finite
+// state space, which advances one step on every clock tick.
+//
+// A Markov chain is safe for concurrent use.
+type MarkovChain struct {
+	// states are the names of the states of the chain.
+	states []string
+	// transitions is the transition matrix of the chain, where
+	// transitions[i][j] is the probability of moving from state i to j.
+	transitions [][]float64
+	// clock represents the discrete-time index set of the chain.
+	clock clock.Clock
+	// mu guards state and path.
+	mu sync.RWMutex
+	// state is the index of the current state of the chain.
+	state int
+	// path is the sequence of states the chain has entered.
+	path []StateChange
+}
+
+// NewMarkovChain returns a Markov chain with the provided states
+// and transition matrix, beginning in the initial state.
+// Each row of the transition matrix should sum to 1;
+// any remaining probability mass of a row stays in its state.
+func NewMarkovChain(states []string, transitions [][]float64, initial int, clock clock.Clock) *MarkovChain {
+	return &MarkovChain{
+		states:      states,
+		transitions: transitions,
+		clock:       clock,
+		state:       initial,
+	}
+}
+
+// Begin enters the chain into its current state at the provided time,
+// unless it has already entered a state, so that its path starts at the
+// time it's first stepped from.
+func (m *MarkovChain) Begin(t time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.path) == 0 {
+		m.path = append(m.path, StateChange{Time: t.UTC(), State: m.states[m.state]})
+	}
+}
+
+// Due returns the simulated time of the chain's next step, as the
+// duration since its origin, and false if its clock has reached its
+// limit.
+func (m *MarkovChain) Due() (time.Duration, bool) {
+	return m.clock.Next()
+}
+
+// Advance steps the chain's clock, and then the chain at the provided
+// time, and returns the index of its resulting state.
+func (m *MarkovChain) Advance(t time.Time) int {
+	m.clock.Step()
+	return m.Step(t)
+}
+
+// Step advances the chain one step at the provided time,
+// and returns the index of its resulting state.
+func (m *MarkovChain) Step(t time.Time) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	next := m.state
+	u, cumulative := Rand.Float64(), 0.0
+	for j, p := range m.transitions[m.state] {
+		cumulative += p
+		if u < cumulative {
+			next = j
+			break
+		}
+	}
+	if next != m.state {
+		m.state = next
+		m.path = append(m.path, StateChange{Time: t.UTC(), State: m.states[next]})
+	}
+	return m.state
+}
+
+// State returns the index of the current state of the chain.
+func (m *MarkovChain) State() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.state
+}
+
+// States returns the names of the states of the chain.
+func (m *MarkovChain) States() []string {
+	return m.states
+}
+
+// Path returns the sequence of states the chain has entered since it started.
+func (m *MarkovChain) Path() []StateChange {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	path := make([]StateChange, len(m.path))
+	copy(path, m.path)
+	return path
+}
+
+// RegimeProcess represents a process that switches between
+// a set of processes according to the state of a Markov chain.
+// Only the events of the process of the chain's current state are events
+// of the regime process, so each state selects the distribution or arrival
+// rate in effect.
+type RegimeProcess struct {
+	// chain is the Markov chain whose state selects the active process.
+	chain *MarkovChain
+	// processes are the processes indexed by state,
+	// where a nil process has no events in its state.
+	processes []Process
+	// next is the state of the process of the next candidate event.
+	next int
+}
+
+func NewRegimeProcess(chain *MarkovChain, processes []Process) *RegimeProcess {
+	return &RegimeProcess{
+		chain:     chain,
+		processes: processes,
+	}
+}
+
+// Next returns the earliest candidate event of the processes of every
+// state, where the process of the lowest state is first at equal times.
+func (p *RegimeProcess) Next(now time.Duration) (time.Duration, bool) {
+	next, found := time.Duration(0), false
+	for i, proc := range p.processes {
+		if proc == nil {
+			continue
+		}
+		t, ok := proc.Next(now)
+		if ok && (!found || t < next) {
+			next, found, p.next = t, true, i
+		}
+	}
+	return next, found
+}
+
+// Step steps the process of the next candidate event, whose
+// event is only an event of the regime process if the chain
+// is in the process's state.
+func (p *RegimeProcess) Step() bool {
+	proc := p.processes[p.next]
+	if proc == nil {
+		return false
+	}
+	return proc.Step() && p.chain.State() == p.next
+}
@@ -0,0 +1,62 @@
+package prob
+
+import (
+	"testing"
+	"time"
+	"tradesim/src/time/clock"
+)
+
+// TestMarkovChainStationary asserts that the fraction of steps a two-state
+// Markov chain spends in each state converges to its stationary distribution.
+func TestMarkovChainStationary(t *testing.T) {
+	Rng.Seed(1)
+	m := NewMarkovChain(
+		[]string{"calm", "stressed"},
+		[][]float64{{0.9, 0.1}, {0.3, 0.7}},
+		0,
+		clock.NewClock(time.Second, 0),
+	)
+
+	steps, stressed := 100000, 0.0
+	for i := 0; i < steps; i++ {
+		if m.Step(time.Now()) == 1 {
+			stressed++
+		}
+	}
+	// The stationary probability of the stressed state is 0.1 / (0.1 + 0.3).
+	expected := 0.25
+	if actual := stressed / float64(steps); !within(expected, actual, 0.05) {
+		t.Errorf("stressed fraction: expected: %f actual: %f", expected, actual)
+	}
+}
+
+// TestMarkovChainPath asserts that the path of a Markov chain
+// records only steps that enter a different state.
+func TestMarkovChainPath(t *testing.T) {
+	m := NewMarkovChain(
+		[]string{"a", "b"},
+		[][]float64{{0, 1}, {1, 0}},
+		0,
+		clock.NewClock(time.Second, 0),
+	)
+	absorbing := NewMarkovChain(
+		[]string{"a", "b"},
+		[][]float64{{1, 0}, {1, 0}},
+		0,
+		clock.NewClock(time.Second, 0),
+	)
+
+	for i := 0; i < 10; i++ {
+		m.Step(time.Now())
+		absorbing.Step(time.Now())
+	}
+	if expected, actual := 10, len(m.Path()); expected != actual {
+		t.Errorf("alternating path length: expected: %d actual: %d", expected, actual)
+	}
+	if expected, actual := "a", m.Path()[9].State; expected != actual {
+		t.Errorf("alternating final state: expected: %s actual: %s", expected, actual)
+	}
+	if expected, actual := 0, len(absorbing.Path()); expected != actual {
+		t.Errorf("absorbing path length: expected: %d actual: %d", expected, actual)
+	}
+}
@@ -1,42 +1,83 @@
 package prob
 
 import (
-	"context"
+	"fmt"
+	"strings"
 	"time"
 	"tradesim/src/time/clock"
 )
 
-// Process represents a discrete-time stochastic process.
-type Process struct {
-	// Event receives a clock tick when the success event
-	// of the probability distribution is satisfied.
-	Event chan time.Time
+// Process represents a stochastic process that is stepped through its
+// events in simulated time, so that a simulation driven by it is
+// reproducible whatever the speed it runs at.
+type Process interface {
+	// Next returns the simulated time of the process's next candidate
+	// event, as the duration since its origin, and false if it has none.
+	// The provided time is the current simulated time, at which any
+	// pending excitations of the process are applied. The candidate is
+	// kept until it's stepped, unless an excitation replaces it.
+	Next(now time.Duration) (time.Duration, bool)
+	// Step steps the process to its next candidate event, and returns
+	// whether the candidate is an event of the process.
+	Step() bool
+}
+
+type ProcessType = string
+
+const (
+	ProcessBernoulli     ProcessType = "bernoulli"
+	ProcessPoisson       ProcessType = "poisson"
+	ProcessNHPoisson     ProcessType = "nonhomogeneous_poisson"
+	ProcessSuperposition ProcessType = "superposition"
+	ProcessRegime        ProcessType = "regime"
+	ProcessHawkes        ProcessType = "hawkes"
+)
+
+var ProcessTypes = []ProcessType{
+	ProcessBernoulli,
+	ProcessPoisson,
+	ProcessNHPoisson,
+	ProcessSuperposition,
+	ProcessRegime,
+	ProcessHawkes,
+}
+
+type ProcessTypeError struct {
+	Type string
+}
+
+func NewProcessTypeError(processType string) *ProcessTypeError {
+	return &ProcessTypeError{Type: processType}
+}
+
+func (e ProcessTypeError) Error() string {
+	return fmt.Sprintf("unsupported process type: supported=%s got=%s", strings.Join(ProcessTypes, ", "), e.Type)
+}
+
+// BernoulliProcess represents a discrete-time stochastic process
+// that performs a Bernoulli trial on every clock tick, and so
+// has at most one event per tick.
+type BernoulliProcess struct {
 	// distribution represents the probability distribution of the process.
 	distribution Distribution
 	// clock represents the discrete-time index set of the process.
 	clock clock.Clock
 }
 
-func NewProcess(distribution Distribution, clock clock.Clock) *Process {
-	return &Process{
-		Event:        make(chan time.Time, 8),
+func NewBernoulliProcess(distribution Distribution, clock clock.Clock) *BernoulliProcess {
+	return &BernoulliProcess{
 		distribution: distribution,
 		clock:        clock,
 	}
 }
 
-func (p *Process) Start(ctx context.Context) error {
-	go p.clock.Start(ctx)
-	for {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		case <-p.clock.Done:
-			return nil
-		case t := <-p.clock.Tick:
-			if ok := p.distribution.Indicate(); ok {
-				p.Event <- t
-			}
-		}
-	}
+// Next returns the simulated time of the next tick of the process's clock.
+func (p *BernoulliProcess) Next(time.Duration) (time.Duration, bool) {
+	return p.clock.Next()
+}
+
+// Step steps the process's clock, and performs the Bernoulli trial of the tick.
+func (p *BernoulliProcess) Step() bool {
+	p.clock.Step()
+	return p.distribution.Indicate()
 }
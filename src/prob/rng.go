@@ -0,0 +1,79 @@
+package prob
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Source is a seedable source of pseudo-random numbers,
+// implementing the SplitMix64 generator, whose state can be
+// saved and restored so that a simulation can be resumed.
+//
+// A source is safe for concurrent use.
+type Source struct {
+	// mu guards state.
+	mu    sync.Mutex
+	state uint64
+}
+
+func NewSource(seed int64) *Source {
+	return &Source{state: uint64(seed)}
+}
+
+func (s *Source) Seed(seed int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state = uint64(seed)
+}
+
+func (s *Source) Uint64() uint64 {
+	s.mu.Lock()
+	s.state += 0x9e3779b97f4a7c15
+	z := s.state
+	s.mu.Unlock()
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+func (s *Source) Int63() int64 {
+	return int64(s.Uint64() >> 1)
+}
+
+// Read fills p with pseudo-random bytes, so that a source can generate
+// random identifiers. It always returns len(p) and a nil error.
+func (s *Source) Read(p []byte) (int, error) {
+	for i := 0; i < len(p); i += 8 {
+		v := s.Uint64()
+		for j := i; j < i+8 && j < len(p); j++ {
+			p[j] = byte(v)
+			v >>= 8
+		}
+	}
+	return len(p), nil
+}
+
+// State returns the current state of the source.
+func (s *Source) State() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state
+}
+
+// SetState restores a state of the source returned by State.
+func (s *Source) SetState(state uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state = state
+}
+
+var (
+	// Rng is the source of every random number
+	// generated by processes and distributions.
+	Rng = NewSource(time.Now().UnixNano())
+	// Rand generates random numbers from Rng. Only its methods
+	// that are safe for concurrent use with a concurrency-safe
+	// source, which exclude Read and Seed, may be used.
+	Rand = rand.New(Rng)
+)
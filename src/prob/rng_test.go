@@ -0,0 +1,21 @@
+package prob
+
+import "testing"
+
+// TestSourceRestore asserts that a source restored to a saved state
+// generates the same numbers it generated after the state was saved.
+func TestSourceRestore(t *testing.T) {
+	s := NewSource(1)
+	for i := 0; i < 10; i++ {
+		s.Uint64()
+	}
+	state := s.State()
+	expected := []uint64{s.Uint64(), s.Uint64(), s.Uint64()}
+
+	s.SetState(state)
+	for i, e := range expected {
+		if actual := s.Uint64(); e != actual {
+			t.Errorf("number %d: expected: %d actual: %d", i, e, actual)
+		}
+	}
+}
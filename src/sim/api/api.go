@@ -0,0 +1,313 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"tradesim/src/db"
+	"tradesim/src/exchange"
+	"tradesim/src/trade"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// defaultLimit is the number of recent trades returned
+	// when a request doesn't provide a limit.
+	defaultLimit = 20
+	// maxLimit bounds the number of recent trades returned.
+	maxLimit = 1000
+	// streamBuffer is the number of events buffered for each stream,
+	// beyond which events are dropped for a slow client.
+	streamBuffer = 256
+	// shutdownTimeout bounds how long in-flight requests
+	// are waited on once the server is stopped.
+	shutdownTimeout = 5 * time.Second
+)
+
+var ErrAPI = errors.New("failed to serve simulation API")
+
+// Status represents the status of a running simulation's clock.
+type Status struct {
+	StartedOn time.Time `json:"started_on"`
+	// Elapsed is the simulated duration that has elapsed,
+	// and Duration is the total, where 0 has no limit.
+	Elapsed  float64 `json:"elapsed_seconds"`
+	Duration float64 `json:"duration_seconds"`
+	Running  bool    `json:"running"`
+	// Regime is the current state of the regime chain, if any.
+	Regime string `json:"regime,omitempty"`
+}
+
+// Market represents a market of an exchange and its traders.
+type Market struct {
+	Item      trade.Item  `json:"item"`
+	TraderIDs []uuid.UUID `json:"trader_ids"`
+}
+
+// Trader represents the holdings of a trader.
+type Trader struct {
+	ID    uuid.UUID    `json:"id"`
+	Haves []trade.Have `json:"haves"`
+	Wants []trade.Want `json:"wants"`
+}
+
+// Chain represents the status of an exchange's blockchain.
+type Chain struct {
+	Height int            `json:"height"`
+	Mining db.MiningStats `json:"mining"`
+	// Tips are the hashes of the last block of every branch, starting
+	// with the canonical chain, and Reorgs are its reorganizations.
+	Tips   []string   `json:"tips"`
+	Reorgs []db.Reorg `json:"reorgs"`
+}
+
+// Server serves the markets, traders, trades and blockchain of a running
+// exchange, and the status of its simulation, as JSON over HTTP.
+//
+// Routes are
+//
+//     GET /markets             every market and its trader IDs
+//     GET /traders/{id}        the haves and wants of a trader
+//     GET /trades?limit={n}    the most recent trades, latest first
+//     GET /chain               the height of the blockchain
+//     GET /blocks/{height}     the block at a height, or its canonical
+//                              binary encoding with ?format=binary
+//     GET /blocks/{hash}       the block with a hash, on any branch
+//     GET /status              the status of the simulation clock
+//     GET /stream?market={item id}&trader={id}
+//                              every trade and block, as server-sent events
+type Server struct {
+	exchange *exchange.Exchange
+	status   func() Status
+	mux      *http.ServeMux
+}
+
+// NewServer returns a server of the provided exchange,
+// whose simulation status is returned by the provided function.
+func NewServer(e *exchange.Exchange, status func() Status) *Server {
+	s := &Server{
+		exchange: e,
+		status:   status,
+		mux:      http.NewServeMux(),
+	}
+	s.mux.HandleFunc("/markets", s.markets)
+	s.mux.HandleFunc("/traders/", s.trader)
+	s.mux.HandleFunc("/trades", s.trades)
+	s.mux.HandleFunc("/chain", s.chain)
+	s.mux.HandleFunc("/blocks/", s.block)
+	s.mux.HandleFunc("/status", s.simStatus)
+	s.mux.HandleFunc("/stream", s.stream)
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("unsupported method: %s", r.Method))
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+// Serve serves the server on the provided listener
+// until the provided context is done.
+func (s *Server) Serve(ctx context.Context, ln net.Listener) error {
+	// Requests, such as streams, are cancelled once the server stops.
+	srv := &http.Server{Handler: s, BaseContext: func(net.Listener) context.Context { return ctx }}
+	errs := make(chan error, 1)
+	go func() { errs <- srv.Serve(ln) }()
+	select {
+	case err := <-errs:
+		return fmt.Errorf("%w: %v", ErrAPI, err)
+	case <-ctx.Done():
+		c, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		return srv.Shutdown(c)
+	}
+}
+
+func (s *Server) markets(w http.ResponseWriter, r *http.Request) {
+	markets := make([]Market, 0, len(s.exchange.Markets))
+	for _, m := range s.exchange.Markets {
+		market := Market{Item: m.Item, TraderIDs: make([]uuid.UUID, 0, len(m.TraderByID))}
+		for id := range m.TraderByID {
+			market.TraderIDs = append(market.TraderIDs, id)
+		}
+		sort.Slice(market.TraderIDs, func(i, j int) bool {
+			return market.TraderIDs[i].String() < market.TraderIDs[j].String()
+		})
+		markets = append(markets, market)
+	}
+	sort.Slice(markets, func(i, j int) bool { return markets[i].Item.ID.String() < markets[j].Item.ID.String() })
+	writeJSON(w, markets)
+}
+
+func (s *Server) trader(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(strings.TrimPrefix(r.URL.Path, "/traders/"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("trader id: %v", err))
+		return
+	}
+	t, ok := s.exchange.Trader(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no trader found: %s", id))
+		return
+	}
+	haves, wants := t.Holdings()
+	writeJSON(w, Trader{ID: t.ID, Haves: haves, Wants: wants})
+}
+
+func (s *Server) trades(w http.ResponseWriter, r *http.Request) {
+	limit := defaultLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxLimit {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("limit: expected: 1 to %d got: %s", maxLimit, v))
+			return
+		}
+		limit = n
+	}
+	trades := make([]db.IndexEntry, 0, limit)
+	for _, b := range s.exchange.DB.Recent(limit) {
+		for i := len(b.Transactions) - 1; i >= 0 && len(trades) < limit; i-- {
+			trades = append(trades, db.IndexEntry{Height: b.Height, CreatedOn: b.CreatedOn, Transaction: b.Transactions[i]})
+		}
+	}
+	writeJSON(w, trades)
+}
+
+func (s *Server) chain(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, Chain{
+		Height: s.exchange.DB.Height(),
+		Mining: s.exchange.DB.Mining(),
+		Tips:   s.exchange.DB.Tips(),
+		Reorgs: s.exchange.DB.Reorgs(),
+	})
+}
+
+func (s *Server) block(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/blocks/")
+	height, err := strconv.Atoi(key)
+	if err != nil {
+		if !isHash(key) {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("block height or hash: %v", err))
+			return
+		}
+		b, ok := s.exchange.DB.BlockByHash(key)
+		if !ok {
+			writeError(w, http.StatusNotFound, fmt.Errorf("no block found with hash: %s", key))
+			return
+		}
+		writeJSON(w, b)
+		return
+	}
+	if r.URL.Query().Get("format") == "binary" {
+		p, err := s.exchange.DB.EncodeBlock(height)
+		if errors.Is(err, db.ErrUnknownBlock) {
+			writeError(w, http.StatusNotFound, fmt.Errorf("no block found at height: %d", height))
+			return
+		} else if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(p)
+		return
+	}
+	b, ok := s.exchange.DB.Block(height)
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no block found at height: %d", height))
+		return
+	}
+	writeJSON(w, b)
+}
+
+// isHash returns whether the provided string is the hex encoding of a block hash.
+func isHash(s string) bool {
+	if len(s) != 2*sha256.Size {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+func (s *Server) simStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, s.status())
+}
+
+// stream writes every published trade and block matching the request's
+// market and trader as a server-sent event, until the request is done.
+// Each event's type is its feed event type, and its data is its JSON
+// encoding. Events dropped because the client is too slow are reported
+// in a comment of the form ": dropped={n}".
+func (s *Server) stream(w http.ResponseWriter, r *http.Request) {
+	var f exchange.Filter
+	var err error
+	if v := r.URL.Query().Get("market"); v != "" {
+		if f.ItemID, err = uuid.Parse(v); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("market: %v", err))
+			return
+		}
+	}
+	if v := r.URL.Query().Get("trader"); v != "" {
+		if f.TraderID, err = uuid.Parse(v); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("trader: %v", err))
+			return
+		}
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errors.New("streaming unsupported"))
+		return
+	}
+
+	sub := s.exchange.Subscribe(f, streamBuffer)
+	defer s.exchange.Unsubscribe(sub)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	var dropped uint64
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case ev := <-sub.Events():
+			if n := sub.Dropped(); n != dropped {
+				dropped = n
+				fmt.Fprintf(w, ": dropped=%d\n\n", n)
+			}
+			data, err := json.Marshal(ev)
+			if err != nil {
+				return
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, data); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(struct {
+		Error string `json:"error"`
+	}{Error: err.Error()})
+}
@@ -0,0 +1,148 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"tradesim/src/db"
+	"tradesim/src/exchange"
+	"tradesim/src/trade"
+
+	"github.com/google/uuid"
+)
+
+// get returns the status code of a GET request to the provided
+// server and path, decoding its JSON body into v.
+func get(t *testing.T, s *Server, path string, v interface{}) int {
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	if err := json.NewDecoder(rec.Body).Decode(v); err != nil {
+		t.Fatalf("%s: decode: %v", path, err)
+	}
+	return rec.Code
+}
+
+// TestServer asserts that the server serves the markets, traders,
+// trades and blocks of an exchange, and the simulation status.
+func TestServer(t *testing.T) {
+	item := trade.NewItem("a")
+	trader, err := trade.NewTrader([]trade.Have{{Item: item, Price: 2, Quantity: 3}}, nil, nil)
+	if err != nil {
+		t.Fatalf("new trader: %v", err)
+	}
+	e := exchange.NewExchange([]exchange.Market{exchange.NewMarket(item, trader)})
+	resp := trade.Response{ID: uuid.New(), Request: trade.Request{ID: uuid.New(), TraderID: trader.ID, Item: item}, TraderID: trader.ID}
+	trader.SignQuote(&resp)
+	trader.SignChoice(&resp)
+	txn := resp.Transaction()
+	txn.ID = uuid.New()
+	e.DB.Append(db.NewBlock(&txn))
+	s := NewServer(e, func() Status { return Status{Elapsed: 1, Running: true} })
+
+	var markets []Market
+	if code := get(t, s, "/markets", &markets); code != http.StatusOK || len(markets) != 1 || markets[0].TraderIDs[0] != trader.ID {
+		t.Errorf("markets: expected: one market with trader %s actual: %d %+v", trader.ID, code, markets)
+	}
+	var tr Trader
+	if code := get(t, s, "/traders/"+trader.ID.String(), &tr); code != http.StatusOK || len(tr.Haves) != 1 || tr.Haves[0].Quantity != 3 {
+		t.Errorf("trader: expected: one have of quantity 3 actual: %d %+v", code, tr)
+	}
+	var trades []db.IndexEntry
+	if code := get(t, s, "/trades", &trades); code != http.StatusOK || len(trades) != 1 || trades[0].Transaction.ID != txn.ID {
+		t.Errorf("trades: expected: transaction %s actual: %d %+v", txn.ID, code, trades)
+	}
+	var chain Chain
+	if code := get(t, s, "/chain", &chain); code != http.StatusOK || chain.Height != 1 {
+		t.Errorf("chain: expected: height 1 actual: %d %+v", code, chain)
+	}
+	var block db.BlockInfo
+	if code := get(t, s, "/blocks/1", &block); code != http.StatusOK || block.Height != 1 || len(block.Transactions) != 1 {
+		t.Errorf("block: expected: height 1 with one transaction actual: %d %+v", code, block)
+	}
+	var byHash db.BlockInfo
+	if code := get(t, s, "/blocks/"+block.Hash, &byHash); code != http.StatusOK || byHash.Height != 1 || byHash.Hash != block.Hash {
+		t.Errorf("block by hash: expected: block %s at height 1 actual: %d %+v", block.Hash, code, byHash)
+	}
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/blocks/1?format=binary", nil))
+	if decoded, err := db.DecodeBlock(rec.Body.Bytes()); err != nil || decoded.Hash != block.Hash {
+		t.Errorf("binary block: expected: block %s actual: %+v %v", block.Hash, decoded, err)
+	}
+	var status Status
+	if code := get(t, s, "/status", &status); code != http.StatusOK || !status.Running {
+		t.Errorf("status: expected: running actual: %d %+v", code, status)
+	}
+
+	var errBody struct{ Error string }
+	if code := get(t, s, "/blocks/2", &errBody); code != http.StatusNotFound || errBody.Error == "" {
+		t.Errorf("missing block: expected: %d actual: %d %+v", http.StatusNotFound, code, errBody)
+	}
+	if code := get(t, s, "/traders/"+uuid.New().String(), &errBody); code != http.StatusNotFound {
+		t.Errorf("missing trader: expected: %d actual: %d", http.StatusNotFound, code)
+	}
+	if code := get(t, s, "/trades?limit=0", &errBody); code != http.StatusBadRequest {
+		t.Errorf("invalid limit: expected: %d actual: %d", http.StatusBadRequest, code)
+	}
+}
+
+// TestStream asserts that the server streams the trades of
+// a running exchange's market as server-sent events.
+func TestStream(t *testing.T) {
+	item := trade.NewItem("a")
+	buyer, err := trade.NewTrader(nil, nil, nil)
+	if err != nil {
+		t.Fatalf("new trader: %v", err)
+	}
+	seller, err := trade.NewTrader(nil, nil, nil)
+	if err != nil {
+		t.Fatalf("new trader: %v", err)
+	}
+	e := exchange.NewExchange([]exchange.Market{exchange.NewMarket(item, buyer, seller)})
+	ts := httptest.NewServer(NewServer(e, func() Status { return Status{} }))
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/stream?market="+item.ID.String(), nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("stream: %v", err)
+	}
+	defer resp.Body.Close()
+
+	sim, err := exchange.NewSimulation(e, nil, []*trade.Trader{buyer, seller}, nil, time.Now())
+	if err != nil {
+		t.Fatalf("new simulation: %v", err)
+	}
+	go sim.Run(ctx, 0)
+	c := trade.Response{ID: uuid.New(), TraderID: seller.ID}
+	c.Request = trade.Request{ID: uuid.New(), TraderID: buyer.ID, Item: item, Quantity: 1, Side: trade.SideBuy}
+	c.OrderBook.Ask.Item = item
+	c.OrderBook.Ask.Price = 1
+	c.OrderBook.Ask.Quantity = 1
+	seller.SignQuote(&c)
+	buyer.SignChoice(&c)
+	buyer.Choice <- c
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		if scanner.Text() != "event: trade" {
+			continue
+		}
+		scanner.Scan()
+		var ev exchange.FeedEvent
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(scanner.Text(), "data: ")), &ev); err != nil {
+			t.Fatalf("unmarshal event: %v", err)
+		}
+		if ev.Transaction == nil || ev.Transaction.Credit.TraderID != buyer.ID {
+			t.Errorf("trade event: expected: credit trader %s actual: %+v", buyer.ID, ev)
+		}
+		return
+	}
+	t.Fatalf("stream: expected: trade event actual: %v", scanner.Err())
+}
@@ -4,7 +4,10 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"math"
 	"strings"
+	"time"
+	"tradesim/src/db"
 	"tradesim/src/prob"
 	"tradesim/src/util"
 
@@ -18,6 +21,22 @@ const (
 	minDistribMean    = 0.0
 	minDistribStdDev  = 0.0
 	minDistribLambda  = 0.0
+	minProcessRate    = 0.0
+	minRetainProb     = 0.0
+	maxRetainProb     = 1.0
+	minTransitionProb = 0.0
+	maxTransitionProb = 1.0
+	transitionEpsilon = 1e-9
+	minHawkesBaseline = 0.0
+	minHawkesDecay    = 0.0
+	minBranchingRatio = 0.0
+	maxBranchingRatio = 1.0
+	minExcitation     = 0.0
+	minOrderProb      = 0.0
+	maxOrderProb      = 1.0
+	minDifficulty     = 0
+	minTargetInterval = 0.0
+	minRetargetBlocks = 0
 )
 
 var (
@@ -27,12 +46,39 @@ var (
 )
 
 type ExchangeConfig struct {
-	Markets []MarketConfig `yaml:"markets"`
+	Markets     []MarketConfig     `yaml:"markets"`
+	Excitations []ExcitationConfig `yaml:"excitations"`
+	// Clock ticks the expiry of orders and requotes the orders that
+	// rest, if its frequency is set, SessionTicks is the number of its
+	// ticks per session, at whose close day orders expire, and
+	// RequoteTicks is the number of its ticks between the requotes of an
+	// order, or the exchange's default if it's 0.
+	Clock        ClockConfig `yaml:"clock"`
+	SessionTicks uint64      `yaml:"session_ticks"`
+	RequoteTicks uint64      `yaml:"requote_ticks"`
+}
+
+// ExcitationConfig configures the cross-excitation of a trader's
+// Hawkes process by every trade in the market of an item.
+type ExcitationConfig struct {
+	ItemID   string `yaml:"item_id"`
+	TraderID string `yaml:"trader_id"`
+	// Weight is the expected number of additional events of
+	// the trader's process triggered by each trade.
+	Weight float64 `yaml:"weight"`
 }
 
 type MarketConfig struct {
 	ItemID    string   `yaml:"item_id"`
 	TraderIDs []string `yaml:"trader_ids"`
+	// Mode is continuous, the default, or auction, which clears the
+	// market's orders every AuctionTicks ticks of the exchange's clock.
+	// OpeningTicks and ClosingTicks are the number of ticks of the opening
+	// and closing auctions of every session of a continuous market.
+	Mode         string `yaml:"mode"`
+	AuctionTicks uint64 `yaml:"auction_ticks"`
+	OpeningTicks uint64 `yaml:"opening_ticks"`
+	ClosingTicks uint64 `yaml:"closing_ticks"`
 }
 
 type ItemConfig struct {
@@ -41,9 +87,30 @@ type ItemConfig struct {
 }
 
 type TraderConfig struct {
-	ID    string       `yaml:"id"`
-	Haves []HaveConfig `yaml:"haves"`
-	Wants []WantConfig `yaml:"wants"`
+	ID      string        `yaml:"id"`
+	Haves   []HaveConfig  `yaml:"haves"`
+	Wants   []WantConfig  `yaml:"wants"`
+	Process ProcessConfig `yaml:"process"`
+	// Agent is whether the trader is driven by an external agent
+	// connected over TCP, rather than by its process.
+	Agent bool `yaml:"agent"`
+	// FIX is whether the trader is driven by a FIX client
+	// connected to the FIX gateway, rather than by its process.
+	FIX bool `yaml:"fix"`
+	// Orders configures the orders the trader places
+	// rather than requests for quotes.
+	Orders OrdersConfig `yaml:"orders"`
+}
+
+type OrdersConfig struct {
+	// Probability is the probability in [0, 1] that each request of the
+	// trader's process is a limit order rather than a request for quotes.
+	// TimeInForce is the orders' time in force: ioc, the default, fok,
+	// gtc, gtt, which expires after ExpireTicks ticks of the exchange's
+	// clock, or day, which expires at the close of its session.
+	Probability float64 `yaml:"probability"`
+	TimeInForce string  `yaml:"time_in_force"`
+	ExpireTicks uint64  `yaml:"expire_ticks"`
 }
 
 type HaveConfig struct {
@@ -60,8 +127,44 @@ type WantConfig struct {
 }
 
 type ProcessConfig struct {
+	// Type is the type of process; if empty, the trader's default process is used.
+	Type string `yaml:"type"`
+	// Clock and Distrib configure a Bernoulli process.
 	Clock   ClockConfig   `yaml:"clock"`
 	Distrib DistribConfig `yaml:"distribution"`
+	// Rate is the number of events per second of a homogeneous Poisson process.
+	Rate float64 `yaml:"rate"`
+	// Intensity configures the rate function of a non-homogeneous Poisson process.
+	Intensity IntensityConfig `yaml:"intensity"`
+	// Components are the processes of a superposition,
+	// which must not be Bernoulli or regime processes.
+	Components []ProcessConfig `yaml:"components"`
+	// RetainProb is the probability in (0, 1] that each event of a Poisson,
+	// non-homogeneous Poisson or superposition process is retained.
+	// If it's not set, every event is retained.
+	RetainProb *float64 `yaml:"retain_probability"`
+	// Regimes are the processes of a regime process by regime state name.
+	Regimes map[string]ProcessConfig `yaml:"regimes"`
+	// Baseline, Decay and BranchingRatio configure a Hawkes process, with
+	// a baseline intensity in events per second, an exponential kernel decay
+	// rate per second, and the expected number of events each event triggers.
+	Baseline       float64 `yaml:"baseline"`
+	Decay          float64 `yaml:"decay"`
+	BranchingRatio float64 `yaml:"branching_ratio"`
+}
+
+type IntensityConfig struct {
+	Type string `yaml:"type"`
+	// Breaks and Rates configure a piecewise intensity, where each rate
+	// in events per second holds from its break in seconds until the next.
+	Breaks []float64 `yaml:"breaks"`
+	Rates  []float64 `yaml:"rates"`
+	// Period is the number of seconds after which the intensity repeats.
+	Period float64 `yaml:"period"`
+	// Base, Amplitude and Phase configure a sinusoidal intensity in events per second.
+	Base      float64 `yaml:"base"`
+	Amplitude float64 `yaml:"amplitude"`
+	Phase     float64 `yaml:"phase"`
 }
 
 type ClockConfig struct {
@@ -79,11 +182,76 @@ type DistribConfig struct {
 	Lambda float64 `yaml:"lambda"`
 }
 
+type RegimeConfig struct {
+	// States are the names of the regime states.
+	States []string `yaml:"states"`
+	// Transitions is the transition matrix of the regime states, where each
+	// row holds the probabilities of moving from its state on a clock tick.
+	Transitions [][]float64 `yaml:"transitions"`
+	// Initial is the name of the initial state; if empty, the first state is used.
+	Initial string      `yaml:"initial"`
+	Clock   ClockConfig `yaml:"clock"`
+}
+
+// MiningConfig configures the proof of work of the exchange's blocks.
+type MiningConfig struct {
+	// Difficulty is the number of leading zero bits of every block's
+	// hash, or of the first blocks' if the difficulty is retargeted.
+	Difficulty int `yaml:"difficulty"`
+	// TargetInterval is the target number of seconds between blocks,
+	// and RetargetBlocks is the number of blocks between retargets of
+	// the difficulty towards it; if either is 0, it isn't retargeted.
+	TargetInterval float64 `yaml:"target_interval_seconds"`
+	RetargetBlocks int     `yaml:"retarget_blocks"`
+	// MinDifficulty and MaxDifficulty bound retargeted difficulties,
+	// where a MaxDifficulty of 0 is the largest supported difficulty.
+	MinDifficulty int `yaml:"min_difficulty"`
+	MaxDifficulty int `yaml:"max_difficulty"`
+}
+
+// NetworkConfig configures a simulated network of ledger nodes
+// that the transactions of the simulation are replayed to.
+type NetworkConfig struct {
+	// Nodes is the number of nodes; if 0, no network is simulated.
+	Nodes int `yaml:"nodes"`
+	// Consensus is the consensus rule of the nodes, either pow or bft.
+	Consensus string `yaml:"consensus"`
+	// MinDelay is the minimum delay of a message between two nodes in
+	// seconds, and Delay, if it has a type, generates the additional delay.
+	MinDelay float64       `yaml:"min_delay_seconds"`
+	Delay    DistribConfig `yaml:"delay"`
+	// BlockInterval is the mean number of seconds between blocks with
+	// proof of work, or between a commit and the next proposal with BFT.
+	BlockInterval float64 `yaml:"block_interval_seconds"`
+	// BlockSize is the maximum number of transactions of a block;
+	// if 0, blocks include every pending transaction.
+	BlockSize int `yaml:"block_size"`
+	// FinalityDepth is the number of blocks that finalize
+	// a transaction's block with proof of work.
+	FinalityDepth int `yaml:"finality_depth"`
+	// RoundTimeout is the number of seconds a BFT round waits
+	// for a commit, and Faulty is the number of crashed nodes.
+	RoundTimeout float64 `yaml:"round_timeout_seconds"`
+	Faulty       int     `yaml:"faulty"`
+	// Drain is the number of seconds the network runs after the
+	// last transaction; if 0, it's derived from the block interval.
+	Drain float64 `yaml:"drain_seconds"`
+}
+
 type SimConfig struct {
-	Duration int64          `yaml:"duration_seconds"`
+	Duration int64 `yaml:"duration_seconds"`
+	// Seed seeds every random number and identifier of the simulation;
+	// if 0, they're seeded from the time the simulation starts.
+	Seed int64 `yaml:"seed"`
+	// Epoch is the simulated time the simulation starts at;
+	// if not set, it's the time the simulation starts.
+	Epoch    time.Time      `yaml:"epoch,omitempty"`
 	Items    []ItemConfig   `yaml:"items"`
 	Traders  []TraderConfig `yaml:"traders"`
 	Exchange ExchangeConfig `yaml:"exchange"`
+	Regime   RegimeConfig   `yaml:"regime"`
+	Mining   MiningConfig   `yaml:"mining"`
+	Network  NetworkConfig  `yaml:"network"`
 }
 
 func NewSimConfig(filepath string) (SimConfig, error) {
@@ -111,14 +279,207 @@ func parseSimConfig(filepath string) (SimConfig, error) {
 }
 
 func validateSimConfig(config SimConfig) error {
+	for _, x := range config.Exchange.Excitations {
+		if x.Weight < minExcitation {
+			return fmt.Errorf("%w: name=weight min=%f got=%f", ErrOutOfRange, minExcitation, x.Weight)
+		}
+	}
+	if config.Exchange.Clock != (ClockConfig{}) || config.Exchange.SessionTicks > 0 || config.Exchange.RequoteTicks > 0 {
+		if err := validateClockConfig(config.Exchange.Clock); err != nil {
+			return err
+		}
+	}
+	for _, m := range config.Exchange.Markets {
+		if err := validateMarketConfig(m, config.Exchange); err != nil {
+			return err
+		}
+	}
+	if len(config.Regime.States) > 0 {
+		if err := validateRegimeConfig(config.Regime); err != nil {
+			return err
+		}
+	}
+	if err := validateMiningConfig(config.Mining); err != nil {
+		return err
+	}
+	if err := validateNetworkConfig(config.Network); err != nil {
+		return err
+	}
+	for _, t := range config.Traders {
+		if t.Agent && t.FIX {
+			return fmt.Errorf("%w: trader is both agent and fix: id=%s", ErrInvalid, t.ID)
+		}
+		if err := validateOrdersConfig(t.Orders, config.Exchange); err != nil {
+			return err
+		}
+		if t.Process.Type == "" || t.Agent || t.FIX {
+			continue
+		}
+		if err := validateProcessConfig(t.Process, config.Regime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateMarketConfig(config MarketConfig, exchange ExchangeConfig) error {
+	switch config.Mode {
+	case "", "continuous":
+	case "auction":
+		if config.AuctionTicks == 0 {
+			return fmt.Errorf("%w: auction market without auction ticks: item_id=%s", ErrInvalid, config.ItemID)
+		}
+		if err := validateClockConfig(exchange.Clock); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("%w: unknown market mode: %s", ErrInvalid, config.Mode)
+	}
+	if config.OpeningTicks+config.ClosingTicks == 0 {
+		return nil
+	}
+	if exchange.SessionTicks == 0 {
+		return fmt.Errorf("%w: opening or closing auction without session ticks: item_id=%s", ErrInvalid, config.ItemID)
+	}
+	if max := exchange.SessionTicks; config.OpeningTicks+config.ClosingTicks > max {
+		return fmt.Errorf("%w: name=opening_ticks+closing_ticks max=%d got=%d",
+			ErrOutOfRange, max, config.OpeningTicks+config.ClosingTicks)
+	}
+	return nil
+}
+
+func validateOrdersConfig(config OrdersConfig, exchange ExchangeConfig) error {
+	if config.Probability < minOrderProb || config.Probability > maxOrderProb {
+		return fmt.Errorf("%w: name=probability min=%f max=%f got=%f",
+			ErrOutOfRange, minOrderProb, maxOrderProb, config.Probability)
+	}
+	switch config.TimeInForce {
+	case "", "ioc", "fok", "gtc":
+	case "gtt":
+		if config.ExpireTicks == 0 {
+			return fmt.Errorf("%w: good-till-time orders without expire ticks", ErrInvalid)
+		}
+		if err := validateClockConfig(exchange.Clock); err != nil {
+			return err
+		}
+	case "day":
+		if exchange.SessionTicks == 0 {
+			return fmt.Errorf("%w: day orders without session ticks", ErrInvalid)
+		}
+	default:
+		return fmt.Errorf("%w: unknown time in force: %s", ErrInvalid, config.TimeInForce)
+	}
 	return nil
 }
 
-func validateProcessConfig(config ProcessConfig) error {
+func validateRegimeConfig(config RegimeConfig) error {
 	if err := validateClockConfig(config.Clock); err != nil {
 		return err
 	}
-	return validateDistribConfig(config.Distrib)
+	if config.Initial != "" && !util.ContainsString(config.States, config.Initial) {
+		return fmt.Errorf("%w: unknown initial regime state: %s", ErrInvalid, config.Initial)
+	}
+	if len(config.Transitions) != len(config.States) {
+		return fmt.Errorf("%w: transition matrix rows: expected=%d got=%d",
+			ErrInvalid, len(config.States), len(config.Transitions))
+	}
+	for i, row := range config.Transitions {
+		if len(row) != len(config.States) {
+			return fmt.Errorf("%w: transition matrix row %d columns: expected=%d got=%d",
+				ErrInvalid, i, len(config.States), len(row))
+		}
+		sum := 0.0
+		for _, p := range row {
+			if p < minTransitionProb || p > maxTransitionProb {
+				return fmt.Errorf("%w: name=transitions min=%f max=%f got=%f",
+					ErrOutOfRange, minTransitionProb, maxTransitionProb, p)
+			}
+			sum += p
+		}
+		if math.Abs(sum-1) > transitionEpsilon {
+			return fmt.Errorf("%w: transition matrix row %d sums to %f", ErrInvalid, i, sum)
+		}
+	}
+	return nil
+}
+
+func validateProcessConfig(config ProcessConfig, regime RegimeConfig) error {
+	processType := strings.ToLower(strings.TrimSpace(config.Type))
+	if !util.ContainsString(prob.ProcessTypes, processType) {
+		return prob.NewProcessTypeError(config.Type)
+	}
+	if processType == prob.ProcessBernoulli {
+		if err := validateClockConfig(config.Clock); err != nil {
+			return err
+		}
+		return validateDistribConfig(config.Distrib)
+	}
+	if p := config.RetainProb; p != nil && (*p <= minRetainProb || *p > maxRetainProb) {
+		return fmt.Errorf("%w: name=retain_probability min=%f (exclusive) max=%f got=%f",
+			ErrOutOfRange, minRetainProb, maxRetainProb, *p)
+	}
+	switch processType {
+	case prob.ProcessPoisson:
+		if config.Rate < minProcessRate {
+			return fmt.Errorf("%w: name=rate min=%f got=%f",
+				ErrOutOfRange, minProcessRate, config.Rate)
+		}
+	case prob.ProcessNHPoisson:
+		return validateIntensityConfig(config.Intensity)
+	case prob.ProcessSuperposition:
+		for _, c := range config.Components {
+			switch strings.ToLower(strings.TrimSpace(c.Type)) {
+			case prob.ProcessBernoulli:
+				return fmt.Errorf("%w: superposition of bernoulli process", ErrInvalid)
+			case prob.ProcessRegime:
+				return fmt.Errorf("%w: superposition of regime process", ErrInvalid)
+			}
+			if err := validateProcessConfig(c, regime); err != nil {
+				return err
+			}
+		}
+	case prob.ProcessHawkes:
+		if config.Baseline < minHawkesBaseline {
+			return fmt.Errorf("%w: name=baseline min=%f got=%f",
+				ErrOutOfRange, minHawkesBaseline, config.Baseline)
+		}
+		if config.Decay <= minHawkesDecay {
+			return fmt.Errorf("%w: name=decay min=%f (exclusive) got=%f",
+				ErrOutOfRange, minHawkesDecay, config.Decay)
+		}
+		if config.BranchingRatio < minBranchingRatio || config.BranchingRatio >= maxBranchingRatio {
+			return fmt.Errorf("%w: name=branching_ratio min=%f max=%f (exclusive) got=%f",
+				ErrOutOfRange, minBranchingRatio, maxBranchingRatio, config.BranchingRatio)
+		}
+	case prob.ProcessRegime:
+		if len(regime.States) == 0 {
+			return fmt.Errorf("%w: regime process without regime states", ErrInvalid)
+		}
+		for state, c := range config.Regimes {
+			if !util.ContainsString(regime.States, state) {
+				return fmt.Errorf("%w: unknown regime state: %s", ErrInvalid, state)
+			}
+			if err := validateProcessConfig(c, regime); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func validateIntensityConfig(config IntensityConfig) error {
+	intensityType := strings.ToLower(strings.TrimSpace(config.Type))
+	if !util.ContainsString(prob.IntensityTypes, intensityType) {
+		return prob.NewIntensityTypeError(config.Type)
+	}
+	if config.Period < 0 {
+		return fmt.Errorf("%w: name=period min=%f got=%f", ErrOutOfRange, 0.0, config.Period)
+	}
+	if intensityType == prob.IntensityPiecewise && len(config.Breaks) != len(config.Rates) {
+		return fmt.Errorf("%w: breaks and rates differ in length: breaks=%d rates=%d",
+			ErrInvalid, len(config.Breaks), len(config.Rates))
+	}
+	return nil
 }
 
 func validateClockConfig(config ClockConfig) error {
@@ -151,3 +512,41 @@ func validateDistribConfig(config DistribConfig) error {
 	}
 	return nil
 }
+
+func validateNetworkConfig(config NetworkConfig) error {
+	if config.Nodes == 0 {
+		return nil
+	}
+	if config.Delay.Type != "" {
+		if err := validateDistribConfig(config.Delay); err != nil {
+			return err
+		}
+	}
+	if err := ParseNetwork(config).Validate(); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	return nil
+}
+
+func validateMiningConfig(config MiningConfig) error {
+	maxDifficulty := config.MaxDifficulty
+	if maxDifficulty == 0 {
+		maxDifficulty = db.MaxDifficulty
+	}
+	if maxDifficulty < minDifficulty || maxDifficulty > db.MaxDifficulty {
+		return fmt.Errorf("%w: name=max_difficulty min=%d max=%d got=%d", ErrOutOfRange, minDifficulty, db.MaxDifficulty, config.MaxDifficulty)
+	}
+	if config.MinDifficulty < minDifficulty || config.MinDifficulty > maxDifficulty {
+		return fmt.Errorf("%w: name=min_difficulty min=%d max=%d got=%d", ErrOutOfRange, minDifficulty, maxDifficulty, config.MinDifficulty)
+	}
+	if config.Difficulty < config.MinDifficulty || config.Difficulty > maxDifficulty {
+		return fmt.Errorf("%w: name=difficulty min=%d max=%d got=%d", ErrOutOfRange, config.MinDifficulty, maxDifficulty, config.Difficulty)
+	}
+	if config.TargetInterval < minTargetInterval {
+		return fmt.Errorf("%w: name=target_interval_seconds min=%f got=%f", ErrOutOfRange, minTargetInterval, config.TargetInterval)
+	}
+	if config.RetargetBlocks < minRetargetBlocks {
+		return fmt.Errorf("%w: name=retarget_blocks min=%d got=%d", ErrOutOfRange, minRetargetBlocks, config.RetargetBlocks)
+	}
+	return nil
+}
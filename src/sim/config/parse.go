@@ -1,15 +1,33 @@
 package config
 
 import (
+	"fmt"
 	"strings"
 	"time"
+	"tradesim/src/db"
 	"tradesim/src/exchange"
+	"tradesim/src/network"
 	"tradesim/src/prob"
 	"tradesim/src/time/clock"
 	"tradesim/src/trade"
 )
 
-func ParseExchange(config ExchangeConfig, items map[string]trade.Item, traders map[string]*trade.Trader) *exchange.Exchange {
+// timesInForce maps the times in force of the orders of a trader's
+// configuration onto the exchange's, where an unset time in force is
+// immediate-or-cancel.
+var timesInForce = map[string]trade.TimeInForce{
+	"":    trade.TimeInForceIOC,
+	"ioc": trade.TimeInForceIOC,
+	"fok": trade.TimeInForceFOK,
+	"gtc": trade.TimeInForceGTC,
+	"gtt": trade.TimeInForceGTT,
+	"day": trade.TimeInForceDay,
+}
+
+// ParseExchange returns the exchange of the provided configuration, or an
+// error if an excitation is of an unknown item or trader, or of a trader
+// whose process can't be excited.
+func ParseExchange(config ExchangeConfig, items map[string]trade.Item, traders map[string]*trade.Trader) (*exchange.Exchange, error) {
 	markets := make([]exchange.Market, 0, len(config.Markets))
 	for _, c := range config.Markets {
 		i, ok := items[c.ItemID]
@@ -24,9 +42,39 @@ func ParseExchange(config ExchangeConfig, items map[string]trade.Item, traders m
 			}
 		}
 		m := exchange.NewMarket(i, ts...)
+		if c.Mode == "auction" {
+			m.Mode = exchange.MarketAuction
+		}
+		m.AuctionTicks = c.AuctionTicks
+		m.OpeningTicks = c.OpeningTicks
+		m.ClosingTicks = c.ClosingTicks
 		markets = append(markets, m)
 	}
-	return exchange.NewExchange(markets)
+	e := exchange.NewExchange(markets)
+	if config.Clock.Frequency > 0 {
+		c := parseClock(config.Clock)
+		e.Clock = &c
+		e.SessionTicks = config.SessionTicks
+		if config.RequoteTicks > 0 {
+			e.RequoteTicks = config.RequoteTicks
+		}
+	}
+	for _, c := range config.Excitations {
+		i, ok := items[c.ItemID]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown excitation item: %s", ErrInvalid, c.ItemID)
+		}
+		t, ok := traders[c.TraderID]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown excitation trader: %s", ErrInvalid, c.TraderID)
+		}
+		x, ok := t.Process().(prob.Exciter)
+		if !ok {
+			return nil, fmt.Errorf("%w: excitation of trader without hawkes process: %s", ErrInvalid, c.TraderID)
+		}
+		e.AddExcitation(i.ID, x, c.Weight)
+	}
+	return e, nil
 }
 
 func ParseItems(config []ItemConfig) map[string]trade.Item {
@@ -37,15 +85,22 @@ func ParseItems(config []ItemConfig) map[string]trade.Item {
 	return result
 }
 
-func ParseTraders(config []TraderConfig, items map[string]trade.Item) map[string]*trade.Trader {
+// ParseTraders returns the traders of the provided configuration by ID.
+// The provided regime chain, which may be nil, drives regime processes.
+// An error is returned if the key pair of a trader can't be generated.
+func ParseTraders(config []TraderConfig, items map[string]trade.Item, regime *prob.MarkovChain) (map[string]*trade.Trader, error) {
 	result := make(map[string]*trade.Trader, len(config))
 	for _, v := range config {
-		result[v.ID] = parseTrader(v, items)
+		t, err := parseTrader(v, items, regime)
+		if err != nil {
+			return nil, err
+		}
+		result[v.ID] = t
 	}
-	return result
+	return result, nil
 }
 
-func parseTrader(config TraderConfig, items map[string]trade.Item) *trade.Trader {
+func parseTrader(config TraderConfig, items map[string]trade.Item, regime *prob.MarkovChain) (*trade.Trader, error) {
 	haves := make([]trade.Have, 0, len(config.Haves))
 	for _, c := range config.Haves {
 		i, ok := items[c.ItemID]
@@ -62,7 +117,16 @@ func parseTrader(config TraderConfig, items map[string]trade.Item) *trade.Trader
 			wants = append(wants, w)
 		}
 	}
-	return trade.NewTrader(haves, wants)
+	t, err := trade.NewTrader(haves, wants, ParseProcess(config.Process, regime))
+	if err != nil {
+		return nil, err
+	}
+	t.Orders = trade.OrderPolicy{
+		Probability: config.Orders.Probability,
+		TimeInForce: timesInForce[config.Orders.TimeInForce],
+		ExpireTicks: config.Orders.ExpireTicks,
+	}
+	return t, nil
 }
 
 func parseHave(config HaveConfig, item trade.Item) trade.Have {
@@ -82,11 +146,129 @@ func parseWant(config WantConfig, item trade.Item) trade.Want {
 	}
 }
 
-func ParseProcess(config ProcessConfig) *prob.Process {
-	return prob.NewProcess(
-		parseDistribution(config.Distrib),
-		parseClock(config.Clock),
-	)
+// ParseProofOfWork returns the proof of work of the provided mining configuration.
+func ParseProofOfWork(config MiningConfig) db.ProofOfWork {
+	return db.ProofOfWork{
+		Difficulty:       config.Difficulty,
+		TargetInterval:   time.Duration(config.TargetInterval * float64(time.Second)),
+		RetargetInterval: config.RetargetBlocks,
+		MinDifficulty:    config.MinDifficulty,
+		MaxDifficulty:    config.MaxDifficulty,
+	}
+}
+
+// ParseNetwork returns the network of the provided configuration.
+func ParseNetwork(config NetworkConfig) network.Config {
+	seconds := func(s float64) time.Duration { return time.Duration(s * float64(time.Second)) }
+	cfg := network.Config{
+		Nodes:         config.Nodes,
+		Consensus:     strings.ToLower(strings.TrimSpace(config.Consensus)),
+		MinDelay:      seconds(config.MinDelay),
+		BlockInterval: seconds(config.BlockInterval),
+		BlockSize:     config.BlockSize,
+		FinalityDepth: config.FinalityDepth,
+		RoundTimeout:  seconds(config.RoundTimeout),
+		Faulty:        config.Faulty,
+		Drain:         seconds(config.Drain),
+	}
+	if config.Delay.Type != "" {
+		cfg.Delay = parseDistribution(config.Delay)
+	}
+	return cfg
+}
+
+// ParseRegime returns the Markov chain of the provided regime configuration,
+// or nil if the configuration has no states.
+func ParseRegime(config RegimeConfig) *prob.MarkovChain {
+	if len(config.States) == 0 {
+		return nil
+	}
+	initial := 0
+	for i, s := range config.States {
+		if s == config.Initial {
+			initial = i
+		}
+	}
+	return prob.NewMarkovChain(config.States, config.Transitions, initial, parseClock(config.Clock))
+}
+
+// ParseProcess returns the process of the provided configuration,
+// or nil if the configuration has an unsupported process type.
+// The provided regime chain, which may be nil, drives a regime process.
+func ParseProcess(config ProcessConfig, regime *prob.MarkovChain) prob.Process {
+	switch strings.ToLower(strings.TrimSpace(config.Type)) {
+	case prob.ProcessBernoulli:
+		return prob.NewBernoulliProcess(
+			parseDistribution(config.Distrib),
+			parseClock(config.Clock),
+		)
+	case prob.ProcessRegime:
+		if regime == nil {
+			return nil
+		}
+		processes := make([]prob.Process, len(regime.States()))
+		for i, s := range regime.States() {
+			if c, ok := config.Regimes[s]; ok {
+				processes[i] = ParseProcess(c, regime)
+			}
+		}
+		return prob.NewRegimeProcess(regime, processes)
+	case prob.ProcessHawkes:
+		return prob.NewHawkesProcess(
+			prob.NewHawkes(config.Baseline, config.Decay, config.BranchingRatio),
+			time.Second,
+		)
+	}
+	arrivals := parseArrivals(config)
+	if arrivals == nil {
+		return nil
+	}
+	return prob.NewArrivalProcess(arrivals, time.Second)
+}
+
+func parseArrivals(config ProcessConfig) prob.Arrivals {
+	var arrivals prob.Arrivals
+	switch strings.ToLower(strings.TrimSpace(config.Type)) {
+	case prob.ProcessPoisson:
+		arrivals = prob.NewPoisson(config.Rate)
+	case prob.ProcessNHPoisson:
+		intensity := parseIntensity(config.Intensity)
+		if intensity == nil {
+			return nil
+		}
+		arrivals = prob.NewNHPoisson(intensity)
+	case prob.ProcessHawkes:
+		arrivals = prob.NewHawkes(config.Baseline, config.Decay, config.BranchingRatio)
+	case prob.ProcessSuperposition:
+		components := make([]prob.Arrivals, 0, len(config.Components))
+		for _, c := range config.Components {
+			if a := parseArrivals(c); a != nil {
+				components = append(components, a)
+			}
+		}
+		arrivals = prob.NewSuperposition(components...)
+	default:
+		return nil
+	}
+	if p := config.RetainProb; p != nil && *p < 1 {
+		arrivals = prob.NewThinning(arrivals, *p)
+	}
+	return arrivals
+}
+
+func parseIntensity(config IntensityConfig) prob.Intensity {
+	switch strings.ToLower(strings.TrimSpace(config.Type)) {
+	case prob.IntensityPiecewise:
+		intensity, err := prob.NewPiecewiseIntensity(config.Breaks, config.Rates, config.Period)
+		if err != nil {
+			return nil
+		}
+		return intensity
+	case prob.IntensitySinusoidal:
+		return prob.NewSinusoidalIntensity(config.Base, config.Amplitude, config.Period, config.Phase)
+	default:
+		return nil
+	}
 }
 
 func parseClock(config ClockConfig) clock.Clock {
@@ -1,7 +1,9 @@
 package config
 
 import (
+	"errors"
 	"testing"
+	"tradesim/src/prob"
 )
 
 var cfg = SimConfig{
@@ -97,3 +99,38 @@ func TestParseItems(t *testing.T) {
 		t.Errorf("missing item name: %s", cfg.Items[1].Name)
 	}
 }
+
+// TestParseExchangeExcitations asserts that an exchange is only parsed if
+// every excitation is of a known item and of a known trader whose process
+// is a Hawkes process.
+func TestParseExchangeExcitations(t *testing.T) {
+	items := ParseItems(cfg.Items)
+	traders, err := ParseTraders(cfg.Traders, items, nil)
+	if err != nil {
+		t.Fatalf("parse traders: %v", err)
+	}
+	traders["hawkes"], err = parseTrader(TraderConfig{
+		Process: ProcessConfig{Type: prob.ProcessHawkes, Baseline: 1, Decay: 2, BranchingRatio: 0.5},
+	}, items, nil)
+	if err != nil {
+		t.Fatalf("parse trader: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		excitation ExcitationConfig
+		err        error
+	}{
+		{"hawkes", ExcitationConfig{ItemID: "1", TraderID: "hawkes", Weight: 0.5}, nil},
+		{"unknown item", ExcitationConfig{ItemID: "3", TraderID: "hawkes", Weight: 0.5}, ErrInvalid},
+		{"unknown trader", ExcitationConfig{ItemID: "1", TraderID: "3", Weight: 0.5}, ErrInvalid},
+		{"not hawkes", ExcitationConfig{ItemID: "1", TraderID: "1", Weight: 0.5}, ErrInvalid},
+	}
+	for _, test := range tests {
+		c := cfg.Exchange
+		c.Excitations = []ExcitationConfig{test.excitation}
+		if _, err := ParseExchange(c, items, traders); !errors.Is(err, test.err) {
+			t.Errorf("%s: error: expected: %v actual: %v", test.name, test.err, err)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package trade
+
+import (
+	"errors"
+	"fmt"
+	"tradesim/src/prob"
+
+	"github.com/google/uuid"
+)
+
+var ErrCheckpoint = errors.New("failed to checkpoint trader")
+
+// TraderCheckpoint represents the state of a stopped trader,
+// from which it can be resumed.
+type TraderCheckpoint struct {
+	ID    uuid.UUID `json:"id"`
+	Haves []Have    `json:"haves"`
+	Wants []Want    `json:"wants"`
+	// Key is the seed of the trader's key pair, so that its
+	// messages are still signed by it once it's resumed.
+	Key []byte `json:"key,omitempty"`
+	// Process is the checkpoint of the trader's process,
+	// if it can be checkpointed.
+	Process *prob.ProcessCheckpoint `json:"process,omitempty"`
+}
+
+// Checkpoint returns the state of the trader, or an error if messages are
+// queued on its channels, which aren't part of its state, or its process
+// can't be checkpointed. The trader must be stopped, and no messages may
+// be sent to or by it until it returns.
+func (t *Trader) Checkpoint() (TraderCheckpoint, error) {
+	queued := len(t.RequestSend) + len(t.RequestRecv) + len(t.ResponseSend) + len(t.ResponseRecv) +
+		len(t.Choice) + len(t.ReportRecv) + len(t.CancelSend) + len(t.ReplaceSend)
+	if queued > 0 {
+		return TraderCheckpoint{}, fmt.Errorf("%w: messages are queued: %s: %d", ErrCheckpoint, t.ID, queued)
+	}
+	c := TraderCheckpoint{ID: t.ID, Key: t.key.Seed()}
+	c.Haves, c.Wants = t.Holdings()
+	if cp, ok := t.process.(prob.Checkpointer); ok {
+		pc, err := cp.Checkpoint()
+		if err != nil {
+			return TraderCheckpoint{}, fmt.Errorf("%w: %s: %v", ErrCheckpoint, t.ID, err)
+		}
+		c.Process = &pc
+	}
+	return c, nil
+}
+
+// Restore resumes the trader from the provided checkpoint when it's next
+// stepped, or returns an error if the checkpoint's key isn't a key seed or
+// its process isn't of the trader's process. The trader must be restored
+// before it's simulated, nor its ID or key used meanwhile.
+func (t *Trader) Restore(c TraderCheckpoint) error {
+	cp, ok := t.process.(prob.Checkpointer)
+	if ok != (c.Process != nil) {
+		return fmt.Errorf("%w: process of checkpoint doesn't match trader's: %s", ErrCheckpoint, c.ID)
+	}
+	if ok {
+		if err := cp.Restore(*c.Process); err != nil {
+			return fmt.Errorf("%w: %s: %v", ErrCheckpoint, c.ID, err)
+		}
+	}
+	if err := t.SetKey(c.Key); err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrCheckpoint, c.ID, err)
+	}
+	t.ID = c.ID
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.Haves = make(map[uuid.UUID]*Have, len(c.Haves))
+	for _, h := range c.Haves {
+		_h := h
+		t.Haves[h.Item.ID] = &_h
+	}
+	t.Wants = make(map[uuid.UUID]*Want, len(c.Wants))
+	for _, w := range c.Wants {
+		_w := w
+		t.Wants[w.Item.ID] = &_w
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package trade
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// TestTraderCheckpoint asserts that a trader restored from the checkpoint
+// of another has its identity, key and holdings, and that a trader can't be
+// checkpointed while messages are queued on its channels.
+func TestTraderCheckpoint(t *testing.T) {
+	item := Item{ID: uuid.New(), Name: "a"}
+	from, err := NewTrader([]Have{{Item: item, Price: 2, Quantity: 3}}, []Want{{Item: item, PriceMin: 1, PriceMax: 2, Quantity: 4}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := from.Checkpoint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	to, err := NewTrader(nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := to.Restore(c); err != nil {
+		t.Fatal(err)
+	}
+	if expected, actual := from.ID, to.ID; expected != actual {
+		t.Errorf("expected restored ID: want=%s got=%s", expected, actual)
+	}
+	if expected, actual := from.PublicKey(), to.PublicKey(); !bytes.Equal(expected, actual) {
+		t.Errorf("expected restored key: want=%x got=%x", expected, actual)
+	}
+	haves, wants := from.Holdings()
+	restoredHaves, restoredWants := to.Holdings()
+	if !reflect.DeepEqual(haves, restoredHaves) || !reflect.DeepEqual(wants, restoredWants) {
+		t.Errorf("expected restored holdings: want=%v %v got=%v %v", haves, wants, restoredHaves, restoredWants)
+	}
+
+	from.ReportRecv <- ExecutionReport{}
+	if _, err := from.Checkpoint(); !errors.Is(err, ErrCheckpoint) {
+		t.Errorf("expected checkpoint of queued messages to fail: want=%v got=%v", ErrCheckpoint, err)
+	}
+	c.Key = c.Key[:1]
+	if err := to.Restore(c); !errors.Is(err, ErrCheckpoint) {
+		t.Errorf("expected restore of invalid key to fail: want=%v got=%v", ErrCheckpoint, err)
+	}
+}
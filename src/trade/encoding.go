@@ -0,0 +1,93 @@
+package trade
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"tradesim/src/codec"
+)
+
+// TransactionVersion is the version of the canonical encoding of
+// transactions written by MarshalBinary.
+const TransactionVersion = 1
+
+// MarshalBinary returns the canonical encoding of the transaction,
+// which is its version, followed by
+//
+//     ID                          UUID
+//     Credit and Debit, each as
+//         TraderID                UUID
+//         Item.ID                 UUID
+//         Item.Name               string
+//         Price                   float
+//         Quantity                float
+//     QuoteID                     UUID
+//     Quoted                      float
+//     CreditSignature             byte string
+//     DebitSignature              byte string
+//
+// as encoded by package codec.
+func (t *Transaction) MarshalBinary() ([]byte, error) {
+	var e codec.Encoder
+	t.encode(&e)
+	return e.Bytes(), nil
+}
+
+// UnmarshalBinary sets the transaction to the transaction
+// of the provided canonical encoding.
+func (t *Transaction) UnmarshalBinary(p []byte) error {
+	d := codec.NewDecoder(p)
+	if v := d.Uint8(); d.Err() == nil && v != TransactionVersion {
+		return fmt.Errorf("%w: unsupported transaction version: supported=%d got=%d", codec.ErrDecode, TransactionVersion, v)
+	}
+	var txn Transaction
+	txn.ID = d.UUID()
+	for _, r := range []*TransactionRecord{&txn.Credit, &txn.Debit} {
+		r.TraderID = d.UUID()
+		r.Item.ID = d.UUID()
+		r.Item.Name = d.Text()
+		r.Price = d.Float64()
+		r.Quantity = d.Float64()
+	}
+	txn.QuoteID = d.UUID()
+	txn.Quoted = d.Float64()
+	txn.CreditSignature = nilIfEmpty(d.Blob())
+	txn.DebitSignature = nilIfEmpty(d.Blob())
+	if err := d.Close(); err != nil {
+		return err
+	}
+	*t = txn
+	return nil
+}
+
+func (t *Transaction) encode(e *codec.Encoder) {
+	e.Uint8(TransactionVersion)
+	e.UUID(t.ID)
+	for _, r := range []TransactionRecord{t.Credit, t.Debit} {
+		e.UUID(r.TraderID)
+		e.UUID(r.Item.ID)
+		e.Text(r.Item.Name)
+		e.Float64(r.Price)
+		e.Float64(r.Quantity)
+	}
+	e.UUID(t.QuoteID)
+	e.Float64(t.Quoted)
+	e.Blob(t.CreditSignature)
+	e.Blob(t.DebitSignature)
+}
+
+// Hash returns the hex encoding of the SHA-256 hash
+// of the canonical encoding of the transaction.
+func (t *Transaction) Hash() string {
+	var e codec.Encoder
+	t.encode(&e)
+	return fmt.Sprintf("%x", sha256.Sum256(e.Bytes()))
+}
+
+// nilIfEmpty returns nil for an empty byte string, so that
+// an absent signature decodes as it was before it was encoded.
+func nilIfEmpty(p []byte) []byte {
+	if len(p) == 0 {
+		return nil
+	}
+	return p
+}
@@ -0,0 +1,82 @@
+package trade
+
+import (
+	"encoding/hex"
+	"reflect"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// goldenTransaction is the transaction of the golden encoding.
+var goldenTransaction = Transaction{
+	ID: uuid.MustParse("00000000-0000-0000-0000-000000000001"),
+	Credit: TransactionRecord{
+		TraderID: uuid.MustParse("00000000-0000-0000-0000-000000000002"),
+		Item:     Item{ID: uuid.MustParse("00000000-0000-0000-0000-000000000003"), Name: "a b"},
+		Price:    1.5,
+		Quantity: 2,
+	},
+	Debit: TransactionRecord{
+		TraderID: uuid.MustParse("00000000-0000-0000-0000-000000000004"),
+		Item:     Item{ID: uuid.MustParse("00000000-0000-0000-0000-000000000003"), Name: "a b"},
+		Price:    1.5,
+		Quantity: 2,
+	},
+	QuoteID:         uuid.MustParse("00000000-0000-0000-0000-000000000005"),
+	Quoted:          4,
+	CreditSignature: []byte{0xc1},
+	DebitSignature:  []byte{0xd1, 0xd2},
+}
+
+const (
+	goldenTransactionEncoding = "01" +
+		"00000000000000000000000000000001" +
+		"00000000000000000000000000000002" + "00000000000000000000000000000003" + "00000003612062" + "3ff8000000000000" + "4000000000000000" +
+		"00000000000000000000000000000004" + "00000000000000000000000000000003" + "00000003612062" + "3ff8000000000000" + "4000000000000000" +
+		"00000000000000000000000000000005" + "4010000000000000" +
+		"00000001c1" + "00000002d1d2"
+	goldenTransactionHash = "096920b6d89dc6773a6c2dec046cccb7d48fd9fe952ab2b556272b29aead3094"
+)
+
+// TestTransactionGolden asserts that the canonical encoding and hash of
+// a transaction match the golden vectors, and that it decodes back.
+func TestTransactionGolden(t *testing.T) {
+	p, err := goldenTransaction.MarshalBinary()
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	if expected, actual := goldenTransactionEncoding, hex.EncodeToString(p); expected != actual {
+		t.Errorf("encoding: expected: %s actual: %s", expected, actual)
+	}
+	if expected, actual := goldenTransactionHash, goldenTransaction.Hash(); expected != actual {
+		t.Errorf("hash: expected: %s actual: %s", expected, actual)
+	}
+	var decoded Transaction
+	if err := decoded.UnmarshalBinary(p); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if !reflect.DeepEqual(goldenTransaction, decoded) {
+		t.Errorf("decoded: expected: %+v actual: %+v", goldenTransaction, decoded)
+	}
+	for _, n := range []int{0, 1, len(p) - 1} {
+		if err := decoded.UnmarshalBinary(p[:n]); err == nil {
+			t.Errorf("truncated to %d bytes: expected: error actual: none", n)
+		}
+	}
+	if err := decoded.UnmarshalBinary(append(p, 0)); err == nil {
+		t.Errorf("trailing byte: expected: error actual: none")
+	}
+}
+
+// TestTransactionHashCollision asserts that transactions whose fields
+// concatenate to the same string, such as an item name absorbing the
+// digits of a price, have different hashes.
+func TestTransactionHashCollision(t *testing.T) {
+	a, b := goldenTransaction, goldenTransaction
+	a.Credit.Item.Name, a.Credit.Price = "a1", 2
+	b.Credit.Item.Name, b.Credit.Price = "a", 12
+	if a.Hash() == b.Hash() {
+		t.Errorf("hash: expected: different actual: %s", a.Hash())
+	}
+}
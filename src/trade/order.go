@@ -0,0 +1,179 @@
+package trade
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// OrderType represents how the exchange executes a request.
+type OrderType uint8
+
+const (
+	// OrderQuote requests quotes, which the requester chooses among.
+	OrderQuote OrderType = iota
+	// OrderMarket trades at the best quoted prices, and OrderLimit at the
+	// best quoted prices the request's price accepts, which for a buy
+	// are the lowest asks, and for a sell the highest bids.
+	OrderMarket
+	OrderLimit
+)
+
+// TimeInForce represents how long an order is live.
+type TimeInForce uint8
+
+const (
+	// TimeInForceUnset is the zero value, which isn't a valid time in
+	// force of an order, so that every order states how long it's live.
+	TimeInForceUnset TimeInForce = iota
+	// TimeInForceIOC fills as much of an order as is quoted when it's
+	// matched, and cancels the rest. TimeInForceFOK fills all of it when
+	// it's matched, or cancels all of it.
+	TimeInForceIOC
+	TimeInForceFOK
+	// TimeInForceGTC keeps the rest of an order live, quoted again on every
+	// tick of the exchange's clock, until it's filled or canceled.
+	// TimeInForceGTT also expires it after the request's ExpireTicks ticks,
+	// and TimeInForceDay at the close of the exchange's session.
+	TimeInForceGTC
+	TimeInForceGTT
+	TimeInForceDay
+)
+
+// Rests returns whether the rest of an order
+// of the time in force stays live once it's matched.
+func (tif TimeInForce) Rests() bool {
+	return tif == TimeInForceGTC || tif == TimeInForceGTT || tif == TimeInForceDay
+}
+
+// IsOrder returns whether the request is an order executed by the
+// exchange, rather than a request for quotes.
+func (r Request) IsOrder() bool {
+	return r.Type != OrderQuote
+}
+
+// Accepts returns whether the request accepts a quote of the provided
+// price, which a limit buy order only does at or below its price, and a
+// limit sell order at or above it.
+func (r Request) Accepts(price float64) bool {
+	if r.Type != OrderLimit {
+		return true
+	}
+	if r.Side == SideSell {
+		return price >= r.Price
+	}
+	return price <= r.Price
+}
+
+// ExecType represents the event of an order reported by an execution report.
+type ExecType uint8
+
+const (
+	// ExecNew reports an accepted order, and ExecRejected a rejected one.
+	ExecNew ExecType = iota
+	ExecRejected
+	// ExecTrade reports a fill of an order.
+	ExecTrade
+	// ExecCanceled reports the cancellation of the unfilled quantity
+	// of an order.
+	ExecCanceled
+	// ExecReplaced reports an accepted replace of an order, and
+	// ExecCancelRejected a rejected cancel or replace of one.
+	ExecReplaced
+	ExecCancelRejected
+	// ExecExpired reports the expiry of the live quantity of an order.
+	ExecExpired
+)
+
+func (t ExecType) String() string {
+	switch t {
+	case ExecNew:
+		return "new"
+	case ExecRejected:
+		return "rejected"
+	case ExecTrade:
+		return "trade"
+	case ExecCanceled:
+		return "canceled"
+	case ExecReplaced:
+		return "replaced"
+	case ExecCancelRejected:
+		return "cancel rejected"
+	case ExecExpired:
+		return "expired"
+	}
+	return fmt.Sprintf("exec type %d", uint8(t))
+}
+
+// ExecutionReport represents an event of an order,
+// reported by the exchange to the order's trader.
+type ExecutionReport struct {
+	// OrderID is the ID the exchange assigned the order,
+	// and RequestID is the ID of its request.
+	OrderID   uuid.UUID
+	RequestID uuid.UUID
+	TraderID  uuid.UUID
+	Item      Item
+	Type      ExecType
+	// Quantity is the quantity of the order, which is the sum of its
+	// filled quantity, its live leaves quantity and its canceled
+	// quantity, unless it was rejected.
+	Quantity float64
+	Filled   float64
+	Leaves   float64
+	Canceled float64
+	// LastPrice and LastQuantity are the price and quantity
+	// of the fill reported by a trade report.
+	LastPrice    float64
+	LastQuantity float64
+	// CancelID is the ID of the cancel or replace
+	// answered by the report, if any.
+	CancelID uuid.UUID
+	// Reason is why the order was rejected, canceled or expired,
+	// or why its cancel or replace was rejected.
+	Reason string
+}
+
+func (r *ExecutionReport) String() string {
+	var s strings.Builder
+
+	s.WriteString(fmt.Sprintf("order id=%s ", r.OrderID))
+	s.WriteString(fmt.Sprintf("request id=%s ", r.RequestID))
+	s.WriteString(fmt.Sprintf("trader id=%s ", r.TraderID))
+	s.WriteString(fmt.Sprintf("item id=%s ", r.Item.ID))
+	s.WriteString(fmt.Sprintf("item name=%s ", r.Item.Name))
+	s.WriteString(fmt.Sprintf("type=%q ", r.Type))
+
+	s.WriteString(fmt.Sprintf("quantity=%f ", r.Quantity))
+	s.WriteString(fmt.Sprintf("filled=%f ", r.Filled))
+	s.WriteString(fmt.Sprintf("leaves=%f ", r.Leaves))
+	s.WriteString(fmt.Sprintf("canceled=%f ", r.Canceled))
+	s.WriteString(fmt.Sprintf("last price=%f ", r.LastPrice))
+	s.WriteString(fmt.Sprintf("last quantity=%f ", r.LastQuantity))
+
+	s.WriteString(fmt.Sprintf("cancel id=%s ", r.CancelID))
+	s.WriteString(fmt.Sprintf("reason=%q ", r.Reason))
+
+	return strings.TrimSpace(s.String())
+}
+
+// Cancel represents a trader's request to cancel the live quantity of one
+// of its orders, identified by the ID of its request or its order ID.
+type Cancel struct {
+	ID       uuid.UUID
+	TraderID uuid.UUID
+	OrderID  uuid.UUID
+}
+
+// Replace represents a trader's request to replace the quantity and limit
+// price of one of its orders, identified by the ID of its request or its
+// order ID, which keeps the order's quotes.
+type Replace struct {
+	ID       uuid.UUID
+	TraderID uuid.UUID
+	OrderID  uuid.UUID
+	Quantity float64
+	// Price is the new limit price of a limit order.
+	Price float64
+}
@@ -0,0 +1,140 @@
+package trade
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"tradesim/src/codec"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// quoteDomain and termsDomain prefix the signed encodings of
+	// quotes and transaction terms, so that a signature of one
+	// can't be used as a signature of the other.
+	quoteDomain = "tradesim quote v1"
+	termsDomain = "tradesim terms v1"
+)
+
+// PublicKey returns the public key of the trader's key pair,
+// with which its signatures are verified.
+func (t *Trader) PublicKey() ed25519.PublicKey {
+	return t.key.Public().(ed25519.PublicKey)
+}
+
+// SetKey sets the trader's key pair to the one of the provided seed, so
+// that a seeded simulation signs with the same keys on every run, or
+// returns ErrKey if the seed isn't ed25519.SeedSize bytes long. It must
+// not be called once the trader's public key is registered.
+func (t *Trader) SetKey(seed []byte) error {
+	if len(seed) != ed25519.SeedSize {
+		return fmt.Errorf("%w: seed must be %d bytes: got=%d", ErrKey, ed25519.SeedSize, len(seed))
+	}
+	t.key = ed25519.NewKeyFromSeed(seed)
+	return nil
+}
+
+// SignQuote signs the quote of the provided response of the trader, which
+// authorizes the requester to trade up to its quantity at its price.
+func (t *Trader) SignQuote(r *Response) {
+	txn := r.Transaction()
+	r.Signature = ed25519.Sign(t.key, txn.quote())
+}
+
+// SignChoice signs the terms of the transaction that the provided
+// response executes once it's chosen by the trader of its request.
+func (t *Trader) SignChoice(r *Response) {
+	txn := r.Transaction()
+	r.ChoiceSignature = ed25519.Sign(t.key, txn.terms())
+}
+
+// Quote returns the quote of the response to its request,
+// which is its bid for a sell request, and its ask otherwise.
+func (r *Response) Quote() Quote {
+	if r.Request.Side == SideSell {
+		return r.OrderBook.Bid
+	}
+	return r.OrderBook.Ask
+}
+
+// Transaction returns the transaction that the response executes once it's
+// chosen, without an ID. The trader of a buy request is the credit trader,
+// who buys the chosen quantity of the ask from the responder, and the
+// trader of a sell request the debit trader, who sells the chosen quantity
+// of the bid to the responder.
+func (r *Response) Transaction() Transaction {
+	q := r.Quote()
+	quantity := q.Quantity
+	if r.Fill > 0 && r.Fill < quantity {
+		quantity = r.Fill
+	}
+	requester := TransactionRecord{
+		TraderID: r.Request.TraderID,
+		Item:     r.Request.Item,
+		Price:    q.Price,
+		Quantity: quantity,
+	}
+	responder := TransactionRecord{
+		TraderID: r.TraderID,
+		Item:     r.Request.Item,
+		Price:    q.Price,
+		Quantity: quantity,
+	}
+	t := Transaction{
+		Credit:          requester,
+		Debit:           responder,
+		QuoteID:         r.ID,
+		Quoted:          q.Quantity,
+		CreditSignature: r.ChoiceSignature,
+		DebitSignature:  r.Signature,
+	}
+	if r.Request.Side == SideSell {
+		t.Credit, t.Debit = responder, requester
+		t.CreditSignature, t.DebitSignature = r.Signature, r.ChoiceSignature
+	}
+	return t
+}
+
+// Verify returns whether the transaction's terms are signed by one of its
+// traders, and its quote by the other, with the provided credit and debit
+// keys, and whether its quantity is within the quote's.
+func (t *Transaction) Verify(credit, debit ed25519.PublicKey) bool {
+	if len(credit) != ed25519.PublicKeySize || len(debit) != ed25519.PublicKeySize {
+		return false
+	}
+	if t.Credit.Quantity > t.Quoted || t.Debit.Quantity > t.Quoted {
+		return false
+	}
+	terms, quote := t.terms(), t.quote()
+	return (ed25519.Verify(credit, terms, t.CreditSignature) && ed25519.Verify(debit, quote, t.DebitSignature)) ||
+		(ed25519.Verify(credit, quote, t.CreditSignature) && ed25519.Verify(debit, terms, t.DebitSignature))
+}
+
+// quote returns the encoding of the transaction's quote signed by
+// the trader that quoted it, which is its quote ID, traders, item, price and
+// quoted quantity.
+func (t *Transaction) quote() []byte {
+	var e codec.Encoder
+	e.Raw([]byte(quoteDomain))
+	e.UUID(t.QuoteID)
+	e.UUID(t.Credit.TraderID)
+	e.UUID(t.Debit.TraderID)
+	e.UUID(t.Debit.Item.ID)
+	e.Text(t.Debit.Item.Name)
+	e.Float64(t.Debit.Price)
+	e.Float64(t.Quoted)
+	return e.Bytes()
+}
+
+// terms returns the encoding of the transaction's terms signed by its
+// credit trader, which is its canonical encoding without its ID and
+// signatures, which aren't known when it's signed.
+func (t *Transaction) terms() []byte {
+	unsigned := *t
+	unsigned.ID = uuid.Nil
+	unsigned.CreditSignature, unsigned.DebitSignature = nil, nil
+	var e codec.Encoder
+	e.Raw([]byte(termsDomain))
+	unsigned.encode(&e)
+	return e.Bytes()
+}
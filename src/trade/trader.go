@@ -1,16 +1,21 @@
 package trade
 
 import (
-	"context"
-	"math/rand"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
 	"time"
 	"tradesim/src/prob"
 	"tradesim/src/time/clock"
 
 	"github.com/google/uuid"
-	"golang.org/x/sync/errgroup"
 )
 
+var ErrKey = errors.New("failed to generate trader key")
+
 // Have represents an item that a trader holds,
 // along with their evaluation of its unit price
 // and how many units they hold.
@@ -33,18 +38,58 @@ type Want struct {
 // Trader represents an entity participating
 // in the exchange of items with other traders.
 type Trader struct {
-	ID           uuid.UUID
-	Haves        map[uuid.UUID]*Have
-	Wants        map[uuid.UUID]*Want
+	ID uuid.UUID
+	// mu guards Haves and Wants, so that
+	// they can be read while the trader runs.
+	mu    sync.RWMutex
+	Haves map[uuid.UUID]*Have
+	Wants map[uuid.UUID]*Want
+	// The channels carry the trader's messages to and from the exchange,
+	// where only a trader driven by a client sends its messages on them.
 	RequestSend  chan Request
 	RequestRecv  chan Request
 	ResponseSend chan Response
 	ResponseRecv chan Responses
 	Choice       chan Response
-	process      *prob.Process
+	// ReportRecv receives the execution reports of the trader's orders,
+	// and CancelSend and ReplaceSend send cancels and replaces of them.
+	ReportRecv  chan ExecutionReport
+	CancelSend  chan Cancel
+	ReplaceSend chan Replace
+	// Orders is how the trader places orders rather than requests for
+	// quotes, which is set before the trader is simulated.
+	Orders  OrderPolicy
+	process prob.Process
+	// key signs the trader's quotes and choices.
+	key ed25519.PrivateKey
+}
+
+// OrderPolicy is how a trader driven by its process places orders. Each
+// request is a limit order with probability Probability, buying a want at
+// its maximum price or selling a have at its price, whose time in force is
+// TimeInForce, or immediate-or-cancel if it's unset, and which expires
+// after ExpireTicks ticks if it's good-till-time.
+type OrderPolicy struct {
+	Probability float64
+	TimeInForce TimeInForce
+	ExpireTicks uint64
 }
 
-func NewTrader(haves []Have, wants []Want) *Trader {
+// NewTrader returns a trader with the provided haves and wants,
+// whose requests are driven by the events of the provided process.
+// If the provided process is nil, a Bernoulli process with
+// a success probability of 0.2 on one second ticks is used.
+// If the trader's key pair can't be generated, ErrKey is returned.
+func NewTrader(haves []Have, wants []Want, process prob.Process) (*Trader, error) {
+	if process == nil {
+		process = prob.NewBernoulliProcess(prob.NewUniform(0.2), clock.NewClock(time.Second, 0))
+	}
+	// Keys are generated from the system's entropy rather than the seeded
+	// source, so that they neither change nor perturb seeded simulations.
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrKey, err)
+	}
 	t := &Trader{
 		ID:           uuid.New(),
 		Haves:        make(map[uuid.UUID]*Have, len(haves)),
@@ -54,51 +99,74 @@ func NewTrader(haves []Have, wants []Want) *Trader {
 		ResponseSend: make(chan Response, 8),
 		ResponseRecv: make(chan Responses, 8),
 		Choice:       make(chan Response, 8),
-		// TODO: configurable
-		process: prob.NewProcess(prob.NewUniform(0.2), clock.NewClock(time.Second, 0)),
+		ReportRecv:   make(chan ExecutionReport, 8),
+		CancelSend:   make(chan Cancel, 8),
+		ReplaceSend:  make(chan Replace, 8),
+		process:      process,
+		key:          key,
 	}
 	for _, h := range haves {
-		t.Haves[h.Item.ID] = &h
+		_h := h
+		t.Haves[h.Item.ID] = &_h
 	}
 	for _, w := range wants {
-		t.Wants[w.Item.ID] = &w
+		_w := w
+		t.Wants[w.Item.ID] = &_w
 	}
-	return t
+	return t, nil
 }
 
-func (t *Trader) Start(ctx context.Context) error {
-	wg, c := errgroup.WithContext(ctx)
-	wg.Go(func() error { return t.process.Start(c) })
-	wg.Go(func() error { return t.sendRequest(c) })
-	wg.Go(func() error { return t.sendResponse(c) })
-	wg.Go(func() error { return t.sendChoice(c) })
-	return wg.Wait()
+// Process returns the process that drives the trader's requests.
+func (t *Trader) Process() prob.Process {
+	return t.process
 }
 
-func (t *Trader) sendRequest(ctx context.Context) error {
-	select {
-	case <-ctx.Done():
-		return ctx.Err()
-	case <-t.process.Event:
-		r, ok := t.randomRequest()
-		if ok {
-			t.RequestSend <- r
-		}
+// Holdings returns the haves and wants of the trader, ordered by item ID.
+// It's safe to call while the trader runs.
+func (t *Trader) Holdings() ([]Have, []Want) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	haves := make([]Have, 0, len(t.Haves))
+	for _, h := range t.Haves {
+		haves = append(haves, *h)
 	}
-	return nil
+	wants := make([]Want, 0, len(t.Wants))
+	for _, w := range t.Wants {
+		wants = append(wants, *w)
+	}
+	sort.Slice(haves, func(i, j int) bool { return haves[i].Item.ID.String() < haves[j].Item.ID.String() })
+	sort.Slice(wants, func(i, j int) bool { return wants[i].Item.ID.String() < wants[j].Item.ID.String() })
+	return haves, wants
+}
+
+// Act returns the request the trader sends on an event of its process,
+// and whether it sends one.
+func (t *Trader) Act() (Request, bool) {
+	return t.randomRequest()
+}
+
+// Respond returns the trader's response to the provided request,
+// and whether it responds to it.
+func (t *Trader) Respond(req Request) (Response, bool) {
+	return t.response(req)
+}
+
+// Choose returns the crosses the trader signs and the choices it makes
+// of the provided responses, which it sends in that order.
+func (t *Trader) Choose(resps Responses) (Responses, Responses) {
+	return t.crosses(resps), t.choices(resps)
 }
 
 func (t *Trader) randomRequest() (Request, bool) {
-	if len(t.Wants) == 0 {
-		return Request{}, false
+	// Holdings are ordered so that a seeded random choice is reproducible.
+	hs, ws := t.Holdings()
+	if t.Orders.Probability > 0 && prob.Rand.Float64() < t.Orders.Probability {
+		return t.randomOrder(hs, ws)
 	}
-	ws := make([]*Want, len(t.Wants))
-	i := 0
-	for _, v := range t.Wants {
-		ws[i] = v
-		i++
+	if len(ws) == 0 {
+		return Request{}, false
 	}
-	w := ws[rand.Intn(len(ws))]
+	w := ws[prob.Rand.Intn(len(ws))]
 	return Request{
 		ID:       uuid.New(),
 		TraderID: t.ID,
@@ -108,20 +176,37 @@ func (t *Trader) randomRequest() (Request, bool) {
 	}, true
 }
 
-func (t *Trader) sendResponse(ctx context.Context) error {
-	select {
-	case <-ctx.Done():
-		return ctx.Err()
-	case req := <-t.RequestRecv:
-		resp, ok := t.response(req)
-		if ok {
-			t.ResponseSend <- resp
-		}
+// randomOrder returns a limit order of one of the provided haves and wants
+// chosen at random, placed according to the trader's order policy.
+func (t *Trader) randomOrder(hs []Have, ws []Want) (Request, bool) {
+	if len(hs)+len(ws) == 0 {
+		return Request{}, false
+	}
+	r := Request{
+		ID:          uuid.New(),
+		TraderID:    t.ID,
+		Type:        OrderLimit,
+		TimeInForce: t.Orders.TimeInForce,
+	}
+	if r.TimeInForce == TimeInForceUnset {
+		r.TimeInForce = TimeInForceIOC
 	}
-	return nil
+	if r.TimeInForce == TimeInForceGTT {
+		r.ExpireTicks = t.Orders.ExpireTicks
+	}
+	if i := prob.Rand.Intn(len(hs) + len(ws)); i < len(ws) {
+		w := ws[i]
+		r.Item, r.Quantity, r.Price, r.Side = w.Item, w.Quantity, w.PriceMax, SideBuy
+	} else {
+		h := hs[i-len(ws)]
+		r.Item, r.Quantity, r.Price, r.Side = h.Item, h.Quantity, h.Price, SideSell
+	}
+	return r, true
 }
 
 func (t *Trader) response(req Request) (Response, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
 	h, have := t.Haves[req.Item.ID]
 	w, want := t.Wants[req.Item.ID]
 	if !(have || want) {
@@ -143,25 +228,60 @@ func (t *Trader) response(req Request) (Response, bool) {
 		r.OrderBook.Bid.Price = w.PriceMax
 		r.OrderBook.Bid.Quantity = w.Quantity
 	}
+	t.SignQuote(&r)
 	return r, true
 }
 
-func (t *Trader) sendChoice(ctx context.Context) error {
-	select {
-	case <-ctx.Done():
-		return ctx.Err()
-	case resps := <-t.ResponseRecv:
+// choices returns the signed choices of the provided responses. The
+// responses to an order of the trader are the fills the exchange allocated
+// to it, which are all chosen if its order accepts their price, and one
+// of the responses to a request for quotes is chosen at random.
+func (t *Trader) choices(resps Responses) Responses {
+	if len(resps) == 0 || !resps[0].Request.IsOrder() {
 		c, ok := t.randomChoice(resps)
-		if ok {
-			t.Choice <- c
+		if !ok {
+			return nil
+		}
+		return Responses{c}
+	}
+	var cs Responses
+	for _, c := range resps {
+		r := c.Request
+		if r.TraderID != t.ID || !r.Accepts(c.Quote().Price) {
+			continue
+		}
+		t.SignChoice(&c)
+		cs = append(cs, c)
+	}
+	return cs
+}
+
+// crosses returns the signed crosses of the provided responses. A cross
+// is a fill of another trader's order that an auction allocated to a sell
+// order of the trader, which is signed if the trader has its item.
+func (t *Trader) crosses(resps Responses) Responses {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	var cs Responses
+	for _, c := range resps {
+		r := c.Request
+		if c.TraderID != t.ID || r.TraderID == t.ID || !r.IsOrder() {
+			continue
+		}
+		if _, ok := t.Haves[r.Item.ID]; !ok {
+			continue
 		}
+		t.SignQuote(&c)
+		cs = append(cs, c)
 	}
-	return nil
+	return cs
 }
 
 func (t *Trader) randomChoice(resp Responses) (Response, bool) {
 	if len(resp) == 0 {
 		return Response{}, false
 	}
-	return resp[rand.Intn(len(resp))], true
+	c := resp[prob.Rand.Intn(len(resp))]
+	t.SignChoice(&c)
+	return c, true
 }
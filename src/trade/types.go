@@ -1,9 +1,7 @@
 package trade
 
 import (
-	"crypto/sha256"
 	"fmt"
-	"strconv"
 	"strings"
 
 	"github.com/google/uuid"
@@ -35,6 +33,14 @@ type Request struct {
 	Item     Item
 	Quantity float64
 	Side     Side
+	// Type is how the exchange executes the request, Price is the limit
+	// price of a limit order, and TimeInForce is how long an order is live,
+	// where ExpireTicks is the number of ticks of the exchange's clock after
+	// which a good-till-time order expires.
+	Type        OrderType   `json:",omitempty"`
+	Price       float64     `json:",omitempty"`
+	TimeInForce TimeInForce `json:",omitempty"`
+	ExpireTicks uint64      `json:",omitempty"`
 }
 
 type Responses []Response
@@ -44,19 +50,26 @@ type Response struct {
 	Request   Request
 	TraderID  uuid.UUID
 	OrderBook OrderBook
+	// Signature is the responder's signature of its ask, set by SignQuote.
+	Signature []byte
+	// Fill is the quantity of the ask chosen by the trader of the request,
+	// where 0 chooses all of it, and ChoiceSignature is its signature of
+	// the chosen transaction, set by SignChoice.
+	Fill            float64
+	ChoiceSignature []byte
 }
 
 type OrderBook struct {
-	Ask struct {
-		Item     Item
-		Price    float64
-		Quantity float64
-	}
-	Bid struct {
-		Item     Item
-		Price    float64
-		Quantity float64
-	}
+	Ask Quote
+	Bid Quote
+}
+
+// Quote represents a price and quantity of an item
+// a trader quotes on either side of its order book.
+type Quote struct {
+	Item     Item
+	Price    float64
+	Quantity float64
 }
 
 type Choice struct {
@@ -68,6 +81,17 @@ type Transaction struct {
 	ID     uuid.UUID
 	Credit TransactionRecord
 	Debit  TransactionRecord
+	// QuoteID is the ID of the response whose ask the transaction
+	// executed, and Quoted is the ask's quantity.
+	QuoteID uuid.UUID
+	Quoted  float64
+	// CreditSignature and DebitSignature are the signatures of the
+	// credit and debit traders, where the trader of the request signs
+	// the transaction's terms, and the trader that quoted it signs its
+	// quote: the debit trader quoted a buy request, and the credit
+	// trader a sell request.
+	CreditSignature []byte
+	DebitSignature  []byte
 }
 
 type TransactionRecord struct {
@@ -96,23 +120,3 @@ func (t *Transaction) String() string {
 
 	return strings.TrimSpace(s.String())
 }
-
-func (t *Transaction) Hash() string {
-	var s strings.Builder
-
-	s.WriteString(t.ID.String())
-
-	s.WriteString(t.Credit.TraderID.String())
-	s.WriteString(t.Credit.Item.ID.String())
-	s.WriteString(t.Credit.Item.Name)
-	s.WriteString(strconv.FormatFloat(t.Credit.Price, 'f', -1, 64))
-	s.WriteString(strconv.FormatFloat(t.Credit.Quantity, 'f', -1, 64))
-
-	s.WriteString(t.Debit.TraderID.String())
-	s.WriteString(t.Debit.Item.ID.String())
-	s.WriteString(t.Debit.Item.Name)
-	s.WriteString(strconv.FormatFloat(t.Debit.Price, 'f', -1, 64))
-	s.WriteString(strconv.FormatFloat(t.Debit.Quantity, 'f', -1, 64))
-
-	return fmt.Sprintf("%x", sha256.Sum256([]byte(s.String())))
-}